@@ -0,0 +1,161 @@
+package audiorelay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access log modes
+const (
+	accessLogOff     = "off"
+	accessLogStreams = "streams" // Audio streams and captures only
+	accessLogAll     = "all"
+)
+
+// Reasons an audio stream ended besides those in the X-Stream-End trailer
+const (
+	streamEndClientLeft = "client-left"
+	streamEndLimit      = "limit"
+	streamEndWriteError = "write-error"
+	streamEndTimeout    = "timeout"
+)
+
+// accessRecord collects what a handler reports about its request for the
+// access log
+type accessRecord struct {
+	stream  bool
+	reason  string
+	dropped int64
+}
+
+type accessRecordKey struct{}
+
+// requestAccess returns the access record of a request, nil outside the
+// access log middleware
+func requestAccess(r *http.Request) *accessRecord {
+	record, _ := r.Context().Value(accessRecordKey{}).(*accessRecord)
+	return record
+}
+
+// streamEnded marks the request as an audio stream that ended for reason,
+// after dropping the given number of bytes for a slow connection
+func (a *accessRecord) streamEnded(reason string, dropped int64) {
+	if a == nil {
+		return
+	}
+	a.stream = true
+	a.reason = reason
+	a.dropped = dropped
+}
+
+// accessResponse records the status and size of a response
+type accessResponse struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader records the status before sending it
+func (a *accessResponse) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends p and counts it
+func (a *accessResponse) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(p)
+	a.bytes += int64(n)
+	return n, err
+}
+
+// Flush pushes buffered data to the client
+func (a *accessResponse) Flush() {
+	http.NewResponseController(a.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the response
+func (a *accessResponse) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// wrapAccessLog returns a handler that logs finished requests as key=value
+// pairs, as chosen by logging.access_log, and publishes them as
+// EventRequest
+func (hs *HTTPServer) wrapAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode := hs.accessLog.Load().(string)
+		if mode == accessLogOff {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		started := time.Now()
+		record := &accessRecord{}
+		response := &accessResponse{ResponseWriter: w}
+		next.ServeHTTP(response, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, record)))
+		if mode != accessLogAll && !record.stream {
+			return
+		}
+
+		status := response.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		duration := time.Since(started)
+		fields := []string{
+			"remote=" + logfmtValue(r.RemoteAddr),
+			"method=" + r.Method,
+			"path=" + logfmtValue(r.URL.Path),
+			"status=" + strconv.Itoa(status),
+			"bytes=" + strconv.FormatInt(response.bytes, 10),
+			"duration=" + duration.Round(time.Millisecond).String(),
+		}
+		data := map[string]interface{}{
+			"remote_addr": r.RemoteAddr,
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      status,
+			"bytes":       response.bytes,
+			"duration_ms": float64(duration.Microseconds()) / 1000,
+		}
+		if record.reason != "" {
+			fields = append(fields, "reason="+record.reason)
+			data["reason"] = record.reason
+		}
+		if record.dropped > 0 {
+			fields = append(fields, "dropped="+strconv.FormatInt(record.dropped, 10))
+			data["dropped_bytes"] = record.dropped
+		}
+		log.Printf("access %s", strings.Join(fields, " "))
+		if hs.events != nil {
+			hs.events.Publish(EventRequest, data)
+		}
+	})
+}
+
+// logfmtValue quotes s if it would break up a key=value line
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\\") || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// validateAccessLog checks the logging.access_log mode
+func validateAccessLog(mode string) error {
+	switch mode {
+	case accessLogOff, accessLogStreams, accessLogAll:
+		return nil
+	}
+	return fmt.Errorf("logging access_log must be %s, %s or %s", accessLogStreams, accessLogAll, accessLogOff)
+}
@@ -0,0 +1,182 @@
+package audiorelay
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// analysisQueueSize is how many buffers may wait for slow analyzers before
+// the tap starts dropping them
+const analysisQueueSize = 64
+
+// AnalysisFrame is one broadcast buffer handed to analyzers
+type AnalysisFrame struct {
+	Samples    []float64 // Interleaved, in 16-bit full-scale units like Processor
+	Channels   int
+	SampleRate int
+	Time       time.Time // When the buffer was broadcast
+	Position   int64     // Index of the first sample frame since capture started
+}
+
+// Duration returns the length of the frame
+func (f AnalysisFrame) Duration() time.Duration {
+	frames := len(f.Samples) / f.Channels
+	return time.Duration(frames) * time.Second / time.Duration(f.SampleRate)
+}
+
+// Analyzer inspects the broadcast stream without being able to change it.
+// Analyze runs on the tap's goroutine and must not retain frame.Samples.
+// Results are usually published as events.
+type Analyzer interface {
+	Analyze(frame AnalysisFrame)
+}
+
+// AnalyzerFactory builds an analyzer that reports through events
+type AnalyzerFactory func(config *Config, events *EventBus) (Analyzer, error)
+
+// builtinAnalyzers lists the analyzers available to analysis.analyzers
+var builtinAnalyzers = map[string]AnalyzerFactory{
+	"classifier": NewClassifier,
+}
+
+var (
+	customAnalyzers   = make(map[string]AnalyzerFactory)
+	customAnalyzersMu sync.RWMutex
+)
+
+// RegisterAnalyzer makes a custom analyzer available to analysis.analyzers
+// under name. Register before starting the relay.
+func RegisterAnalyzer(name string, factory AnalyzerFactory) error {
+	if _, ok := builtinAnalyzers[name]; ok {
+		return fmt.Errorf("analyzer %q is built in", name)
+	}
+
+	customAnalyzersMu.Lock()
+	defer customAnalyzersMu.Unlock()
+	if _, exists := customAnalyzers[name]; exists {
+		return fmt.Errorf("analyzer %q is already registered", name)
+	}
+	customAnalyzers[name] = factory
+	return nil
+}
+
+// lookupAnalyzer returns the factory of a built-in or custom analyzer
+func lookupAnalyzer(name string) (AnalyzerFactory, bool) {
+	if factory, ok := builtinAnalyzers[name]; ok {
+		return factory, true
+	}
+	customAnalyzersMu.RLock()
+	defer customAnalyzersMu.RUnlock()
+	factory, ok := customAnalyzers[name]
+	return factory, ok
+}
+
+// AnalysisTap copies the broadcast stream to analyzers on a separate
+// goroutine, so slow analysis never delays listeners: when the queue is
+// full, buffers are dropped for the analyzers only.
+type AnalysisTap struct {
+	config    *Config
+	capture   *AudioCapture // For the rate being captured
+	analyzers []Analyzer
+	names     []string
+
+	queue    chan tappedBuffer
+	done     chan struct{}
+	position int64 // Sample frames fed so far, only touched by Feed
+
+	dropped  atomic.Int64
+	analyzed atomic.Int64
+}
+
+// tappedBuffer is a broadcast buffer waiting to be decoded
+type tappedBuffer struct {
+	data     []byte
+	format   wavFormat
+	time     time.Time
+	position int64
+}
+
+// NewAnalysisTap builds the configured analyzers
+func NewAnalysisTap(config *Config, capture *AudioCapture, events *EventBus) (*AnalysisTap, error) {
+	tap := &AnalysisTap{
+		config:  config,
+		capture: capture,
+		queue:   make(chan tappedBuffer, analysisQueueSize),
+		done:    make(chan struct{}),
+	}
+	for _, name := range config.Analysis.Analyzers {
+		factory, ok := lookupAnalyzer(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown analyzer: %q", name)
+		}
+		analyzer, err := factory(config, events)
+		if err != nil {
+			return nil, fmt.Errorf("analyzer %s: %v", name, err)
+		}
+		tap.analyzers = append(tap.analyzers, analyzer)
+		tap.names = append(tap.names, name)
+	}
+	return tap, nil
+}
+
+// Start runs the analyzers until Stop
+func (at *AnalysisTap) Start() {
+	go at.run()
+	log.Printf("🔬 Analysis tap started: %v", at.names)
+}
+
+// Stop lets the analyzers finish the queued buffers and ends the tap
+func (at *AnalysisTap) Stop() {
+	close(at.queue)
+	<-at.done
+}
+
+// Feed queues a broadcast buffer without blocking
+func (at *AnalysisTap) Feed(data []byte) {
+	format := at.capture.StreamFormat(false)
+	buf := tappedBuffer{data: data, format: format, time: time.Now(), position: at.position}
+	at.position += int64(len(data) / format.blockAlign())
+
+	select {
+	case at.queue <- buf:
+	default:
+		at.dropped.Add(1)
+	}
+}
+
+// run decodes queued buffers and hands them to every analyzer
+func (at *AnalysisTap) run() {
+	defer close(at.done)
+	for buf := range at.queue {
+		frame := AnalysisFrame{
+			Samples:    buf.format.decodeSamples(buf.data),
+			Channels:   buf.format.Channels,
+			SampleRate: buf.format.SampleRate,
+			Time:       buf.time,
+			Position:   buf.position,
+		}
+		for _, analyzer := range at.analyzers {
+			analyzer.Analyze(frame)
+		}
+		at.analyzed.Add(1)
+	}
+}
+
+// debugInfo reports the tap's throughput for the debug endpoint
+func (at *AnalysisTap) debugInfo() interface{} {
+	info := map[string]interface{}{
+		"analyzers": at.names,
+		"analyzed":  at.analyzed.Load(),
+		"dropped":   at.dropped.Load(),
+		"queued":    len(at.queue),
+	}
+	for _, analyzer := range at.analyzers {
+		if c, ok := analyzer.(*Classifier); ok {
+			info["class"] = c.Class()
+		}
+	}
+	return info
+}
@@ -0,0 +1,290 @@
+package audiorelay
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// registerAPI adds the relay's API endpoints to the HTTP server
+func (ar *AudioRelay) registerAPI(hs *HTTPServer) {
+	// Effective configuration, with live audio and processing changes
+	hs.HandleFunc("GET /api/v1/config", ar.handleGetConfig)
+	hs.HandleFunc("PUT /api/v1/config", ar.handlePutConfig)
+
+	// Runtime processing controls
+	hs.HandleFunc("GET /api/v1/processing", ar.handleGetProcessing)
+	hs.HandleFunc("PATCH /api/v1/processing", ar.handleUpdateProcessing)
+
+	// Stream title sent to internet radio players
+	hs.HandleFunc("GET /api/v1/metadata", hs.handleGetMetadata)
+	hs.HandleFunc("PUT /api/v1/metadata", hs.handleSetMetadata)
+
+	// Capture devices
+	hs.HandleFunc("GET /devices", ar.handleListDevices)
+	hs.HandleFunc("GET /api/v1/device", ar.handleGetDevice)
+	hs.HandleFunc("POST /api/v1/device", ar.handleSwitchDevice)
+
+	// Latency breakdown of the processing chain and server buffers
+	hs.HandleFunc("GET /api/v1/latency", ar.handleLatency)
+
+	// Per-client controls
+	hs.HandleFunc("GET /api/v1/clients", ar.handleListClients)
+	hs.HandleFunc("PATCH /api/v1/clients/{id}", ar.handleUpdateClient)
+	hs.HandleFunc("DELETE /api/v1/clients/{id}", ar.handleDisconnectClient)
+	hs.HandleFunc("GET /api/v1/client-prefs", ar.handleListClientPrefs)
+	hs.HandleFunc("DELETE /api/v1/client-prefs/{name}", ar.handleDeleteClientPrefs)
+
+	// Recordings and post-processing
+	hs.HandleFunc("GET /api/v1/recordings", ar.recordings.handleList)
+	hs.HandleFunc("GET /api/v1/recordings/{name}", ar.recordings.handleGet)
+	hs.HandleFunc("POST /api/v1/recordings/{name}/process", ar.recordings.handleProcess)
+
+	// Chimes and announcement sounds
+	hs.HandleFunc("GET /api/v1/assets", ar.assets.handleListAssets)
+	hs.HandleFunc("GET /api/v1/assets/{name}", ar.assets.handleGetAsset)
+	hs.HandleFunc("PUT /api/v1/assets/{name}", ar.assets.handleUploadAsset)
+	hs.HandleFunc("DELETE /api/v1/assets/{name}", ar.assets.handleDeleteAsset)
+
+	// Share links and their public listening pages
+	if ar.config.Share.Enabled {
+		hs.HandleFunc("GET /api/v1/shares", ar.handleListShares)
+		hs.HandleFunc("POST /api/v1/shares", ar.handleCreateShare)
+		hs.HandleFunc("DELETE /api/v1/shares/{id}", ar.handleDeleteShare)
+		hs.HandleFunc("GET /share/{id}", ar.handleSharePage)
+		hs.HandleFunc("GET /share/{id}/stream.wav", ar.handleShareStream)
+	}
+
+	// Hot standby registration
+	hs.HandleFunc("GET /api/v1/standby", ar.handleGetStandby)
+	hs.HandleFunc("POST /api/v1/standby", ar.handleRegisterStandby)
+
+	// Fault injection for exercising failover and recovery
+	if ar.faults != nil {
+		hs.HandleFunc("GET /api/v1/dev/faults", ar.handleGetFaults)
+		hs.HandleFunc("PUT /api/v1/dev/faults", ar.handleSetFaults)
+		hs.HandleFunc("DELETE /api/v1/dev/faults", ar.handleClearFaults)
+	}
+
+	// CPU, memory and goroutine profiles of a running relay
+	if ar.config.Server.Profiling {
+		ar.registerProfiling(hs)
+	}
+
+	// Background jobs
+	hs.HandleFunc("GET /api/v1/jobs", ar.jobs.handleListJobs)
+	hs.HandleFunc("GET /api/v1/jobs/{id}", ar.jobs.handleGetJob)
+	hs.HandleFunc("DELETE /api/v1/jobs/{id}", ar.jobs.handleCancelJob)
+}
+
+// processingState is the runtime-adjustable part of the processing chain
+type processingState struct {
+	Channels    ChannelState `json:"channels"`
+	StereoWidth float64      `json:"stereo_width"`
+}
+
+// processingUpdate is a partial update; omitted fields are left unchanged
+type processingUpdate struct {
+	Mute   *[]bool `json:"mute"`
+	Invert *[]bool `json:"invert"`
+	Swap   *bool   `json:"swap"`
+
+	StereoWidth *float64 `json:"stereo_width"`
+}
+
+// handleGetProcessing returns the current runtime processing state
+func (ar *AudioRelay) handleGetProcessing(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, processingState{
+		Channels:    ar.audioCapture.ChannelState(),
+		StereoWidth: ar.audioCapture.StereoWidth(),
+	})
+}
+
+// handleUpdateProcessing applies runtime processing changes
+func (ar *AudioRelay) handleUpdateProcessing(w http.ResponseWriter, r *http.Request) {
+	var update processingUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	state := ar.audioCapture.ChannelState()
+	if update.Mute != nil {
+		state.Mute = *update.Mute
+	}
+	if update.Invert != nil {
+		state.Invert = *update.Invert
+	}
+	if update.Swap != nil {
+		state.Swap = *update.Swap
+	}
+	if err := ar.audioCapture.SetChannelState(state); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if update.StereoWidth != nil {
+		if err := ar.audioCapture.SetStereoWidth(*update.StereoWidth); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	ar.handleGetProcessing(w, r)
+}
+
+// clientUpdate is a partial update of a client's settings
+type clientUpdate struct {
+	Gain *float64 `json:"gain"`
+}
+
+// handleListClients returns all connected clients
+func (ar *AudioRelay) handleListClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"clients": ar.clients.List()})
+}
+
+// handleUpdateClient changes settings of a single connected client
+func (ar *AudioRelay) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := ar.clients.Get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "client not found")
+		return
+	}
+
+	var update clientUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if update.Gain != nil {
+		if err := client.SetGain(*update.Gain); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Named clients keep the volume when they reconnect
+		if client.Name != "" {
+			prefs := ar.clientPrefs.Get(client.Name)
+			prefs.Volume = *update.Gain
+			if err := ar.clientPrefs.Set(client.Name, prefs); err != nil {
+				log.Printf("Failed to save client preferences: %v", err)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, client.Info())
+}
+
+// handleDisconnectClient closes the connection of a single client, e.g. a
+// stale player that keeps a connection open
+func (ar *AudioRelay) handleDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := ar.clients.Get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	if !client.Disconnect() {
+		writeJSONError(w, http.StatusConflict, "client is still connecting, try again")
+		return
+	}
+	log.Printf("🔌 Client %s (%s) disconnected through the API", client.ID, client.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deviceSelection picks a device by name or by index in the device list
+type deviceSelection struct {
+	Name  *string `json:"name"`
+	Index *int    `json:"index"`
+}
+
+// deviceInfo describes a capture device in API responses
+func deviceInfo(device *portaudio.DeviceInfo) map[string]interface{} {
+	info := map[string]interface{}{
+		"name":                device.Name,
+		"channels":            device.MaxInputChannels,
+		"default_sample_rate": device.DefaultSampleRate,
+	}
+	if device.HostApi != nil {
+		info["host_api"] = device.HostApi.Name
+	}
+	return info
+}
+
+// handleListDevices enumerates the input devices on every request, with the
+// indexes accepted by POST /api/v1/device, audio.device_index and --device
+func (ar *AudioRelay) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	// A capture reconnecting elsewhere may be restarting PortAudio
+	portaudioMu.RLock()
+	defer portaudioMu.RUnlock()
+
+	dm := NewDeviceManager()
+	dm.SetHostApi(ar.config.Audio.HostApi)
+	if err := dm.Initialize(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	defaultName := ""
+	if device, err := dm.GetDefaultInputDevice(); err == nil {
+		defaultName = device.Name
+	}
+	currentName := ""
+	if device := ar.audioCapture.Device(); device != nil {
+		currentName = device.Name
+	}
+
+	devices := make([]map[string]interface{}, 0, len(dm.devices))
+	for i, device := range dm.devices {
+		info := deviceInfo(device)
+		info["index"] = i
+		info["is_default"] = device.Name == defaultName
+		info["is_loopback"] = isLoopback(device)
+		info["current"] = device.Name == currentName
+		devices = append(devices, info)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"devices": devices})
+}
+
+// handleGetDevice returns the device currently being captured
+func (ar *AudioRelay) handleGetDevice(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, deviceInfo(ar.audioCapture.Device()))
+}
+
+// handleSwitchDevice moves capture to another device; connected clients
+// stay connected and hear a crossfade across the switch
+func (ar *AudioRelay) handleSwitchDevice(w http.ResponseWriter, r *http.Request) {
+	if ar.config.Audio.Backend == BackendPulse {
+		writeJSONError(w, http.StatusConflict, "the pulse backend captures audio.pulse.source, change it in the config")
+		return
+	}
+
+	var selection deviceSelection
+	if err := json.NewDecoder(r.Body).Decode(&selection); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var device *portaudio.DeviceInfo
+	var err error
+	switch {
+	case selection.Name != nil:
+		device, err = ar.deviceMgr.GetDeviceByName(*selection.Name)
+	case selection.Index != nil:
+		device, err = ar.deviceMgr.GetDeviceByIndex(*selection.Index)
+	default:
+		writeJSONError(w, http.StatusBadRequest, "name or index is required")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := ar.audioCapture.SwitchDevice(device); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ar.events.Publish(EventDeviceSwitched, map[string]interface{}{"device": device.Name})
+
+	ar.handleGetDevice(w, r)
+}
@@ -0,0 +1,369 @@
+package audiorelay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxAssetSeconds bounds announcement sounds, which are held in memory
+const maxAssetSeconds = 600
+
+// Asset names are file names without the .wav extension; languages are
+// tags such as "en" or "zh-CN", with language-neutral assets at the top level
+var (
+	assetNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+	assetLangPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+)
+
+// AssetsConfig controls the announcement sounds and chimes library
+type AssetsConfig struct {
+	Directory   string  `mapstructure:"directory" desc:"Where chimes and announcements are kept; language versions go in subdirectories such as en/ or zh-CN/"`
+	NormalizeDB float64 `mapstructure:"normalize_db" desc:"Peak level (dBFS) imported sounds are normalized to"`
+	MaxUploadMB float64 `mapstructure:"max_upload_mb" desc:"Largest WAV file accepted by the upload API"`
+}
+
+// AssetInfo describes a sound in the asset library
+type AssetInfo struct {
+	Name       string    `json:"name"`
+	Lang       string    `json:"lang,omitempty"`
+	Duration   float64   `json:"duration"`
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Size       int64     `json:"size"`
+	Modified   time.Time `json:"modified"`
+	Ready      bool      `json:"ready"`           // Already in the stream's format, so it plays without conversion
+	Error      string    `json:"error,omitempty"` // Why the file can't be used
+}
+
+// AssetStore keeps chimes and announcements, converted on import to the
+// stream's sample rate and channels and normalized to a common level
+type AssetStore struct {
+	config  *Config
+	capture *AudioCapture // For the rate being captured
+	dir     string
+}
+
+// NewAssetStore creates a store for the assets in the configured directory
+func NewAssetStore(config *Config, capture *AudioCapture) *AssetStore {
+	return &AssetStore{
+		config:  config,
+		capture: capture,
+		dir:     config.Assets.Directory,
+	}
+}
+
+// format returns the layout assets are stored in
+func (as *AssetStore) format() wavFormat {
+	return wavFormat{
+		SampleRate:    int(as.capture.SampleRate()),
+		Channels:      as.config.OutputChannels(),
+		BitsPerSample: 16,
+	}
+}
+
+// path resolves an asset to its file, rejecting names outside the store
+func (as *AssetStore) path(name, lang string) (string, error) {
+	if !assetNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid asset name: %q (letters, digits, '.', '_' and '-')", name)
+	}
+	if lang == "" {
+		return filepath.Join(as.dir, name+".wav"), nil
+	}
+	if !assetLangPattern.MatchString(lang) {
+		return "", fmt.Errorf("invalid language: %q (e.g. en, zh-CN)", lang)
+	}
+	return filepath.Join(as.dir, lang, name+".wav"), nil
+}
+
+// Find returns the file of an asset in lang, falling back to the base
+// language (zh-CN to zh) and then the language-neutral version
+func (as *AssetStore) Find(name, lang string) (string, error) {
+	candidates := []string{lang}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		candidates = append(candidates, base)
+	}
+	if lang != "" {
+		candidates = append(candidates, "")
+	}
+
+	for _, candidate := range candidates {
+		path, err := as.path(name, candidate)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("asset not found: %s", name)
+}
+
+// List returns all assets, by name and language
+func (as *AssetStore) List() ([]AssetInfo, error) {
+	entries, err := os.ReadDir(as.dir)
+	if os.IsNotExist(err) {
+		return []AssetInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	assets := make([]AssetInfo, 0, len(entries))
+	add := func(dir, lang string, entry os.DirEntry) {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".wav") {
+			return
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		assets = append(assets, as.stat(filepath.Join(dir, entry.Name()), name, lang))
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			add(as.dir, "", entry)
+			continue
+		}
+		if !assetLangPattern.MatchString(entry.Name()) {
+			continue
+		}
+		dir := filepath.Join(as.dir, entry.Name())
+		langEntries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, langEntry := range langEntries {
+			add(dir, entry.Name(), langEntry)
+		}
+	}
+
+	sort.Slice(assets, func(i, j int) bool {
+		if assets[i].Name != assets[j].Name {
+			return assets[i].Name < assets[j].Name
+		}
+		return assets[i].Lang < assets[j].Lang
+	})
+	return assets, nil
+}
+
+// stat describes an asset file and checks that it can be played
+func (as *AssetStore) stat(path, name, lang string) AssetInfo {
+	info := AssetInfo{Name: name, Lang: lang}
+
+	file, err := os.Open(path)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	defer file.Close()
+
+	if fileInfo, err := file.Stat(); err == nil {
+		info.Size = fileInfo.Size()
+		info.Modified = fileInfo.ModTime()
+	}
+
+	format, size, err := readWAVHeader(file)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	if size == wavUnknownSize {
+		offset, _ := file.Seek(0, io.SeekCurrent)
+		size = uint32(info.Size - offset)
+	}
+	info.SampleRate = format.SampleRate
+	info.Channels = format.Channels
+	info.Duration = float64(size) / float64(format.byteRate())
+	info.Ready = format == as.format()
+	if err := validateAssetFormat(format, info.Duration); err != nil {
+		info.Error = err.Error()
+	}
+	return info
+}
+
+// validateAssetFormat checks that a sound can be imported
+func validateAssetFormat(format wavFormat, duration float64) error {
+	if format.Channels < 1 || format.Channels > 2 {
+		return fmt.Errorf("assets must be mono or stereo, not %d channels", format.Channels)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("the file contains no audio")
+	}
+	if duration > maxAssetSeconds {
+		return fmt.Errorf("assets can be at most %d seconds long", maxAssetSeconds)
+	}
+	return nil
+}
+
+// Import validates a WAV file, converts it to the stream's format,
+// normalizes its peak level and stores it as name in lang
+func (as *AssetStore) Import(name, lang string, r io.Reader) (AssetInfo, error) {
+	dst, err := as.path(name, lang)
+	if err != nil {
+		return AssetInfo{}, err
+	}
+
+	format, samples, err := readWAV(r)
+	if err != nil {
+		return AssetInfo{}, fmt.Errorf("not a supported WAV file: %v", err)
+	}
+	if err := validateAssetFormat(format, float64(len(samples))/float64(max(1, format.Channels*format.SampleRate))); err != nil {
+		return AssetInfo{}, err
+	}
+
+	target := as.format()
+	if format.Channels != target.Channels {
+		if samples, err = convertChannels(samples, format.Channels, target.Channels); err != nil {
+			return AssetInfo{}, err
+		}
+	}
+	if format.SampleRate != target.SampleRate {
+		samples = resampleLinear(samples, target.Channels, format.SampleRate, target.SampleRate)
+	}
+	normalizeAssetPeak(samples, as.config.Assets.NormalizeDB)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return AssetInfo{}, fmt.Errorf("failed to create asset directory: %v", err)
+	}
+	var data bytes.Buffer
+	pcm := target.encodeSamples(samples)
+	target.writeHeader(&data, uint32(len(pcm)))
+	data.Write(pcm)
+
+	// Write to a temporary file first so a failed upload can't replace a good asset
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data.Bytes(), 0644); err != nil {
+		return AssetInfo{}, fmt.Errorf("failed to save asset: %v", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return AssetInfo{}, fmt.Errorf("failed to save asset: %v", err)
+	}
+
+	log.Printf("🔔 Asset imported: %s (%s, %d Hz %dch → %d Hz %dch)",
+		name, langOrNeutral(lang), format.SampleRate, format.Channels, target.SampleRate, target.Channels)
+	return as.stat(dst, name, lang), nil
+}
+
+// CheckLibrary logs the assets that can't be played, e.g. files copied
+// into the directory by hand
+func (as *AssetStore) CheckLibrary() {
+	assets, err := as.List()
+	if err != nil {
+		log.Printf("Failed to read assets in %s: %v", as.dir, err)
+		return
+	}
+	for _, asset := range assets {
+		if asset.Error != "" {
+			log.Printf("⚠️ Asset %s (%s) can't be used: %s", asset.Name, langOrNeutral(asset.Lang), asset.Error)
+		}
+	}
+	if len(assets) > 0 {
+		log.Printf("🔔 %d assets in %s", len(assets), as.dir)
+	}
+}
+
+// Delete removes an asset
+func (as *AssetStore) Delete(name, lang string) error {
+	path, err := as.path(name, lang)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// normalizeAssetPeak scales samples so the loudest peak reaches targetDB,
+// so chimes and announcements play at the same level
+func normalizeAssetPeak(samples []float64, targetDB float64) {
+	peak := 0.0
+	for _, sample := range samples {
+		peak = math.Max(peak, math.Abs(sample))
+	}
+	if peak == 0 {
+		return
+	}
+	gain := 32767 * math.Pow(10, targetDB/20) / peak
+	for i := range samples {
+		samples[i] *= gain
+	}
+}
+
+// langOrNeutral names a language for logs
+func langOrNeutral(lang string) string {
+	if lang == "" {
+		return "any language"
+	}
+	return lang
+}
+
+// handleListAssets returns the asset library, optionally one language
+func (as *AssetStore) handleListAssets(w http.ResponseWriter, r *http.Request) {
+	assets, err := as.List()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		filtered := make([]AssetInfo, 0, len(assets))
+		for _, asset := range assets {
+			if asset.Lang == lang {
+				filtered = append(filtered, asset)
+			}
+		}
+		assets = filtered
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"directory": as.dir,
+		"assets":    assets,
+	})
+}
+
+// handleGetAsset plays an asset, in the requested language if there is one
+func (as *AssetStore) handleGetAsset(w http.ResponseWriter, r *http.Request) {
+	path, err := as.Find(r.PathValue("name"), r.URL.Query().Get("lang"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	http.ServeFile(w, r, path)
+}
+
+// handleUploadAsset imports the WAV file in the request body
+func (as *AssetStore) handleUploadAsset(w http.ResponseWriter, r *http.Request) {
+	maxBytes := int64(as.config.Assets.MaxUploadMB * 1024 * 1024)
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("assets can be at most %.0f MB", as.config.Assets.MaxUploadMB))
+		return
+	}
+
+	info, err := as.Import(r.PathValue("name"), r.URL.Query().Get("lang"), bytes.NewReader(data))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// handleDeleteAsset removes an asset in one language
+func (as *AssetStore) handleDeleteAsset(w http.ResponseWriter, r *http.Request) {
+	err := as.Delete(r.PathValue("name"), r.URL.Query().Get("lang"))
+	if os.IsNotExist(err) {
+		writeJSONError(w, http.StatusNotFound, "asset not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
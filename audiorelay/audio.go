@@ -3,7 +3,10 @@ package audiorelay
 import (
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gordonklaus/portaudio"
@@ -12,20 +15,86 @@ import (
 // AudioCapture handles audio capture and processing
 type AudioCapture struct {
 	config *Config
-	stream *portaudio.Stream
+	stream captureStream
+	device *portaudio.DeviceInfo
+
+	// Runtime device switching, handled by the processing loop
+	fader          *Fader
+	switchRequests chan deviceSwitch
+
+	// Idle mode, also handled by the processing loop
+	sleepRequests chan struct{}
+	wakeRequests  chan struct{}
+	asleep        atomic.Bool
 
 	// Audio processing
-	buffer       []int16
-	dataCallback func([]byte)
+	buffer       interface{} // []int16, []int32 or []float32 depending on audio.sample_format
+	output       wavFormat   // Layout of the processed broadcast stream
+	work         []float64
+	pipeline     []pipelineStage
+	noiseGate    *NoiseGate // Consulted by silence detection
+	limiter      *Limiter   // Its ceiling follows clipThreshold
+	dither       *Dither
+	meter        *LevelMeter
+	dataCallback func(data []byte, captured time.Time)
+	faults       *FaultInjector // Developer-mode fault injection, nil otherwise
+
+	// Rate being captured as float64 bits; audio.follow_device_rate changes
+	// it with the device while the shared config keeps audio.sample_rate
+	rate atomic.Uint64
+
+	levelsCallback func(Levels)
+	formatCallback func(wavFormat)
+	stallCallback  func(device string, stalled time.Duration)
+
+	// Tracing of sampled buffers, nil when disabled; trace and
+	// broadcastTrace are only touched by the capture loop
+	tracer         *Tracer
+	trace          *span
+	broadcastTrace *span
+
+	lostCallback      func(device string, reconnecting bool)
+	recoveredCallback func(device string, down time.Duration)
+	silenceCallback   func(silent bool, duration time.Duration)
+	silenceAfter      time.Duration
+	silentFor         time.Duration // Only touched by the capture loop
+	silenceReported   bool
+
+	// Runtime-adjustable processing state
+	channelState ChannelState
+	stereoWidth  float64
+	levels       levelSettings
+	runtimeMu    sync.RWMutex
+
+	// Settings the processing chain is built from; when they change the
+	// capture loop rebuilds the chain before the next buffer
+	processing      ProcessingConfig
+	rebuildPipeline atomic.Bool
 
 	// 添加实际使用的缓冲区大小
 	actualBufferSize int
 
+	// Buffers between keepalive frames while silence is skipped, 0 when disabled
+	keepaliveEvery int
+
 	// Statistics
 	statsMu      sync.RWMutex
 	frameCount   int64
 	bytesSent    int64
 	silenceCount int64
+	inputSilent  int64 // Buffers of silent input, counted with or without silence detection
+
+	// Watchdog state: when the last buffer was read, in Unix nanoseconds,
+	// and whether the watchdog stopped the stream
+	lastRead atomic.Int64
+	stalled  atomic.Bool
+
+	// When the last buffer was read, for readiness checks; unlike lastRead
+	// it isn't reset when the stream is restarted
+	lastBuffer atomic.Int64
+
+	// Most recent failure to open or start the stream, nil once it works
+	lastError atomic.Pointer[CaptureError]
 
 	// Control
 	mu          sync.RWMutex
@@ -33,53 +102,294 @@ type AudioCapture struct {
 	isRunning   bool
 }
 
+// deviceSwitch asks the processing loop to move capture to another device
+type deviceSwitch struct {
+	device *portaudio.DeviceInfo
+	done   chan error
+}
+
+// deviceSwitchTimeout bounds how long SwitchDevice waits for the loop
+const deviceSwitchTimeout = 5 * time.Second
+
 // NewAudioCapture creates a new audio capture instance
 func NewAudioCapture(config *Config) *AudioCapture {
-	return &AudioCapture{
-		config: config,
+	ac := &AudioCapture{
+		config:         config,
+		switchRequests: make(chan deviceSwitch),
+		sleepRequests:  make(chan struct{}, 1),
+		wakeRequests:   make(chan struct{}, 1),
+		channelState:   newChannelState(config.Processing, config.Audio.Channels),
+		stereoWidth:    config.Processing.StereoWidth,
+		levels:         newLevelSettings(config.Processing),
+		processing:     config.Processing,
 	}
+	ac.setSampleRate(config.Audio.SampleRate)
+	return ac
+}
+
+// SampleRate returns the rate being captured
+func (ac *AudioCapture) SampleRate() float64 {
+	return math.Float64frombits(ac.rate.Load())
+}
+
+// setSampleRate changes the rate streams are opened and described with
+func (ac *AudioCapture) setSampleRate(rate float64) {
+	ac.rate.Store(math.Float64bits(rate))
+}
+
+// StreamFormat returns the PCM layout delivered to an endpoint at the rate
+// being captured
+func (ac *AudioCapture) StreamFormat(upmixStereo bool) wavFormat {
+	format := ac.config.StreamFormat(upmixStereo)
+	format.SampleRate = int(ac.SampleRate())
+	return format
 }
 
 // Initialize sets up the audio capture with the selected device
 func (ac *AudioCapture) Initialize(device *portaudio.DeviceInfo) error {
 	// Calculate optimal buffer size for smooth streaming
 	ac.actualBufferSize = ac.calculateOptimalBufferSize()
-	ac.buffer = make([]int16, ac.actualBufferSize)
+	ac.work = make([]float64, ac.actualBufferSize)
+
+	// 24-bit devices deliver their samples in the top bits of an int32
+	switch ac.config.Audio.SampleFormat {
+	case "int24":
+		ac.buffer = make([]int32, ac.actualBufferSize)
+	case "float32":
+		ac.buffer = make([]float32, ac.actualBufferSize)
+	default:
+		ac.buffer = make([]int16, ac.actualBufferSize)
+	}
+
+	// Output format, dither and rate-dependent processing
+	if err := ac.configureProcessing(); err != nil {
+		return err
+	}
 
 	fmt.Printf("🎵 Initializing audio capture:\n")
 	fmt.Printf("   Device: %s\n", device.Name)
-	fmt.Printf("   Sample Rate: %.0f Hz\n", ac.config.Audio.SampleRate)
+	fmt.Printf("   Sample Rate: %.0f Hz\n", ac.SampleRate())
 	fmt.Printf("   Channels: %d\n", ac.config.Audio.Channels)
+	fmt.Printf("   Format: %s capture, %s output\n", ac.config.Audio.SampleFormat, ac.output.describe())
+	if ac.dither != nil {
+		fmt.Printf("   Dither: TPDF, %s shaping\n", ac.config.Processing.Dither.Profile)
+	}
+	if ac.config.Processing.DownmixMono {
+		fmt.Printf("   Output: mono downmix\n")
+	}
 
 	if ac.config.Audio.BufferSize > 0 {
 		fmt.Printf("   Buffer Size: %d samples (configured, %.1f ms)\n",
-			ac.actualBufferSize, float64(ac.actualBufferSize)/ac.config.Audio.SampleRate*1000)
+			ac.actualBufferSize, float64(ac.actualBufferSize)/ac.SampleRate()*1000)
 	} else {
 		fmt.Printf("   Buffer Size: %d samples (auto-calculated, %.1f ms)\n",
-			ac.actualBufferSize, float64(ac.actualBufferSize)/ac.config.Audio.SampleRate*1000)
+			ac.actualBufferSize, float64(ac.actualBufferSize)/ac.SampleRate()*1000)
+	}
+	if device.HostApi != nil && ac.config.Audio.Backend != BackendPulse {
+		fmt.Printf("   Host API: %s, requested latency %.1f ms\n",
+			device.HostApi.Name, ac.inputLatency(device).Seconds()*1000)
 	}
 
 	// Open audio stream
+	stream, err := ac.openStream(device)
+	if err != nil {
+		return err
+	}
+
+	ac.stream = stream
+	ac.device = device
+	return nil
+}
+
+// configureProcessing sets up the processing state that depends on the
+// stream format, so it can be rebuilt when the sample rate follows a device
+func (ac *AudioCapture) configureProcessing() error {
+	ac.output = ac.StreamFormat(false)
+
+	// Dither whenever the output throws away capture resolution
+	ac.dither = nil
+	if dither := ac.config.Processing.Dither; dither.Enabled && !ac.output.Float && ac.output.BitsPerSample < ac.config.CaptureBits() {
+		d, err := NewDither(ac.output.BitsPerSample, ac.output.Channels, dither.Profile)
+		if err != nil {
+			return err
+		}
+		ac.dither = d
+	}
+
+	// Assemble the configured processing stages
+	if err := ac.buildPipeline(); err != nil {
+		return err
+	}
+
+	// Convert the keepalive interval to a buffer count
+	if ka := ac.config.Processing.Keepalive; ka.Enabled {
+		bufferMs := float64(ac.actualBufferSize/ac.config.Audio.Channels) / ac.SampleRate() * 1000
+		ac.keepaliveEvery = max(1, int(math.Round(ka.IntervalMs/bufferMs)))
+	}
+
+	// Fade around device switches instead of cutting abruptly
+	ac.fader = NewFader(ac.SampleRate(), ac.config.OutputChannels(), ac.config.Audio.CrossfadeMs)
+
+	// Meter what listeners actually hear
+	ac.meter = NewLevelMeter(ac.SampleRate(), ac.config.OutputChannels())
+	return nil
+}
+
+// openStream opens an input stream on device with the configured format
+func (ac *AudioCapture) openStream(device *portaudio.DeviceInfo) (captureStream, error) {
+	if ac.config.Audio.Backend == BackendPulse {
+		return ac.openPulseStream()
+	}
+
+	portaudioMu.RLock()
+	defer portaudioMu.RUnlock()
 	stream, err := portaudio.OpenStream(
 		portaudio.StreamParameters{
 			Input: portaudio.StreamDeviceParameters{
 				Device:   device,
 				Channels: ac.config.Audio.Channels,
-				Latency:  device.DefaultLowInputLatency,
+				Latency:  ac.inputLatency(device),
 			},
-			SampleRate:      ac.config.Audio.SampleRate,
-			FramesPerBuffer: len(ac.buffer),
+			SampleRate:      ac.SampleRate(),
+			FramesPerBuffer: ac.actualBufferSize,
 		},
 		ac.buffer,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to open audio stream: %v", err)
+		return nil, ac.captureFailed("open audio stream", device, err)
+	}
+	ac.lastError.Store(nil)
+	openPortAudioStreams.Add(1)
+	return &portaudioStream{Stream: stream}, nil
+}
+
+// Device returns the device currently being captured
+func (ac *AudioCapture) Device() *portaudio.DeviceInfo {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	return ac.device
+}
+
+// SwitchDevice moves capture to another device. While capturing, the output
+// fades out before the switch and back in afterwards so listeners don't hear
+// a click.
+func (ac *AudioCapture) SwitchDevice(device *portaudio.DeviceInfo) error {
+	if !ac.IsCapturing() {
+		return ac.switchStream(device, false)
+	}
+
+	req := deviceSwitch{device: device, done: make(chan error, 1)}
+	timeout := time.NewTimer(deviceSwitchTimeout)
+	defer timeout.Stop()
+
+	select {
+	case ac.switchRequests <- req:
+	case <-timeout.C:
+		return fmt.Errorf("timed out waiting for the capture loop")
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-timeout.C:
+		return fmt.Errorf("timed out switching device")
+	}
+}
+
+// switchStream moves capture to device and reports a resulting change of
+// the output format, so clients can be told before they misread the audio
+func (ac *AudioCapture) switchStream(device *portaudio.DeviceInfo, start bool) error {
+	previous := ac.output
+	err := ac.reopenStream(device, start)
+	if ac.output != previous && ac.formatCallback != nil {
+		ac.formatCallback(ac.output)
+	}
+	return err
+}
+
+// reopenStream replaces the capture stream with one on device, falling back
+// to the previous device if the new one can't be opened
+func (ac *AudioCapture) reopenStream(device *portaudio.DeviceInfo, start bool) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	// Some drivers refuse to open a device twice, so release the old stream first
+	previous := ac.device
+	if ac.stream != nil {
+		ac.stream.Stop()
+		ac.stream.Close()
+		ac.stream = nil
+	}
+
+	// Follow the new device's native rate instead of making it resample
+	rate := ac.SampleRate()
+	if ac.config.Audio.FollowRate && device.DefaultSampleRate > 0 {
+		ac.setSampleRate(device.DefaultSampleRate)
+	}
+
+	stream, err := ac.openStream(device)
+	if err != nil {
+		ac.setSampleRate(rate)
+		ac.fallBack(previous, start)
+		return fmt.Errorf("failed to switch to %s: %v", device.Name, err)
+	}
+
+	if start {
+		if err := stream.Start(); err != nil {
+			stream.Close()
+			ac.setSampleRate(rate)
+			ac.fallBack(previous, start)
+			return ac.captureFailed("start audio stream", device, err)
+		}
 	}
 
 	ac.stream = stream
+	ac.device = device
+	log.Printf("🎤 Capture device switched: %s", device.Name)
+
+	if ac.SampleRate() != rate {
+		log.Printf("🔀 Sample rate changed: %.0f Hz → %.0f Hz", rate, ac.SampleRate())
+		if err := ac.configureProcessing(); err != nil {
+			return err
+		}
+		// The rebuilt fader must not undo the fade-out around the switch
+		ac.fader.Mute()
+	}
 	return nil
 }
 
+// fallBack reopens the previous device after a failed switch. If that fails
+// too the stream stays nil, which the capture loop reads as a lost device.
+// Called with ac.mu held.
+func (ac *AudioCapture) fallBack(previous *portaudio.DeviceInfo, start bool) {
+	if previous == nil {
+		return
+	}
+	stream, err := ac.openStream(previous)
+	if err == nil && start {
+		if err = stream.Start(); err != nil {
+			stream.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to reopen %s: %v", previous.Name, err)
+		return
+	}
+	ac.stream = stream
+}
+
+// read reads the next buffer from the stream. A failed device switch can
+// leave no stream, which counts as a read error so capture reconnects.
+func (ac *AudioCapture) read() error {
+	ac.mu.RLock()
+	stream := ac.stream
+	ac.mu.RUnlock()
+	if stream == nil {
+		return fmt.Errorf("no open capture stream")
+	}
+	return stream.Read()
+}
+
 // calculateOptimalBufferSize calculates the optimal buffer size for smooth streaming
 func (ac *AudioCapture) calculateOptimalBufferSize() int {
 	// 如果配置了 buffer_size 且大于0，使用配置的值（假设配置的是每声道样本数）
@@ -92,7 +402,7 @@ func (ac *AudioCapture) calculateOptimalBufferSize() int {
 	targetLatencySeconds := targetLatencyMs / 1000.0
 
 	// 计算每声道需要的样本数
-	samplesPerChannel := int(ac.config.Audio.SampleRate * targetLatencySeconds)
+	samplesPerChannel := int(ac.SampleRate() * targetLatencySeconds)
 
 	// 调整为2的幂次方（硬件友好）
 	optimalSamplesPerChannel := roundToPowerOfTwo(samplesPerChannel, 256, 2048)
@@ -101,7 +411,7 @@ func (ac *AudioCapture) calculateOptimalBufferSize() int {
 	totalBufferSize := optimalSamplesPerChannel * ac.config.Audio.Channels
 
 	log.Printf("  Buffer calculation: %.0fHz × %.1fms = %d samples/channel → %d total",
-		ac.config.Audio.SampleRate, targetLatencyMs, optimalSamplesPerChannel, totalBufferSize)
+		ac.SampleRate(), targetLatencyMs, optimalSamplesPerChannel, totalBufferSize)
 
 	return totalBufferSize
 }
@@ -122,16 +432,128 @@ func roundToPowerOfTwo(value, min, max int) int {
 	return powerOfTwo
 }
 
+// ChannelState returns the current channel mute/swap state
+func (ac *AudioCapture) ChannelState() ChannelState {
+	ac.runtimeMu.RLock()
+	defer ac.runtimeMu.RUnlock()
+	return ac.channelState.clone()
+}
+
+// SetChannelState changes channel muting and swapping at runtime
+func (ac *AudioCapture) SetChannelState(state ChannelState) error {
+	if len(state.Mute) != ac.config.Audio.Channels || len(state.Invert) != ac.config.Audio.Channels {
+		return fmt.Errorf("mute and invert must list all %d channels", ac.config.Audio.Channels)
+	}
+	if state.Swap && ac.config.Audio.Channels < 2 {
+		return fmt.Errorf("swapping needs at least 2 channels")
+	}
+
+	ac.runtimeMu.Lock()
+	defer ac.runtimeMu.Unlock()
+	ac.channelState = state.clone()
+	return nil
+}
+
+// StereoWidth returns the current stereo width
+func (ac *AudioCapture) StereoWidth() float64 {
+	ac.runtimeMu.RLock()
+	defer ac.runtimeMu.RUnlock()
+	return ac.stereoWidth
+}
+
+// SetStereoWidth changes the stereo width at runtime:
+// 0 is mono, 1 leaves the image untouched and larger values widen it
+func (ac *AudioCapture) SetStereoWidth(width float64) error {
+	if width < 0 || width > maxStereoWidth {
+		return fmt.Errorf("stereo width must be between 0 and %.0f", maxStereoWidth)
+	}
+	if width != 1 && ac.config.Audio.Channels < 2 {
+		return fmt.Errorf("stereo width needs at least 2 channels")
+	}
+
+	ac.runtimeMu.Lock()
+	defer ac.runtimeMu.Unlock()
+	ac.stereoWidth = width
+	return nil
+}
+
+// levelSettings are the level-related processing settings a config reload
+// may change while capturing
+type levelSettings struct {
+	Volume           float64
+	SilenceThreshold int
+	ClipThreshold    int16
+}
+
+// newLevelSettings takes the level settings from the processing config
+func newLevelSettings(p ProcessingConfig) levelSettings {
+	return levelSettings{
+		Volume:           p.VolumeMultiplier,
+		SilenceThreshold: p.SilenceThreshold,
+		ClipThreshold:    p.ClipThreshold,
+	}
+}
+
+// levelSettings returns the current level settings
+func (ac *AudioCapture) levelSettings() levelSettings {
+	ac.runtimeMu.RLock()
+	defer ac.runtimeMu.RUnlock()
+	return ac.levels
+}
+
+// setLevelSettings changes volume, silence threshold and limiter ceiling;
+// they take effect with the next buffer
+func (ac *AudioCapture) setLevelSettings(levels levelSettings) {
+	ac.runtimeMu.Lock()
+	defer ac.runtimeMu.Unlock()
+	ac.levels = levels
+}
+
+// processingSettings returns the settings the processing chain is built from
+func (ac *AudioCapture) processingSettings() ProcessingConfig {
+	ac.runtimeMu.RLock()
+	defer ac.runtimeMu.RUnlock()
+	return ac.processing
+}
+
+// setProcessing changes the settings of the processing stages; the chain
+// is rebuilt with them before the next buffer is processed
+func (ac *AudioCapture) setProcessing(p ProcessingConfig) {
+	ac.runtimeMu.Lock()
+	ac.processing = p
+	ac.runtimeMu.Unlock()
+	ac.rebuildPipeline.Store(true)
+}
+
 // GetActualBufferSize returns the actual buffer size being used
 func (ac *AudioCapture) GetActualBufferSize() int {
 	return ac.actualBufferSize
 }
 
-// SetDataCallback sets the callback function for processed audio data
-func (ac *AudioCapture) SetDataCallback(callback func([]byte)) {
+// SetDataCallback sets the callback function for processed audio data,
+// called with when the buffer it came from was read
+func (ac *AudioCapture) SetDataCallback(callback func(data []byte, captured time.Time)) {
 	ac.dataCallback = callback
 }
 
+// SetFormatCallback sets the callback invoked after the output format changed
+func (ac *AudioCapture) SetFormatCallback(callback func(wavFormat)) {
+	ac.formatCallback = callback
+}
+
+// SetLevelsCallback sets the callback for new level meter readings
+func (ac *AudioCapture) SetLevelsCallback(callback func(Levels)) {
+	ac.levelsCallback = callback
+}
+
+// Levels returns the latest per-channel output levels
+func (ac *AudioCapture) Levels() Levels {
+	if ac.meter == nil {
+		return Levels{}
+	}
+	return ac.meter.Levels()
+}
+
 // Start begins audio capture
 func (ac *AudioCapture) Start() error {
 	ac.mu.Lock()
@@ -142,7 +564,7 @@ func (ac *AudioCapture) Start() error {
 	}
 
 	if err := ac.stream.Start(); err != nil {
-		return fmt.Errorf("failed to start audio stream: %v", err)
+		return ac.captureFailed("start audio stream", ac.device, err)
 	}
 
 	ac.isCapturing = true
@@ -150,6 +572,9 @@ func (ac *AudioCapture) Start() error {
 
 	// Start audio processing loop
 	go ac.processAudio()
+	if ac.config.Audio.Watchdog.Enabled {
+		go ac.watchdog()
+	}
 
 	fmt.Println("√ Audio capture started")
 	return nil
@@ -173,6 +598,12 @@ func (ac *AudioCapture) Stop() {
 		ac.stream = nil
 	}
 
+	// Let a sleeping processing loop exit
+	select {
+	case ac.wakeRequests <- struct{}{}:
+	default:
+	}
+
 	fmt.Println("√ Audio capture stopped")
 }
 
@@ -190,52 +621,154 @@ func (ac *AudioCapture) GetStats() (frames int64, bytes int64, silence int64) {
 	return ac.frameCount, ac.bytesSent, ac.silenceCount
 }
 
+// bufferCounts returns the buffers captured and those of silent input; nil
+// captures count nothing
+func (ac *AudioCapture) bufferCounts() (buffers, silent int64) {
+	if ac == nil {
+		return 0, 0
+	}
+	ac.statsMu.RLock()
+	defer ac.statsMu.RUnlock()
+	return ac.frameCount, ac.inputSilent
+}
+
+// broadcastSpan returns the span of the buffer being handed to the data
+// callback, for the callback to add its own steps to. It is nil unless the
+// buffer is traced, and only valid during the callback.
+func (ac *AudioCapture) broadcastSpan() *span {
+	return ac.broadcastTrace
+}
+
 // processAudio handles the main audio processing loop
 func (ac *AudioCapture) processAudio() {
 	lastStats := time.Now()
 	bytesTransferred := 0
 	silenceFrames := 0
 	consecutiveErrors := 0
+	var pendingSwitch *deviceSwitch
 
 	for ac.isRunning {
-		if err := ac.stream.Read(); err != nil {
+		// Pick up device switch requests; the switch itself waits for the fade-out
+		if pendingSwitch == nil {
+			select {
+			case req := <-ac.switchRequests:
+				pendingSwitch = &req
+				ac.fader.FadeOut()
+			default:
+			}
+		}
+		if pendingSwitch == nil {
+			select {
+			case <-ac.sleepRequests:
+				if !ac.sleep() {
+					return
+				}
+			default:
+			}
+		}
+		if pendingSwitch != nil && ac.fader.Silent() {
+			pendingSwitch.done <- ac.switchStream(pendingSwitch.device, true)
+			pendingSwitch = nil
+			ac.fader.FadeIn()
+		}
+
+		// A buffer skipped below still ends its trace here
+		ac.trace.finish()
+		ac.trace = ac.tracer.startTrace("audio.buffer")
+		if ac.trace != nil {
+			ac.trace.set("device", ac.Device().Name)
+		}
+		readSpan := ac.trace.child("capture.read")
+
+		err := ac.faults.readError()
+		if err == nil {
+			err = ac.read()
+		}
+		readSpan.finish()
+		if err != nil {
+			ac.trace.set("error", err.Error())
+			if ac.stalled.Swap(false) {
+				if !ac.restartStalled() {
+					break
+				}
+				continue
+			}
 			log.Printf("Audio read error: %v", err)
 			consecutiveErrors++
 			if consecutiveErrors > 20 {
-				log.Printf("Too many consecutive errors, stopping audio capture")
-				break
+				// Usually the device was unplugged or the machine slept
+				if !ac.config.Audio.Reconnect.Enabled {
+					log.Printf("Too many consecutive errors, stopping audio capture")
+					if ac.lostCallback != nil {
+						ac.lostCallback(ac.Device().Name, false)
+					}
+					break
+				}
+				if !ac.reconnect() {
+					break
+				}
+				consecutiveErrors = 0
 			}
 			time.Sleep(1 * time.Millisecond)
 			continue
 		}
 		consecutiveErrors = 0
+		ac.markAlive()
+		read := time.Now()
+		ac.lastBuffer.Store(read.UnixNano())
 
 		ac.statsMu.Lock()
 		ac.frameCount++
 		ac.statsMu.Unlock()
 
+		// Silence is judged on the raw input, before processing changes it
+		ac.readInput()
+		rawSilent := ac.isSilence(ac.work)
+		ac.watchSilence(rawSilent)
+		if rawSilent {
+			ac.statsMu.Lock()
+			ac.inputSilent++
+			ac.statsMu.Unlock()
+		}
+
+		// Process every buffer so filter state stays continuous across silence
+		processedBuffer := ac.processAudioData()
+
 		// Silence detection (optional)
 		isSilent := false
 		if ac.config.Processing.SilenceDetection {
-			isSilent = ac.isSilence(ac.buffer)
+			// A fully closed noise gate counts as silence too
+			isSilent = rawSilent || (ac.noiseGate != nil && ac.noiseGate.IsClosed())
 			if isSilent {
 				silenceFrames++
 				ac.statsMu.Lock()
 				ac.silenceCount++
 				ac.statsMu.Unlock()
 
-				// Skip processing during extended silence to save bandwidth
+				// Skip sending during extended silence to save bandwidth, but
+				// keep downstream players' clocks running with occasional frames
 				if silenceFrames > 30 {
-					continue
+					if !ac.keepaliveDue(silenceFrames - 30) {
+						ac.trace.set("skipped", "silence")
+						continue
+					}
+					ac.fillKeepalive(processedBuffer)
 				}
 			} else {
 				silenceFrames = 0
 			}
 		}
 
-		// Process audio data with high quality processing
-		processedBuffer := ac.processAudioData(ac.buffer)
-		audioData := ac.int16ToBytes(processedBuffer)
+		// Injected faults: a stalled encoder, a lost buffer
+		ac.faults.stallEncoder()
+		if ac.faults.dropBuffer() {
+			continue
+		}
+
+		encodeSpan := ac.trace.child("encode")
+		audioData := ac.output.encodeSamples(processedBuffer)
+		encodeSpan.set("bytes", len(audioData))
+		encodeSpan.finish()
 
 		ac.statsMu.Lock()
 		ac.bytesSent += int64(len(audioData))
@@ -245,8 +778,12 @@ func (ac *AudioCapture) processAudio() {
 
 		// Send data via callback (non-blocking)
 		if ac.dataCallback != nil {
-			ac.dataCallback(audioData)
+			ac.broadcastTrace = ac.trace.child("broadcast")
+			ac.dataCallback(audioData, read)
+			ac.broadcastTrace.finish()
+			ac.broadcastTrace = nil
 		}
+		ac.trace.finish()
 
 		// Display statistics periodically
 		if time.Since(lastStats) > 5*time.Second {
@@ -282,54 +819,111 @@ func (ac *AudioCapture) processAudio() {
 	}
 }
 
+// keepaliveDue reports whether a keepalive frame should replace the n-th skipped buffer
+func (ac *AudioCapture) keepaliveDue(n int) bool {
+	return ac.keepaliveEvery > 0 && n%ac.keepaliveEvery == 0
+}
+
+// fillKeepalive overwrites a buffer with digital silence or, if configured,
+// comfort noise at the level of one 16-bit LSB
+func (ac *AudioCapture) fillKeepalive(samples []float64) {
+	for i := range samples {
+		samples[i] = 0
+		if ac.config.Processing.Keepalive.ComfortNoise {
+			samples[i] = rand.Float64() - rand.Float64()
+		}
+	}
+}
+
+// readInput converts the captured buffer to floating point samples in
+// 16-bit full-scale units so every capture format shares one pipeline
+func (ac *AudioCapture) readInput() {
+	switch buffer := ac.buffer.(type) {
+	case []int16:
+		for i, sample := range buffer {
+			ac.work[i] = float64(sample)
+		}
+	case []int32:
+		for i, sample := range buffer {
+			ac.work[i] = float64(sample) / 65536
+		}
+	case []float32:
+		for i, sample := range buffer {
+			ac.work[i] = float64(sample) * 32768
+		}
+	}
+}
+
 // isSilence checks if the audio buffer contains silence with improved detection
-func (ac *AudioCapture) isSilence(buffer []int16) bool {
+func (ac *AudioCapture) isSilence(samples []float64) bool {
 	// Use configured silence threshold
-	threshold := int16(ac.config.Processing.SilenceThreshold)
+	threshold := float64(ac.levelSettings().SilenceThreshold)
 
-	for i := 0; i < len(buffer); i++ {
-		if buffer[i] > threshold || buffer[i] < -threshold {
+	for _, sample := range samples {
+		if sample > threshold || sample < -threshold {
 			return false
 		}
 	}
 	return true
 }
 
-// processAudioData applies high-quality audio processing
-func (ac *AudioCapture) processAudioData(buffer []int16) []int16 {
-	processed := make([]int16, len(buffer))
+// processAudioData applies high-quality audio processing to the captured
+// samples in ac.work. Working in floating point means stages don't accumulate
+// rounding errors; quantization happens once when the output is encoded.
+func (ac *AudioCapture) processAudioData() []float64 {
+	process := ac.trace.child("process")
+	defer process.finish()
+
+	if ac.rebuildPipeline.Swap(false) {
+		if err := ac.buildPipeline(); err != nil {
+			log.Printf("⚠️ Failed to rebuild the processing chain, keeping the previous one: %v", err)
+		}
+	}
 
-	// Use high-quality processing with minimal distortion
-	for i := range buffer {
-		// Apply volume adjustment with smooth curve
-		sample := float64(buffer[i])
+	samples := ac.work
+	if ac.limiter != nil {
+		ac.limiter.ceiling = float64(ac.levelSettings().ClipThreshold)
+	}
+	for _, stage := range ac.pipeline {
+		stageSpan := process.child("process." + stage.name)
+		samples = stage.processor.Process(samples)
+		stageSpan.finish()
+	}
 
-		// Gentle volume adjustment to preserve dynamics
-		sample = sample * ac.config.Processing.VolumeMultiplier
+	// Measure output levels before dither noise is added
+	if ac.meter.Process(samples) && ac.levelsCallback != nil {
+		ac.levelsCallback(ac.meter.Levels())
+	}
 
-		// Soft clipping to prevent harsh distortion
-		if sample > float64(ac.config.Processing.ClipThreshold) {
-			// Soft clip: gradual roll-off instead of hard limit
-			excess := sample - float64(ac.config.Processing.ClipThreshold)
-			sample = float64(ac.config.Processing.ClipThreshold) + excess*0.3
-		} else if sample < -float64(ac.config.Processing.ClipThreshold) {
-			excess := sample + float64(ac.config.Processing.ClipThreshold)
-			sample = -float64(ac.config.Processing.ClipThreshold) + excess*0.3
-		}
+	// Fade around device switches
+	ac.fader.Process(samples)
 
-		processed[i] = int16(sample)
+	// Decorrelate (and optionally shape) quantization error when dropping bits
+	if ac.dither != nil {
+		ac.dither.Process(samples)
 	}
 
-	return processed
+	return samples
 }
 
-// int16ToBytes converts int16 audio samples to byte array (little-endian)
-func (ac *AudioCapture) int16ToBytes(buffer []int16) []byte {
-	bytes := make([]byte, len(buffer)*2)
-	for i, sample := range buffer {
-		// Little-endian format (standard for WAV, Web Audio API, etc.)
-		bytes[i*2] = byte(sample & 0xFF)
-		bytes[i*2+1] = byte((sample >> 8) & 0xFF)
+// floatToInt16 rounds and saturates a sample to the int16 range
+func floatToInt16(sample float64) int16 {
+	if sample >= 32767 {
+		return 32767
+	}
+	if sample <= -32768 {
+		return -32768
+	}
+	return int16(math.Round(sample))
+}
+
+// floatToInt24 rounds and saturates a sample to the signed 24-bit range
+func floatToInt24(sample float64) int32 {
+	if sample >= 8388607 {
+		return 8388607
+	}
+	if sample <= -8388608 {
+		return -8388608
 	}
-	return bytes
+	return int32(math.Round(sample))
 }
@@ -0,0 +1,234 @@
+package audiorelay
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Endpoint groups with their own credentials
+const (
+	authStreams = "streams"
+	authStatus  = "status"
+	authAdmin   = "admin"
+)
+
+// AuthConfig protects the HTTP endpoints, per group. A group without
+// tokens or users is open to anyone who can reach the server.
+type AuthConfig struct {
+	Streams AuthGroupConfig `mapstructure:"streams" desc:"Web player, /stream.wav, /capture.wav and named streams"`
+	Status  AuthGroupConfig `mapstructure:"status" desc:"/status, /clients, /levels, /events, /debug, /devices and /streams"`
+	Admin   AuthGroupConfig `mapstructure:"admin" desc:"The /api/v1 endpoints"`
+}
+
+// AuthGroupConfig lists the credentials accepted for one endpoint group
+type AuthGroupConfig struct {
+	Tokens []string `mapstructure:"tokens" secret:"true" desc:"Tokens sent as Authorization: Bearer, as ?token= or as a basic auth password; empty with users leaves the group open"`
+	Users  []string `mapstructure:"users" secret:"true" desc:"Basic auth logins as user:password"`
+}
+
+// enabled reports whether the group requires credentials
+func (g AuthGroupConfig) enabled() bool {
+	return len(g.Tokens) > 0 || len(g.Users) > 0
+}
+
+// validate checks the group's credentials
+func (g AuthGroupConfig) validate() error {
+	for _, token := range g.Tokens {
+		if token == "" {
+			return fmt.Errorf("empty token")
+		}
+	}
+	for _, login := range g.Users {
+		if user, password, ok := strings.Cut(login, ":"); !ok || user == "" || password == "" {
+			return fmt.Errorf("user %q must be user:password", user)
+		}
+	}
+	return nil
+}
+
+// Validate checks the credentials of every group
+func (a AuthConfig) Validate() error {
+	for name, group := range a.groups() {
+		if err := group.validate(); err != nil {
+			return fmt.Errorf("auth %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// groups returns the endpoint groups by name
+func (a AuthConfig) groups() map[string]AuthGroupConfig {
+	return map[string]AuthGroupConfig{authStreams: a.Streams, authStatus: a.Status, authAdmin: a.Admin}
+}
+
+// authGroup returns the endpoint group of a request path, or "" for pages
+// that are public by design, such as share links with their own PIN, the
+// web interface's scripts and styles and the health checks, which hold no
+// audio or settings
+func authGroup(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/share/"), strings.HasPrefix(path, "/static/"), path == "/healthz", path == "/readyz":
+		return ""
+	case strings.HasPrefix(path, "/api/"):
+		return authAdmin
+	case path == "/status", path == "/clients", path == "/metrics", path == "/stats", path == "/levels", path == "/events", path == "/debug", path == "/devices", path == "/streams",
+		strings.HasPrefix(path, "/streams/") && strings.HasSuffix(path, "/status"):
+		return authStatus
+	default:
+		return authStreams
+	}
+}
+
+// Authenticator checks the credentials of HTTP requests
+type Authenticator struct {
+	groups map[string]AuthGroupConfig
+	// adminFrom names the group whose credentials the admin group inherited
+	adminFrom string
+}
+
+// NewAuthenticator creates an authenticator for the protected groups in
+// config, or returns nil if every group is open. Once any group is
+// protected the admin group is too: without credentials of its own it
+// takes those of status, else of streams, so the API can't be used to read
+// the credentials or share the protected streams.
+func NewAuthenticator(config AuthConfig) *Authenticator {
+	groups := make(map[string]AuthGroupConfig)
+	for name, group := range config.groups() {
+		if group.enabled() {
+			groups[name] = group
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	a := &Authenticator{groups: groups}
+	if _, ok := groups[authAdmin]; !ok {
+		for _, name := range []string{authStatus, authStreams} {
+			if group, ok := groups[name]; ok {
+				groups[authAdmin], a.adminFrom = group, name
+				break
+			}
+		}
+	}
+	return a
+}
+
+// Protected returns the names of the groups that require credentials
+func (a *Authenticator) Protected() []string {
+	var names []string
+	if a == nil {
+		return names
+	}
+	for _, name := range []string{authStreams, authStatus, authAdmin} {
+		if _, ok := a.groups[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// protects reports whether the group requires credentials
+func (a *Authenticator) protects(group string) bool {
+	if a == nil {
+		return false
+	}
+	_, ok := a.groups[group]
+	return ok
+}
+
+// Wrap returns a handler that rejects requests without valid credentials
+// for their endpoint group before passing them on to next
+func (a *Authenticator) Wrap(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group, ok := a.groups[authGroup(r.URL.Path)]
+		if !ok || group.allows(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		debugf("🔐 Rejected %s %s from %s: missing or wrong credentials", r.Method, r.URL.Path, r.RemoteAddr)
+		if len(group.Users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="audiorelay", charset="UTF-8"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="audiorelay"`)
+		}
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+	})
+}
+
+// redactedQuery returns the query of u for logging, with the stream token
+// and share PIN replaced by redactedValue
+func redactedQuery(u *url.URL) string {
+	query := u.Query()
+	for _, name := range []string{"token", "pin"} {
+		if query.Has(name) {
+			query.Set(name, redactedValue)
+		}
+	}
+	return query.Encode()
+}
+
+// allows reports whether r carries credentials of the group
+func (g AuthGroupConfig) allows(r *http.Request) bool {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return g.validToken(token)
+	}
+	auth := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return g.validToken(strings.TrimSpace(token))
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		// Players that only take user:password@host URLs can send a token
+		// as the password
+		return g.validLogin(user, password) || g.validToken(password)
+	}
+	return false
+}
+
+// validToken reports whether token is one of the group's tokens
+func (g AuthGroupConfig) validToken(token string) bool {
+	valid := false
+	for _, t := range g.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// validLogin reports whether user and password match one of the group's users
+func (g AuthGroupConfig) validLogin(user, password string) bool {
+	valid := false
+	for _, login := range g.Users {
+		u, p, _ := strings.Cut(login, ":")
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if userOK && passwordOK {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// logAuth reports which endpoint groups are protected
+func logAuth(auth *Authenticator, tls bool) {
+	protected := auth.Protected()
+	if len(protected) == 0 {
+		return
+	}
+	log.Printf("🔐 Authentication required for: %s", strings.Join(protected, ", "))
+	if auth.adminFrom != "" {
+		log.Printf("🔐 auth.admin is empty, the admin API accepts the auth.%s credentials", auth.adminFrom)
+	}
+	if !tls {
+		log.Printf("⚠️ Credentials are sent in plain text; enable protocols.http.tls on untrusted networks")
+	}
+}
@@ -0,0 +1,156 @@
+package audiorelay
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+	"github.com/spf13/viper"
+)
+
+// Bounds for the recommended volume_multiplier
+const (
+	minCalibrationGain = 0.1
+	maxCalibrationGain = 10.0
+)
+
+// Calibration holds input levels measured over a calibration run and the
+// gain settings recommended for the requested headroom
+type Calibration struct {
+	Seconds    float64 `json:"seconds"`
+	PeakDB     float64 `json:"peak_db"`
+	RMSDB      float64 `json:"rms_db"`
+	HeadroomDB float64 `json:"headroom_db"`
+
+	VolumeMultiplier float64 `json:"volume_multiplier"`
+	ClipThreshold    int16   `json:"clip_threshold"`
+}
+
+// recommend derives volume_multiplier and clip_threshold from the measured
+// peak: the gain brings the loudest peak to HeadroomDB below full scale and
+// the limiter sits halfway between that peak and full scale, so it only
+// catches material louder than anything seen during calibration.
+func (c *Calibration) recommend(peak float64) {
+	gain := 32767 * math.Pow(10, -c.HeadroomDB/20) / peak
+	c.VolumeMultiplier = math.Round(max(minCalibrationGain, min(maxCalibrationGain, gain))*100) / 100
+	c.ClipThreshold = int16(math.Round(32767 * math.Pow(10, -c.HeadroomDB/40)))
+}
+
+// Calibrate measures the raw input level for the given duration. The stream
+// must be open but not started; processing and broadcasting are bypassed.
+func (ac *AudioCapture) Calibrate(seconds, headroomDB float64) (*Calibration, error) {
+	if err := ac.stream.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start audio stream: %v", err)
+	}
+	defer ac.stream.Stop()
+
+	peak, sumSquares, count := 0.0, 0.0, 0
+	deadline := time.Now().Add(time.Duration(seconds * float64(time.Second)))
+	for time.Now().Before(deadline) {
+		if err := ac.stream.Read(); err != nil {
+			return nil, fmt.Errorf("failed to read audio: %v", err)
+		}
+		ac.readInput()
+
+		for _, sample := range ac.work {
+			peak = math.Max(peak, math.Abs(sample))
+			sumSquares += sample * sample
+		}
+		count += len(ac.work)
+		fmt.Printf("\r%s %6.1f dBFS peak", levelBar(levelDB(peak), 40), levelDB(peak))
+	}
+	fmt.Println()
+
+	if peak == 0 {
+		return nil, fmt.Errorf("no signal captured, play typical material while calibrating")
+	}
+
+	cal := &Calibration{
+		Seconds:    seconds,
+		PeakDB:     math.Round(levelDB(peak)*10) / 10,
+		RMSDB:      math.Round(levelDB(math.Sqrt(sumSquares/float64(count)))*10) / 10,
+		HeadroomDB: headroomDB,
+	}
+	cal.recommend(peak)
+	return cal, nil
+}
+
+// runCalibrateCommand handles "audiorelay calibrate"
+func runCalibrateCommand(opts Options, args []string) error {
+	flags := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	seconds := flags.Float64("seconds", 10, "measurement duration")
+	headroom := flags.Float64("headroom", 6, "target headroom below full scale in dB")
+	apply := flags.Bool("apply", false, "write the recommended settings to the config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *seconds <= 0 {
+		return fmt.Errorf("seconds must be positive")
+	}
+	if *headroom < 0 || *headroom > 40 {
+		return fmt.Errorf("headroom must be between 0 and 40 dB")
+	}
+
+	config, err := LoadConfigWithFlags(opts.ConfigPath, opts.Flags)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	config.ApplyDeviceFlag(opts.Device)
+
+	if err := portaudio.Initialize(); err != nil {
+		return fmt.Errorf("PortAudio initialization failed: %v", err)
+	}
+	defer portaudio.Terminate()
+
+	// Capture from the same device the service would use
+	relay := New(config, emptyFS{})
+	if config.Audio.Backend != BackendPulse {
+		if err := relay.deviceMgr.Initialize(); err != nil {
+			return fmt.Errorf("failed to initialize device manager: %v", err)
+		}
+	}
+	device, err := relay.selectAudioDevice()
+	if err != nil {
+		return fmt.Errorf("failed to select audio device: %v", err)
+	}
+	if err := relay.audioCapture.Initialize(device); err != nil {
+		return fmt.Errorf("failed to initialize audio capture: %v", err)
+	}
+	defer relay.audioCapture.stream.Close()
+
+	fmt.Printf("🎚 Calibrating for %.0f seconds, play typical (loud) material now...\n", *seconds)
+	cal, err := relay.audioCapture.Calibrate(*seconds, *headroom)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("   Peak: %.1f dBFS, RMS: %.1f dBFS\n", cal.PeakDB, cal.RMSDB)
+	fmt.Printf("   Recommended for %.1f dB headroom:\n", cal.HeadroomDB)
+	fmt.Printf("     volume_multiplier: %.2f (currently %.2f)\n", cal.VolumeMultiplier, config.Processing.VolumeMultiplier)
+	fmt.Printf("     clip_threshold: %d (currently %d)\n", cal.ClipThreshold, config.Processing.ClipThreshold)
+	if cal.VolumeMultiplier == maxCalibrationGain {
+		fmt.Println("   Warning: input is very quiet, consider raising the level at the source")
+	}
+
+	if !*apply {
+		fmt.Println("   Run with -apply to write these settings")
+		return nil
+	}
+
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(opts.ConfigPath)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	v.Set("processing.volume_multiplier", cal.VolumeMultiplier)
+	v.Set("processing.clip_threshold", cal.ClipThreshold)
+	if err := v.WriteConfigAs(opts.ConfigPath); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	fmt.Printf("√ Settings written to %s\n", opts.ConfigPath)
+	return nil
+}
@@ -0,0 +1,153 @@
+package audiorelay
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Per-packet costs used by the capacity estimate, at the IP layer
+const (
+	tcpSegmentPayload  = 1460 // MSS on a 1500-byte MTU
+	tcpSegmentOverhead = 40   // IPv4 and TCP headers
+	httpChunkOverhead  = 8    // Chunk size line and trailing CRLF
+)
+
+// endpointCapacity is the estimated listener capacity of one endpoint
+type endpointCapacity struct {
+	Name        string
+	Format      wavFormat
+	WireBps     float64 // Bits per second per listener, including overhead
+	Max         int     // Listeners that saturate the uplink
+	Recommended int     // Listeners within the headroom
+	LatencyMs   float64 // Delay behind live at the recommended load
+}
+
+// runCapacityCommand handles "audiorelay capacity"
+func runCapacityCommand(opts Options, args []string) error {
+	flags := flag.NewFlagSet("capacity", flag.ContinueOnError)
+	uplink := flags.String("uplink", "", "upload bandwidth, e.g. 20mbps, 800kbps")
+	headroom := flags.Float64("headroom", 0.75, "share of the uplink to plan for, leaving room for retransmits and other traffic")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *uplink == "" {
+		return fmt.Errorf("usage: audiorelay capacity --uplink 20mbps")
+	}
+	uplinkBps, err := parseBitrate(*uplink)
+	if err != nil {
+		return err
+	}
+	if *headroom <= 0 || *headroom > 1 {
+		return fmt.Errorf("headroom must be between 0 and 1")
+	}
+
+	config, err := LoadConfigWithFlags(opts.ConfigPath, opts.Flags)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+
+	estimates := estimateCapacity(config, uplinkBps, *headroom)
+	if len(estimates) == 0 {
+		return fmt.Errorf("no protocols are enabled")
+	}
+
+	fmt.Printf("📶 Uplink %.1f Mbit/s, planning for %.0f%%\n\n", uplinkBps/1e6, *headroom*100)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Endpoint\tFormat\tPer listener\tMax\tRecommended\tLatency")
+	for _, e := range estimates {
+		fmt.Fprintf(tw, "%s\t%d Hz %dch %s\t%.2f Mbit/s\t%d\t%d\t%.0f ms\n",
+			e.Name, e.Format.SampleRate, e.Format.Channels, e.Format.describe(),
+			e.WireBps/1e6, e.Max, e.Recommended, e.LatencyMs)
+	}
+	tw.Flush()
+
+	fmt.Println("\nOverhead is computed from the protocol framing (IPv4/TCP headers, HTTP chunks, relay UDP headers);")
+	fmt.Println("TLS, retransmits and silence skipping are not included. UDP sends to fixed targets, so its count is targets.")
+	if len(config.Streams) > 0 {
+		fmt.Printf("Named streams (%d) share the same uplink; the counts are for the main stream alone.\n", len(config.Streams))
+	}
+	return nil
+}
+
+// estimateCapacity computes how many listeners each enabled endpoint
+// supports on an uplink of uplinkBps, with the latency at that load
+func estimateCapacity(config *Config, uplinkBps, headroom float64) []endpointCapacity {
+	// Audio is sent one capture buffer at a time
+	frames := NewAudioCapture(config).calculateOptimalBufferSize() / config.Audio.Channels
+	bufferMs := float64(frames) / config.Audio.SampleRate * 1000
+
+	estimate := func(name string, format wavFormat, prebufferMs float64, overhead func(bufBytes int) int) endpointCapacity {
+		bufBytes := frames * format.blockAlign()
+		writesPerSec := 1000 / bufferMs
+		wireBytes := float64(bufBytes + overhead(bufBytes))
+
+		e := endpointCapacity{
+			Name:    name,
+			Format:  format,
+			WireBps: wireBytes * 8 * writesPerSec,
+		}
+		e.Max = int(uplinkBps / e.WireBps)
+		e.Recommended = int(uplinkBps * headroom / e.WireBps)
+
+		// Each buffer is written to every listener in turn, so the last one
+		// gets it only after all the others have been sent
+		sendMs := float64(e.Recommended) * wireBytes * 8 / uplinkBps * 1000
+		e.LatencyMs = bufferMs + prebufferMs + sendMs
+		return e
+	}
+
+	tcpOverhead := func(bytes int) int {
+		return int(math.Ceil(float64(bytes)/tcpSegmentPayload)) * tcpSegmentOverhead
+	}
+
+	var estimates []endpointCapacity
+	if config.Protocols.HTTP.Enabled {
+		format := config.StreamFormat(config.Protocols.HTTP.UpmixStereo)
+		estimates = append(estimates, estimate("http", format, config.Protocols.HTTP.PrebufferMs, func(bytes int) int {
+			return httpChunkOverhead + tcpOverhead(bytes+httpChunkOverhead)
+		}))
+	}
+	if config.Protocols.TCP.Enabled {
+		format := config.StreamFormat(config.Protocols.TCP.UpmixStereo)
+		estimates = append(estimates, estimate("tcp", format, config.Protocols.TCP.PrebufferMs, tcpOverhead))
+	}
+	if config.Protocols.UDP.Enabled {
+		format := config.StreamFormat(config.Protocols.UDP.UpmixStereo)
+		maxPayload := (config.Protocols.UDP.MTU - ipv4Overhead - udpHeaderSize) / format.blockAlign() * format.blockAlign()
+		estimates = append(estimates, estimate("udp", format, 0, func(bytes int) int {
+			packets := int(math.Ceil(float64(bytes) / float64(max(1, maxPayload))))
+			return packets * (ipv4Overhead + udpHeaderSize)
+		}))
+	}
+	return estimates
+}
+
+// parseBitrate parses a bandwidth such as 20mbps, 800kbps, 1.5Mbit or 1g;
+// a plain number is in Mbit/s
+func parseBitrate(s string) (float64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	for _, suffix := range []string{"/s", "ps", "bit", "b"} {
+		v = strings.TrimSuffix(v, suffix)
+	}
+
+	multiplier := 1e6
+	switch {
+	case strings.HasSuffix(v, "k"):
+		multiplier, v = 1e3, strings.TrimSuffix(v, "k")
+	case strings.HasSuffix(v, "m"):
+		v = strings.TrimSuffix(v, "m")
+	case strings.HasSuffix(v, "g"):
+		multiplier, v = 1e9, strings.TrimSuffix(v, "g")
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth: %q (e.g. 20mbps, 800kbps)", s)
+	}
+	return value * multiplier, nil
+}
@@ -0,0 +1,88 @@
+package audiorelay
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxCaptureSeconds bounds one-shot captures, which are held in memory
+const maxCaptureSeconds = 300
+
+// handleCapture records the next N seconds of audio and returns them as a
+// complete, correctly-sized WAV file
+func (hs *HTTPServer) handleCapture(w http.ResponseWriter, r *http.Request) {
+	seconds := 10.0
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed <= 0 || parsed > maxCaptureSeconds {
+			http.Error(w, "seconds must be between 0 and "+strconv.Itoa(maxCaptureSeconds), http.StatusBadRequest)
+			return
+		}
+		seconds = parsed
+	}
+	trim, _ := strconv.ParseBool(r.URL.Query().Get("trim"))
+
+	// Fixed up front: a format change mid-capture ends it early
+	format := hs.wavFormat()
+	blockAlign := int64(format.blockAlign())
+	limit := int64(seconds*float64(format.SampleRate)) * blockAlign
+	if limit <= 0 {
+		http.Error(w, "capture is shorter than one audio frame", http.StatusBadRequest)
+		return
+	}
+	if hs.turnAway(w, r) {
+		return
+	}
+
+	hs.listenerConnected()
+	started := time.Now()
+	debugf("HTTP capture %s: %.1fs", r.RemoteAddr, seconds)
+
+	var data bytes.Buffer
+	data.Grow(int(limit))
+	client := newStreamClient(&data, limit)
+	hs.addStreamClient(client)
+
+	// Silence skipping can pause the stream, so allow generous slack
+	timeout := time.NewTimer(time.Duration(seconds*2*float64(time.Second)) + 5*time.Second)
+	defer timeout.Stop()
+
+	reason := streamEndLimit
+	select {
+	case <-client.done:
+		// Cut short by a format change, return the audio so far
+		if client.endReason != "" {
+			reason = client.endReason
+		}
+	case <-timeout.C:
+		reason = streamEndTimeout
+	case <-hs.shutdown:
+		// Return what was captured so far
+		reason = streamEndShutdown
+	case <-r.Context().Done():
+		hs.removeStreamClient(client)
+		requestAccess(r).streamEnded(streamEndClientLeft, 0)
+		return
+	}
+	hs.removeStreamClient(client)
+	requestAccess(r).streamEnded(reason, 0)
+
+	// The client is detached, so the buffer is no longer written to
+	audio := data.Bytes()
+	if trim {
+		audio = trimSilentPCM(audio, format, hs.audioCapture.levelSettings().SilenceThreshold)
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Length", strconv.Itoa(format.headerSize()+len(audio)))
+	w.Header().Set("Cache-Control", "no-cache")
+	setFilename(w, r, "capture-"+started.Format(downloadTimeFormat)+".wav")
+
+	format.writeHeader(w, uint32(len(audio)))
+	w.Write(audio)
+
+	log.Printf("🎙 Capture finished: %s (%d bytes)", r.RemoteAddr, len(audio))
+}
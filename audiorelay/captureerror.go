@@ -0,0 +1,125 @@
+package audiorelay
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// Kinds of capture failure, for errors.Is
+var (
+	ErrDeviceUnavailable  = errors.New("audio device unavailable")
+	ErrInvalidSampleRate  = errors.New("sample rate not supported")
+	ErrInvalidChannels    = errors.New("channel count not supported")
+	ErrSampleFormat       = errors.New("sample format not supported")
+	ErrInvalidBufferSize  = errors.New("buffer size not supported")
+	ErrNoInputDevice      = errors.New("no input device")
+	ErrAudioHostError     = errors.New("audio driver error")
+	ErrAudioNotAvailable  = errors.New("audio system not available")
+	ErrCaptureUnavailable = errors.New("capture failed")
+)
+
+// CaptureError is a failure to open or start a capture stream, with a hint
+// on what the user can change to fix it
+type CaptureError struct {
+	Op     string // What failed, e.g. "open audio stream"
+	Device string
+	Kind   error // One of the Err* kinds above
+	Hint   string
+	Err    error // The underlying PortAudio error
+	Time   time.Time
+}
+
+// Error describes the failure, with the hint on a second line
+func (e *CaptureError) Error() string {
+	msg := fmt.Sprintf("failed to %s on %s: %v", e.Op, e.Device, e.Err)
+	if e.Hint != "" {
+		msg += "\n   💡 " + e.Hint
+	}
+	return msg
+}
+
+// Unwrap returns the kind and the PortAudio error, so errors.Is matches both
+func (e *CaptureError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// info returns the error as reported in /status
+func (e *CaptureError) info() map[string]interface{} {
+	return map[string]interface{}{
+		"kind":    e.Kind.Error(),
+		"message": fmt.Sprintf("failed to %s on %s: %v", e.Op, e.Device, e.Err),
+		"hint":    e.Hint,
+		"time":    e.Time,
+	}
+}
+
+// newCaptureError classifies a PortAudio error from opening or starting a
+// stream on device with the configured format
+func newCaptureError(op string, device *portaudio.DeviceInfo, config *Config, err error) *CaptureError {
+	ce := &CaptureError{
+		Op:     op,
+		Device: device.Name,
+		Kind:   ErrCaptureUnavailable,
+		Err:    err,
+		Time:   time.Now(),
+	}
+
+	var hostErr portaudio.UnanticipatedHostError
+	if errors.As(err, &hostErr) {
+		ce.Kind = ErrAudioHostError
+		ce.Err = fmt.Errorf("%w (host error %d)", hostErr, hostErr.Code)
+		ce.Hint = "The audio driver rejected the stream. Check that the device is plugged in and not used exclusively " +
+			"by another application, try another audio.host_api, or restart the system's audio service."
+		return ce
+	}
+
+	var paErr portaudio.Error
+	if !errors.As(err, &paErr) {
+		return ce
+	}
+	switch paErr {
+	case portaudio.DeviceUnavailable:
+		ce.Kind = ErrDeviceUnavailable
+		ce.Hint = "The device was unplugged or another application holds it exclusively. Close that application " +
+			"(or turn off exclusive mode in the system sound settings), or pick another device with --device."
+	case portaudio.InvalidSampleRate:
+		ce.Kind = ErrInvalidSampleRate
+		ce.Hint = fmt.Sprintf("The device doesn't support %.0f Hz. Set audio.sample_rate to %.0f (its default rate), "+
+			"or enable audio.follow_device_rate.", config.Audio.SampleRate, device.DefaultSampleRate)
+	case portaudio.InvalidChannelCount:
+		ce.Kind = ErrInvalidChannels
+		ce.Hint = fmt.Sprintf("The device has %d input channels but audio.channels is %d. Lower audio.channels, "+
+			"or choose a device with more inputs.", device.MaxInputChannels, config.Audio.Channels)
+	case portaudio.SampleFormatNotSupported:
+		ce.Kind = ErrSampleFormat
+		ce.Hint = fmt.Sprintf("The device can't capture %s. Set audio.sample_format to int16.", config.Audio.SampleFormat)
+	case portaudio.BufferTooBig, portaudio.BufferTooSmall:
+		ce.Kind = ErrInvalidBufferSize
+		ce.Hint = "The driver refused the buffer size. Set audio.buffer_size to 0 to size it automatically."
+	case portaudio.InvalidDevice, portaudio.NoDefaultInputDevice:
+		ce.Kind = ErrNoInputDevice
+		ce.Hint = "The device no longer exists. Run with --device to pick one from the current device list."
+	case portaudio.NotInitialized, portaudio.HostApiNotFound, portaudio.InvalidHostApi:
+		ce.Kind = ErrAudioNotAvailable
+		ce.Hint = "The audio system isn't available. Check audio.host_api and that the sound service is running."
+	case portaudio.IncompatibleHostApiSpecificStreamInfo, portaudio.BadIODeviceCombination:
+		ce.Hint = "The device's driver doesn't accept these settings. Try another audio.host_api or the default audio.latency_ms."
+	}
+	return ce
+}
+
+// captureFailed records a capture error for /status and returns it
+func (ac *AudioCapture) captureFailed(op string, device *portaudio.DeviceInfo, err error) error {
+	ce := newCaptureError(op, device, ac.config, err)
+	ac.lastError.Store(ce)
+	return ce
+}
+
+// LastError returns the most recent capture failure, or nil if the stream
+// has opened fine since
+func (ac *AudioCapture) LastError() *CaptureError {
+	return ac.lastError.Load()
+}
@@ -0,0 +1,184 @@
+package audiorelay
+
+import "math"
+
+// downmixMono mixes interleaved channels into mono in place and returns the
+// shortened slice. Stereo uses the standard -3 dB pan law (L+R)/sqrt(2).
+func downmixMono(samples []float64, channels int) []float64 {
+	if channels <= 1 {
+		return samples
+	}
+
+	// Equal-power scaling generalises the pan law to any channel count
+	scale := 1 / math.Sqrt(float64(channels))
+	frames := len(samples) / channels
+	for f := 0; f < frames; f++ {
+		sum := 0.0
+		for c := 0; c < channels; c++ {
+			sum += samples[f*channels+c]
+		}
+		samples[f] = sum * scale
+	}
+	return samples[:frames]
+}
+
+// duplicateChannels copies each mono sample of sampleBytes bytes to the given
+// number of channels, e.g. so browsers don't play a mono microphone hard-panned left
+func duplicateChannels(data []byte, channels, sampleBytes int) []byte {
+	out := make([]byte, len(data)*channels)
+	for i := 0; i+sampleBytes <= len(data); i += sampleBytes {
+		for c := 0; c < channels; c++ {
+			copy(out[i*channels+c*sampleBytes:], data[i:i+sampleBytes])
+		}
+	}
+	return out
+}
+
+// ChannelState is the runtime channel routing applied before any downmix
+type ChannelState struct {
+	Mute   []bool `json:"mute"`   // Muted capture channels, by index
+	Invert []bool `json:"invert"` // Polarity-inverted capture channels, by index
+	Swap   bool   `json:"swap"`   // Swap the first two channels (left/right)
+}
+
+// newChannelState returns the channel routing set in the processing config
+// for a capture of channels channels; out-of-range channels are ignored
+func newChannelState(p ProcessingConfig, channels int) ChannelState {
+	state := ChannelState{
+		Mute:   make([]bool, channels),
+		Invert: make([]bool, channels),
+		Swap:   p.SwapChannels,
+	}
+	for _, ch := range p.MuteChannels {
+		if ch >= 0 && ch < len(state.Mute) {
+			state.Mute[ch] = true
+		}
+	}
+	for _, ch := range p.InvertChannels {
+		if ch >= 0 && ch < len(state.Invert) {
+			state.Invert[ch] = true
+		}
+	}
+	return state
+}
+
+// clone returns a deep copy safe to hand out of a lock
+func (cs ChannelState) clone() ChannelState {
+	return ChannelState{
+		Mute:   append([]bool(nil), cs.Mute...),
+		Invert: append([]bool(nil), cs.Invert...),
+		Swap:   cs.Swap,
+	}
+}
+
+// channelGains folds mute and polarity into one multiplier per channel
+func (cs ChannelState) channelGains(channels int) ([]float64, bool) {
+	gains := make([]float64, channels)
+	active := false
+	for c := range gains {
+		gains[c] = 1
+		if c < len(cs.Invert) && cs.Invert[c] {
+			gains[c] = -1
+			active = true
+		}
+		if c < len(cs.Mute) && cs.Mute[c] {
+			gains[c] = 0
+			active = true
+		}
+	}
+	return gains, active
+}
+
+// applyChannelState mutes, inverts and swaps channels of interleaved samples
+// in place. Mute and polarity refer to capture channels and apply before the swap.
+func applyChannelState(samples []float64, channels int, state ChannelState) {
+	gains, active := state.channelGains(channels)
+	swap := state.Swap && channels >= 2
+	if !active && !swap {
+		return
+	}
+
+	for i := 0; i+channels <= len(samples); i += channels {
+		if active {
+			for c := 0; c < channels; c++ {
+				samples[i+c] *= gains[c]
+			}
+		}
+		if swap {
+			samples[i], samples[i+1] = samples[i+1], samples[i]
+		}
+	}
+}
+
+// maxStereoWidth bounds widening; beyond this the side signal dominates and
+// the image falls apart
+const maxStereoWidth = 2.0
+
+// applyStereoWidth scales the side signal of the first channel pair in place:
+// 0 collapses it to mono, 1 leaves it untouched and larger values widen it
+func applyStereoWidth(samples []float64, channels int, width float64) {
+	if width == 1 || channels < 2 {
+		return
+	}
+
+	for i := 0; i+channels <= len(samples); i += channels {
+		mid := (samples[i] + samples[i+1]) / 2
+		side := (samples[i] - samples[i+1]) / 2 * width
+		samples[i] = mid + side
+		samples[i+1] = mid - side
+	}
+}
+
+// Mid/side stage modes
+const (
+	MidSideOff    = ""
+	MidSideEncode = "encode" // L/R in, M/S out
+	MidSideDecode = "decode" // M/S in, L/R out
+	MidSideMatrix = "matrix" // L/R in, adjust M/S gains, L/R out
+)
+
+// MidSide converts the first two channels between left/right and mid/side,
+// applying separate gains to the mid and side signals
+type MidSide struct {
+	mode     string
+	channels int
+	midGain  float64
+	sideGain float64
+}
+
+// NewMidSide creates a mid/side stage
+func NewMidSide(mode string, channels int, midGain, sideGain float64) *MidSide {
+	return &MidSide{
+		mode:     mode,
+		channels: channels,
+		midGain:  midGain,
+		sideGain: sideGain,
+	}
+}
+
+// Process converts the interleaved samples in place
+func (ms *MidSide) Process(samples []float64) {
+	ch := ms.channels
+	if ch < 2 {
+		return
+	}
+
+	for i := 0; i+ch <= len(samples); i += ch {
+		a, b := samples[i], samples[i+1]
+
+		switch ms.mode {
+		case MidSideEncode:
+			samples[i] = (a + b) / 2 * ms.midGain
+			samples[i+1] = (a - b) / 2 * ms.sideGain
+		case MidSideDecode:
+			mid, side := a*ms.midGain, b*ms.sideGain
+			samples[i] = mid + side
+			samples[i+1] = mid - side
+		case MidSideMatrix:
+			mid := (a + b) / 2 * ms.midGain
+			side := (a - b) / 2 * ms.sideGain
+			samples[i] = mid + side
+			samples[i+1] = mid - side
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package audiorelay
+
+import (
+	"math"
+	"sync"
+)
+
+// Audio classes reported by the classifier
+const (
+	ClassSilence = "silence"
+	ClassSpeech  = "speech"
+	ClassMusic   = "music"
+)
+
+// classifierSubframeMs is the length of the short frames features are measured on
+const classifierSubframeMs = 20
+
+// classifierHistory is how many window decisions are smoothed into one class
+const classifierHistory = 3
+
+// Classifier is a sample analyzer telling music from speech with the
+// classic low-energy-ratio and zero-crossing features. Speech alternates
+// between syllables and short pauses, and between voiced (few zero
+// crossings) and unvoiced (many) sounds; music keeps a steadier energy and
+// spectrum. It publishes an audio_class event whenever the class changes.
+type Classifier struct {
+	events       *EventBus
+	windowFrames int     // Sub-frames per decision window
+	silenceLevel float64 // RMS below which a window counts as silence
+
+	// Current sub-frame, sized from the frames' sample rate
+	subframeSize int
+	sumSquares   float64
+	crossings    int
+	samples      int
+	lastSign     bool
+
+	// Features of the current window's sub-frames
+	rms []float64
+	zcr []float64
+
+	decisions []string
+	mu        sync.RWMutex
+	class     string
+}
+
+// NewClassifier creates the music/speech classifier
+func NewClassifier(config *Config, events *EventBus) (Analyzer, error) {
+	c := config.Analysis.Classifier
+	return &Classifier{
+		events:       events,
+		windowFrames: max(1, int(c.WindowSeconds*1000/classifierSubframeMs)),
+		silenceLevel: 32767 * math.Pow(10, c.SilenceDB/20),
+	}, nil
+}
+
+// Class returns the current class, empty before the first decision
+func (c *Classifier) Class() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.class
+}
+
+// Analyze measures the frame's sub-frames on a mono mix
+func (c *Classifier) Analyze(frame AnalysisFrame) {
+	c.subframeSize = frame.SampleRate * classifierSubframeMs / 1000
+	ch := frame.Channels
+	for i := 0; i+ch <= len(frame.Samples); i += ch {
+		mono := 0.0
+		for _, s := range frame.Samples[i : i+ch] {
+			mono += s
+		}
+		mono /= float64(ch)
+
+		c.sumSquares += mono * mono
+		if sign := mono >= 0; c.samples > 0 && sign != c.lastSign {
+			c.crossings++
+		}
+		c.lastSign = mono >= 0
+		c.samples++
+
+		if c.samples >= c.subframeSize {
+			c.endSubframe()
+		}
+	}
+}
+
+// endSubframe records the features of a finished sub-frame
+func (c *Classifier) endSubframe() {
+	c.rms = append(c.rms, math.Sqrt(c.sumSquares/float64(c.samples)))
+	c.zcr = append(c.zcr, float64(c.crossings)/float64(c.samples))
+	c.sumSquares, c.crossings, c.samples = 0, 0, 0
+
+	if len(c.rms) >= c.windowFrames {
+		c.decide()
+		c.rms = c.rms[:0]
+		c.zcr = c.zcr[:0]
+	}
+}
+
+// decide classifies the finished window and publishes changes
+func (c *Classifier) decide() {
+	meanRMS := mean(c.rms)
+	lowEnergy, zcrVariation := 0.0, 0.0
+
+	decision := ClassSilence
+	if meanRMS >= c.silenceLevel {
+		for _, rms := range c.rms {
+			if rms < meanRMS/2 {
+				lowEnergy++
+			}
+		}
+		lowEnergy /= float64(len(c.rms))
+
+		if meanZCR := mean(c.zcr); meanZCR > 0 {
+			variance := 0.0
+			for _, z := range c.zcr {
+				variance += (z - meanZCR) * (z - meanZCR)
+			}
+			zcrVariation = math.Sqrt(variance/float64(len(c.zcr))) / meanZCR
+		}
+
+		// Either feature well above its music range marks speech
+		decision = ClassMusic
+		if lowEnergy/0.3+zcrVariation/0.6 > 2 {
+			decision = ClassSpeech
+		}
+	}
+
+	// Majority of the recent windows, so one odd window doesn't flip the class
+	c.decisions = append(c.decisions, decision)
+	if len(c.decisions) > classifierHistory {
+		c.decisions = c.decisions[1:]
+	}
+	counts := make(map[string]int)
+	for _, d := range c.decisions {
+		counts[d]++
+	}
+	class := decision
+	for d, n := range counts {
+		if n > counts[class] {
+			class = d
+		}
+	}
+
+	c.mu.Lock()
+	changed := class != c.class
+	c.class = class
+	c.mu.Unlock()
+
+	if changed {
+		c.events.Publish(EventAudioClass, map[string]interface{}{
+			"class":         class,
+			"level_db":      math.Round(levelDB(meanRMS)*10) / 10,
+			"low_energy":    math.Round(lowEnergy*100) / 100,
+			"zcr_variation": math.Round(zcrVariation*100) / 100,
+		})
+	}
+}
+
+// mean returns the average of values
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
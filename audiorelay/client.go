@@ -0,0 +1,315 @@
+package audiorelay
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxClientGain bounds per-client volume to avoid accidental ear-splitting boosts
+const maxClientGain = 4.0
+
+// Client is a connected listener on any protocol
+type Client struct {
+	ID         string
+	Name       string // Chosen by the listener with ?client=, may be empty
+	Protocol   string
+	RemoteAddr string
+	Connected  time.Time
+
+	mu   sync.RWMutex
+	gain float64
+
+	// Reported by receivers with TCP control messages
+	paused   bool
+	bufferMs *float64
+
+	// Closes the connection, set by the protocol server
+	disconnect func()
+
+	// Traffic counters kept by the protocol server
+	bytesSent     atomic.Int64
+	droppedFrames atomic.Int64
+	writeLatency  atomic.Int64 // How long the last write to the connection took, in nanoseconds
+
+	// Capture to write latency of the client's audio, and of its protocol
+	delivery         *latencySamples
+	protocolDelivery *latencySamples
+
+	// Keeps the traffic totals of all clients, nil outside a registry
+	registry *ClientRegistry
+}
+
+// ClientInfo is a point-in-time view of a client for the API
+type ClientInfo struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	Protocol   string    `json:"protocol"`
+	RemoteAddr string    `json:"remote_addr"`
+	Connected  time.Time `json:"connected"`
+	Gain       float64   `json:"gain"`
+
+	BytesSent      int64   `json:"bytes_sent"`
+	DroppedFrames  int64   `json:"dropped_frames"`
+	WriteLatencyMs float64 `json:"write_latency_ms"`
+
+	Paused   bool     `json:"paused,omitempty"`
+	BufferMs *float64 `json:"buffer_ms,omitempty"` // Receiver's buffer level as last reported
+
+	Latency LatencyPercentiles `json:"latency"` // From capture to the write to the connection
+}
+
+// Gain returns the client's volume multiplier
+func (c *Client) Gain() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gain
+}
+
+// SetGain changes the client's volume multiplier
+func (c *Client) SetGain(gain float64) error {
+	if gain < 0 || gain > maxClientGain {
+		return fmt.Errorf("gain must be between 0 and %.0f", maxClientGain)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gain = gain
+	return nil
+}
+
+// Paused reports whether the client asked to get no audio for now
+func (c *Client) Paused() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.paused
+}
+
+// setPaused stops or resumes the client's audio
+func (c *Client) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = paused
+}
+
+// reportBuffer records the receiver's buffer level
+func (c *Client) reportBuffer(ms float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bufferMs = &ms
+}
+
+// onDisconnect sets how the protocol server closes the connection
+func (c *Client) onDisconnect(disconnect func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disconnect = disconnect
+}
+
+// Disconnect closes the client's connection, reporting false if it can't
+// be closed yet because the client is still being set up
+func (c *Client) Disconnect() bool {
+	c.mu.RLock()
+	disconnect := c.disconnect
+	c.mu.RUnlock()
+	if disconnect == nil {
+		return false
+	}
+	disconnect()
+	return true
+}
+
+// Info returns a snapshot of the client
+func (c *Client) Info() ClientInfo {
+	c.mu.RLock()
+	paused, bufferMs := c.paused, c.bufferMs
+	c.mu.RUnlock()
+	return ClientInfo{
+		ID:         c.ID,
+		Name:       c.Name,
+		Protocol:   c.Protocol,
+		RemoteAddr: c.RemoteAddr,
+		Connected:  c.Connected,
+		Gain:       c.Gain(),
+
+		BytesSent:      c.bytesSent.Load(),
+		DroppedFrames:  c.droppedFrames.Load(),
+		WriteLatencyMs: float64(c.writeLatency.Load()) / float64(time.Millisecond),
+
+		Paused:   paused,
+		BufferMs: bufferMs,
+
+		Latency: c.Latency(),
+	}
+}
+
+// recordSent counts bytes sent to the client; nil clients are ignored
+func (c *Client) recordSent(n int) {
+	if c != nil {
+		c.bytesSent.Add(int64(n))
+		if c.registry != nil {
+			c.registry.sentBytes.Add(int64(n))
+		}
+	}
+}
+
+// recordLatency notes how long the last write to the client took
+func (c *Client) recordLatency(took time.Duration) {
+	if c != nil {
+		c.writeLatency.Store(int64(took))
+	}
+}
+
+// recordDropped counts audio frames the client missed by falling behind
+func (c *Client) recordDropped(frames int) {
+	if c != nil {
+		c.droppedFrames.Add(int64(frames))
+		if c.registry != nil {
+			c.registry.droppedFrames.Add(int64(frames))
+		}
+	}
+}
+
+// applyGain returns the PCM data scaled by the client's gain. The shared
+// broadcast buffer is never modified; a copy is made only when needed.
+func (c *Client) applyGain(data []byte, format wavFormat) []byte {
+	gain := c.Gain()
+	if gain == 1 {
+		return data
+	}
+
+	samples := format.decodeSamples(data)
+	for i := range samples {
+		samples[i] *= gain
+	}
+	return format.encodeSamples(samples)
+}
+
+// ClientRegistry tracks connected clients across all protocols
+type ClientRegistry struct {
+	clients   map[string]*Client
+	clientsMu sync.RWMutex
+	nextID    int
+
+	// Receives client_connected and client_disconnected, if set
+	events *EventBus
+
+	// Delivery latency per protocol, kept after clients leave
+	delivery map[string]*latencySamples
+
+	// Traffic of all clients, including those that left
+	sentBytes     atomic.Int64
+	droppedFrames atomic.Int64
+	peakClients   int // Most clients connected since the last takePeak
+}
+
+// NewClientRegistry creates an empty client registry
+func NewClientRegistry() *ClientRegistry {
+	return &ClientRegistry{
+		clients:  make(map[string]*Client),
+		delivery: make(map[string]*latencySamples),
+	}
+}
+
+// Register creates and tracks a new client
+func (cr *ClientRegistry) Register(protocol, remoteAddr string) *Client {
+	return cr.RegisterNamed(protocol, remoteAddr, "")
+}
+
+// RegisterNamed creates and tracks a new client that gave its name
+func (cr *ClientRegistry) RegisterNamed(protocol, remoteAddr, name string) *Client {
+	cr.clientsMu.Lock()
+	cr.nextID++
+	client := &Client{
+		ID:         fmt.Sprintf("%s-%d", protocol, cr.nextID),
+		Name:       name,
+		Protocol:   protocol,
+		RemoteAddr: remoteAddr,
+		Connected:  time.Now(),
+		gain:       1,
+
+		delivery:         newLatencySamples(clientLatencySamples),
+		protocolDelivery: cr.deliveryWindowLocked(protocol),
+		registry:         cr,
+	}
+	cr.clients[client.ID] = client
+	cr.peakClients = max(cr.peakClients, len(cr.clients))
+	cr.clientsMu.Unlock()
+
+	cr.publish(EventClientConnected, client, nil)
+	return client
+}
+
+// Unregister stops tracking a client
+func (cr *ClientRegistry) Unregister(client *Client) {
+	cr.clientsMu.Lock()
+	_, tracked := cr.clients[client.ID]
+	delete(cr.clients, client.ID)
+	cr.clientsMu.Unlock()
+
+	// Servers may unregister a client from more than one cleanup path
+	if tracked {
+		cr.publish(EventClientDisconnected, client, map[string]interface{}{
+			"connected_seconds": math.Round(time.Since(client.Connected).Seconds()),
+			"bytes_sent":        client.bytesSent.Load(),
+		})
+	}
+}
+
+// publish announces a client coming or going on the event bus
+func (cr *ClientRegistry) publish(eventType string, client *Client, data map[string]interface{}) {
+	if cr.events == nil {
+		return
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["id"] = client.ID
+	data["protocol"] = client.Protocol
+	data["remote_addr"] = client.RemoteAddr
+	if client.Name != "" {
+		data["name"] = client.Name
+	}
+	cr.events.Publish(eventType, data)
+}
+
+// Get looks up a client by ID
+func (cr *ClientRegistry) Get(id string) (*Client, bool) {
+	cr.clientsMu.RLock()
+	defer cr.clientsMu.RUnlock()
+	client, ok := cr.clients[id]
+	return client, ok
+}
+
+// totals returns the bytes sent to and the frames dropped by all clients,
+// including those that left
+func (cr *ClientRegistry) totals() (sent, dropped int64) {
+	return cr.sentBytes.Load(), cr.droppedFrames.Load()
+}
+
+// takePeak returns the clients connected now and the most connected since
+// the last call
+func (cr *ClientRegistry) takePeak() (clients, peak int) {
+	cr.clientsMu.Lock()
+	defer cr.clientsMu.Unlock()
+	clients, peak = len(cr.clients), max(cr.peakClients, len(cr.clients))
+	cr.peakClients = clients
+	return clients, peak
+}
+
+// List returns all connected clients, oldest first
+func (cr *ClientRegistry) List() []ClientInfo {
+	cr.clientsMu.RLock()
+	defer cr.clientsMu.RUnlock()
+
+	infos := make([]ClientInfo, 0, len(cr.clients))
+	for _, client := range cr.clients {
+		infos = append(infos, client.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Connected.Before(infos[j].Connected)
+	})
+	return infos
+}
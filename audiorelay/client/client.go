@@ -0,0 +1,368 @@
+// Package client receives audio from an audiorelay server, so Go programs
+// such as recorders and analyzers don't have to reimplement the wire
+// formats. Supported URLs:
+//
+//	http://host:8888/stream.wav   WAV over HTTP, the format is read from the header
+//	tcp://host:12345              raw PCM over TCP in Client.Format, or in the
+//	                              format of the handshake or frames with
+//	                              Client.Handshake or Client.Framed
+//	tls://host:12345              the same over TLS (protocols.tcp.tls)
+//
+// Streams reconnect automatically after errors, server restarts and
+// format changes.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Reconnect backoff bounds
+const (
+	DefaultReconnectDelay    = time.Second
+	DefaultMaxReconnectDelay = 30 * time.Second
+)
+
+// frameDuration is the amount of audio delivered per Frame
+const frameDuration = 20 * time.Millisecond
+
+// Frame is a chunk of whole sample frames in the stream's format
+type Frame struct {
+	Format Format
+	Data   []byte
+	Time   time.Time // When the data was received
+
+	// Set by framed TCP streams: the frame's sequence number, which skips
+	// where audio was lost, and when its first sample was captured
+	Sequence uint32
+	Captured time.Time
+
+	// Codec of Data when it isn't PCM, e.g. "opus" for an Opus packet
+	// decoding to Format's rate and channels
+	Codec string
+}
+
+// Samples decodes the frame to interleaved samples in [-1, 1]; nil if it
+// holds encoded audio
+func (f Frame) Samples() []float32 {
+	if f.Codec != "" {
+		return nil
+	}
+	return f.Format.Float32(f.Data)
+}
+
+// Client connects to one audiorelay stream
+type Client struct {
+	URL string
+
+	// Format of raw TCP streams, which carry no header; DefaultFormat if unset.
+	// With Handshake it is the format asked for, or the stream's if unset.
+	Format Format
+
+	// Handshake reads the format from the handshake of a relay with
+	// protocols.tcp.handshake enabled
+	Handshake bool
+
+	// Framed reads TCP streams of a relay with protocols.tcp.framing
+	// enabled, whose frames carry their own format
+	Framed bool
+
+	// Codec asks for another codec than PCM in the handshake, e.g. "opus"
+	// for one Opus packet per Frame; needs Handshake and Framed
+	Codec string
+
+	// Backoff between reconnect attempts, doubling up to MaxReconnectDelay.
+	// A negative ReconnectDelay disables reconnecting.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+
+	// HTTPClient is used for http(s) URLs; http.DefaultClient if nil
+	HTTPClient *http.Client
+
+	// Token is sent first on TCP connections to a relay with
+	// protocols.tcp.auth_token set
+	Token string
+
+	// TLSConfig is used for tls URLs, e.g. to trust a self-signed
+	// certificate; the system roots if nil
+	TLSConfig *tls.Config
+
+	// OnError, if set, is called with errors that trigger a reconnect
+	OnError func(error)
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// New creates a client for the stream at rawURL
+func New(rawURL string) *Client {
+	return &Client{URL: rawURL}
+}
+
+// Stream connects and returns a channel of audio frames. The first
+// connection is made before returning so configuration errors surface
+// immediately; later failures reconnect in the background. The channel is
+// closed by Close, or when a connection fails with reconnecting disabled.
+func (c *Client) Stream() (<-chan Frame, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tcp" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("unsupported URL scheme: %q", u.Scheme)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.mu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("already streaming")
+	}
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	conn, err := c.connect(ctx, u)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	frames := make(chan Frame, 50)
+	go c.run(ctx, u, conn, frames)
+	return frames, nil
+}
+
+// Close stops streaming and closes the frame channel
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+}
+
+// connection is an open stream positioned at the sample data
+type connection struct {
+	body    io.ReadCloser
+	format  Format
+	trailer http.Header   // Filled in once an HTTP body has been read to the end
+	frames  *bufio.Reader // Framed TCP streams, read one frame at a time
+}
+
+// connect opens the stream and reads its format
+func (c *Client) connect(ctx context.Context, u *url.URL) (*connection, error) {
+	if u.Scheme == "tcp" || u.Scheme == "tls" {
+		return c.connectTCP(ctx, u)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	format, err := readWAVHeader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return &connection{body: resp.Body, format: format, trailer: resp.Trailer}, nil
+}
+
+// connectTCP opens a TCP stream, learning its format from the handshake if
+// enabled
+func (c *Client) connectTCP(ctx context.Context, u *url.URL) (*connection, error) {
+	format := c.Format
+	if format == (Format{}) && !c.Handshake {
+		format = DefaultFormat
+	}
+	if format != (Format{}) {
+		if err := format.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	if u.Scheme == "tls" {
+		dialer := tls.Dialer{Config: c.TLSConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", u.Host)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", u.Host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	if c.Token != "" {
+		conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+		_, err = io.WriteString(conn, c.Token+"\n")
+		conn.SetWriteDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to send token: %v", err)
+		}
+	}
+	r := bufio.NewReader(conn)
+	if c.Framed {
+		r = newFrameReader(conn)
+	}
+	if c.Handshake {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		format, err = handshake(r, conn, format, c.Codec)
+		conn.SetDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	body := struct {
+		io.Reader
+		io.Closer
+	}{r, conn}
+	if c.Framed {
+		return &connection{body: body, frames: r}, nil
+	}
+	return &connection{body: body, format: format}, nil
+}
+
+// run delivers frames from conn and reconnects until the client is closed
+func (c *Client) run(ctx context.Context, u *url.URL, conn *connection, frames chan<- Frame) {
+	defer close(frames)
+
+	delay := c.ReconnectDelay
+	if delay == 0 {
+		delay = DefaultReconnectDelay
+	}
+	maxDelay := c.MaxReconnectDelay
+	if maxDelay == 0 {
+		maxDelay = DefaultMaxReconnectDelay
+	}
+
+	backoff := delay
+	for {
+		if conn != nil {
+			err := c.read(ctx, conn, frames)
+			if ctx.Err() != nil {
+				return
+			}
+			c.reportError(err)
+			// The stream was healthy, so retry promptly
+			backoff = delay
+		}
+		if delay < 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		var err error
+		conn, err = c.connect(ctx, u)
+		if err != nil {
+			c.reportError(err)
+			backoff = min(backoff*2, maxDelay)
+		}
+	}
+}
+
+// read forwards whole sample frames from conn until it fails or ends
+func (c *Client) read(ctx context.Context, conn *connection, frames chan<- Frame) error {
+	defer conn.body.Close()
+
+	// Unblock the read when the client is closed
+	stop := context.AfterFunc(ctx, func() { conn.body.Close() })
+	defer stop()
+
+	if conn.frames != nil {
+		return readFrames(ctx, conn.frames, frames)
+	}
+
+	blockAlign := conn.format.BlockAlign()
+	buf := make([]byte, max(1, int(frameDuration.Seconds()*float64(conn.format.SampleRate)))*blockAlign)
+	filled := 0
+	for {
+		n, err := conn.body.Read(buf[filled:])
+		filled += n
+
+		// Hand out whole sample frames, keeping a partial one for the next read
+		if whole := filled - filled%blockAlign; whole > 0 {
+			data := make([]byte, whole)
+			copy(data, buf)
+			select {
+			case frames <- Frame{Format: conn.format, Data: data, Time: time.Now()}:
+			case <-ctx.Done():
+				return nil
+			}
+			filled = copy(buf, buf[whole:filled])
+		}
+
+		if err == io.EOF {
+			// The relay says why it ended an endless HTTP stream
+			if reason := conn.trailer.Get("X-Stream-End"); reason != "" {
+				return fmt.Errorf("stream ended: %s", reason)
+			}
+			return fmt.Errorf("stream ended")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readFrames forwards the audio of a framed stream until it fails or ends
+func readFrames(ctx context.Context, r *bufio.Reader, frames chan<- Frame) error {
+	for {
+		audio, err := readFrame(r)
+		if err == io.EOF {
+			return fmt.Errorf("stream ended")
+		}
+		if err != nil {
+			return err
+		}
+		frame := Frame{
+			Format:   audio.format,
+			Data:     audio.data,
+			Time:     time.Now(),
+			Sequence: audio.sequence,
+			Captured: audio.captured,
+			Codec:    audio.codec,
+		}
+		select {
+		case frames <- frame:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// reportError passes a reconnect cause to OnError
+func (c *Client) reportError(err error) {
+	if c.OnError != nil && err != nil {
+		c.OnError(err)
+	}
+}
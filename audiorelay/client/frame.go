@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Framed TCP streams (protocols.tcp.framing on the relay) send every chunk
+// of audio with a header; see the relay's tcpframe.go for the layout
+const (
+	frameMagic      = "ARTF"
+	frameHeaderSize = 32
+	frameVersion    = 1
+	frameTypeAudio  = 0
+	frameTypeEnd    = 2
+	frameFlagFloat  = 0x01
+	frameFlagCRC    = 0x02
+	frameFlagOpus   = 0x04
+	frameCRCSize    = 4
+
+	// maxFramePayload bounds the payload length of a header, so a false
+	// magic match in corrupted data can't make the reader wait for megabytes
+	maxFramePayload = 256 << 10
+)
+
+// newFrameReader buffers a framed stream so a whole frame can be checked
+// before it is consumed
+func newFrameReader(r io.Reader) *bufio.Reader {
+	return bufio.NewReaderSize(r, frameHeaderSize+maxFramePayload+frameCRCSize)
+}
+
+// framedAudio is one audio frame of a framed stream
+type framedAudio struct {
+	format   Format
+	sequence uint32
+	captured time.Time
+	data     []byte
+	codec    string // Empty for PCM
+}
+
+// readFrame returns the next valid audio frame from a reader made by
+// newFrameReader. Data before a frame's magic, heartbeats, frames of unknown
+// versions or types and frames with a wrong CRC are skipped, so the reader resyncs
+// on the next good frame; the gap shows in the sequence numbers. An end of
+// stream frame is returned as an error giving the relay's reason.
+func readFrame(r *bufio.Reader) (framedAudio, error) {
+	for {
+		header, err := r.Peek(frameHeaderSize)
+		if err != nil {
+			return framedAudio{}, err
+		}
+		if i := bytes.Index(header, []byte(frameMagic)); i != 0 {
+			// Skip to the next possible magic
+			if i < 0 {
+				i = frameHeaderSize - len(frameMagic) + 1
+			}
+			r.Discard(i)
+			continue
+		}
+
+		flags := header[6]
+		format := Format{
+			SampleRate:    int(binary.BigEndian.Uint32(header[12:16])),
+			Channels:      int(header[7]),
+			BitsPerSample: int(header[8]),
+			Float:         flags&frameFlagFloat != 0,
+		}
+		length := int(binary.BigEndian.Uint32(header[20:24]))
+		opus := flags&frameFlagOpus != 0
+		pcm := header[5] == frameTypeAudio && !opus
+		if header[4] != frameVersion || length > maxFramePayload || format.validate() != nil || (pcm && length%format.BlockAlign() != 0) {
+			r.Discard(1)
+			continue
+		}
+		size := frameHeaderSize + length
+		if flags&frameFlagCRC != 0 {
+			size += frameCRCSize
+		}
+
+		frame, err := r.Peek(size)
+		if err != nil {
+			return framedAudio{}, err
+		}
+		if flags&frameFlagCRC != 0 {
+			body := frame[:size-frameCRCSize]
+			if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(frame[size-frameCRCSize:]) {
+				// A damaged frame, or a false magic match: look further
+				r.Discard(1)
+				continue
+			}
+		}
+		// frame is only valid until the next read
+		frame = append([]byte(nil), frame...)
+		r.Discard(size)
+		if frame[5] == frameTypeEnd {
+			// The relay says why it ended the stream
+			return framedAudio{}, fmt.Errorf("stream ended: %s", frame[frameHeaderSize:frameHeaderSize+length])
+		}
+		if frame[5] != frameTypeAudio {
+			continue
+		}
+		codec := ""
+		if opus {
+			codec = "opus"
+		}
+		return framedAudio{
+			format:   format,
+			sequence: binary.BigEndian.Uint32(frame[16:20]),
+			captured: time.Unix(0, int64(binary.BigEndian.Uint64(frame[24:32]))),
+			data:     frame[frameHeaderSize : frameHeaderSize+length],
+			codec:    codec,
+		}, nil
+	}
+}
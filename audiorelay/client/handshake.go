@@ -0,0 +1,88 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handshakeTimeout bounds the exchange with the relay after connecting
+const handshakeTimeout = 5 * time.Second
+
+// handshake reads the relay's greeting, asks for request and codec unless
+// they are zero, and returns the format the relay confirmed. See the
+// relay's tcphandshake.go for the exchange.
+func handshake(r *bufio.Reader, w io.Writer, request Format, codec string) (Format, error) {
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return Format{}, fmt.Errorf("handshake failed: %v", err)
+	}
+	if message, ok := strings.CutPrefix(strings.TrimSpace(greeting), "ERROR "); ok {
+		// Server full, or a wrong Token
+		return Format{}, fmt.Errorf("connection refused: %s", message)
+	}
+	if !strings.HasPrefix(greeting, "AUDIORELAY/") {
+		return Format{}, fmt.Errorf("handshake failed: unexpected greeting %q", strings.TrimSpace(greeting))
+	}
+
+	var params []string
+	if request != (Format{}) {
+		params = append(params, fmt.Sprintf("rate=%d channels=%d sample_format=%s",
+			request.SampleRate, request.Channels, request.sampleFormat()))
+	}
+	if codec != "" {
+		params = append(params, "codec="+codec)
+	}
+	line := "\n"
+	if len(params) > 0 {
+		line = "FORMAT " + strings.Join(params, " ") + "\n"
+	}
+	if _, err := io.WriteString(w, line); err != nil {
+		return Format{}, fmt.Errorf("handshake failed: %v", err)
+	}
+
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return Format{}, fmt.Errorf("handshake failed: %v", err)
+	}
+	reply = strings.TrimSpace(reply)
+	if message, ok := strings.CutPrefix(reply, "ERROR "); ok {
+		return Format{}, fmt.Errorf("format rejected: %s", message)
+	}
+	fields, ok := strings.CutPrefix(reply, "OK ")
+	if !ok {
+		return Format{}, fmt.Errorf("handshake failed: unexpected reply %q", reply)
+	}
+	return parseHandshakeFormat(fields)
+}
+
+// parseHandshakeFormat reads the rate, channels and sample_format of an OK
+// line
+func parseHandshakeFormat(fields string) (Format, error) {
+	var format Format
+	for _, field := range strings.Fields(fields) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "rate":
+			format.SampleRate, _ = strconv.Atoi(value)
+		case "channels":
+			format.Channels, _ = strconv.Atoi(value)
+		case "sample_format":
+			switch value {
+			case "int16":
+				format.BitsPerSample = 16
+			case "int24":
+				format.BitsPerSample = 24
+			case "float32":
+				format.BitsPerSample, format.Float = 32, true
+			}
+		}
+	}
+	if err := format.validate(); err != nil {
+		return Format{}, fmt.Errorf("handshake failed: %v", err)
+	}
+	return format, nil
+}
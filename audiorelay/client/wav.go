@@ -0,0 +1,138 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// WAV format tags
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatExtensible = 0xfffe
+)
+
+// Format describes the PCM layout of a stream: little-endian, interleaved
+// 16-bit or 24-bit integer samples, or 32-bit IEEE float
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	Float         bool
+}
+
+// DefaultFormat matches the relay's default configuration
+var DefaultFormat = Format{SampleRate: 48000, Channels: 2, BitsPerSample: 16}
+
+// BlockAlign returns the size of one sample frame in bytes
+func (f Format) BlockAlign() int {
+	return f.Channels * f.BitsPerSample / 8
+}
+
+// ByteRate returns the number of bytes per second of audio
+func (f Format) ByteRate() int {
+	return f.SampleRate * f.BlockAlign()
+}
+
+// sampleFormat returns the relay's name of the sample encoding
+func (f Format) sampleFormat() string {
+	if f.Float {
+		return "float32"
+	}
+	return fmt.Sprintf("int%d", f.BitsPerSample)
+}
+
+// validate reports formats the relay never produces
+func (f Format) validate() error {
+	supported := (!f.Float && (f.BitsPerSample == 16 || f.BitsPerSample == 24)) || (f.Float && f.BitsPerSample == 32)
+	if !supported || f.Channels <= 0 || f.SampleRate <= 0 {
+		return fmt.Errorf("unsupported format: %d Hz, %d channels, %d-bit (float %v)",
+			f.SampleRate, f.Channels, f.BitsPerSample, f.Float)
+	}
+	return nil
+}
+
+// Float32 decodes interleaved PCM in format f to samples in [-1, 1]
+func (f Format) Float32(data []byte) []float32 {
+	bytesPerSample := f.BitsPerSample / 8
+	samples := make([]float32, len(data)/bytesPerSample)
+	for i := range samples {
+		b := data[i*bytesPerSample:]
+		switch {
+		case f.Float:
+			samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(b))
+		case f.BitsPerSample == 24:
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			samples[i] = float32(v) / (1 << 23)
+		default:
+			samples[i] = float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+		}
+	}
+	return samples
+}
+
+// readWAVHeader parses a WAV header, leaving r positioned at the sample data
+func readWAVHeader(r io.Reader) (Format, error) {
+	var format Format
+
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return format, fmt.Errorf("failed to read WAV header: %v", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return format, fmt.Errorf("not a WAV stream")
+	}
+
+	haveFormat := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return format, fmt.Errorf("missing data chunk: %v", err)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return format, fmt.Errorf("invalid fmt chunk")
+			}
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return format, fmt.Errorf("invalid fmt chunk")
+			}
+			tag := binary.LittleEndian.Uint16(body[0:2])
+			if tag == wavFormatExtensible {
+				if size < 40 {
+					return format, fmt.Errorf("invalid extensible fmt chunk")
+				}
+				tag = binary.LittleEndian.Uint16(body[24:26])
+			}
+			format.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			format.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			format.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			format.Float = tag == wavFormatIEEEFloat
+			if tag != wavFormatPCM && tag != wavFormatIEEEFloat {
+				return format, fmt.Errorf("unsupported WAV format tag %#x", tag)
+			}
+			if err := format.validate(); err != nil {
+				return format, err
+			}
+			haveFormat = true
+
+		case "data":
+			if !haveFormat {
+				return format, fmt.Errorf("data chunk before fmt chunk")
+			}
+			return format, nil
+
+		default:
+			// Skip unknown chunks (padded to an even size)
+			if _, err := io.CopyN(io.Discard, r, int64(size)+int64(size%2)); err != nil {
+				return format, fmt.Errorf("truncated WAV chunk %q", id)
+			}
+		}
+	}
+}
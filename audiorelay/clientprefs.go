@@ -0,0 +1,247 @@
+package audiorelay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits on stream.wav preferences
+const (
+	maxClientNameLength = 64
+	maxClientDelayMs    = 5000
+)
+
+// ClientPrefs are the stream settings a named listener asked for last time
+type ClientPrefs struct {
+	Format  string    `json:"format,omitempty"` // int16, int24 or float32; empty for the stream's own
+	Volume  float64   `json:"volume"`
+	DelayMs float64   `json:"delay_ms,omitempty"` // Silence sent before the stream, to line up with other speakers
+	Updated time.Time `json:"updated"`
+
+	// PrerollMs is how much recent audio to send before the live stream;
+	// nil for all of the server's prebuffer
+	PrerollMs *float64 `json:"preroll_ms,omitempty"`
+}
+
+// defaultClientPrefs are the settings of a listener without preferences
+func defaultClientPrefs() ClientPrefs {
+	return ClientPrefs{Volume: 1}
+}
+
+// ClientPrefStore remembers listener preferences by client name, so a
+// device that reconnects with ?client=name gets its previous settings
+type ClientPrefStore struct {
+	path    string
+	prefs   map[string]ClientPrefs
+	prefsMu sync.RWMutex
+}
+
+// LoadClientPrefs reads the saved preferences. An empty path keeps them in
+// memory only.
+func LoadClientPrefs(path string) (*ClientPrefStore, error) {
+	cs := &ClientPrefStore{
+		path:  path,
+		prefs: make(map[string]ClientPrefs),
+	}
+	if path == "" {
+		return cs, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client preferences: %v", err)
+	}
+	if err := json.Unmarshal(data, &cs.prefs); err != nil {
+		return nil, fmt.Errorf("invalid client preferences file %s: %v", path, err)
+	}
+	return cs, nil
+}
+
+// Get returns the preferences of a client, or the defaults
+func (cs *ClientPrefStore) Get(name string) ClientPrefs {
+	cs.prefsMu.RLock()
+	defer cs.prefsMu.RUnlock()
+	if prefs, ok := cs.prefs[name]; ok {
+		return prefs
+	}
+	return defaultClientPrefs()
+}
+
+// Set stores the preferences of a client and saves them
+func (cs *ClientPrefStore) Set(name string, prefs ClientPrefs) error {
+	cs.prefsMu.Lock()
+	defer cs.prefsMu.Unlock()
+	prefs.Updated = time.Now()
+	cs.prefs[name] = prefs
+	return cs.save()
+}
+
+// Delete forgets a client's preferences
+func (cs *ClientPrefStore) Delete(name string) (bool, error) {
+	cs.prefsMu.Lock()
+	defer cs.prefsMu.Unlock()
+	if _, ok := cs.prefs[name]; !ok {
+		return false, nil
+	}
+	delete(cs.prefs, name)
+	return true, cs.save()
+}
+
+// List returns all saved preferences by client name
+func (cs *ClientPrefStore) List() map[string]ClientPrefs {
+	cs.prefsMu.RLock()
+	defer cs.prefsMu.RUnlock()
+	prefs := make(map[string]ClientPrefs, len(cs.prefs))
+	for name, p := range cs.prefs {
+		prefs[name] = p
+	}
+	return prefs
+}
+
+// save writes the preferences atomically. The caller holds prefsMu.
+func (cs *ClientPrefStore) save() error {
+	if cs.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(cs.prefs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(cs.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create state directory: %v", err)
+		}
+	}
+	tmp := cs.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write client preferences: %v", err)
+	}
+	if err := os.Rename(tmp, cs.path); err != nil {
+		return fmt.Errorf("failed to write client preferences: %v", err)
+	}
+	return nil
+}
+
+// clientFormat returns the layout a client asked for: the stream's own, or
+// the same audio in another sample encoding
+func clientFormat(stream wavFormat, name string) (wavFormat, error) {
+	format := stream
+	switch name {
+	case "":
+	case "int16":
+		format.BitsPerSample, format.Float = 16, false
+	case "int24":
+		format.BitsPerSample, format.Float = 24, false
+	case "float32":
+		format.BitsPerSample, format.Float = 32, true
+	default:
+		return stream, fmt.Errorf("format must be int16, int24 or float32")
+	}
+	return format, nil
+}
+
+// parseClientPrefs returns the stream settings for a request: the saved
+// preferences of the client named by ?client=, overridden by the format,
+// volume, delay_ms and preroll_ms query parameters. Overrides are remembered for the
+// next connection.
+func parseClientPrefs(query url.Values, store *ClientPrefStore) (string, ClientPrefs, error) {
+	name := query.Get("client")
+	if len(name) > maxClientNameLength {
+		return "", ClientPrefs{}, fmt.Errorf("client name is longer than %d characters", maxClientNameLength)
+	}
+
+	prefs := defaultClientPrefs()
+	if name != "" && store != nil {
+		prefs = store.Get(name)
+	}
+
+	changed := false
+	if query.Has("format") {
+		if _, err := clientFormat(wavFormat{}, query.Get("format")); err != nil {
+			return "", ClientPrefs{}, err
+		}
+		prefs.Format = query.Get("format")
+		changed = true
+	}
+	if v := query.Get("volume"); v != "" {
+		volume, err := strconv.ParseFloat(v, 64)
+		if err != nil || volume < 0 || volume > maxClientGain {
+			return "", ClientPrefs{}, fmt.Errorf("volume must be between 0 and %.0f", maxClientGain)
+		}
+		prefs.Volume = volume
+		changed = true
+	}
+	if v := query.Get("delay_ms"); v != "" {
+		delay, err := strconv.ParseFloat(v, 64)
+		if err != nil || delay < 0 || delay > maxClientDelayMs {
+			return "", ClientPrefs{}, fmt.Errorf("delay_ms must be between 0 and %d", maxClientDelayMs)
+		}
+		prefs.DelayMs = delay
+		changed = true
+	}
+	if v := query.Get("preroll_ms"); v != "" {
+		preroll, err := strconv.ParseFloat(v, 64)
+		if err != nil || preroll < 0 || preroll > maxPrebufferMs {
+			return "", ClientPrefs{}, fmt.Errorf("preroll_ms must be between 0 and %d", maxPrebufferMs)
+		}
+		prefs.PrerollMs = &preroll
+		changed = true
+	}
+
+	if changed && name != "" && store != nil {
+		if err := store.Set(name, prefs); err != nil {
+			return "", ClientPrefs{}, err
+		}
+	}
+	return name, prefs, nil
+}
+
+// handleListClientPrefs returns the saved preferences of all named clients
+func (ar *AudioRelay) handleListClientPrefs(w http.ResponseWriter, r *http.Request) {
+	prefs := ar.clientPrefs.List()
+	names := make([]string, 0, len(prefs))
+	for name := range prefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		p := prefs[name]
+		list = append(list, map[string]interface{}{
+			"client":     name,
+			"format":     p.Format,
+			"volume":     p.Volume,
+			"delay_ms":   p.DelayMs,
+			"preroll_ms": p.PrerollMs,
+			"updated":    p.Updated,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"preferences": list})
+}
+
+// handleDeleteClientPrefs forgets a client's preferences
+func (ar *AudioRelay) handleDeleteClientPrefs(w http.ResponseWriter, r *http.Request) {
+	ok, err := ar.clientPrefs.Delete(r.PathValue("name"))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no preferences for this client")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,47 @@
+package audiorelay
+
+import (
+	"fmt"
+	"os"
+)
+
+// RunCommand executes a command-line subcommand such as "config docs"
+func RunCommand(opts Options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given")
+	}
+
+	switch args[0] {
+	case "config":
+		return runConfigCommand(opts, args[1:])
+	case "setup":
+		return RunSetup(opts.ConfigPath)
+	case "calibrate":
+		return runCalibrateCommand(opts, args[1:])
+	case "proxy-check":
+		return runProxyCheckCommand(args[1:])
+	case "capacity":
+		return runCapacityCommand(opts, args[1:])
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+// runConfigCommand handles "config" subcommands
+func runConfigCommand(opts Options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: audiorelay config docs|init|validate")
+	}
+
+	switch args[0] {
+	case "docs":
+		PrintConfigDocs(os.Stdout)
+		return nil
+	case "init":
+		return runConfigInit(opts, args[1:])
+	case "validate":
+		return runConfigValidate(opts)
+	default:
+		return fmt.Errorf("unknown config command: %s", args[0])
+	}
+}
@@ -1,57 +1,300 @@
 package audiorelay
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 // Config defines the configuration structure for audio relay
 type Config struct {
-	Server     ServerConfig     `mapstructure:"server"`
-	Audio      AudioConfig      `mapstructure:"audio"`
-	Processing ProcessingConfig `mapstructure:"processing"`
-	Protocols  ProtocolsConfig  `mapstructure:"protocols"`
+	Profile    string           `mapstructure:"profile" desc:"Defaults for buffer sizes, prebuffering, bit depth and silence skipping: low-latency, balanced or quality; keys set here still override"`
+	Server     ServerConfig     `mapstructure:"server" desc:"Listening ports and addresses"`
+	Audio      AudioConfig      `mapstructure:"audio" desc:"Capture device and sample format"`
+	Processing ProcessingConfig `mapstructure:"processing" desc:"DSP chain applied before broadcasting"`
+	Protocols  ProtocolsConfig  `mapstructure:"protocols" desc:"Output protocols"`
+	Triggers   TriggersConfig   `mapstructure:"triggers" desc:"Level triggers and snapshots"`
+	Jobs       JobsConfig       `mapstructure:"jobs" desc:"Background job queue"`
+	Analysis   AnalysisConfig   `mapstructure:"analysis" desc:"Analyzers fed a copy of the broadcast stream"`
+	Logging    LoggingConfig    `mapstructure:"logging" desc:"Log output"`
+	Power      PowerConfig      `mapstructure:"power" desc:"Commands or webhooks switching gear with the main stream's listeners"`
+	Share      ShareConfig      `mapstructure:"share" desc:"Public listening links with an optional PIN and expiry"`
+	Assets     AssetsConfig     `mapstructure:"assets" desc:"Chimes and announcement sounds, per language"`
+	Standby    StandbyConfig    `mapstructure:"standby" desc:"Run as the hot standby of another relay"`
+	Auth       AuthConfig       `mapstructure:"auth" desc:"Tokens and logins required by the HTTP endpoints, per group"`
+	Streams    []StreamConfig   `mapstructure:"streams" desc:"Additional devices relayed as separate named streams"`
+
+	Notifications NotificationsConfig `mapstructure:"notifications" desc:"Webhooks called when clients come and go, capture fails or recovers, audio goes silent and on shutdown"`
+	Tracing       TracingConfig       `mapstructure:"tracing" desc:"OpenTelemetry spans of the audio path: capture, processing stages, encoding and broadcast"`
 }
 
 type ServerConfig struct {
-	Port     string `mapstructure:"port"`      // TCP server port
-	HttpPort string `mapstructure:"http_port"` // HTTP server port
+	Port            string  `mapstructure:"port" desc:"TCP server port"`
+	HttpPort        string  `mapstructure:"http_port" desc:"HTTP server port"`
+	BindAddress     string  `mapstructure:"bind_address" desc:"Interface to listen on, empty for all"`
+	DrainSeconds    float64 `mapstructure:"drain_seconds" desc:"Time given to clients to finish on shutdown"`
+	StateFile       string  `mapstructure:"state_file" desc:"Where stream IDs are persisted across restarts, empty to not persist"`
+	ClientPrefsFile string  `mapstructure:"client_prefs_file" desc:"Where the format, volume and delay of named listeners (?client=) are remembered, empty to not persist"`
+	DSCP            string  `mapstructure:"dscp" desc:"QoS marking for audio sockets: EF, AF41, ... or 0-63; empty leaves the default"`
+	DeveloperMode   bool    `mapstructure:"developer_mode" desc:"Enable the /api/v1/dev fault injection API for testing; never on a production relay"`
+
+	Profiling bool `mapstructure:"profiling" desc:"Serve pprof and expvar under /api/v1/debug, to localhost only unless auth.admin has credentials"`
+
+	ReadySeconds float64 `mapstructure:"ready_seconds" desc:"/readyz fails once no audio has been captured for this long"`
+
+	StatsMinutes int `mapstructure:"stats_minutes" desc:"Minutes of per-minute statistics kept for /stats, 0 to disable"`
 }
 
 type AudioConfig struct {
-	SampleRate      float64 `mapstructure:"sample_rate"`      // Audio sample rate in Hz
-	Channels        int     `mapstructure:"channels"`         // Number of audio channels
-	BufferSize      int     `mapstructure:"buffer_size"`      // Audio buffer size in samples
-	DeviceName      string  `mapstructure:"device_name"`      // Specific audio device name
-	AutoSelect      bool    `mapstructure:"auto_select"`      // Auto select default device
-	PreferBlackHole bool    `mapstructure:"prefer_blackhole"` // Prefer BlackHole virtual devices
+	SampleRate      float64  `mapstructure:"sample_rate" desc:"Audio sample rate in Hz"`
+	Channels        int      `mapstructure:"channels" desc:"Number of audio channels"`
+	BufferSize      int      `mapstructure:"buffer_size" desc:"Audio buffer size in samples"`
+	DeviceName      string   `mapstructure:"device_name" desc:"Specific audio device name"`
+	DeviceIndex     int      `mapstructure:"device_index" desc:"Index in the input device list (as printed at startup) when device_name is empty; -1 to not select by index"`
+	DevicePriority  []string `mapstructure:"device_priority" desc:"Devices to try in order when device_name is empty; \"default\" is the system default input"`
+	AutoSelect      bool     `mapstructure:"auto_select" desc:"Auto select default device"`
+	PreferBlackHole bool     `mapstructure:"prefer_blackhole" desc:"Auto-select the first present device of prefer_devices"`
+	PreferDevices   []string `mapstructure:"prefer_devices" desc:"Virtual devices to auto-select, in order: name substrings or globs, case-insensitive"`
+	SampleFormat    string   `mapstructure:"sample_format" desc:"Capture format: int16, int24 or float32"`
+	OutputBitDepth  int      `mapstructure:"output_bit_depth" desc:"Relayed bit depth: 16, 24 or 32 (float); 0 follows the capture format"`
+	CrossfadeMs     float64  `mapstructure:"crossfade_ms" desc:"Fade out/in length when switching devices"`
+	FollowRate      bool     `mapstructure:"follow_device_rate" desc:"Switch to the new device's default sample rate on device switches; clients are told to reconnect"`
+	Loopback        bool     `mapstructure:"loopback" desc:"Windows: capture system output via WASAPI loopback; device_name names the output device"`
+	HostApi         string   `mapstructure:"host_api" desc:"Only use devices of this host API: wasapi, wdmks, asio, coreaudio, alsa, jack, ...; empty for any"`
+	LatencyMs       float64  `mapstructure:"latency_ms" desc:"Input latency requested from the driver; 0 uses the device's default low latency"`
+
+	Backend string      `mapstructure:"backend" desc:"Capture backend: portaudio, or pulse (Linux PulseAudio/PipeWire via parec)"`
+	Pulse   PulseConfig `mapstructure:"pulse" desc:"PulseAudio/PipeWire backend settings"`
+
+	Reconnect ReconnectConfig `mapstructure:"reconnect" desc:"Recovery when the capture device disappears"`
+	Idle      IdleConfig      `mapstructure:"idle" desc:"Pause capture while no one listens"`
+	Watchdog  WatchdogConfig  `mapstructure:"watchdog" desc:"Restart capture when the device stops delivering audio"`
+}
+
+type IdleConfig struct {
+	Enabled        bool    `mapstructure:"enabled" desc:"Stop the capture stream after the timeout without listeners; it restarts on the next connect"`
+	TimeoutMinutes float64 `mapstructure:"timeout_minutes" desc:"Time without listeners before capture pauses"`
+}
+
+type PulseConfig struct {
+	Source      string `mapstructure:"source" desc:"Source name as shown by pactl list sources; empty for the default sink's monitor"`
+	Application string `mapstructure:"application" desc:"Capture only this application's playback (application.name or binary)"`
+}
+
+type ReconnectConfig struct {
+	Enabled         bool    `mapstructure:"enabled" desc:"Reopen the device when it returns instead of stopping capture"`
+	IntervalSeconds float64 `mapstructure:"interval_seconds" desc:"Time between checks for the device"`
 }
 
 type ProcessingConfig struct {
-	SilenceDetection bool    `mapstructure:"silence_detection"` // Enable/disable silence detection
-	SilenceThreshold int     `mapstructure:"silence_threshold"` // Silence detection threshold
-	VolumeMultiplier float64 `mapstructure:"volume_multiplier"` // Volume adjustment
-	ClipThreshold    int16   `mapstructure:"clip_threshold"`    // Audio clipping threshold
+	SilenceDetection bool     `mapstructure:"silence_detection" desc:"Enable/disable silence detection"`
+	SilenceThreshold int      `mapstructure:"silence_threshold" desc:"Silence detection threshold"`
+	VolumeMultiplier float64  `mapstructure:"volume_multiplier" desc:"Volume adjustment"`
+	ClipThreshold    int16    `mapstructure:"clip_threshold" desc:"Limiter ceiling in sample units"`
+	DCBlock          bool     `mapstructure:"dc_block" desc:"Remove DC offset from the input"`
+	DownmixMono      bool     `mapstructure:"downmix_mono" desc:"Mix all channels to mono before broadcasting"`
+	MuteChannels     []int    `mapstructure:"mute_channels" desc:"Capture channels muted at startup"`
+	SwapChannels     bool     `mapstructure:"swap_channels" desc:"Swap left/right at startup"`
+	InvertChannels   []int    `mapstructure:"invert_channels" desc:"Capture channels with inverted polarity"`
+	StereoWidth      float64  `mapstructure:"stereo_width" desc:"0 = mono, 1 = original, >1 = wider"`
+	Chain            []string `mapstructure:"chain" desc:"Order of processing stages; leave a stage out to bypass it"`
+
+	Limiter   LimiterConfig   `mapstructure:"limiter" desc:"Lookahead limiter settings"`
+	NoiseGate NoiseGateConfig `mapstructure:"noise_gate" desc:"Noise gate settings"`
+	HighPass  FilterConfig    `mapstructure:"highpass" desc:"High-pass filter settings"`
+	LowPass   FilterConfig    `mapstructure:"lowpass" desc:"Low-pass filter settings"`
+	MidSide   MidSideConfig   `mapstructure:"mid_side" desc:"Mid/side stage settings"`
+	Dither    DitherConfig    `mapstructure:"dither" desc:"Dither applied when reducing bit depth"`
+	Keepalive KeepaliveConfig `mapstructure:"keepalive" desc:"Frames sent while silence is skipped"`
+}
+
+type LimiterConfig struct {
+	LookaheadMs float64 `mapstructure:"lookahead_ms" desc:"Lookahead window in milliseconds"`
+	ReleaseMs   float64 `mapstructure:"release_ms" desc:"Gain recovery time in milliseconds"`
+}
+
+type FilterConfig struct {
+	CutoffHz float64 `mapstructure:"cutoff_hz" desc:"Cutoff frequency, 0 disables the filter"`
+	Q        float64 `mapstructure:"q" desc:"Resonance for second-order filters"`
+	Order    int     `mapstructure:"order" desc:"1 (6 dB/oct) or 2 (12 dB/oct biquad)"`
+}
+
+type MidSideConfig struct {
+	Mode     string  `mapstructure:"mode" desc:"Empty (off), encode, decode or matrix"`
+	MidGain  float64 `mapstructure:"mid_gain" desc:"Gain applied to the mid signal"`
+	SideGain float64 `mapstructure:"side_gain" desc:"Gain applied to the side signal"`
+}
+
+type KeepaliveConfig struct {
+	Enabled      bool    `mapstructure:"enabled" desc:"Send occasional frames during skipped silence"`
+	IntervalMs   float64 `mapstructure:"interval_ms" desc:"Time between keepalive frames"`
+	ComfortNoise bool    `mapstructure:"comfort_noise" desc:"Low-level noise instead of digital silence"`
+}
+
+type DitherConfig struct {
+	Enabled bool   `mapstructure:"enabled" desc:"Add TPDF dither when the output has fewer bits than the capture"`
+	Profile string `mapstructure:"profile" desc:"Noise shaping: flat, highpass or e-weighted"`
+}
+
+type NoiseGateConfig struct {
+	Enabled   bool    `mapstructure:"enabled" desc:"Enable the noise gate"`
+	Threshold int     `mapstructure:"threshold" desc:"Level below which the gate closes"`
+	HoldMs    float64 `mapstructure:"hold_ms" desc:"Time the gate stays open after the signal drops"`
+	ReleaseMs float64 `mapstructure:"release_ms" desc:"Fade-out time when closing"`
 }
 
 type ProtocolsConfig struct {
-	TCP  ProtocolConfig `mapstructure:"tcp"`  // TCP protocol configuration
-	HTTP HTTPConfig     `mapstructure:"http"` // HTTP protocol configuration
+	TCP  ProtocolConfig `mapstructure:"tcp" desc:"TCP protocol configuration"`
+	HTTP HTTPConfig     `mapstructure:"http" desc:"HTTP protocol configuration"`
+	UDP  UDPConfig      `mapstructure:"udp" desc:"UDP unicast push configuration"`
+
+	RateLimit RateLimitConfig `mapstructure:"rate_limit" desc:"Per-IP limit on new TCP connections and HTTP requests"`
 }
 
 type ProtocolConfig struct {
-	Enabled bool `mapstructure:"enabled"` // Enable the protocol
+	Enabled     bool    `mapstructure:"enabled" desc:"Enable the protocol"`
+	UpmixStereo bool    `mapstructure:"upmix_stereo" desc:"Duplicate mono output to stereo"`
+	PrebufferMs float64 `mapstructure:"prebuffer_ms" desc:"Recent audio sent to new clients; adds the same delay"`
+	Listeners   int     `mapstructure:"listeners" desc:"Accept loops sharing the port via SO_REUSEPORT; clients are sharded across them"`
+	MaxClients  int     `mapstructure:"max_clients" desc:"Connected clients allowed at once, 0 for no limit; more are turned away"`
+	QueueMs     float64 `mapstructure:"queue_ms" desc:"Audio queued for each client before the oldest is dropped, so a slow one can't hold up the others"`
+	Framing     bool    `mapstructure:"framing" desc:"Wrap the PCM in frames with a sequence number and capture time instead of sending it raw"`
+	FrameCRC    bool    `mapstructure:"frame_crc" desc:"Append a CRC-32 to every frame, with framing enabled"`
+	TLS         bool    `mapstructure:"tls" desc:"Encrypt the stream with TLS, using the certificate of protocols.http.tls"`
+
+	Handshake          bool    `mapstructure:"handshake" desc:"Describe the stream to new clients in a text line and let them ask for another rate, channel count or sample format"`
+	HandshakeTimeoutMs float64 `mapstructure:"handshake_timeout_ms" desc:"How long a client has to ask for a format before it gets the stream as it is"`
+
+	AuthToken string `mapstructure:"auth_token" secret:"true" desc:"Token clients must send, followed by a newline, as the first bytes of the connection; empty for none"`
+
+	HeartbeatMs  float64 `mapstructure:"heartbeat_ms" desc:"Send a heartbeat frame after this long without audio, with framing enabled; 0 to disable"`
+	DeadClientMs float64 `mapstructure:"dead_client_ms" desc:"Drop a client once sent data has gone unacknowledged this long (Linux); 0 for the system default"`
+
+	Control bool `mapstructure:"control" desc:"Read text control messages from clients to change their volume and format, pause and report their buffer level"`
+
+	WriteTimeoutMs   float64 `mapstructure:"write_timeout_ms" desc:"How long a write may block before the client is dropped; short on LANs, longer for WAN relays"`
+	WriteBufferBytes int     `mapstructure:"write_buffer_bytes" desc:"Socket send buffer per client, 0 for the system default"`
+	ReadBufferBytes  int     `mapstructure:"read_buffer_bytes" desc:"Socket receive buffer per client, 0 for the system default"`
+	KeepaliveMs      float64 `mapstructure:"keepalive_ms" desc:"Idle time before TCP keepalive probes and time between them, 0 to disable keepalive"`
 }
 
 type HTTPConfig struct {
-	Enabled bool `mapstructure:"enabled"` // Enable HTTP server
+	Enabled     bool       `mapstructure:"enabled" desc:"Enable HTTP server"`
+	UpmixStereo bool       `mapstructure:"upmix_stereo" desc:"Duplicate mono output to stereo"`
+	PrebufferMs float64    `mapstructure:"prebuffer_ms" desc:"Recent audio sent to new clients; adds the same delay"`
+	MaxClients  int        `mapstructure:"max_clients" desc:"Stream and capture listeners allowed at once, 0 for no limit; more get 503"`
+	FFmpegPath  string     `mapstructure:"ffmpeg_path" desc:"ffmpeg binary encoding the opus, mp3 and flac formats of /stream"`
+	WebRoot     string     `mapstructure:"web_root" desc:"Directory whose files replace or add to the built-in web interface; empty for the built-in one only"`
+	QueueMs     float64    `mapstructure:"queue_ms" desc:"Audio queued for each listener before the oldest is dropped, so a slow one can't hold up the others"`
+	TLS         TLSConfig  `mapstructure:"tls" desc:"HTTPS, needed by browsers for autoplay and service workers on other hosts than localhost"`
+	CORS        CORSConfig `mapstructure:"cors" desc:"Which web pages on other origins may read the stream, status and API responses"`
+	ICY         ICYConfig  `mapstructure:"icy" desc:"SHOUTcast stream titles for internet radio players"`
+
+	TrustedProxies []string `mapstructure:"trusted_proxies" desc:"Reverse proxies (IPs or CIDRs) whose X-Forwarded-For and X-Real-IP name the real client"`
 	// StreamPath string `mapstructure:"stream_path"` // WebSocket stream path
 }
 
+type UDPConfig struct {
+	Enabled     bool     `mapstructure:"enabled" desc:"Send the stream to the UDP targets"`
+	UpmixStereo bool     `mapstructure:"upmix_stereo" desc:"Duplicate mono output to stereo"`
+	Targets     []string `mapstructure:"targets" desc:"Receivers (host:port) that always get the stream"`
+	MTU         int      `mapstructure:"mtu" desc:"Path MTU; packets are sized to avoid IP fragmentation (1420 for WireGuard)"`
+	Aggregate   bool     `mapstructure:"aggregate" desc:"Combine small capture buffers into full-size packets"`
+}
+
+type TriggersConfig struct {
+	Level    LevelTriggerConfig `mapstructure:"level" desc:"Fire when the audio level crosses a threshold"`
+	Snapshot SnapshotConfig     `mapstructure:"snapshot" desc:"Save a clip around each trigger"`
+}
+
+type LevelTriggerConfig struct {
+	Enabled         bool    `mapstructure:"enabled" desc:"Enable the level trigger"`
+	Threshold       int     `mapstructure:"threshold" desc:"Peak level that fires the trigger"`
+	CooldownSeconds float64 `mapstructure:"cooldown_seconds" desc:"Minimum time between triggers"`
+}
+
+type SnapshotConfig struct {
+	Enabled         bool    `mapstructure:"enabled" desc:"Save audio clips on triggers"`
+	Directory       string  `mapstructure:"directory" desc:"Directory for saved clips"`
+	PreRollSeconds  float64 `mapstructure:"pre_roll_seconds" desc:"Audio kept from before the trigger"`
+	PostRollSeconds float64 `mapstructure:"post_roll_seconds" desc:"Audio recorded after the trigger"`
+	TrimSilence     bool    `mapstructure:"trim_silence" desc:"Cut leading and trailing silence (below processing.silence_threshold) from saved clips"`
+}
+
+type JobsConfig struct {
+	Workers int `mapstructure:"workers" desc:"Number of concurrent background jobs"`
+}
+
+type AnalysisConfig struct {
+	Enabled    bool             `mapstructure:"enabled" desc:"Feed the broadcast stream to analyzers"`
+	Analyzers  []string         `mapstructure:"analyzers" desc:"Analyzers to run: classifier, or names registered with RegisterAnalyzer"`
+	Classifier ClassifierConfig `mapstructure:"classifier" desc:"Music/speech classifier"`
+}
+
+type ClassifierConfig struct {
+	WindowSeconds float64 `mapstructure:"window_seconds" desc:"Audio judged per decision; the class changes after two agreeing windows"`
+	SilenceDB     float64 `mapstructure:"silence_db" desc:"Average level (dBFS) below which audio counts as silence"`
+}
+
+type LoggingConfig struct {
+	Verbose   bool   `mapstructure:"verbose" desc:"Log debug details (connections, switches, events)"`
+	AccessLog string `mapstructure:"access_log" desc:"HTTP access log: streams (audio streams and captures), all or off"`
+}
+
+// defaultConfigFile is the config file name, looked for in the working
+// directory and the system config directories
+const defaultConfigFile = "config.yml"
+
+// ConfigSearchPaths lists where the config file is looked for when no path
+// is given, in order
+func ConfigSearchPaths() []string {
+	paths := []string{defaultConfigFile}
+
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		configDir, _ = os.UserConfigDir()
+	}
+	if configDir != "" {
+		paths = append(paths, filepath.Join(configDir, "audiorelay", defaultConfigFile))
+	}
+
+	if runtime.GOOS != "windows" {
+		paths = append(paths, filepath.Join("/etc/audiorelay", defaultConfigFile))
+	}
+	return paths
+}
+
+// FindConfig returns the first existing file of ConfigSearchPaths, or
+// config.yml in the working directory if there is none
+func FindConfig() string {
+	for _, path := range ConfigSearchPaths() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return defaultConfigFile
+}
+
 // LoadConfig loads configuration using Viper
 func LoadConfig(configPath string) (*Config, error) {
+	return LoadConfigWithFlags(configPath, nil)
+}
+
+// LoadConfigWithFlags loads configuration like LoadConfig, with the flags
+// registered by RegisterConfigFlags overriding the file. flags may be nil.
+func LoadConfigWithFlags(configPath string, flags *pflag.FlagSet) (*Config, error) {
 	v := viper.New()
 
 	// Set default values
@@ -63,8 +306,23 @@ func LoadConfig(configPath string) (*Config, error) {
 
 	// Read configuration
 	if err := v.ReadInConfig(); err != nil {
-		log.Printf("Warning: Could not read config file: %v", err)
-		log.Println("Using default configuration")
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("No config file at %s, using defaults and command-line flags", configPath)
+		} else {
+			log.Printf("Warning: Could not read config file: %v", err)
+			log.Println("Using default configuration")
+		}
+	}
+
+	if flags != nil {
+		if err := bindConfigFlags(v, flags); err != nil {
+			return nil, fmt.Errorf("failed to apply command-line flags: %v", err)
+		}
+	}
+
+	// The profile replaces some defaults; explicit keys still win
+	if err := applyProfile(v); err != nil {
+		return nil, err
 	}
 
 	// Unmarshal configuration
@@ -82,39 +340,280 @@ func LoadConfig(configPath string) (*Config, error) {
 	return &cfg, nil
 }
 
+// DefaultConfig returns the built-in configuration without reading any file
+func DefaultConfig() (*Config, error) {
+	v := viper.New()
+	setDefaults(v)
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+	return &cfg, nil
+}
+
+// ApplyDeviceFlag overrides the configured device with the --device flag,
+// which is either an index in the input device list or a device name
+func (c *Config) ApplyDeviceFlag(device string) {
+	if device == "" {
+		return
+	}
+	if index, err := strconv.Atoi(device); err == nil {
+		c.Audio.DeviceName = ""
+		c.Audio.DeviceIndex = index
+		return
+	}
+	c.Audio.DeviceName = device
+	c.Audio.DeviceIndex = -1
+}
+
+// ApplySafeMode reduces the configuration to a known-good baseline for
+// troubleshooting: no processing or extras, localhost only, verbose logging
+func (c *Config) ApplySafeMode() {
+	c.Server.BindAddress = "127.0.0.1"
+	c.Logging.Verbose = true
+
+	// Plain 16-bit capture passed through untouched
+	c.Audio.SampleFormat = "int16"
+	c.Audio.OutputBitDepth = 0
+	c.Processing = ProcessingConfig{
+		SilenceThreshold: c.Processing.SilenceThreshold,
+		VolumeMultiplier: 1,
+		ClipThreshold:    c.Processing.ClipThreshold,
+		Chain:            []string{}, // Every stage bypassed
+		StereoWidth:      1,
+		Limiter:          c.Processing.Limiter,
+		Dither:           DitherConfig{Profile: DitherFlat},
+		HighPass:         c.Processing.HighPass,
+		LowPass:          c.Processing.LowPass,
+	}
+	c.Processing.HighPass.CutoffHz = 0
+	c.Processing.LowPass.CutoffHz = 0
+
+	// No extras
+	c.Triggers.Level.Enabled = false
+	c.Triggers.Snapshot.Enabled = false
+	c.Analysis.Enabled = false
+	c.Audio.Idle.Enabled = false
+	c.Share.Enabled = false
+	c.Standby.Primary = ""
+	c.Server.DeveloperMode = false
+	c.Server.Profiling = false
+	c.Tracing.Endpoint = ""
+	c.Server.ClientPrefsFile = "" // Saved listener settings don't apply either
+	// Plain HTTP and TCP can't fail on a bad certificate
+	c.Protocols.HTTP.TLS.Enabled = false
+	c.Protocols.TCP.TLS = false
+	// The built-in web interface, not a customized one
+	c.Protocols.HTTP.WebRoot = ""
+}
+
+// DrainTimeout returns how long shutdown waits for clients to finish
+func (c *Config) DrainTimeout() time.Duration {
+	return time.Duration(c.Server.DrainSeconds * float64(time.Second))
+}
+
+// ListenAddr returns the listen address for a port on the configured interface
+func (c *Config) ListenAddr(port string) string {
+	return net.JoinHostPort(c.Server.BindAddress, port)
+}
+
 // setDefaults sets default configuration values
 func setDefaults(v *viper.Viper) {
 	// Server defaults
 	v.SetDefault("server.port", "12345")
 	v.SetDefault("server.http_port", "8080")
+	v.SetDefault("server.bind_address", "")
+	v.SetDefault("server.dscp", "")
+	v.SetDefault("server.drain_seconds", 2.0)
+	v.SetDefault("server.developer_mode", false)
+	v.SetDefault("server.profiling", false)
+	v.SetDefault("server.ready_seconds", 5)
+	v.SetDefault("server.stats_minutes", 60)
+	v.SetDefault("server.state_file", "audiorelay-state.json")
+	v.SetDefault("server.client_prefs_file", "audiorelay-clients.json")
+
+	// Logging defaults
+	v.SetDefault("logging.verbose", false)
+	v.SetDefault("logging.access_log", accessLogStreams)
 
 	// Audio defaults
+	v.SetDefault("profile", ProfileBalanced)
 	v.SetDefault("audio.sample_rate", 48000)
 	v.SetDefault("audio.channels", 2)
 	v.SetDefault("audio.buffer_size", 0)
 	v.SetDefault("audio.device_name", "")
 	v.SetDefault("audio.auto_select", false)
 	v.SetDefault("audio.prefer_blackhole", true)
+	v.SetDefault("audio.host_api", "")
+	v.SetDefault("audio.watchdog.enabled", true)
+	v.SetDefault("audio.watchdog.timeout_seconds", 5)
+	v.SetDefault("audio.latency_ms", 0)
+	v.SetDefault("power.off_delay_seconds", 60)
+	v.SetDefault("notifications.silence_seconds", 60)
+	v.SetDefault("tracing.endpoint", "")
+	v.SetDefault("tracing.service_name", "audiorelay")
+	v.SetDefault("tracing.sample_ratio", 0.01)
+	v.SetDefault("share.enabled", true)
+	v.SetDefault("share.default_expiry_minutes", 60)
+	v.SetDefault("share.max_expiry_minutes", 7*24*60)
+	v.SetDefault("assets.directory", "assets")
+	v.SetDefault("assets.normalize_db", -3)
+	v.SetDefault("assets.max_upload_mb", 20)
+	v.SetDefault("standby.primary", "")
+	v.SetDefault("standby.advertise_url", "")
+	v.SetDefault("standby.sync_seconds", 2.0)
+	v.SetDefault("standby.token", "")
+	for _, group := range []string{authStreams, authStatus, authAdmin} {
+		v.SetDefault("auth."+group+".tokens", []string{})
+		v.SetDefault("auth."+group+".users", []string{})
+	}
+	v.SetDefault("audio.prefer_devices", []string{"BlackHole", "VB-Cable", "Loopback", "Soundflower"})
+	v.SetDefault("audio.sample_format", "int16")
+	v.SetDefault("audio.output_bit_depth", 0)
+	v.SetDefault("audio.device_index", -1)
+	v.SetDefault("audio.device_priority", []string{})
+	v.SetDefault("audio.crossfade_ms", 50.0)
+	v.SetDefault("audio.follow_device_rate", false)
+	v.SetDefault("audio.loopback", false)
+	v.SetDefault("audio.backend", BackendPortAudio)
+	v.SetDefault("audio.pulse.source", "")
+	v.SetDefault("audio.pulse.application", "")
+	v.SetDefault("audio.idle.enabled", false)
+	v.SetDefault("audio.idle.timeout_minutes", 10.0)
+	v.SetDefault("audio.reconnect.enabled", true)
+	v.SetDefault("audio.reconnect.interval_seconds", 2.0)
 
 	// Processing defaults
 	v.SetDefault("processing.silence_detection", true) // Enable silence detection by default
 	v.SetDefault("processing.silence_threshold", 1000)
 	v.SetDefault("processing.volume_multiplier", 1.0)
 	v.SetDefault("processing.clip_threshold", 28000)
+	v.SetDefault("processing.dc_block", false)
+	v.SetDefault("processing.downmix_mono", false)
+	v.SetDefault("processing.mute_channels", []int{})
+	v.SetDefault("processing.swap_channels", false)
+	v.SetDefault("processing.invert_channels", []int{})
+	v.SetDefault("processing.stereo_width", 1.0)
+	v.SetDefault("processing.chain", defaultProcessingChain)
+	v.SetDefault("processing.mid_side.mode", "")
+	v.SetDefault("processing.mid_side.mid_gain", 1.0)
+	v.SetDefault("processing.mid_side.side_gain", 1.0)
+	v.SetDefault("processing.keepalive.enabled", true)
+	v.SetDefault("processing.keepalive.interval_ms", 250.0)
+	v.SetDefault("processing.keepalive.comfort_noise", false)
+	v.SetDefault("processing.dither.enabled", true)
+	v.SetDefault("processing.dither.profile", DitherFlat)
+	v.SetDefault("processing.limiter.lookahead_ms", 5.0)
+	v.SetDefault("processing.limiter.release_ms", 80.0)
+	v.SetDefault("processing.noise_gate.enabled", false)
+	v.SetDefault("processing.noise_gate.threshold", 300)
+	v.SetDefault("processing.noise_gate.hold_ms", 150.0)
+	v.SetDefault("processing.noise_gate.release_ms", 100.0)
+	v.SetDefault("processing.highpass.cutoff_hz", 0.0)
+	v.SetDefault("processing.highpass.q", 0.707)
+	v.SetDefault("processing.highpass.order", 2)
+	v.SetDefault("processing.lowpass.cutoff_hz", 0.0)
+	v.SetDefault("processing.lowpass.q", 0.707)
+	v.SetDefault("processing.lowpass.order", 2)
 
 	// Protocols defaults
 	v.SetDefault("protocols.tcp.enabled", true)
+	v.SetDefault("protocols.tcp.upmix_stereo", false)
+	v.SetDefault("protocols.tcp.prebuffer_ms", 0)
+	v.SetDefault("protocols.tcp.listeners", 1)
+	v.SetDefault("protocols.tcp.max_clients", 100)
+	v.SetDefault("protocols.tcp.queue_ms", 2000)
+	v.SetDefault("protocols.tcp.framing", false)
+	v.SetDefault("protocols.tcp.frame_crc", false)
+	v.SetDefault("protocols.tcp.tls", false)
+	v.SetDefault("protocols.tcp.handshake", false)
+	v.SetDefault("protocols.tcp.handshake_timeout_ms", 500)
+	v.SetDefault("protocols.tcp.auth_token", "")
+	v.SetDefault("protocols.tcp.heartbeat_ms", 1000)
+	v.SetDefault("protocols.tcp.dead_client_ms", 10000)
+	v.SetDefault("protocols.tcp.control", false)
+	v.SetDefault("protocols.tcp.write_timeout_ms", 2000)
+	v.SetDefault("protocols.tcp.write_buffer_bytes", 32*1024)
+	v.SetDefault("protocols.tcp.read_buffer_bytes", 16*1024)
+	v.SetDefault("protocols.tcp.keepalive_ms", 15000)
+	v.SetDefault("protocols.http.max_clients", 100)
 	v.SetDefault("protocols.http.enabled", true)
+	v.SetDefault("protocols.http.upmix_stereo", true)
+	v.SetDefault("protocols.http.prebuffer_ms", 500)
+	v.SetDefault("protocols.http.queue_ms", 2000)
+	v.SetDefault("protocols.http.ffmpeg_path", "ffmpeg")
+	v.SetDefault("protocols.http.web_root", "")
+	v.SetDefault("protocols.http.trusted_proxies", []string{})
+	v.SetDefault("protocols.http.tls.enabled", false)
+	v.SetDefault("protocols.http.tls.cert_file", "audiorelay-cert.pem")
+	v.SetDefault("protocols.http.tls.key_file", "audiorelay-key.pem")
+	v.SetDefault("protocols.http.tls.self_signed", true)
+	v.SetDefault("protocols.http.tls.hosts", []string{})
+	v.SetDefault("protocols.http.cors.allowed_origins", []string{})
+	v.SetDefault("protocols.http.cors.allow_credentials", false)
+	v.SetDefault("protocols.http.cors.exposed_headers", []string{
+		"X-Stream-Id", "X-Stream-Format-Version", "X-Standby-Url", "X-Audio-Rate", "X-Audio-Channels", "X-Audio-Format",
+		"Accept-Ranges", "Content-Range", "Content-Length", "Icy-Metaint", "Icy-Name",
+	})
+	v.SetDefault("protocols.http.icy.enabled", true)
+	v.SetDefault("protocols.http.icy.metaint", 16000)
+	v.SetDefault("protocols.http.icy.name", "")
+	v.SetDefault("protocols.http.icy.title", "")
+	v.SetDefault("protocols.rate_limit.per_second", 10)
+	v.SetDefault("protocols.rate_limit.burst", 30)
+	v.SetDefault("protocols.udp.enabled", false)
+	v.SetDefault("protocols.udp.upmix_stereo", false)
+	v.SetDefault("protocols.udp.targets", []string{})
+	v.SetDefault("protocols.udp.mtu", 1500)
+	v.SetDefault("protocols.udp.aggregate", false)
+
+	// Job defaults
+	v.SetDefault("jobs.workers", 1)
+
+	// Analysis defaults
+	v.SetDefault("analysis.enabled", false)
+	v.SetDefault("analysis.analyzers", []string{"classifier"})
+	v.SetDefault("analysis.classifier.window_seconds", 1.0)
+	v.SetDefault("analysis.classifier.silence_db", -50.0)
+
+	// Trigger defaults
+	v.SetDefault("triggers.level.enabled", false)
+	v.SetDefault("triggers.level.threshold", 20000)
+	v.SetDefault("triggers.level.cooldown_seconds", 10.0)
+	v.SetDefault("triggers.snapshot.enabled", false)
+	v.SetDefault("triggers.snapshot.directory", "clips")
+	v.SetDefault("triggers.snapshot.pre_roll_seconds", 5.0)
+	v.SetDefault("triggers.snapshot.post_roll_seconds", 5.0)
+	v.SetDefault("triggers.snapshot.trim_silence", false)
 }
 
 // Validate checks if configuration parameters are valid
 func (c *Config) Validate() error {
+	if _, ok := profileDefaults[c.Profile]; !ok {
+		return fmt.Errorf("unknown profile %q, expected %s", c.Profile, strings.Join(profileNames(), ", "))
+	}
 	if c.Server.Port == "" {
 		return fmt.Errorf("server port cannot be empty")
 	}
 	if c.Server.HttpPort == "" {
 		return fmt.Errorf("HTTP server port cannot be empty")
 	}
+	if c.Server.DrainSeconds < 0 {
+		return fmt.Errorf("drain period cannot be negative")
+	}
+	if c.Server.ReadySeconds <= 0 {
+		return fmt.Errorf("ready_seconds must be positive")
+	}
+	if c.Server.StatsMinutes < 0 {
+		return fmt.Errorf("stats_minutes cannot be negative")
+	}
+	if _, _, err := parseDSCP(c.Server.DSCP); err != nil {
+		return err
+	}
+	if err := validateAccessLog(c.Logging.AccessLog); err != nil {
+		return err
+	}
 	if c.Audio.SampleRate <= 0 {
 		return fmt.Errorf("sample rate must be positive")
 	}
@@ -124,15 +623,299 @@ func (c *Config) Validate() error {
 	if c.Audio.BufferSize < 0 {
 		return fmt.Errorf("buffer size must be positive")
 	}
+	switch c.Audio.SampleFormat {
+	case "int16", "int24", "float32":
+	default:
+		return fmt.Errorf("unknown sample format: %q (use int16, int24 or float32)", c.Audio.SampleFormat)
+	}
+	if c.Audio.CrossfadeMs < 0 {
+		return fmt.Errorf("crossfade cannot be negative")
+	}
+	if c.Audio.Watchdog.Enabled && c.Audio.Watchdog.TimeoutSeconds <= 0 {
+		return fmt.Errorf("watchdog timeout must be positive")
+	}
+	if c.Audio.Idle.Enabled && c.Audio.Idle.TimeoutMinutes <= 0 {
+		return fmt.Errorf("idle timeout must be positive")
+	}
+	if c.Audio.LatencyMs < 0 {
+		return fmt.Errorf("latency_ms cannot be negative")
+	}
+	if c.Audio.HostApi != "" {
+		if _, ok := hostApiTypes[strings.ToLower(c.Audio.HostApi)]; !ok {
+			return fmt.Errorf("unknown host API: %q (use %s)", c.Audio.HostApi, strings.Join(hostApiNames(), ", "))
+		}
+		if c.Audio.Loopback && !strings.EqualFold(c.Audio.HostApi, "wasapi") {
+			return fmt.Errorf("loopback capture needs host_api wasapi")
+		}
+	}
+	if c.Audio.DeviceIndex < -1 {
+		return fmt.Errorf("device_index must be -1 or a device list index")
+	}
+	switch c.Audio.Backend {
+	case BackendPortAudio, BackendPulse:
+	default:
+		return fmt.Errorf("unknown capture backend: %q (use portaudio or pulse)", c.Audio.Backend)
+	}
+	if c.Audio.Reconnect.Enabled && c.Audio.Reconnect.IntervalSeconds <= 0 {
+		return fmt.Errorf("reconnect interval must be positive")
+	}
+	switch c.Audio.OutputBitDepth {
+	case 0, 16, 24, 32:
+	default:
+		return fmt.Errorf("output bit depth must be 16, 24 or 32")
+	}
+	if c.Processing.ClipThreshold <= 0 {
+		return fmt.Errorf("clip threshold must be positive")
+	}
+	if c.Processing.Limiter.LookaheadMs < 0 {
+		return fmt.Errorf("limiter lookahead cannot be negative")
+	}
+	if c.Processing.Limiter.ReleaseMs <= 0 {
+		return fmt.Errorf("limiter release must be positive")
+	}
+	if c.Processing.NoiseGate.Enabled {
+		if c.Processing.NoiseGate.Threshold <= 0 {
+			return fmt.Errorf("noise gate threshold must be positive")
+		}
+		if c.Processing.NoiseGate.HoldMs < 0 || c.Processing.NoiseGate.ReleaseMs < 0 {
+			return fmt.Errorf("noise gate hold and release cannot be negative")
+		}
+	}
+	for name, filter := range map[string]FilterConfig{"highpass": c.Processing.HighPass, "lowpass": c.Processing.LowPass} {
+		if filter.CutoffHz == 0 {
+			continue
+		}
+		if filter.CutoffHz < 0 || filter.CutoffHz >= c.Audio.SampleRate/2 {
+			return fmt.Errorf("%s cutoff must be between 0 and half the sample rate", name)
+		}
+		if filter.Order != 1 && filter.Order != 2 {
+			return fmt.Errorf("%s order must be 1 or 2", name)
+		}
+		if filter.Order == 2 && filter.Q <= 0 {
+			return fmt.Errorf("%s q must be positive", name)
+		}
+	}
+	for _, ch := range append(c.Processing.MuteChannels, c.Processing.InvertChannels...) {
+		if ch < 0 || ch >= c.Audio.Channels {
+			return fmt.Errorf("channel %d out of range", ch)
+		}
+	}
+	if c.Processing.Keepalive.Enabled && c.Processing.Keepalive.IntervalMs <= 0 {
+		return fmt.Errorf("keepalive interval must be positive")
+	}
+	seen := make(map[string]bool)
+	for _, name := range c.Processing.Chain {
+		if _, custom := lookupProcessor(name); !builtinProcessors[name] && !custom {
+			return fmt.Errorf("unknown processing stage: %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("processing stage %q listed twice", name)
+		}
+		seen[name] = true
+	}
+	if c.Processing.DownmixMono && !seen["downmix"] {
+		return fmt.Errorf("downmix_mono needs the downmix stage in the processing chain")
+	}
+	if _, ok := ditherProfiles[c.Processing.Dither.Profile]; !ok {
+		return fmt.Errorf("unknown dither profile: %q", c.Processing.Dither.Profile)
+	}
+	if c.Processing.StereoWidth < 0 || c.Processing.StereoWidth > maxStereoWidth {
+		return fmt.Errorf("stereo width must be between 0 and %.0f", maxStereoWidth)
+	}
+	if c.Processing.StereoWidth != 1 && c.Audio.Channels < 2 {
+		return fmt.Errorf("stereo width needs at least 2 channels")
+	}
+	switch c.Processing.MidSide.Mode {
+	case MidSideOff:
+	case MidSideEncode, MidSideDecode, MidSideMatrix:
+		if c.Audio.Channels < 2 {
+			return fmt.Errorf("mid/side processing needs at least 2 channels")
+		}
+		if c.Processing.MidSide.MidGain < 0 || c.Processing.MidSide.SideGain < 0 {
+			return fmt.Errorf("mid/side gains cannot be negative")
+		}
+	default:
+		return fmt.Errorf("unknown mid/side mode: %q", c.Processing.MidSide.Mode)
+	}
+	if c.Jobs.Workers <= 0 {
+		return fmt.Errorf("job workers must be positive")
+	}
+	if c.Triggers.Snapshot.Enabled {
+		if c.Triggers.Snapshot.Directory == "" {
+			return fmt.Errorf("snapshot directory cannot be empty")
+		}
+		if c.Triggers.Snapshot.PreRollSeconds < 0 || c.Triggers.Snapshot.PostRollSeconds < 0 {
+			return fmt.Errorf("snapshot pre/post roll cannot be negative")
+		}
+	}
+	if c.Analysis.Enabled {
+		if c.Analysis.Classifier.WindowSeconds <= 0 {
+			return fmt.Errorf("classifier window must be positive")
+		}
+		for _, name := range c.Analysis.Analyzers {
+			if _, ok := lookupAnalyzer(name); !ok {
+				return fmt.Errorf("unknown analyzer: %q", name)
+			}
+		}
+	}
+	for _, ms := range []float64{c.Protocols.TCP.PrebufferMs, c.Protocols.HTTP.PrebufferMs} {
+		if ms < 0 || ms > maxPrebufferMs {
+			return fmt.Errorf("prebuffer_ms must be between 0 and %d", maxPrebufferMs)
+		}
+	}
+	if c.Protocols.TCP.Listeners < 1 || c.Protocols.TCP.Listeners > 64 {
+		return fmt.Errorf("TCP listeners must be between 1 and 64")
+	}
+	if c.Protocols.TCP.MaxClients < 0 || c.Protocols.HTTP.MaxClients < 0 {
+		return fmt.Errorf("max_clients can't be negative")
+	}
+	if c.Protocols.TCP.Handshake && (c.Protocols.TCP.HandshakeTimeoutMs <= 0 || c.Protocols.TCP.HandshakeTimeoutMs > maxHandshakeMs) {
+		return fmt.Errorf("TCP handshake_timeout_ms must be between 0 and %d", maxHandshakeMs)
+	}
+	if err := validateTCPAuthToken(c.Protocols.TCP.AuthToken); err != nil {
+		return err
+	}
+	if c.Protocols.TCP.HeartbeatMs < 0 || c.Protocols.TCP.DeadClientMs < 0 {
+		return fmt.Errorf("TCP heartbeat_ms and dead_client_ms can't be negative")
+	}
+	if c.Protocols.TCP.WriteTimeoutMs <= 0 {
+		return fmt.Errorf("TCP write_timeout_ms must be positive")
+	}
+	if c.Protocols.TCP.WriteBufferBytes < 0 || c.Protocols.TCP.ReadBufferBytes < 0 || c.Protocols.TCP.KeepaliveMs < 0 {
+		return fmt.Errorf("TCP write_buffer_bytes, read_buffer_bytes and keepalive_ms can't be negative")
+	}
+	if c.Protocols.UDP.Enabled {
+		if c.Protocols.UDP.MTU < 576 || c.Protocols.UDP.MTU > 65535 {
+			return fmt.Errorf("UDP MTU must be between 576 and 65535")
+		}
+		for _, target := range c.Protocols.UDP.Targets {
+			if _, _, err := net.SplitHostPort(target); err != nil {
+				return fmt.Errorf("invalid UDP target %q: %v", target, err)
+			}
+		}
+	}
+	if c.Share.Enabled && (c.Share.DefaultExpiryMinutes <= 0 || c.Share.DefaultExpiryMinutes > c.Share.MaxExpiryMinutes) {
+		return fmt.Errorf("share default_expiry_minutes must be positive and at most max_expiry_minutes")
+	}
+	if c.Assets.NormalizeDB > 0 {
+		return fmt.Errorf("assets normalize_db must be 0 or below")
+	}
+	if c.Assets.MaxUploadMB <= 0 {
+		return fmt.Errorf("assets max_upload_mb must be positive")
+	}
+	if c.Protocols.TCP.QueueMs <= 0 {
+		return fmt.Errorf("TCP queue_ms must be positive")
+	}
+	if c.Protocols.HTTP.QueueMs <= 0 {
+		return fmt.Errorf("HTTP queue_ms must be positive")
+	}
+	if icy := c.Protocols.HTTP.ICY; icy.Enabled && icy.MetaInt <= 0 {
+		return fmt.Errorf("HTTP icy metaint must be positive")
+	}
+	if c.Protocols.HTTP.WebRoot != "" {
+		if err := checkWebRoot(c.Protocols.HTTP.WebRoot); err != nil {
+			return err
+		}
+	}
+	if _, err := parseTrustedProxies(c.Protocols.HTTP.TrustedProxies); err != nil {
+		return fmt.Errorf("HTTP trusted_proxies: %v", err)
+	}
+	if err := c.Protocols.RateLimit.validate(); err != nil {
+		return err
+	}
+	if err := c.Protocols.HTTP.CORS.validate(); err != nil {
+		return err
+	}
+	if tls := c.Protocols.HTTP.TLS; c.Protocols.HTTP.Enabled && tls.Enabled && (tls.CertFile == "" || tls.KeyFile == "") {
+		return fmt.Errorf("HTTP TLS needs cert_file and key_file")
+	}
+	if tls := c.Protocols.HTTP.TLS; c.Protocols.TCP.Enabled && c.Protocols.TCP.TLS && (tls.CertFile == "" || tls.KeyFile == "") {
+		return fmt.Errorf("TCP TLS needs protocols.http.tls cert_file and key_file")
+	}
+	if err := c.Auth.Validate(); err != nil {
+		return err
+	}
+	if c.Standby.Enabled() {
+		if err := checkBaseURL(c.Standby.Primary); err != nil {
+			return fmt.Errorf("standby primary: %v", err)
+		}
+		if c.Standby.AdvertiseURL != "" {
+			if err := checkBaseURL(c.Standby.AdvertiseURL); err != nil {
+				return fmt.Errorf("standby advertise_url: %v", err)
+			}
+		}
+		if c.Standby.SyncSeconds <= 0 {
+			return fmt.Errorf("standby sync_seconds must be positive")
+		}
+	}
+	if err := c.Power.validate(); err != nil {
+		return err
+	}
+	if err := c.Notifications.validate(); err != nil {
+		return err
+	}
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+	if err := c.validateStreams(); err != nil {
+		return err
+	}
 	// if c.Protocols.HTTP.StreamPath == "" {
 	// 	return fmt.Errorf("HTTP stream path cannot be empty")
 	// }
 	return nil
 }
 
-// CreateDefaultConfig creates a default configuration file
+// OutputChannels returns the channel count of the broadcast stream
+func (c *Config) OutputChannels() int {
+	if c.Processing.DownmixMono {
+		return 1
+	}
+	return c.Audio.Channels
+}
+
+// EndpointChannels returns the channel count delivered to an endpoint;
+// upmixing only applies when the broadcast stream is mono
+func (c *Config) EndpointChannels(upmixStereo bool) int {
+	if upmixStereo && c.OutputChannels() == 1 {
+		return 2
+	}
+	return c.OutputChannels()
+}
+
+// CaptureBits returns the bit depth of the capture sample format
+func (c *Config) CaptureBits() int {
+	switch c.Audio.SampleFormat {
+	case "int24":
+		return 24
+	case "float32":
+		return 32
+	}
+	return 16
+}
+
+// OutputBits returns the bit depth of relayed audio; 32 means IEEE float
+func (c *Config) OutputBits() int {
+	if c.Audio.OutputBitDepth > 0 {
+		return c.Audio.OutputBitDepth
+	}
+	return c.CaptureBits()
+}
+
+// StreamFormat returns the PCM layout delivered to an endpoint
+func (c *Config) StreamFormat(upmixStereo bool) wavFormat {
+	return wavFormat{
+		SampleRate:    int(c.Audio.SampleRate),
+		Channels:      c.EndpointChannels(upmixStereo),
+		BitsPerSample: c.OutputBits(),
+		Float:         c.OutputBits() == 32,
+	}
+}
+
+// CreateDefaultConfig creates a default configuration file, with every
+// key commented with its meaning and default
 func CreateDefaultConfig(filename string) error {
-	v := viper.New()
-	setDefaults(v)
-	return v.WriteConfigAs(filename)
+	var template bytes.Buffer
+	WriteConfigTemplate(&template)
+	return os.WriteFile(filename, template.Bytes(), 0644)
 }
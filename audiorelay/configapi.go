@@ -0,0 +1,414 @@
+package audiorelay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+// runtimeConfigSections are the config sections PUT /api/v1/config may change
+var runtimeConfigSections = []string{"audio.", "processing."}
+
+// redactedValue replaces secrets that are set in GET /api/v1/config and
+// credentials in logged queries
+const redactedValue = "[redacted]"
+
+// configMap returns a config struct as nested maps keyed like the YAML file.
+// With redact, fields tagged secret:"true" that are set are replaced by
+// redactedValue.
+func configMap(v reflect.Value, redact bool) map[string]interface{} {
+	m := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		value := v.Field(i)
+		switch {
+		case redact && field.Tag.Get("secret") == "true" && value.Len() > 0:
+			m[name] = redactedValue
+		case value.Kind() == reflect.Struct:
+			m[name] = configMap(value, redact)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			list := make([]interface{}, value.Len())
+			for j := range list {
+				list[j] = configMap(value.Index(j), redact)
+			}
+			m[name] = list
+		default:
+			m[name] = value.Interface()
+		}
+	}
+	return m
+}
+
+// flattenConfig turns nested maps into dotted keys, e.g.
+// {"processing": {"volume_multiplier": 2}} into processing.volume_multiplier
+func flattenConfig(m map[string]interface{}, prefix string, out map[string]interface{}) {
+	for key, value := range m {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenConfig(nested, prefix+key+".", out)
+			continue
+		}
+		out[prefix+key] = value
+	}
+}
+
+// checkRuntimeKeys rejects keys that are unknown or outside the sections the
+// API may change
+func checkRuntimeKeys(changes map[string]interface{}) error {
+	known := make(map[string]bool)
+	for _, doc := range ConfigDocs() {
+		if !doc.Section {
+			known[doc.Key] = true
+		}
+	}
+	for key := range changes {
+		if !known[key] {
+			return fmt.Errorf("unknown config key %q", key)
+		}
+		allowed := false
+		for _, section := range runtimeConfigSections {
+			allowed = allowed || strings.HasPrefix(key, section)
+		}
+		if !allowed {
+			return fmt.Errorf("%s can't be changed at runtime, only audio and processing settings", key)
+		}
+	}
+	return nil
+}
+
+// mergeConfig returns a copy of config with the changes applied, validated
+func mergeConfig(config *Config, changes map[string]interface{}) (*Config, error) {
+	v := viper.New()
+	if err := v.MergeConfigMap(configMap(reflect.ValueOf(*config), false)); err != nil {
+		return nil, err
+	}
+	for key, value := range changes {
+		v.Set(key, value)
+	}
+
+	var merged Config
+	if err := v.Unmarshal(&merged); err != nil {
+		return nil, fmt.Errorf("invalid value: %v", err)
+	}
+	if err := merged.Validate(); err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
+
+// handleGetConfig returns the effective running configuration, without
+// credentials
+func (ar *AudioRelay) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, configMap(reflect.ValueOf(ar.runningConfig()), true))
+}
+
+// handlePutConfig changes audio and processing settings. Hot settings apply
+// at once; with ?persist=true the changes are also written to the config
+// file, which settings that only apply after a restart require.
+func (ar *AudioRelay) handlePutConfig(w http.ResponseWriter, r *http.Request) {
+	persist := false
+	if p := r.URL.Query().Get("persist"); p != "" {
+		var err error
+		if persist, err = strconv.ParseBool(p); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "persist must be true or false")
+			return
+		}
+	}
+	if persist && ar.configPath == "" {
+		writeJSONError(w, http.StatusConflict, "no config file to write to (safe mode?)")
+		return
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	changes := make(map[string]interface{})
+	flattenConfig(body, "", changes)
+	if err := checkRuntimeKeys(changes); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	running := ar.runningConfig()
+	config, err := mergeConfig(&running, changes)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Without persisting, a change that needs a restart would be lost by it
+	if _, restart := splitSettings(changedSettings(reflect.ValueOf(running), reflect.ValueOf(*config), "")); len(restart) > 0 && !persist {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("these settings only apply after a restart: %s; add ?persist=true to write them to the config file", strings.Join(restart, ", ")))
+		return
+	}
+
+	if persist {
+		if err := persistConfig(ar.configPath, changes); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	applied, restart := ar.applyConfig(config)
+	if len(applied) > 0 {
+		log.Printf("🔄 Config changed via API, applied: %s", strings.Join(applied, ", "))
+	}
+	for _, key := range restart {
+		log.Printf("⚠️ %s changed via API, restart to apply", key)
+	}
+	if len(applied) > 0 || len(restart) > 0 {
+		ar.events.Publish(EventConfigReloaded, map[string]interface{}{
+			"source":           "api",
+			"applied":          applied,
+			"restart_required": restart,
+			"persisted":        persist,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"applied":          nonNil(applied),
+		"restart_required": nonNil(restart),
+		"persisted":        persist,
+	})
+}
+
+// nonNil returns an empty list for nil, so it encodes as [] rather than null
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// persistConfig writes changed keys into the YAML config file, keeping its
+// comments, layout and the keys it doesn't change
+func persistConfig(path string, changes map[string]interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s is not a YAML mapping", path)
+	}
+
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Values already in the file are replaced in place, so the file looks
+	// as before; new keys need the document to be re-encoded
+	lines := strings.Split(string(data), "\n")
+	spliced := true
+	for _, key := range keys {
+		node := findYAMLValue(doc.Content[0], strings.Split(key, "."))
+		if node == nil || !spliceYAMLValue(lines, node, changes[key]) {
+			spliced = false
+			break
+		}
+	}
+
+	var out bytes.Buffer
+	if spliced {
+		out.WriteString(strings.Join(lines, "\n"))
+	} else {
+		for _, key := range keys {
+			if err := setYAMLValue(doc.Content[0], strings.Split(key, "."), changes[key]); err != nil {
+				return fmt.Errorf("failed to set %s: %v", key, err)
+			}
+		}
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&doc); err != nil {
+			return fmt.Errorf("failed to write config file: %v", err)
+		}
+		encoder.Close()
+	}
+
+	// The file may hold credentials, so it keeps the permissions it has
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	os.Remove(tmp) // A leftover from an interrupted write would keep its own mode
+	if err := os.WriteFile(tmp, out.Bytes(), mode); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	return nil
+}
+
+// findYAMLValue returns the value node at path in a YAML mapping, or nil
+func findYAMLValue(mapping *yaml.Node, path []string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == path[0] {
+			if len(path) == 1 {
+				return mapping.Content[i+1]
+			}
+			return findYAMLValue(mapping.Content[i+1], path[1:])
+		}
+	}
+	return nil
+}
+
+// spliceYAMLValue replaces a single-line value in the file's lines, leaving
+// the key and any trailing comment as they are. It reports false for values
+// it can't replace in place, such as block lists.
+func spliceYAMLValue(lines []string, old *yaml.Node, value interface{}) bool {
+	flowList := old.Kind == yaml.SequenceNode && old.Style&yaml.FlowStyle != 0
+	if (old.Kind != yaml.ScalarNode && !flowList) || old.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		return false
+	}
+	if old.Line < 1 || old.Line > len(lines) {
+		return false
+	}
+	line := lines[old.Line-1]
+	start := old.Column - 1
+	if start < 0 || start >= len(line) {
+		return false
+	}
+	end := yamlValueEnd(line, start)
+	if end < 0 {
+		return false
+	}
+
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return false
+	}
+	switch {
+	case node.Kind == yaml.SequenceNode:
+		node.Style = yaml.FlowStyle
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!str" && old.Style&yaml.DoubleQuotedStyle != 0:
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	encoded, err := yaml.Marshal(&node)
+	if err != nil || bytes.Count(bytes.TrimSpace(encoded), []byte("\n")) > 0 {
+		return false
+	}
+
+	lines[old.Line-1] = line[:start] + string(bytes.TrimSpace(encoded)) + line[end:]
+	return true
+}
+
+// yamlValueEnd returns where the value starting at start ends on a line:
+// after its closing quote or bracket, or before a comment. It returns -1 if
+// the value continues on the next line.
+func yamlValueEnd(line string, start int) int {
+	switch line[start] {
+	case '"':
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				return i + 1
+			}
+		}
+		return -1
+	case '\'':
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				return i + 1
+			}
+		}
+		return -1
+	case '[':
+		depth, quote := 0, byte(0)
+		for i := start; i < len(line); i++ {
+			switch c := line[i]; {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '[':
+				depth++
+			case c == ']':
+				if depth--; depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return -1
+	}
+
+	end := len(line)
+	if i := strings.Index(line[start:], " #"); i >= 0 {
+		end = start + i
+	}
+	return start + len(strings.TrimRight(line[start:end], " \t"))
+}
+
+// setYAMLValue sets the value at path in a YAML mapping, adding sections
+// and keys that are missing. A replaced value keeps its comments.
+func setYAMLValue(mapping *yaml.Node, path []string, value interface{}) error {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		old := mapping.Content[i+1]
+		if len(path) > 1 {
+			if old.Kind != yaml.MappingNode {
+				*old = yaml.Node{Kind: yaml.MappingNode, LineComment: old.LineComment}
+			}
+			return setYAMLValue(old, path[1:], value)
+		}
+
+		var node yaml.Node
+		if err := node.Encode(value); err != nil {
+			return err
+		}
+		node.HeadComment, node.LineComment, node.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		mapping.Content[i+1] = &node
+		return nil
+	}
+
+	// Not in the file yet
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: path[0]}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	mapping.Content = append(mapping.Content, key, child)
+	if len(path) > 1 {
+		return setYAMLValue(child, path[1:], value)
+	}
+	return child.Encode(value)
+}
@@ -0,0 +1,90 @@
+package audiorelay
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// runConfigInit handles "audiorelay config init", writing the commented
+// default config to the config path
+func runConfigInit(opts Options, args []string) error {
+	flags := flag.NewFlagSet("config init", flag.ContinueOnError)
+	force := flags.Bool("force", false, "overwrite an existing config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	path := opts.ConfigPath
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create config directory: %v", err)
+		}
+	}
+	if err := CreateDefaultConfig(path); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	fmt.Printf("√ Default configuration written to %s\n", path)
+	return nil
+}
+
+// runConfigValidate handles "audiorelay config validate": it checks the
+// config file and opens the device it selects, without starting any server
+func runConfigValidate(opts Options) error {
+	if _, err := os.Stat(opts.ConfigPath); err != nil {
+		return fmt.Errorf("config file %s: %v", opts.ConfigPath, err)
+	}
+	config, err := LoadConfigWithFlags(opts.ConfigPath, opts.Flags)
+	if err != nil {
+		return fmt.Errorf("× %s is invalid: %v", opts.ConfigPath, err)
+	}
+	config.ApplyDeviceFlag(opts.Device)
+	fmt.Printf("√ %s is valid\n", opts.ConfigPath)
+
+	if config.Audio.Backend == BackendPulse {
+		fmt.Println("  Capture uses the pulse backend; the source is checked when parec starts")
+		return nil
+	}
+	if err := portaudio.Initialize(); err != nil {
+		return fmt.Errorf("PortAudio initialization failed: %v", err)
+	}
+	defer portaudio.Terminate()
+
+	relay := New(config, emptyFS{})
+	if err := relay.deviceMgr.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize device manager: %v", err)
+	}
+	if !config.selectsDevice() && (!config.Audio.PreferBlackHole || relay.deviceMgr.AutoDetectVirtual(config.Audio.PreferDevices) == nil) {
+		fmt.Println("⚠️ No capture device is configured: the service will ask for one at startup,")
+		fmt.Println("   and fail when not run from a terminal. Set audio.device_name or audio.device_index.")
+		return nil
+	}
+	device, err := relay.selectAudioDevice()
+	if err != nil {
+		return fmt.Errorf("× device: %v", err)
+	}
+
+	// Opening the stream checks the sample rate, channels and format
+	if err := relay.audioCapture.Initialize(device); err != nil {
+		return fmt.Errorf("× device %s: %v", device.Name, err)
+	}
+	relay.audioCapture.stream.Close()
+	fmt.Printf("√ Device %s opens with the configured format\n", device.Name)
+	return nil
+}
+
+// selectsDevice reports whether the config names a capture device; without
+// one the service auto-selects a present virtual device or asks at startup
+func (c *Config) selectsDevice() bool {
+	a := c.Audio
+	return a.Loopback || a.DeviceName != "" || a.DeviceIndex >= 0 || len(a.DevicePriority) > 0 || a.AutoSelect
+}
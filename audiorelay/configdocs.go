@@ -0,0 +1,186 @@
+package audiorelay
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigDoc describes a single configuration key
+type ConfigDoc struct {
+	Key         string
+	Type        string
+	Default     interface{}
+	Description string
+	Section     bool // A group of keys rather than a value
+}
+
+// ConfigDocs lists every configuration key with its type, default and
+// description, read from the Config struct tags so it never drifts from the code
+func ConfigDocs() []ConfigDoc {
+	v := viper.New()
+	setDefaults(v)
+
+	var docs []ConfigDoc
+	collectConfigDocs(reflect.TypeOf(Config{}), "", v, &docs)
+	return docs
+}
+
+// collectConfigDocs walks a config struct type, recursing into sections
+func collectConfigDocs(t reflect.Type, prefix string, v *viper.Viper, docs *[]ConfigDoc) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		doc := ConfigDoc{
+			Key:         key,
+			Type:        field.Type.String(),
+			Description: field.Tag.Get("desc"),
+		}
+		if field.Type.Kind() == reflect.Struct {
+			doc.Section = true
+			*docs = append(*docs, doc)
+			collectConfigDocs(field.Type, key, v, docs)
+			continue
+		}
+
+		// Lists of sections document the fields of each entry
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			doc.Section = true
+			*docs = append(*docs, doc)
+			collectConfigDocs(field.Type.Elem(), key+"[]", v, docs)
+			continue
+		}
+
+		doc.Default = v.Get(key)
+		*docs = append(*docs, doc)
+	}
+}
+
+// PrintConfigDocs writes human-readable documentation of every configuration key
+func PrintConfigDocs(w io.Writer) {
+	for _, doc := range ConfigDocs() {
+		if doc.Section {
+			fmt.Fprintf(w, "\n[%s] %s\n", doc.Key, doc.Description)
+			continue
+		}
+
+		def := fmt.Sprintf("%v", doc.Default)
+		if s, ok := doc.Default.(string); ok {
+			def = fmt.Sprintf("%q", s)
+		} else if doc.Default == nil {
+			def = "none"
+		}
+		fmt.Fprintf(w, "  %s (%s, default %s)\n", doc.Key, doc.Type, def)
+		if doc.Description != "" {
+			fmt.Fprintf(w, "      %s\n", doc.Description)
+		}
+	}
+}
+
+// WriteConfigTemplate writes a config file with every key set to its
+// default, each commented with its description
+func WriteConfigTemplate(w io.Writer) {
+	fmt.Fprintln(w, "# Audio Relay configuration. Every key is listed with its default;")
+	fmt.Fprintln(w, "# remove the ones you don't change. \"audiorelay config docs\" lists them too.")
+
+	for _, doc := range ConfigDocs() {
+		depth := strings.Count(doc.Key, ".")
+		indent := strings.Repeat("  ", depth)
+		name := doc.Key[strings.LastIndex(doc.Key, ".")+1:]
+
+		// Entries of lists such as streams are shown as a commented example
+		if parent, field, ok := strings.Cut(doc.Key, "[]."); ok {
+			prefix := "  # " + strings.Repeat("  ", strings.Count(field, ".")+1)
+			if field == firstListField(parent) {
+				prefix = "  # - "
+			}
+			if doc.Section {
+				fmt.Fprintf(w, "%s%s:  # %s\n", prefix, name, doc.Description)
+			} else {
+				fmt.Fprintf(w, "%s%s: %s  # %s\n", prefix, name, templateValue(doc), doc.Description)
+			}
+			continue
+		}
+
+		switch {
+		case doc.Section && strings.HasPrefix(doc.Type, "[]"):
+			fmt.Fprintf(w, "\n%s%s: []  # %s, e.g.:\n", indent, name, doc.Description)
+		case doc.Section:
+			if depth == 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s%s:  # %s\n", indent, name, doc.Description)
+		default:
+			if depth == 0 {
+				fmt.Fprintln(w)
+			}
+			// Keys set by profiles are left out so the profile takes effect
+			if profileKey(doc.Key) {
+				indent += "# "
+			}
+			fmt.Fprintf(w, "%s%s: %s", indent, name, templateValue(doc))
+			if doc.Description != "" {
+				fmt.Fprintf(w, "  # %s", doc.Description)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// firstListField returns the first field of a list-of-sections key, which
+// starts each example entry
+func firstListField(list string) string {
+	for _, doc := range ConfigDocs() {
+		if field, ok := strings.CutPrefix(doc.Key, list+"[]."); ok {
+			return field
+		}
+	}
+	return ""
+}
+
+// templateValue formats a default as YAML; keys without a default get the
+// zero value of their type
+func templateValue(doc ConfigDoc) string {
+	switch value := doc.Default.(type) {
+	case nil:
+		switch {
+		case doc.Type == "string":
+			return `""`
+		case doc.Type == "bool":
+			return "false"
+		case strings.HasPrefix(doc.Type, "[]"):
+			return "[]"
+		default:
+			return "0"
+		}
+	case string:
+		return strconv.Quote(value)
+	case []string:
+		quoted := make([]string, len(value))
+		for i, s := range value {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	case []int:
+		items := make([]string, len(value))
+		for i, n := range value {
+			items[i] = strconv.Itoa(n)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
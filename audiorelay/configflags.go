@@ -0,0 +1,111 @@
+package audiorelay
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Flag annotations linking command-line flags to config keys
+const (
+	flagConfigKey    = "config_key"    // The flag sets this key
+	flagConfigNegate = "config_negate" // The flag sets this key to false
+)
+
+// flagSections are the config sections whose keys need no prefix on the
+// command line, so audio.sample_rate is --sample-rate
+var flagSections = []string{"server.", "audio.", "protocols."}
+
+// RegisterConfigFlags adds a flag for every config key to flags, e.g.
+// --http-port for server.http_port. Every "enabled" switch also gets a
+// negated form such as --no-tcp. Flags override the config file when set.
+func RegisterConfigFlags(flags *pflag.FlagSet) {
+	v := viper.New()
+	setDefaults(v)
+	registerConfigFlags(flags, reflect.TypeOf(Config{}), "", v)
+}
+
+// registerConfigFlags walks a config struct type, recursing into sections.
+// Lists of sections such as streams can't be set from the command line.
+func registerConfigFlags(flags *pflag.FlagSet, t reflect.Type, prefix string, v *viper.Viper) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			registerConfigFlags(flags, field.Type, key+".", v)
+			continue
+		}
+
+		flagName := configFlagName(key)
+		if flags.Lookup(flagName) != nil {
+			// Taken by a shorter key, spell this one out in full
+			flagName = strings.NewReplacer(".", "-", "_", "-").Replace(key)
+		}
+		usage := field.Tag.Get("desc")
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			flags.String(flagName, v.GetString(key), usage)
+		case reflect.Bool:
+			flags.Bool(flagName, v.GetBool(key), usage)
+		case reflect.Int:
+			flags.Int(flagName, v.GetInt(key), usage)
+		case reflect.Int16:
+			flags.Int16(flagName, int16(v.GetInt(key)), usage)
+		case reflect.Float64:
+			flags.Float64(flagName, v.GetFloat64(key), usage)
+		case reflect.Slice:
+			switch field.Type.Elem().Kind() {
+			case reflect.String:
+				flags.StringSlice(flagName, v.GetStringSlice(key), usage)
+			case reflect.Int:
+				flags.IntSlice(flagName, v.GetIntSlice(key), usage)
+			default:
+				continue
+			}
+		default:
+			continue
+		}
+		flags.SetAnnotation(flagName, flagConfigKey, []string{key})
+
+		if field.Type.Kind() == reflect.Bool && name == "enabled" && prefix != "" {
+			negated := "no-" + strings.TrimSuffix(flagName, "-enabled")
+			if flags.Lookup(negated) == nil {
+				flags.Bool(negated, false, "Disable "+strings.TrimSuffix(prefix, "."))
+				flags.SetAnnotation(negated, flagConfigNegate, []string{key})
+			}
+		}
+	}
+}
+
+// configFlagName returns the flag name of a config key
+func configFlagName(key string) string {
+	for _, section := range flagSections {
+		if strings.HasPrefix(key, section) {
+			key = strings.TrimPrefix(key, section)
+			break
+		}
+	}
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+// bindConfigFlags makes the flags registered by RegisterConfigFlags
+// override the config file; flags left unset don't change anything
+func bindConfigFlags(v *viper.Viper, flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if keys := flag.Annotations[flagConfigKey]; len(keys) > 0 && err == nil {
+			err = v.BindPFlag(keys[0], flag)
+		}
+		if keys := flag.Annotations[flagConfigNegate]; len(keys) > 0 && flag.Changed && flag.Value.String() == "true" {
+			v.Set(keys[0], false)
+		}
+	})
+	return err
+}
@@ -0,0 +1,97 @@
+package audiorelay
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// corsMaxAge is how long browsers may cache a preflight response, in seconds
+const corsMaxAge = "600"
+
+// CORSConfig decides which web pages on other origins may read responses
+type CORSConfig struct {
+	AllowedOrigins   []string `mapstructure:"allowed_origins" desc:"Origins whose pages may read responses and call the API, e.g. https://dash.local; \"*\" lets any read but not change anything, empty for none"`
+	AllowCredentials bool     `mapstructure:"allow_credentials" desc:"Let allowed pages send cookies and basic auth; needs explicit origins"`
+	ExposedHeaders   []string `mapstructure:"exposed_headers" desc:"Response headers scripts on allowed pages can read"`
+}
+
+// validate checks that credentials aren't offered to any origin
+func (c CORSConfig) validate() error {
+	if c.AllowCredentials && c.allowsAny() {
+		return fmt.Errorf("CORS allow_credentials needs explicit allowed_origins, not \"*\"")
+	}
+	return nil
+}
+
+// allowsAny reports whether every origin is allowed
+func (c CORSConfig) allowsAny() bool {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether pages on origin may read responses
+func (c CORSConfig) allows(origin string) bool {
+	return c.allowsAny() || c.listed(origin)
+}
+
+// listed reports whether origin is allowed by name rather than through "*"
+func (c CORSConfig) listed(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapCORS returns a handler that adds the CORS headers of the policy and
+// answers preflight requests, which carry no credentials, before next
+func wrapCORS(policy CORSConfig, next http.Handler) http.Handler {
+	if len(policy.AllowedOrigins) == 0 {
+		return next
+	}
+	exposed := strings.Join(policy.ExposedHeaders, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !policy.allows(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		if policy.allowsAny() && !policy.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+		}
+		if policy.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			// Pages allowed only through "*" may read, but not change anything
+			methods := "GET, HEAD"
+			if policy.listed(origin) {
+				methods += ", POST, PUT, PATCH, DELETE"
+			}
+			h.Set("Access-Control-Allow-Methods", methods)
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			h.Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if exposed != "" {
+			h.Set("Access-Control-Expose-Headers", exposed)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,150 @@
+package audiorelay
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Delivery latency is the time from the end of a capture buffer's read to
+// the write of its audio to a listener's connection: processing, encoding,
+// fan-out and the time spent in the listener's queue. Network transit and
+// the receiver's own buffering come on top.
+const (
+	// clientLatencySamples are kept per client for its percentiles
+	clientLatencySamples = 256
+	// protocolLatencySamples are kept per protocol, across clients
+	protocolLatencySamples = 4096
+)
+
+// LatencyPercentiles summarizes recent delivery latencies
+type LatencyPercentiles struct {
+	Count int64   `json:"count"` // Chunks delivered in total
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"` // Of the recent chunks percentiles are taken of
+}
+
+// latencySamples keeps the most recent delivery latencies in a ring
+type latencySamples struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	count   int64
+	sum     time.Duration
+}
+
+// newLatencySamples creates a ring of size samples
+func newLatencySamples(size int) *latencySamples {
+	return &latencySamples{samples: make([]time.Duration, 0, size)}
+}
+
+// record adds a latency; nil rings are ignored
+func (s *latencySamples) record(took time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) < cap(s.samples) {
+		s.samples = append(s.samples, took)
+	} else {
+		s.samples[s.next] = took
+		s.next = (s.next + 1) % len(s.samples)
+	}
+	s.count++
+	s.sum += took
+}
+
+// percentiles returns the percentiles of the recent samples, the total
+// count and sum
+func (s *latencySamples) percentiles() (LatencyPercentiles, time.Duration) {
+	s.mu.Lock()
+	sorted := append([]time.Duration(nil), s.samples...)
+	p := LatencyPercentiles{Count: s.count}
+	sum := s.sum
+	s.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return p, sum
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(q float64) float64 {
+		return durationMs(sorted[int(math.Ceil(q*float64(len(sorted))))-1])
+	}
+	p.P50Ms, p.P95Ms, p.P99Ms = at(0.5), at(0.95), at(0.99)
+	p.MaxMs = durationMs(sorted[len(sorted)-1])
+	return p, sum
+}
+
+// recordDelivery notes that audio captured at captured has been written to
+// the client. Chunks without a capture time, such as replayed history, are
+// ignored.
+func (c *Client) recordDelivery(captured time.Time) {
+	if c == nil || captured.IsZero() {
+		return
+	}
+	took := time.Since(captured)
+	c.delivery.record(took)
+	c.protocolDelivery.record(took)
+}
+
+// Latency returns the percentiles of the client's recent deliveries
+func (c *Client) Latency() LatencyPercentiles {
+	p, _ := c.delivery.percentiles()
+	return p
+}
+
+// deliveryWindow returns the latency ring of a protocol, creating it on
+// first use
+func (cr *ClientRegistry) deliveryWindow(protocol string) *latencySamples {
+	cr.clientsMu.Lock()
+	defer cr.clientsMu.Unlock()
+	return cr.deliveryWindowLocked(protocol)
+}
+
+// deliveryWindowLocked is deliveryWindow with clientsMu held
+func (cr *ClientRegistry) deliveryWindowLocked(protocol string) *latencySamples {
+	window := cr.delivery[protocol]
+	if window == nil {
+		window = newLatencySamples(protocolLatencySamples)
+		cr.delivery[protocol] = window
+	}
+	return window
+}
+
+// latencyStatus returns the delivery percentiles per protocol and per
+// connected client, for /status
+func (cr *ClientRegistry) latencyStatus() map[string]interface{} {
+	protocols, _ := cr.deliveryLatency()
+	clients := make(map[string]LatencyPercentiles)
+	for _, client := range cr.List() {
+		if client.Latency.Count > 0 {
+			clients[client.ID] = client.Latency
+		}
+	}
+	return map[string]interface{}{"protocols": protocols, "clients": clients}
+}
+
+// deliveryLatency returns the delivery percentiles of every protocol that
+// has delivered audio, with the total latency of all deliveries
+func (cr *ClientRegistry) deliveryLatency() (map[string]LatencyPercentiles, map[string]time.Duration) {
+	cr.clientsMu.RLock()
+	windows := make(map[string]*latencySamples, len(cr.delivery))
+	for protocol, window := range cr.delivery {
+		windows[protocol] = window
+	}
+	cr.clientsMu.RUnlock()
+
+	latency := make(map[string]LatencyPercentiles, len(windows))
+	sums := make(map[string]time.Duration, len(windows))
+	for protocol, window := range windows {
+		p, sum := window.percentiles()
+		if p.Count > 0 {
+			latency[protocol], sums[protocol] = p, sum
+		}
+	}
+	return latency, sums
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 
@@ -13,6 +14,8 @@ import (
 // DeviceManager handles audio device operations
 type DeviceManager struct {
 	devices []*portaudio.DeviceInfo
+	hostApi string // Only devices of this host API are used, if set
+	api     *portaudio.HostApiInfo
 }
 
 // NewDeviceManager creates a new device manager instance
@@ -20,6 +23,12 @@ func NewDeviceManager() *DeviceManager {
 	return &DeviceManager{}
 }
 
+// SetHostApi restricts the manager to devices of one host API, as named
+// in audio.host_api; call it before Initialize
+func (dm *DeviceManager) SetHostApi(name string) {
+	dm.hostApi = name
+}
+
 // Initialize loads available audio devices
 func (dm *DeviceManager) Initialize() error {
 	allDevices, err := portaudio.Devices()
@@ -27,6 +36,17 @@ func (dm *DeviceManager) Initialize() error {
 		return fmt.Errorf("failed to get audio devices: %v", err)
 	}
 
+	// Windows lists every device once per host API
+	dm.api = nil
+	if dm.hostApi != "" {
+		api, err := lookupHostApi(dm.hostApi)
+		if err != nil {
+			return err
+		}
+		dm.api = api
+		allDevices = api.Devices
+	}
+
 	// Filter input devices
 	var inputDevices []*portaudio.DeviceInfo
 	for _, device := range allDevices {
@@ -36,6 +56,9 @@ func (dm *DeviceManager) Initialize() error {
 	}
 
 	if len(inputDevices) == 0 {
+		if dm.api != nil {
+			return fmt.Errorf("no available input devices found for host API %s", dm.api.Name)
+		}
 		return fmt.Errorf("no available input devices found")
 	}
 
@@ -51,8 +74,15 @@ func (dm *DeviceManager) GetInputDevices() ([]*portaudio.DeviceInfo, error) {
 	return dm.devices, nil
 }
 
-// GetDefaultInputDevice returns the default input device
+// GetDefaultInputDevice returns the default input device, of the selected
+// host API if there is one
 func (dm *DeviceManager) GetDefaultInputDevice() (*portaudio.DeviceInfo, error) {
+	if dm.api != nil {
+		if dm.api.DefaultInputDevice == nil {
+			return nil, fmt.Errorf("host API %s has no default input device", dm.api.Name)
+		}
+		return dm.api.DefaultInputDevice, nil
+	}
 	device, err := portaudio.DefaultInputDevice()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get default input device: %v", err)
@@ -70,41 +100,76 @@ func (dm *DeviceManager) GetDeviceByName(name string) (*portaudio.DeviceInfo, er
 	return nil, fmt.Errorf("device not found: %s", name)
 }
 
-// AutoDetectBlackHole automatically detects BlackHole audio devices
-func (dm *DeviceManager) AutoDetectBlackHole() *portaudio.DeviceInfo {
-	blackHoleNames := []string{
-		"BlackHole 2ch",
-		"BlackHole 16ch",
-		"BlackHole",
-	}
+// defaultDeviceName stands for the system default input in device_priority
+const defaultDeviceName = "default"
 
-	for _, device := range dm.devices {
-		for _, name := range blackHoleNames {
-			if strings.Contains(strings.ToLower(device.Name), strings.ToLower(name)) {
-				return device
+// GetFirstAvailable returns the first present device from a priority list
+func (dm *DeviceManager) GetFirstAvailable(names []string) (*portaudio.DeviceInfo, error) {
+	for _, name := range names {
+		if strings.EqualFold(name, defaultDeviceName) {
+			if device, err := dm.GetDefaultInputDevice(); err == nil {
+				return device, nil
 			}
+			continue
+		}
+		if device, err := dm.GetDeviceByName(name); err == nil {
+			return device, nil
 		}
 	}
-	return nil
+	return nil, fmt.Errorf("none of the devices are present: %s", strings.Join(names, ", "))
 }
 
-// SelectInputDevice provides interactive device selection
-func (dm *DeviceManager) SelectInputDevice() (*portaudio.DeviceInfo, error) {
-	devices, err := dm.GetInputDevices()
-	if err != nil {
-		return nil, err
+// virtualDeviceAliases maps product names in audio.prefer_devices to the
+// names their drivers give the capture endpoints
+var virtualDeviceAliases = map[string][]string{
+	"vb-cable": {"CABLE Output", "VB-Audio"},
+}
+
+// matchDeviceName reports whether a device name matches a prefer_devices
+// pattern: a case-insensitive substring, or a glob if it contains * ? or [
+func matchDeviceName(name, pattern string) bool {
+	name, pattern = strings.ToLower(name), strings.ToLower(pattern)
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, err := path.Match(pattern, name)
+		return err == nil && matched
 	}
+	return strings.Contains(name, pattern)
+}
 
-	// Display available devices
+// AutoDetectVirtual returns the first present device matching the patterns,
+// earlier patterns taking precedence
+func (dm *DeviceManager) AutoDetectVirtual(patterns []string) *portaudio.DeviceInfo {
+	for _, pattern := range patterns {
+		candidates := []string{pattern}
+		if aliases, ok := virtualDeviceAliases[strings.ToLower(pattern)]; ok {
+			candidates = aliases
+		}
+		for _, device := range dm.devices {
+			for _, candidate := range candidates {
+				if matchDeviceName(device.Name, candidate) {
+					return device
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// PrintInputDevices lists the input devices with the indexes accepted by
+// audio.device_index and --device
+func (dm *DeviceManager) PrintInputDevices() {
 	fmt.Println("\nAvailable Audio Input Devices:")
 	fmt.Println("==============================")
 
-	for i, device := range devices {
+	for i, device := range dm.devices {
 		defaultMarker := ""
 		defaultDevice, err := portaudio.DefaultInputDevice()
 		if err == nil && device.Name == defaultDevice.Name {
 			defaultMarker = " (default)"
 		}
+		if isLoopback(device) {
+			defaultMarker += " (system output)"
+		}
 
 		fmt.Printf("[%d] %s%s\n", i, device.Name, defaultMarker)
 		fmt.Printf("    Input Channels: %d, Sample Rate: %.0f Hz, API: %s\n",
@@ -112,12 +177,44 @@ func (dm *DeviceManager) SelectInputDevice() (*portaudio.DeviceInfo, error) {
 			device.HostApi.Name)
 		fmt.Println()
 	}
+}
+
+// GetDeviceByIndex returns the input device at index in the device list
+func (dm *DeviceManager) GetDeviceByIndex(index int) (*portaudio.DeviceInfo, error) {
+	if index < 0 || index >= len(dm.devices) {
+		return nil, fmt.Errorf("device index %d out of range, %d input devices available", index, len(dm.devices))
+	}
+	return dm.devices[index], nil
+}
+
+// stdinIsTerminal reports whether someone can answer interactive prompts
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// SelectInputDevice provides interactive device selection
+func (dm *DeviceManager) SelectInputDevice() (*portaudio.DeviceInfo, error) {
+	devices, err := dm.GetInputDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	dm.PrintInputDevices()
+
+	// Services have no one to answer the prompt
+	if !stdinIsTerminal() {
+		return nil, fmt.Errorf("no device configured and stdin is not a terminal: set audio.device_name or audio.device_index, or pass --device")
+	}
 
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
 		fmt.Print("Select device number (q to quit): ")
-		input, _ := reader.ReadString('\n')
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("device selection aborted: %v", err)
+		}
 		input = strings.TrimSpace(input)
 
 		if strings.ToLower(input) == "q" {
@@ -0,0 +1,86 @@
+package audiorelay
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Dither noise-shaping profiles
+const (
+	DitherFlat      = "flat"       // Plain TPDF, white noise floor
+	DitherHighPass  = "highpass"   // First-order shaping, pushes noise up in frequency
+	DitherEWeighted = "e-weighted" // 9th-order psychoacoustic (E-weighted) shaping
+)
+
+// ditherProfiles holds the error-feedback filter of each profile. The
+// E-weighted coefficients are Wannamaker's design for 44.1 kHz; at other
+// rates the curve shifts proportionally but stays effective.
+var ditherProfiles = map[string][]float64{
+	DitherFlat:      nil,
+	DitherHighPass:  {1},
+	DitherEWeighted: {2.412, -3.370, 3.937, -4.174, 3.353, -2.205, 1.281, -0.569, 0.0847},
+}
+
+// maxShapingError bounds the fed-back error (in LSBs) so a clipped sample
+// can't make the shaping filter run away
+const maxShapingError = 4.0
+
+// Dither adds triangular (TPDF) noise of one output LSB and quantizes to the
+// output resolution, turning truncation distortion into a constant noise
+// floor. Noise shaping feeds the quantization error back through a filter to
+// move that floor to frequencies where the ear is less sensitive.
+type Dither struct {
+	lsb      float64 // Output LSB in 16-bit full-scale units
+	channels int
+	coeffs   []float64
+	errors   [][]float64 // Per-channel error history, newest first, in LSBs
+	rng      *rand.Rand
+}
+
+// NewDither creates a dither stage for integer output of the given bit depth
+func NewDither(outputBits, channels int, profile string) (*Dither, error) {
+	coeffs, ok := ditherProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown dither profile: %q", profile)
+	}
+
+	errors := make([][]float64, channels)
+	for c := range errors {
+		errors[c] = make([]float64, len(coeffs))
+	}
+
+	return &Dither{
+		lsb:      math.Ldexp(1, 16-outputBits),
+		channels: channels,
+		coeffs:   coeffs,
+		errors:   errors,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Process dithers and quantizes the interleaved samples in place
+func (d *Dither) Process(samples []float64) {
+	ch := d.channels
+	for i := 0; i+ch <= len(samples); i += ch {
+		for c := 0; c < ch; c++ {
+			history := d.errors[c]
+
+			// Work in LSB units so the same filter serves every bit depth
+			v := samples[i+c] / d.lsb
+			for k, h := range d.coeffs {
+				v -= h * history[k]
+			}
+
+			// The difference of two uniform values has a triangular distribution
+			y := math.Round(v + d.rng.Float64() - d.rng.Float64())
+
+			if len(history) > 0 {
+				copy(history[1:], history[:len(history)-1])
+				history[0] = math.Max(-maxShapingError, math.Min(maxShapingError, y-v))
+			}
+			samples[i+c] = y * d.lsb
+		}
+	}
+}
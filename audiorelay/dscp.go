@@ -0,0 +1,55 @@
+package audiorelay
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// dscpNames maps the common per-hop behaviour names to DSCP code points
+var dscpNames = map[string]int{
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+	"EF": 46,
+}
+
+// parseDSCP accepts a per-hop behaviour name (e.g. EF) or a number 0-63.
+// An empty string leaves the operating system default.
+func parseDSCP(value string) (int, bool, error) {
+	if value == "" {
+		return 0, false, nil
+	}
+	if dscp, ok := dscpNames[strings.ToUpper(value)]; ok {
+		return dscp, true, nil
+	}
+	dscp, err := strconv.Atoi(value)
+	if err != nil || dscp < 0 || dscp > 63 {
+		return 0, false, fmt.Errorf("invalid DSCP %q (use a name like EF or AF41, or 0-63)", value)
+	}
+	return dscp, true, nil
+}
+
+// markConn sets the configured DSCP on an audio socket. Failures are only
+// logged: QoS marking is best effort and must never cost a listener.
+func (c *Config) markConn(conn interface{}) {
+	dscp, ok, _ := parseDSCP(c.Server.DSCP)
+	if !ok {
+		return
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		debugf("DSCP: %v", err)
+		return
+	}
+	if err := setDSCP(raw, dscp); err != nil {
+		debugf("DSCP: %v", err)
+	}
+}
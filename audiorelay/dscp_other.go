@@ -0,0 +1,13 @@
+//go:build !unix
+
+package audiorelay
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setDSCP is unsupported here; Windows only honours DSCP set through QoS policies
+func setDSCP(raw syscall.RawConn, dscp int) error {
+	return fmt.Errorf("DSCP marking is not supported on this platform, use a QoS policy instead")
+}
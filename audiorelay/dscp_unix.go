@@ -0,0 +1,26 @@
+//go:build unix
+
+package audiorelay
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setDSCP writes the DSCP into the traffic class of an IPv4 and/or IPv6 socket
+func setDSCP(raw syscall.RawConn, dscp int) error {
+	tos := dscp << 2
+	var v4Err, v6Err error
+	err := raw.Control(func(fd uintptr) {
+		// Dual-stack sockets need both; single-stack ones reject one of them
+		v4Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		v6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	})
+	if err != nil {
+		return err
+	}
+	if v4Err != nil && v6Err != nil {
+		return fmt.Errorf("failed to set DSCP %d: %v", dscp, v4Err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package audiorelay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus
+const (
+	EventLevelTrigger   = "level_trigger"
+	EventSnapshotSaved  = "snapshot_saved"
+	EventLevels         = "levels"
+	EventFormatChange   = "format_change"
+	EventAudioClass     = "audio_class"
+	EventDeviceSwitched = "device_switched"
+	EventPower          = "power"
+	EventCaptureStalled = "capture_stalled"
+	EventConfigReloaded = "config_reloaded"
+	EventStandby        = "standby"
+	EventFaultInjected  = "fault_injected"
+	EventMetadata       = "metadata"
+	EventRequest        = "request"
+
+	EventClientConnected    = "client_connected"
+	EventClientDisconnected = "client_disconnected"
+	EventCaptureLost        = "capture_lost"
+	EventCaptureRecovered   = "capture_recovered"
+	EventSilenceStarted     = "silence_started"
+	EventSilenceEnded       = "silence_ended"
+	EventShutdown           = "shutdown"
+)
+
+// eventTypes lists every event type, for configuration that names them
+var eventTypes = []string{
+	EventLevelTrigger, EventSnapshotSaved, EventLevels, EventFormatChange,
+	EventAudioClass, EventDeviceSwitched, EventPower, EventCaptureStalled,
+	EventConfigReloaded, EventStandby, EventFaultInjected, EventMetadata,
+	EventRequest, EventClientConnected, EventClientDisconnected,
+	EventCaptureLost, EventCaptureRecovered, EventSilenceStarted,
+	EventSilenceEnded, EventShutdown,
+}
+
+// Event is a notification about something that happened in the relay
+type Event struct {
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventBus fans out events to in-process subscribers
+type EventBus struct {
+	subscribers   map[chan Event]bool
+	subscribersMu sync.RWMutex
+}
+
+// NewEventBus creates a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan Event]bool),
+	}
+}
+
+// Publish sends an event to all subscribers without blocking;
+// subscribers that fall behind miss events rather than stall the audio path
+func (eb *EventBus) Publish(eventType string, data map[string]interface{}) {
+	event := Event{
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+	if eventType != EventLevels {
+		debugf("Event %s: %v", eventType, data)
+	}
+
+	eb.subscribersMu.RLock()
+	defer eb.subscribersMu.RUnlock()
+
+	for ch := range eb.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new subscriber channel
+func (eb *EventBus) Subscribe() chan Event {
+	ch := make(chan Event, 64)
+
+	eb.subscribersMu.Lock()
+	defer eb.subscribersMu.Unlock()
+	eb.subscribers[ch] = true
+	return ch
+}
+
+// Unsubscribe removes a subscriber channel
+func (eb *EventBus) Unsubscribe(ch chan Event) {
+	eb.subscribersMu.Lock()
+	defer eb.subscribersMu.Unlock()
+	delete(eb.subscribers, ch)
+}
+
+// handleEvents streams bus events to the client as Server-Sent Events
+func (hs *HTTPServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Ask nginx not to buffer the stream
+	flusher.Flush()
+
+	ch := hs.events.Subscribe()
+	defer hs.events.Unsubscribe(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-hs.shutdown:
+			fmt.Fprintf(w, "event: shutdown\ndata: {\"reason\":\"shutdown\"}\n\n")
+			flusher.Flush()
+			return
+		case event := <-ch:
+			payload, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package audiorelay
+
+// Fader ramps the output gain between silence and full level, used to
+// avoid clicks when the capture source changes underneath listeners
+type Fader struct {
+	channels int
+	step     float64 // Gain change per frame
+	gain     float64
+	target   float64
+}
+
+// NewFader creates a fader at full level; fadeMs of 0 switches instantly
+func NewFader(sampleRate float64, channels int, fadeMs float64) *Fader {
+	step := 1.0
+	if frames := sampleRate * fadeMs / 1000; frames > 1 {
+		step = 1 / frames
+	}
+	return &Fader{
+		channels: channels,
+		step:     step,
+		gain:     1,
+		target:   1,
+	}
+}
+
+// FadeOut starts ramping down to silence
+func (f *Fader) FadeOut() {
+	f.target = 0
+}
+
+// FadeIn starts ramping back up to full level
+func (f *Fader) FadeIn() {
+	f.target = 1
+}
+
+// Mute jumps straight to silence, ready for a FadeIn
+func (f *Fader) Mute() {
+	f.gain = 0
+	f.target = 0
+}
+
+// Silent reports whether a fade-out has completed
+func (f *Fader) Silent() bool {
+	return f.gain == 0 && f.target == 0
+}
+
+// Process applies the current ramp to the interleaved samples in place
+func (f *Fader) Process(samples []float64) {
+	if f.gain == 1 && f.target == 1 {
+		return
+	}
+
+	ch := f.channels
+	for i := 0; i+ch <= len(samples); i += ch {
+		switch {
+		case f.gain < f.target:
+			f.gain = min(f.target, f.gain+f.step)
+		case f.gain > f.target:
+			f.gain = max(f.target, f.gain-f.step)
+		}
+		for c := 0; c < ch; c++ {
+			samples[i+c] *= f.gain
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package audiorelay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits on injected faults, so a typo can't hang the relay for hours
+const (
+	maxFaultDelayMs = 10000
+	maxFaultCount   = 100000
+)
+
+// errInjectedRead is the capture read error injected by capture_read_errors
+var errInjectedRead = errors.New("injected capture read error")
+
+// Faults are the faults armed through the developer API. Counts are used
+// up as the faults fire, so a test knows exactly what will happen.
+type Faults struct {
+	SlowClientMs      float64 `json:"slow_client_ms"`           // Delay added to every write to a listener
+	SlowClientID      string  `json:"slow_client_id,omitempty"` // Only slow this client; empty for all
+	CaptureReadErrors int     `json:"capture_read_errors"`      // Upcoming capture reads that fail
+	EncoderStallMs    float64 `json:"encoder_stall_ms"`         // One-off pause before the next buffer is encoded
+	DropBuffers       int     `json:"drop_buffers"`             // Upcoming captured buffers that are discarded
+}
+
+// validate checks that the faults are within bounds
+func (f Faults) validate() error {
+	if f.SlowClientMs < 0 || f.SlowClientMs > maxFaultDelayMs || f.EncoderStallMs < 0 || f.EncoderStallMs > maxFaultDelayMs {
+		return fmt.Errorf("delays must be between 0 and %d ms", maxFaultDelayMs)
+	}
+	if f.CaptureReadErrors < 0 || f.CaptureReadErrors > maxFaultCount || f.DropBuffers < 0 || f.DropBuffers > maxFaultCount {
+		return fmt.Errorf("counts must be between 0 and %d", maxFaultCount)
+	}
+	return nil
+}
+
+// armed reports whether any fault is set
+func (f Faults) armed() bool {
+	return f != Faults{}
+}
+
+// FaultInjector holds the armed faults and fires them from the capture
+// and client paths. A nil injector never fires, so the hooks cost nothing
+// outside developer mode.
+type FaultInjector struct {
+	events *EventBus
+
+	faults   Faults
+	faultsMu sync.Mutex
+	armed    atomic.Bool // Fast path for the audio loop when nothing is set
+}
+
+// NewFaultInjector creates an injector with no faults armed
+func NewFaultInjector(events *EventBus) *FaultInjector {
+	return &FaultInjector{events: events}
+}
+
+// Get returns the faults still armed
+func (fi *FaultInjector) Get() Faults {
+	fi.faultsMu.Lock()
+	defer fi.faultsMu.Unlock()
+	return fi.faults
+}
+
+// Set replaces the armed faults
+func (fi *FaultInjector) Set(faults Faults) error {
+	if err := faults.validate(); err != nil {
+		return err
+	}
+	fi.faultsMu.Lock()
+	defer fi.faultsMu.Unlock()
+	fi.faults = faults
+	fi.armed.Store(faults.armed())
+	return nil
+}
+
+// fired updates the faults after one fired and reports it. The caller
+// holds faultsMu.
+func (fi *FaultInjector) fired(fault string, data map[string]interface{}) {
+	fi.armed.Store(fi.faults.armed())
+	debugf("🧪 Injected %s", fault)
+	data["fault"] = fault
+	fi.events.Publish(EventFaultInjected, data)
+}
+
+// readError returns an error if a capture read should fail
+func (fi *FaultInjector) readError() error {
+	if fi == nil || !fi.armed.Load() {
+		return nil
+	}
+	fi.faultsMu.Lock()
+	defer fi.faultsMu.Unlock()
+	if fi.faults.CaptureReadErrors == 0 {
+		return nil
+	}
+	fi.faults.CaptureReadErrors--
+	fi.fired("capture_read_error", map[string]interface{}{"remaining": fi.faults.CaptureReadErrors})
+	return errInjectedRead
+}
+
+// stallEncoder blocks once for the armed encoder stall
+func (fi *FaultInjector) stallEncoder() {
+	if fi == nil || !fi.armed.Load() {
+		return
+	}
+	fi.faultsMu.Lock()
+	stall := fi.faults.EncoderStallMs
+	if stall > 0 {
+		fi.faults.EncoderStallMs = 0
+		fi.fired("encoder_stall", map[string]interface{}{"stall_ms": stall})
+	}
+	fi.faultsMu.Unlock()
+
+	time.Sleep(time.Duration(stall * float64(time.Millisecond)))
+}
+
+// dropBuffer reports whether a captured buffer should be discarded
+func (fi *FaultInjector) dropBuffer() bool {
+	if fi == nil || !fi.armed.Load() {
+		return false
+	}
+	fi.faultsMu.Lock()
+	defer fi.faultsMu.Unlock()
+	if fi.faults.DropBuffers == 0 {
+		return false
+	}
+	fi.faults.DropBuffers--
+	fi.fired("dropped_buffer", map[string]interface{}{"remaining": fi.faults.DropBuffers})
+	return true
+}
+
+// slowClient delays a write to a listener as if it were on a slow link
+func (fi *FaultInjector) slowClient(client *Client) {
+	if fi == nil || !fi.armed.Load() {
+		return
+	}
+	fi.faultsMu.Lock()
+	delay, id := fi.faults.SlowClientMs, fi.faults.SlowClientID
+	fi.faultsMu.Unlock()
+
+	if delay > 0 && (id == "" || (client != nil && client.ID == id)) {
+		time.Sleep(time.Duration(delay * float64(time.Millisecond)))
+	}
+}
+
+// handleGetFaults returns the faults still armed
+func (ar *AudioRelay) handleGetFaults(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ar.faults.Get())
+}
+
+// handleSetFaults arms a new set of faults, replacing the current ones
+func (ar *AudioRelay) handleSetFaults(w http.ResponseWriter, r *http.Request) {
+	var faults Faults
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&faults); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if err := ar.faults.Set(faults); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("🧪 Faults armed: %+v", faults)
+	writeJSON(w, http.StatusOK, faults)
+}
+
+// handleClearFaults disarms all faults
+func (ar *AudioRelay) handleClearFaults(w http.ResponseWriter, r *http.Request) {
+	ar.faults.Set(Faults{})
+	log.Printf("🧪 Faults cleared")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,119 @@
+package audiorelay
+
+import "math"
+
+// FilterType selects the response of a Biquad
+type FilterType int
+
+const (
+	HighPass FilterType = iota
+	LowPass
+)
+
+// Biquad is a second-order IIR filter (direct form I) applied to each channel
+// of an interleaved buffer. First-order responses use the same structure with
+// the second-order coefficients set to zero.
+type Biquad struct {
+	channels int
+
+	b0, b1, b2 float64
+	a1, a2     float64
+
+	// Per-channel history: x[n-1], x[n-2], y[n-1], y[n-2]
+	x1, x2, y1, y2 []float64
+}
+
+// NewBiquad creates a filter using the RBJ audio EQ cookbook formulas.
+// order 1 gives a 6 dB/octave slope, order 2 a 12 dB/octave slope shaped by q.
+func NewBiquad(filterType FilterType, sampleRate float64, channels int, cutoffHz, q float64, order int) *Biquad {
+	bq := &Biquad{
+		channels: channels,
+		x1:       make([]float64, channels),
+		x2:       make([]float64, channels),
+		y1:       make([]float64, channels),
+		y2:       make([]float64, channels),
+	}
+
+	if order == 1 {
+		// Bilinear transform of a one-pole RC filter
+		k := math.Tan(math.Pi * cutoffHz / sampleRate)
+		norm := 1 / (1 + k)
+		if filterType == HighPass {
+			bq.b0 = norm
+			bq.b1 = -norm
+		} else {
+			bq.b0 = k * norm
+			bq.b1 = k * norm
+		}
+		bq.a1 = (k - 1) * norm
+		return bq
+	}
+
+	w0 := 2 * math.Pi * cutoffHz / sampleRate
+	cosW0 := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * q)
+	a0 := 1 + alpha
+
+	if filterType == HighPass {
+		bq.b0 = (1 + cosW0) / 2 / a0
+		bq.b1 = -(1 + cosW0) / a0
+		bq.b2 = (1 + cosW0) / 2 / a0
+	} else {
+		bq.b0 = (1 - cosW0) / 2 / a0
+		bq.b1 = (1 - cosW0) / a0
+		bq.b2 = (1 - cosW0) / 2 / a0
+	}
+	bq.a1 = -2 * cosW0 / a0
+	bq.a2 = (1 - alpha) / a0
+
+	return bq
+}
+
+// Process filters the interleaved samples in place
+func (bq *Biquad) Process(samples []float64) {
+	ch := bq.channels
+	for i := 0; i+ch <= len(samples); i += ch {
+		for c := 0; c < ch; c++ {
+			x := samples[i+c]
+			y := bq.b0*x + bq.b1*bq.x1[c] + bq.b2*bq.x2[c] - bq.a1*bq.y1[c] - bq.a2*bq.y2[c]
+
+			bq.x2[c], bq.x1[c] = bq.x1[c], x
+			bq.y2[c], bq.y1[c] = bq.y1[c], y
+			samples[i+c] = y
+		}
+	}
+}
+
+// dcBlockCutoffHz is the corner frequency of the DC blocker, well below audible bass
+const dcBlockCutoffHz = 5.0
+
+// DCBlocker removes a constant offset from each channel using a one-pole
+// high-pass filter: y[n] = x[n] - x[n-1] + r*y[n-1]
+type DCBlocker struct {
+	channels int
+	r        float64
+	x1, y1   []float64
+}
+
+// NewDCBlocker creates a DC blocker for the given stream format
+func NewDCBlocker(sampleRate float64, channels int) *DCBlocker {
+	return &DCBlocker{
+		channels: channels,
+		r:        1 - 2*math.Pi*dcBlockCutoffHz/sampleRate,
+		x1:       make([]float64, channels),
+		y1:       make([]float64, channels),
+	}
+}
+
+// Process removes DC offset from the interleaved samples in place
+func (dc *DCBlocker) Process(samples []float64) {
+	ch := dc.channels
+	for i := 0; i+ch <= len(samples); i += ch {
+		for c := 0; c < ch; c++ {
+			x := samples[i+c]
+			y := x - dc.x1[c] + dc.r*dc.y1[c]
+			dc.x1[c], dc.y1[c] = x, y
+			samples[i+c] = y
+		}
+	}
+}
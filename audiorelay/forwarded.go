@@ -0,0 +1,92 @@
+package audiorelay
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For and
+// X-Real-IP headers name the real client
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies reads IPs and CIDR ranges such as 127.0.0.1 or
+// 10.0.0.0/8
+func parseTrustedProxies(entries []string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// trusts reports whether ip belongs to a trusted proxy
+func (tp trustedProxies) trusts(ip net.IP) bool {
+	for _, network := range tp {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the client a request from a trusted proxy was made
+// for: the last address in X-Forwarded-For that isn't a trusted proxy
+// itself, or X-Real-IP. It returns nil if the headers name no client.
+func (tp trustedProxies) clientIP(r *http.Request) net.IP {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !tp.trusts(ip) {
+			break
+		}
+	}
+	if client == nil {
+		client = net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	}
+	return client
+}
+
+// wrapForwarded returns a handler that replaces the remote address of
+// requests from trusted proxies with the client's, so logs, client stats,
+// rate limits and standby registration see the listener rather than the
+// proxy. The port isn't forwarded and is given as 0.
+func wrapForwarded(proxies trustedProxies, next http.Handler) http.Handler {
+	if len(proxies) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if peer := net.ParseIP(host); err == nil && peer != nil && proxies.trusts(peer) {
+			if client := proxies.clientIP(r); client != nil {
+				r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
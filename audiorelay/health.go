@@ -0,0 +1,66 @@
+package audiorelay
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// handleHealthz answers as long as the process serves HTTP, for liveness
+// probes
+func (hs *HTTPServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// handleReadyz answers 200 while audio is being captured, and 503 when
+// capture isn't running, the device isn't open or no audio has arrived for
+// server.ready_seconds, so monitors notice a relay that runs but doesn't
+// capture. Capture paused by idle mode counts as ready.
+func (hs *HTTPServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := map[string]interface{}{"status": "ready"}
+	problem := hs.audioCapture.readiness(time.Duration(hs.config.Server.ReadySeconds*float64(time.Second)), status)
+	if problem != "" {
+		status["status"] = "not ready"
+		status["reason"] = problem
+		writeJSON(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// readiness adds the state of capture to status and returns why it isn't
+// delivering audio, or "" if it is
+func (ac *AudioCapture) readiness(maxAge time.Duration, status map[string]interface{}) string {
+	if ac == nil || !ac.IsCapturing() {
+		return "capture not running"
+	}
+	if device := ac.Device(); device != nil {
+		status["device"] = device.Name
+	}
+	if ac.IsAsleep() {
+		// Stopped on purpose while no one listens
+		status["idle"] = true
+		return ""
+	}
+
+	ac.mu.RLock()
+	open := ac.stream != nil
+	ac.mu.RUnlock()
+	if !open {
+		if ce := ac.LastError(); ce != nil {
+			status["error"] = ce.info()
+		}
+		return "device not open"
+	}
+
+	last := ac.lastBuffer.Load()
+	if last == 0 {
+		return "no audio captured yet"
+	}
+	age := time.Since(time.Unix(0, last))
+	status["last_audio_ms"] = durationMs(age)
+	if age > maxAge {
+		return fmt.Sprintf("no audio captured for %.1fs", age.Seconds())
+	}
+	return ""
+}
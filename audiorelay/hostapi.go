@@ -0,0 +1,83 @@
+package audiorelay
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// hostApiTypes maps audio.host_api names to PortAudio host APIs
+var hostApiTypes = map[string]portaudio.HostApiType{
+	"asio":        portaudio.ASIO,
+	"wasapi":      portaudio.WASAPI,
+	"wdmks":       portaudio.WDMkS,
+	"directsound": portaudio.DirectSound,
+	"mme":         portaudio.MME,
+	"coreaudio":   portaudio.CoreAudio,
+	"alsa":        portaudio.ALSA,
+	"jack":        portaudio.JACK,
+	"oss":         portaudio.OSS,
+}
+
+// hostApiNames lists the accepted audio.host_api values
+func hostApiNames() []string {
+	names := make([]string, 0, len(hostApiTypes))
+	for name := range hostApiTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupHostApi returns the named host API if PortAudio was built with it
+func lookupHostApi(name string) (*portaudio.HostApiInfo, error) {
+	apiType, ok := hostApiTypes[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown host API: %q (use %s)", name, strings.Join(hostApiNames(), ", "))
+	}
+	api, err := portaudio.HostApi(apiType)
+	if err != nil || api == nil {
+		return nil, fmt.Errorf("host API %s is not available in this PortAudio build", name)
+	}
+	return api, nil
+}
+
+// inputLatency returns the latency requested from the driver: the
+// configured latency_ms, or else the device's default low latency
+func (ac *AudioCapture) inputLatency(device *portaudio.DeviceInfo) time.Duration {
+	if ms := ac.config.Audio.LatencyMs; ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	return device.DefaultLowInputLatency
+}
+
+// streamSettings reports the host API and stream parameters in use, with
+// the latency the driver actually granted
+func (ac *AudioCapture) streamSettings() map[string]interface{} {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+
+	settings := map[string]interface{}{
+		"backend":           ac.config.Audio.Backend,
+		"frames_per_buffer": ac.actualBufferSize / ac.config.Audio.Channels,
+		"buffer_ms":         durationMs(ac.BufferDuration()),
+	}
+	if ac.device != nil && ac.device.HostApi != nil {
+		settings["host_api"] = ac.device.HostApi.Name
+	}
+	if ac.device != nil && ac.config.Audio.Backend != BackendPulse {
+		settings["requested_latency_ms"] = durationMs(ac.inputLatency(ac.device))
+	}
+	if ac.stream != nil {
+		if info := ac.stream.Info(); info != nil {
+			settings["input_latency_ms"] = durationMs(info.InputLatency)
+		}
+	}
+	if ce := ac.LastError(); ce != nil {
+		settings["error"] = ce.info()
+	}
+	return settings
+}
@@ -1,69 +1,162 @@
 package audiorelay
 
 import (
-	"embed"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-//go:embed web/index.html
-var webFS embed.FS
-
 // HTTPServer handles HTTP audio stream connections
 type HTTPServer struct {
 	config *Config
 	server *http.Server
+	mux    *http.ServeMux
 	webFS  fs.FS
 
 	// Audio components
 	audioCapture *AudioCapture // 添加 AudioCapture 引用
+	events       *EventBus
+	registry     *ClientRegistry
 
 	// Audio stream clients
-	streamClients   map[http.ResponseWriter]bool
+	streamClients   map[*streamClient]bool
 	streamClientsMu sync.RWMutex
 
-	// Audio data buffer for new clients
-	audioBuffer   [][]byte
-	audioBufferMu sync.RWMutex
-	bufferSize    int
+	// Recent audio for new clients
+	history *prebuffer
+
+	// Stable identity of the stream served here
+	identity *StreamIdentity
+
+	// Title sent to ICY clients
+	metadata *StreamMetadata
+
+	// Saved settings of named listeners, may be nil
+	prefs *ClientPrefStore
+
+	// Standbys advertised to listeners, and this relay's own primary when
+	// it is a standby; both may be nil
+	standbys *StandbyRegistry
+	standby  *StandbyMirror
+
+	// Statistics history served at /stats, nil when disabled
+	stats *StatsHistory
+
+	// Extra /debug sections provided by other components
+	debugSections map[string]func() interface{}
+
+	// Closed on shutdown so streaming handlers end their responses cleanly
+	shutdown chan struct{}
+
+	// Called when a listener connects, e.g. to wake idle capture
+	onConnect func()
+
+	// Developer-mode fault injection, may be nil
+	faults *FaultInjector
+
+	// Parent of every request context, may be nil
+	ctx context.Context
+
+	// Requests per IP, nil for no limit
+	limiter *ipRateLimiter
+
+	// logging.access_log, which config reloads change
+	accessLog atomic.Value
 
 	// Control
 	isRunning bool
 }
 
-// NewHTTPServer creates a new HTTP server instance
-func NewHTTPServer(config *Config, webFS fs.FS, audioCapture *AudioCapture) *HTTPServer {
-	return &HTTPServer{
+// NewHTTPServer creates a new HTTP server instance serving the web
+// interface from webFS, or the embedded one if it is nil
+func NewHTTPServer(config *Config, webFS fs.FS, audioCapture *AudioCapture, events *EventBus, registry *ClientRegistry) *HTTPServer {
+	if webFS == nil {
+		webFS = WebFS("")
+	}
+	hs := &HTTPServer{
 		config:        config,
+		mux:           http.NewServeMux(),
 		webFS:         webFS,
 		audioCapture:  audioCapture, // 保存 AudioCapture 引用
-		streamClients: make(map[http.ResponseWriter]bool),
-		audioBuffer:   make([][]byte, 0),
-		bufferSize:    50,
+		events:        events,
+		registry:      registry,
+		streamClients: make(map[*streamClient]bool),
+		debugSections: make(map[string]func() interface{}),
+		shutdown:      make(chan struct{}),
+		metadata:      NewStreamMetadata(config.Protocols.HTTP.ICY.Title),
+		limiter:       newIPRateLimiter(config.Protocols.RateLimit),
+		history:       newPrebuffer(config.StreamFormat(config.Protocols.HTTP.UpmixStereo), config.Protocols.HTTP.PrebufferMs),
 	}
+	hs.accessLog.Store(config.Logging.AccessLog)
+	return hs
 }
 
 // Start begins the HTTP server
 func (hs *HTTPServer) Start() error {
-	mux := http.NewServeMux()
+	mux := hs.mux
 
 	// Set up routes
 	mux.HandleFunc("/", hs.handleRoot)
 	mux.HandleFunc("/stream.wav", hs.handleWavStream) // WAV format stream
+	mux.HandleFunc("/stream.pcm", hs.handlePCMStream) // Raw PCM, format in headers
+	mux.HandleFunc("/stream", hs.handleStream)        // Format, rate and channels chosen per request
+	mux.HandleFunc("/capture.wav", hs.handleCapture)  // One-shot WAV capture
+	mux.HandleFunc("GET /id/{id}/stream.wav", hs.handleStreamByID)
 	mux.HandleFunc("/status", hs.handleStatus)
+	mux.HandleFunc("/levels", hs.handleLevels) // Live level meter readings
+	mux.HandleFunc("/events", hs.handleEvents) // Server-Sent Events
 	mux.HandleFunc("/debug", hs.handleDebug)
+	mux.HandleFunc("GET /clients", hs.handleClients) // Per-listener traffic
+	mux.HandleFunc("/healthz", hs.handleHealthz)     // Process up
+	mux.HandleFunc("/readyz", hs.handleReadyz)       // Capturing audio
+	mux.HandleFunc("GET /metrics", hs.handleMetrics) // Prometheus
+	mux.HandleFunc("GET /stats", hs.handleStats)     // Per-minute history
+
+	// Certificate problems should stop startup rather than fail every request
+	var tlsConfig *tls.Config
+	if hs.config.Protocols.HTTP.TLS.Enabled {
+		var err error
+		if tlsConfig, err = loadTLSConfig(hs.config.Protocols.HTTP.TLS); err != nil {
+			return err
+		}
+	}
+
+	auth := NewAuthenticator(hs.config.Auth)
+	logAuth(auth, tlsConfig != nil)
+	proxies, err := parseTrustedProxies(hs.config.Protocols.HTTP.TrustedProxies)
+	if err != nil {
+		return err
+	}
 
 	hs.server = &http.Server{
-		Addr:         ":" + hs.config.Server.HttpPort,
-		Handler:      mux,
+		Addr:         hs.config.ListenAddr(hs.config.Server.HttpPort),
+		TLSConfig:    tlsConfig,
+		Handler:      wrapForwarded(proxies, hs.wrapAccessLog(wrapCORS(hs.config.Protocols.HTTP.CORS, auth.Wrap(mux)))),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 0, // No timeout for streaming connections
+		BaseContext: func(net.Listener) context.Context {
+			if hs.ctx != nil {
+				return hs.ctx
+			}
+			return context.Background()
+		},
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			hs.config.markConn(c)
+			return ctx
+		},
 	}
 
 	hs.isRunning = true
@@ -73,7 +166,13 @@ func (hs *HTTPServer) Start() error {
 
 	// Start HTTP server
 	go func() {
-		if err := hs.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			err = hs.server.ListenAndServeTLS("", "")
+		} else {
+			err = hs.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Printf("  HTTP server error: %v", err)
 		}
 	}()
@@ -81,51 +180,69 @@ func (hs *HTTPServer) Start() error {
 	return nil
 }
 
+// streamEndTrailer carries the reason an endless HTTP stream ended
+const streamEndTrailer = "X-Stream-End"
+
+// httpWriteTimeout drops a listener whose connection accepts no data at all
+const httpWriteTimeout = 10 * time.Second
+
+// formatVersionTrailer carries the new format version after a format change
+const formatVersionTrailer = "X-Stream-Format-Version"
+
+// Reasons sent in the X-Stream-End trailer
+const (
+	streamEndShutdown     = "shutdown"
+	streamEndFormatChange = "format-change"
+	streamEndDisconnected = "disconnected" // By an admin through the API
+)
+
+// HandleFunc registers an additional route, e.g. API endpoints owned by other components
+func (hs *HTTPServer) HandleFunc(pattern string, handler http.HandlerFunc) {
+	hs.mux.HandleFunc(pattern, handler)
+}
+
+// AddDebugInfo adds a section to the /debug output, computed on each request
+func (hs *HTTPServer) AddDebugInfo(name string, info func() interface{}) {
+	hs.debugSections[name] = info
+}
+
 // Stop gracefully shuts down the HTTP server
 func (hs *HTTPServer) Stop() {
 	hs.isRunning = false
 
-	if hs.server != nil {
-		hs.server.Close()
-	}
+	// Streams write out their queued audio, then finish their responses
+	close(hs.shutdown)
 
-	// Close all stream connections
-	hs.streamClientsMu.Lock()
-	for client := range hs.streamClients {
-		if flusher, ok := client.(http.Flusher); ok {
-			flusher.Flush()
+	// Wait up to the drain period for responses to complete
+	if hs.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), hs.config.DrainTimeout())
+		defer cancel()
+		if err := hs.server.Shutdown(ctx); err != nil {
+			log.Printf("  HTTP connections still open after %s, closing them", hs.config.DrainTimeout())
+			hs.server.Close()
 		}
 	}
-	hs.streamClients = make(map[http.ResponseWriter]bool)
-	hs.streamClientsMu.Unlock()
 
 	fmt.Println(" HTTP server stopped")
 }
 
-// Broadcast sends audio data to all connected clients
-func (hs *HTTPServer) Broadcast(data []byte) {
+// Broadcast sends audio data read from the device at captured to all
+// connected clients
+func (hs *HTTPServer) Broadcast(data []byte, captured time.Time) {
+	// Convert once for all clients when the endpoint wants more channels
+	if channels := hs.streamChannels(); channels != hs.config.OutputChannels() {
+		data = duplicateChannels(data, channels, hs.wavFormat().bytesPerSample())
+	}
+
 	// Broadcast to HTTP stream clients
-	hs.broadcastHTTPStream(data)
+	hs.broadcastHTTPStream(data, captured)
 
 	// Buffer audio data for new clients
-	hs.bufferAudioData(data)
-}
-
-// bufferAudioData keeps recent audio data for new clients
-func (hs *HTTPServer) bufferAudioData(data []byte) {
-	hs.audioBufferMu.Lock()
-	defer hs.audioBufferMu.Unlock()
-
-	hs.audioBuffer = append(hs.audioBuffer, data)
-
-	// Keep only the last bufferSize frames
-	if len(hs.audioBuffer) > hs.bufferSize {
-		hs.audioBuffer = hs.audioBuffer[len(hs.audioBuffer)-hs.bufferSize:]
-	}
+	hs.history.add(data)
 }
 
 // broadcastHTTPStream sends data to HTTP stream clients
-func (hs *HTTPServer) broadcastHTTPStream(data []byte) {
+func (hs *HTTPServer) broadcastHTTPStream(data []byte, captured time.Time) {
 	hs.streamClientsMu.RLock()
 	defer hs.streamClientsMu.RUnlock()
 
@@ -133,26 +250,55 @@ func (hs *HTTPServer) broadcastHTTPStream(data []byte) {
 		return
 	}
 
-	failedClients := make([]http.ResponseWriter, 0)
+	// Each client's writer sends at its own pace; one that falls behind
+	// loses its oldest audio instead of holding up the others
+	for client := range hs.streamClients {
+		client.enqueue(data, captured)
+	}
+}
 
+// restartStreams ends every stream response because the audio format
+// changed: the WAV headers already sent no longer describe the data, so
+// clients are told to reconnect and the stale history is dropped.
+func (hs *HTTPServer) restartStreams() {
+	hs.history.reset(hs.wavFormat())
+
+	hs.streamClientsMu.Lock()
+	defer hs.streamClientsMu.Unlock()
 	for client := range hs.streamClients {
-		_, err := client.Write(data)
-		if err != nil {
-			failedClients = append(failedClients, client)
-		} else {
-			// Flush the data to client
-			if flusher, ok := client.(http.Flusher); ok {
-				flusher.Flush()
-			}
-		}
+		client.end(streamEndFormatChange)
+		delete(hs.streamClients, client)
 	}
+}
 
-	// Clean up failed clients
-	if len(failedClients) > 0 {
-		go hs.cleanupStreamClients(failedClients)
+// listenerConnected runs the connect hook
+func (hs *HTTPServer) listenerConnected() {
+	if hs.onConnect != nil {
+		hs.onConnect()
 	}
 }
 
+// retryAfterSeconds is how long clients turned away by max_clients are
+// asked to wait
+const retryAfterSeconds = "10"
+
+// turnAway answers 429 to IPs over protocols.rate_limit and 503 when
+// max_clients listeners are connected, reporting true if the new listener
+// was turned away
+func (hs *HTTPServer) turnAway(w http.ResponseWriter, r *http.Request) bool {
+	if hs.limiter.limited(w, r) {
+		return true
+	}
+	max := hs.config.Protocols.HTTP.MaxClients
+	if max == 0 || hs.GetClientCount() < max {
+		return false
+	}
+	log.Printf("⚠️ HTTP listener %s turned away, %d listeners connected", r.RemoteAddr, max)
+	w.Header().Set("Retry-After", retryAfterSeconds)
+	http.Error(w, "too many listeners, try again later", http.StatusServiceUnavailable)
+	return true
+}
+
 // GetClientCount returns the number of connected clients
 func (hs *HTTPServer) GetClientCount() int {
 	hs.streamClientsMu.RLock()
@@ -160,17 +306,16 @@ func (hs *HTTPServer) GetClientCount() int {
 	return len(hs.streamClients)
 }
 
-// handleRoot serves the web interface
+// handleRoot serves the web interface, and its other files such as the
+// scripts in /static/
 func (hs *HTTPServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
-		http.NotFound(w, r)
+		http.FileServerFS(hs.webFS).ServeHTTP(w, r)
 		return
 	}
 
-	// Serve the embedded HTML file
-	htmlContent, err := webFS.ReadFile("web/index.html")
+	htmlContent, err := fs.ReadFile(hs.webFS, "index.html")
 	if err != nil {
-		// Fallback: serve a simple HTML page if embedded file is not found
 		http.Error(w, "Web interface not found", http.StatusInternalServerError)
 		return
 	}
@@ -179,77 +324,234 @@ func (hs *HTTPServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 	w.Write(htmlContent)
 }
 
+// Containers an HTTP stream is served in
+const (
+	containerWAV = "wav" // WAV header of unknown length, then PCM
+	containerPCM = "pcm" // Headerless PCM, the format in X-Audio-* headers
+)
+
 // handleWavStream handles WAV format audio streaming
 func (hs *HTTPServer) handleWavStream(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🎵 WAV audio stream connected: %s", r.RemoteAddr)
+	hs.serveStream(w, r, containerWAV, r.URL.Query())
+}
+
+// handlePCMStream streams raw little-endian PCM, for tools like ffmpeg and
+// sox that take raw input more readily than a WAV header of fake length
+func (hs *HTTPServer) handlePCMStream(w http.ResponseWriter, r *http.Request) {
+	hs.serveStream(w, r, containerPCM, r.URL.Query())
+}
+
+// serveStream streams live audio in the given container, one of
+// streamEncodings, until the client leaves, its limit is reached or the
+// server ends the stream. The listener settings are read from query.
+func (hs *HTTPServer) serveStream(w http.ResponseWriter, r *http.Request, container string, query url.Values) {
+	encoding := streamEncodings[container]
+	name, prefs, err := parseClientPrefs(query, hs.prefs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := clientFormat(hs.wavFormat(), prefs.Format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	output, err := streamLayout(format, r.URL.Query())
+	if err == nil {
+		err = encoding.check(output)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// PCM the stream client produces: the output layout, or the broadcast
+	// layout for ffmpeg to convert while encoding
+	if !encoding.encoded() {
+		format = output
+	} else if _, err := exec.LookPath(hs.config.Protocols.HTTP.FFmpegPath); err != nil {
+		http.Error(w, "format "+container+" needs ffmpeg, see protocols.http.ffmpeg_path", http.StatusNotImplemented)
+		return
+	}
+	limit, err := hs.parseStreamLimit(r, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hs.turnAway(w, r) {
+		return
+	}
 
-	// Set headers for WAV stream
-	w.Header().Set("Content-Type", "audio/wav")
+	listener := hs.registry.RegisterNamed("http", r.RemoteAddr, name)
+	listener.SetGain(prefs.Volume)
+	defer hs.registry.Unregister(listener)
+	w = &meteredResponse{ResponseWriter: w, client: listener}
+
+	hs.listenerConnected()
+	debugf("HTTP stream %s: query=%q user-agent=%q format=%+v limit=%d prefs=%+v",
+		r.RemoteAddr, redactedQuery(r.URL), r.UserAgent(), format, limit, prefs)
+
+	// Set headers for the stream
+	hs.identity.setHeaders(w)
+	hs.standbys.setHeaders(w)
+	headerSize := 0
+	w.Header().Set("Content-Type", encoding.contentType)
+	switch container {
+	case containerPCM:
+		w.Header().Set("X-Audio-Rate", strconv.Itoa(format.SampleRate))
+		w.Header().Set("X-Audio-Channels", strconv.Itoa(format.Channels))
+		w.Header().Set("X-Audio-Format", format.rawName())
+	case containerWAV:
+		headerSize = format.headerSize()
+	}
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Transfer-Encoding", "chunked")
+	w.Header().Set("X-Accel-Buffering", "no") // Ask nginx not to buffer the stream
+	w.Header().Set("Accept-Ranges", "none")   // Live audio can't be seeked; Range headers are ignored
+	if limit > 0 {
+		// A limited stream is a download, of known length unless encoded
+		if !encoding.encoded() {
+			w.Header().Set("Content-Length", strconv.FormatInt(limit+int64(headerSize), 10))
+		}
+		setFilename(w, r, "stream-"+time.Now().Format(downloadTimeFormat)+"."+container)
+	} else {
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.Header().Set("Trailer", streamEndTrailer+", "+formatVersionTrailer)
 
-	// Write WAV header
-	hs.writeWAVHeader(w)
+		// Internet radio players get the title between blocks of audio
+		w = hs.wrapICY(w, r)
+	}
 
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
+	// Write WAV header; a limited stream knows its exact length up front
+	switch {
+	case container != containerWAV:
+	case limit > 0:
+		format.writeHeader(w, uint32(limit))
+	default:
+		format.writeHeader(w, wavUnknownSize)
+	}
+
+	// Encoded formats are written by ffmpeg, which the client feeds
+	var out io.Writer = w
+	var encoder *ffmpegEncoder
+	if encoding.encoded() {
+		if encoder, err = startEncoder(r.Context(), hs.config.Protocols.HTTP.FFmpegPath, encoding, format, output, w); err != nil {
+			log.Printf("⚠️ %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = encoder.stdin
+	}
+
+	client := newStreamClient(out, limit)
+	client.source = hs.wavFormat()
+	client.format = format
+	client.convert = newStreamConverter(client.source, format)
+	client.Client = listener
+	listener.onDisconnect(func() { client.end(streamEndDisconnected) })
+
+	// Leading silence holds this listener behind the others by the delay
+	if prefs.DelayMs > 0 && limit == 0 {
+		frames := int(prefs.DelayMs / 1000 * float64(format.SampleRate))
+		client.w.Write(make([]byte, frames*format.blockAlign()))
 	}
+	client.flush()
 
 	// Send buffered audio data to new client
-	hs.sendBufferedAudio(w)
+	hs.sendBufferedAudio(client, prefs.PrerollMs)
 
 	// Add client to stream clients
-	hs.addStreamClient(w)
-
-	// Keep connection alive
-	<-r.Context().Done()
+	hs.addStreamClient(client)
+
+	// Keep connection alive until the client leaves or its limit is reached
+	reason, logReason := "", streamEndClientLeft
+	select {
+	case <-r.Context().Done():
+	case <-client.done:
+		reason, logReason = client.endReason, client.endReason
+		if reason == "" && limit > 0 && client.written >= limit {
+			logReason = streamEndLimit
+		} else if reason == "" {
+			logReason = streamEndWriteError
+		}
+	case <-hs.shutdown:
+		reason, logReason = streamEndShutdown, streamEndShutdown
+	}
 
 	// Remove client when connection closes
-	hs.removeStreamClient(w)
-	log.Printf("🎵 WAV audio stream disconnected: %s", r.RemoteAddr)
-}
-
-// writeWAVHeader writes WAV file header
-func (hs *HTTPServer) writeWAVHeader(w http.ResponseWriter) {
-	sampleRate := int(hs.config.Audio.SampleRate)
-	channels := hs.config.Audio.Channels
-	bitsPerSample := 16
-	byteRate := sampleRate * channels * bitsPerSample / 8
-	blockAlign := channels * bitsPerSample / 8
-
-	// RIFF header
-	w.Write([]byte("RIFF"))
-	w.Write([]byte{0xff, 0xff, 0xff, 0xff}) // File size (unknown for stream)
-	w.Write([]byte("WAVE"))
-
-	// Format chunk
-	w.Write([]byte("fmt "))
-	w.Write([]byte{16, 0, 0, 0})                                                                                                               // Chunk size
-	w.Write([]byte{1, 0})                                                                                                                      // Audio format (PCM)
-	w.Write([]byte{byte(channels), 0})                                                                                                         // Number of channels
-	w.Write([]byte{byte(sampleRate & 0xff), byte((sampleRate >> 8) & 0xff), byte((sampleRate >> 16) & 0xff), byte((sampleRate >> 24) & 0xff)}) // Sample rate
-	w.Write([]byte{byte(byteRate & 0xff), byte((byteRate >> 8) & 0xff), byte((byteRate >> 16) & 0xff), byte((byteRate >> 24) & 0xff)})         // Byte rate
-	w.Write([]byte{byte(blockAlign), 0})                                                                                                       // Block align
-	w.Write([]byte{byte(bitsPerSample), 0})                                                                                                    // Bits per sample
-
-	// Data chunk
-	w.Write([]byte("data"))
-	w.Write([]byte{0xff, 0xff, 0xff, 0xff}) // Data size (unknown for stream)
-}
-
-// sendBufferedAudio sends recent audio data to a new client
-func (hs *HTTPServer) sendBufferedAudio(w http.ResponseWriter) {
-	hs.audioBufferMu.RLock()
-	defer hs.audioBufferMu.RUnlock()
-
-	for _, data := range hs.audioBuffer {
-		w.Write(data)
-	}
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
+	hs.removeStreamClient(client)
+	if encoder != nil {
+		encoder.close()
+	}
+	if reason != "" && limit == 0 {
+		// Sent as chunked trailers so players can show "stream ended", or
+		// reconnect right away to pick up the new format
+		w.Header().Set(streamEndTrailer, reason)
+		if reason == streamEndFormatChange {
+			_, version := hs.identity.Snapshot()
+			w.Header().Set(formatVersionTrailer, strconv.Itoa(version))
+		}
 	}
+	requestAccess(r).streamEnded(logReason, client.dropped.Load())
+}
+
+// parseStreamLimit reads the optional max_seconds and max_bytes query parameters
+// and returns the resulting audio byte limit in format (0 for unlimited)
+func (hs *HTTPServer) parseStreamLimit(r *http.Request, format wavFormat) (int64, error) {
+	blockAlign := int64(format.blockAlign())
+	var limit int64
+
+	if v := r.URL.Query().Get("max_seconds"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds <= 0 {
+			return 0, fmt.Errorf("invalid max_seconds: %q", v)
+		}
+		limit = int64(seconds*float64(format.SampleRate)) * blockAlign
+	}
+
+	if v := r.URL.Query().Get("max_bytes"); v != "" {
+		maxBytes, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return 0, fmt.Errorf("invalid max_bytes: %q", v)
+		}
+		if limit == 0 || maxBytes < limit {
+			limit = maxBytes
+		}
+	}
+
+	if limit == 0 {
+		return 0, nil
+	}
+
+	// Never cut a sample frame in half
+	limit -= limit % blockAlign
+	if limit <= 0 {
+		return 0, fmt.Errorf("limit is smaller than one audio frame (%d bytes)", blockAlign)
+	}
+	if limit > wavMaxDataSize {
+		return 0, fmt.Errorf("limit exceeds the WAV size limit")
+	}
+	return limit, nil
+}
+
+// streamChannels returns the channel count served to HTTP clients
+func (hs *HTTPServer) streamChannels() int {
+	return hs.config.EndpointChannels(hs.config.Protocols.HTTP.UpmixStereo)
+}
+
+// wavFormat returns the WAV layout of the broadcast stream
+func (hs *HTTPServer) wavFormat() wavFormat {
+	return hs.audioCapture.StreamFormat(hs.config.Protocols.HTTP.UpmixStereo)
+}
+
+// sendBufferedAudio sends recent audio data to a new client: prerollMs of
+// it, or the whole prebuffer if nil
+func (hs *HTTPServer) sendBufferedAudio(client *streamClient, prerollMs *float64) {
+	if prerollMs == nil {
+		hs.history.replay(client.write)
+	} else {
+		hs.history.replayLast(prebufferBytes(client.source, *prerollMs), client.write)
+	}
+	client.flush()
 }
 
 // handleStatus returns server status information
@@ -261,87 +563,140 @@ func (hs *HTTPServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 		actualBufferSize = hs.audioCapture.GetActualBufferSize()
 	}
 
+	levels := hs.audioCapture.levelSettings()
+	streamID, formatVersion := hs.identity.Snapshot()
 	status := map[string]interface{}{
 		"status":             "running",
+		"stream_id":          streamID,
+		"format_version":     formatVersion,
 		"clients":            clientCount,
-		"sample_rate":        hs.config.Audio.SampleRate,
-		"channels":           hs.config.Audio.Channels,
+		"sample_rate":        hs.audioCapture.SampleRate(),
+		"channels":           hs.streamChannels(),
+		"capture_channels":   hs.config.Audio.Channels,
+		"capture_idle":       hs.audioCapture.IsAsleep(),
+		"bits_per_sample":    hs.wavFormat().BitsPerSample,
+		"float":              hs.wavFormat().Float,
 		"buffer_size":        hs.config.Audio.BufferSize,
 		"actual_buffer_size": actualBufferSize,
+		"capture":            hs.audioCapture.streamSettings(),
 		"processing": map[string]interface{}{
 			"silence_detection": hs.config.Processing.SilenceDetection,
-			"silence_threshold": hs.config.Processing.SilenceThreshold,
-			"volume_multiplier": hs.config.Processing.VolumeMultiplier,
+			"silence_threshold": levels.SilenceThreshold,
+			"volume_multiplier": levels.Volume,
+			"noise_gate":        hs.config.Processing.NoiseGate.Enabled,
+			"channels":          hs.audioCapture.ChannelState(),
+			"stereo_width":      hs.audioCapture.StereoWidth(),
 		},
+		"formats":       hs.streamFormats(),
+		"standby_urls":  hs.standbys.URLs(),
 		"timestamp":     time.Now().Unix(),
 		"server_uptime": time.Since(startTime).Seconds(),
 	}
+	if hs.standby != nil {
+		status["standby"] = hs.standby.info()
+	}
+	if hs.registry != nil {
+		status["latency"] = hs.registry.latencyStatus()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	json.NewEncoder(w).Encode(status)
 }
 
+// handleClients lists the connected listeners of every stream with their
+// traffic counters
+func (hs *HTTPServer) handleClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"clients": hs.registry.List()})
+}
+
 // handleDebug returns debug information
 func (hs *HTTPServer) handleDebug(w http.ResponseWriter, r *http.Request) {
 	clientCount := hs.GetClientCount()
-	historyBufferSize := len(hs.audioBuffer)
+	historyBytes, historyMax := hs.history.stats()
 
 	// Get actual audio buffer size
 	actualAudioBufferSize := 0
+	levels := newLevelSettings(hs.config.Processing)
 	if hs.audioCapture != nil {
 		actualAudioBufferSize = hs.audioCapture.GetActualBufferSize()
+		levels = hs.audioCapture.levelSettings()
 	}
 
 	debugInfo := map[string]interface{}{
 		"clients": clientCount,
 		"buffers": map[string]interface{}{
-			"audio_history_frames": historyBufferSize,          // Current number of frames in history buffer
-			"audio_history_max":    hs.bufferSize,              // Maximum capacity of history buffer
-			"config_buffer_size":   hs.config.Audio.BufferSize, // Configured audio buffer size
-			"actual_buffer_size":   actualAudioBufferSize,      // Actual audio buffer size in use
+			"audio_history_bytes": historyBytes,               // Bytes currently in the history buffer
+			"audio_history_max":   historyMax,                 // Maximum capacity of history buffer
+			"prebuffer_ms":        hs.history.lengthMs(),      // Configured history length
+			"config_buffer_size":  hs.config.Audio.BufferSize, // Configured audio buffer size
+			"actual_buffer_size":  actualAudioBufferSize,      // Actual audio buffer size in use
 		},
 		"audio_config": map[string]interface{}{
-			"sample_rate": hs.config.Audio.SampleRate,
-			"channels":    hs.config.Audio.Channels,
+			"sample_rate":   hs.audioCapture.SampleRate(),
+			"channels":      hs.config.Audio.Channels,
+			"downmix_mono":  hs.config.Processing.DownmixMono,
+			"sample_format": hs.config.Audio.SampleFormat,
+			"output_bits":   hs.config.OutputBits(),
 		},
 		"processing": map[string]interface{}{
 			"silence_detection": hs.config.Processing.SilenceDetection,
-			"silence_threshold": hs.config.Processing.SilenceThreshold,
+			"silence_threshold": levels.SilenceThreshold,
 		},
 	}
+	for name, info := range hs.debugSections {
+		debugInfo[name] = info()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	json.NewEncoder(w).Encode(debugInfo)
 }
 
-// addStreamClient adds a new HTTP stream client
-func (hs *HTTPServer) addStreamClient(w http.ResponseWriter) {
-	hs.streamClientsMu.Lock()
-	defer hs.streamClientsMu.Unlock()
-	hs.streamClients[w] = true
-	log.Printf("  Total stream clients: %d", len(hs.streamClients))
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
 
-// removeStreamClient removes an HTTP stream client
-func (hs *HTTPServer) removeStreamClient(w http.ResponseWriter) {
+// downloadTimeFormat timestamps generated download filenames, like snapshots
+const downloadTimeFormat = "20060102-150405"
+
+// setFilename suggests a filename for saving the response. Browsers still
+// play the audio unless ?download=1 asks for an attachment.
+func setFilename(w http.ResponseWriter, r *http.Request, filename string) {
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+}
+
+// writeJSONError writes an error message as a JSON response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{"error": message})
+}
+
+// addStreamClient adds a new HTTP stream client and starts its writer
+func (hs *HTTPServer) addStreamClient(client *streamClient) {
+	client.start(hs.wavFormat(), hs.config.Protocols.HTTP.QueueMs, hs.faults)
+
 	hs.streamClientsMu.Lock()
 	defer hs.streamClientsMu.Unlock()
-	delete(hs.streamClients, w)
+	hs.streamClients[client] = true
 	log.Printf("  Total stream clients: %d", len(hs.streamClients))
 }
 
-// cleanupStreamClients removes failed stream clients
-func (hs *HTTPServer) cleanupStreamClients(failedClients []http.ResponseWriter) {
+// removeStreamClient removes an HTTP stream client once its writer has
+// sent the queued audio, so the caller may finish the response
+func (hs *HTTPServer) removeStreamClient(client *streamClient) {
 	hs.streamClientsMu.Lock()
-	defer hs.streamClientsMu.Unlock()
-	for _, client := range failedClients {
-		delete(hs.streamClients, client)
-	}
-	log.Printf("  Total stream clients after cleanup: %d", len(hs.streamClients))
+	delete(hs.streamClients, client)
+	log.Printf("  Total stream clients: %d", len(hs.streamClients))
+	hs.streamClientsMu.Unlock()
+
+	client.stop()
 }
 
 // displayServerInfo shows HTTP server connection information
@@ -350,16 +705,25 @@ func (hs *HTTPServer) displayServerInfo() {
 	if ips, err := hs.getLocalIPs(); err == nil {
 		fmt.Printf("  Stream URLs:\n")
 		for _, ip := range ips {
-			fmt.Printf("    http://%s:%s/stream.wav\n", ip, hs.config.Server.HttpPort)
-			fmt.Printf("    http://%s:%s (Web interface)\n", ip, hs.config.Server.HttpPort)
+			fmt.Printf("    %s://%s:%s/stream.wav\n", hs.scheme(), ip, hs.config.Server.HttpPort)
+			fmt.Printf("    %s://%s:%s/id/%s/stream.wav (stable)\n", hs.scheme(), ip, hs.config.Server.HttpPort, hs.identity.ID)
+			fmt.Printf("    %s://%s:%s (Web interface)\n", hs.scheme(), ip, hs.config.Server.HttpPort)
 		}
 	} else {
-		fmt.Printf("  Audio Stream: http://0.0.0.0:%s/stream.wav\n", hs.config.Server.HttpPort)
-		fmt.Printf("  Web Interface: http://0.0.0.0:%s\n", hs.config.Server.HttpPort)
+		fmt.Printf("  Audio Stream: %s://0.0.0.0:%s/stream.wav\n", hs.scheme(), hs.config.Server.HttpPort)
+		fmt.Printf("  Web Interface: %s://0.0.0.0:%s\n", hs.scheme(), hs.config.Server.HttpPort)
 	}
 	fmt.Println()
 }
 
+// scheme returns the URL scheme the server is reached with
+func (hs *HTTPServer) scheme() string {
+	if hs.config.Protocols.HTTP.TLS.Enabled {
+		return "https"
+	}
+	return "http"
+}
+
 // getLocalIPs retrieves all local IP addresses
 func (hs *HTTPServer) getLocalIPs() ([]string, error) {
 	var ips []string
@@ -379,5 +743,198 @@ func (hs *HTTPServer) getLocalIPs() ([]string, error) {
 	return ips, nil
 }
 
+// streamClient is a single HTTP stream listener with an optional byte limit
+type streamClient struct {
+	*Client // Listener identity and gain; nil for one-shot captures
+
+	w       io.Writer
+	source  wavFormat // Sample layout of the broadcast, needed to apply gain
+	format  wavFormat // Sample layout sent to this client
+	limit   int64     // Maximum audio bytes to send, 0 for unlimited
+	written int64
+
+	// Changes the rate and channels of the broadcast, nil if they match
+	convert *streamConverter
+
+	done      chan struct{} // Closed once the limit has been reached or the stream ended
+	doneOnce  sync.Once
+	endReason string // Why the server ended the stream early, set before done is closed
+
+	// Audio waiting for the writer goroutine, which owns w once started
+	queue    *chunkQueue
+	dropped  atomic.Int64 // Bytes dropped because the client fell behind
+	quit     chan struct{}
+	quitOnce sync.Once
+	stopped  chan struct{}
+	deadline func(time.Time) error // Sets the response's write deadline, nil if w isn't one
+
+	frameSize int // Bytes per frame of queued audio, for counting dropped frames
+}
+
+// newStreamClient wraps a writer (usually a response) as a stream client
+func newStreamClient(w io.Writer, limit int64) *streamClient {
+	c := &streamClient{
+		w:       w,
+		limit:   limit,
+		done:    make(chan struct{}),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if rw, ok := w.(http.ResponseWriter); ok {
+		c.deadline = http.NewResponseController(rw).SetWriteDeadline
+	}
+	return c
+}
+
+// start hands w over to a writer goroutine fed by enqueue. The queue holds
+// queueMs of audio in format.
+func (c *streamClient) start(format wavFormat, queueMs float64, faults *FaultInjector) {
+	frames := int(queueMs / 1000 * float64(format.SampleRate))
+	c.frameSize = format.blockAlign()
+	c.queue = newChunkQueue(frames * c.frameSize)
+	go c.run(faults)
+}
+
+// enqueue queues broadcast audio captured by captured for the writer
+// without blocking
+func (c *streamClient) enqueue(data []byte, captured time.Time) {
+	if dropped, _ := c.queue.push(data, captured); dropped > 0 {
+		c.dropped.Add(int64(dropped))
+		c.Client.recordDropped(dropped / c.frameSize)
+	}
+}
+
+// run writes queued audio until stop, then sends what is left
+func (c *streamClient) run(faults *FaultInjector) {
+	defer close(c.stopped)
+	for {
+		select {
+		case <-c.queue.ready:
+			if !c.drain(faults) {
+				return
+			}
+		case <-c.quit:
+			c.drain(faults)
+			return
+		}
+	}
+}
+
+// drain writes all queued audio and flushes it, reporting false once the
+// client can't be written to anymore. The audio counts as delivered once
+// flushed.
+func (c *streamClient) drain(faults *FaultInjector) bool {
+	var written []time.Time
+	for chunk, captured := c.queue.pop(); chunk != nil; chunk, captured = c.queue.pop() {
+		faults.slowClient(c.Client)
+		if c.deadline != nil {
+			c.deadline(time.Now().Add(httpWriteTimeout))
+		}
+		if err := c.write(chunk); err != nil {
+			// Ends the stream without a reason; the handler cleans up
+			c.end("")
+			return false
+		}
+		written = append(written, captured)
+	}
+	c.flush()
+	for _, captured := range written {
+		c.Client.recordDelivery(captured)
+	}
+	return true
+}
+
+// stop ends the writer once it has sent the queued audio, and returns w
+// to the caller
+func (c *streamClient) stop() {
+	c.quitOnce.Do(func() { close(c.quit) })
+	<-c.stopped
+	if c.deadline != nil {
+		c.deadline(time.Time{})
+	}
+}
+
+// write sends audio data, truncating it at the client's limit
+func (c *streamClient) write(data []byte) error {
+	if c.Client != nil {
+		data = c.applyGain(data, c.source)
+	}
+	if c.format != c.source {
+		samples := c.source.decodeSamples(data)
+		if c.convert != nil {
+			samples = c.convert.process(samples)
+		}
+		data = c.format.encodeSamples(samples)
+	}
+
+	if c.limit > 0 {
+		remaining := c.limit - c.written
+		if remaining <= 0 {
+			return nil
+		}
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+	}
+
+	n, err := c.w.Write(data)
+	c.written += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if c.limit > 0 && c.written >= c.limit {
+		c.flush()
+		c.doneOnce.Do(func() { close(c.done) })
+	}
+	return nil
+}
+
+// end stops the stream early for the given reason
+func (c *streamClient) end(reason string) {
+	c.doneOnce.Do(func() {
+		c.endReason = reason
+		close(c.done)
+	})
+}
+
+// flush pushes buffered data to the client
+func (c *streamClient) flush() {
+	if flusher, ok := c.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// meteredResponse counts the bytes written to a listener's response.
+// Writes are buffered until a flush, so the time spent in the writes and
+// the flush together is the listener's write latency.
+type meteredResponse struct {
+	http.ResponseWriter
+	client *Client
+	busy   time.Duration
+}
+
+// Write sends p and counts it
+func (m *meteredResponse) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := m.ResponseWriter.Write(p)
+	m.busy += time.Since(start)
+	m.client.recordSent(n)
+	return n, err
+}
+
+// Flush pushes buffered data to the client and records the write latency
+func (m *meteredResponse) Flush() {
+	start := time.Now()
+	http.NewResponseController(m.ResponseWriter).Flush()
+	m.client.recordLatency(m.busy + time.Since(start))
+	m.busy = 0
+}
+
+// Unwrap lets http.ResponseController reach the response
+func (m *meteredResponse) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
+
 // Global variable to track server start time
 var startTime = time.Now()
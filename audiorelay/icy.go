@@ -0,0 +1,191 @@
+package audiorelay
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// icyMaxMetadata is the largest metadata block: its length is sent as one
+// byte counting 16-byte units
+const icyMaxMetadata = 255 * 16
+
+// ICYConfig controls the SHOUTcast metadata interleaved into the stream for
+// internet radio players that ask for it
+type ICYConfig struct {
+	Enabled bool   `mapstructure:"enabled" desc:"Send the stream title to players that request Icy-MetaData: 1"`
+	MetaInt int    `mapstructure:"metaint" desc:"Stream bytes between metadata blocks"`
+	Name    string `mapstructure:"name" desc:"Station name sent as icy-name; empty for audiorelay and the hostname"`
+	Title   string `mapstructure:"title" desc:"Stream title until one is set with PUT /api/v1/metadata; empty for \"Now relaying: <hostname>\""`
+}
+
+// icyHostname returns the hostname shown in default titles
+func icyHostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "audiorelay"
+	}
+	return name
+}
+
+// StreamMetadata is the now-playing title of a stream. The version goes up
+// with every change, so ICY writers only resend the title when it changed.
+type StreamMetadata struct {
+	mu      sync.RWMutex
+	title   string
+	initial string
+	version int
+}
+
+// NewStreamMetadata creates metadata starting with title, or with the
+// default title if it is empty
+func NewStreamMetadata(title string) *StreamMetadata {
+	if title == "" {
+		title = "Now relaying: " + icyHostname()
+	}
+	return &StreamMetadata{title: title, initial: title, version: 1}
+}
+
+// Snapshot returns the title and its version
+func (m *StreamMetadata) Snapshot() (string, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.title, m.version
+}
+
+// SetTitle changes the title; an empty title restores the initial one
+func (m *StreamMetadata) SetTitle(title string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if title == "" {
+		title = m.initial
+	}
+	if title != m.title {
+		m.title = title
+		m.version++
+	}
+	return m.title
+}
+
+// icyBlock encodes a metadata block: a length byte, then StreamTitle
+// padded with zeros to a multiple of 16 bytes
+func icyBlock(title string) []byte {
+	// Players read the title up to the closing quote and semicolon
+	title = strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return ' '
+		}
+		return r
+	}, title)
+	text := "StreamTitle='" + title + "';"
+	if len(text) > icyMaxMetadata {
+		text = strings.ToValidUTF8(text[:icyMaxMetadata-2], "") + "';"
+	}
+
+	units := (len(text) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], text)
+	return block
+}
+
+// icyWriter inserts a metadata block after every interval bytes written to
+// a response, as requested by the Icy-MetaData header
+type icyWriter struct {
+	http.ResponseWriter
+	meta      *StreamMetadata
+	interval  int
+	untilMeta int // Stream bytes left before the next metadata block
+	sent      int // Version of the title last sent
+}
+
+// newICYWriter wraps w so every interval bytes are followed by metadata
+func newICYWriter(w http.ResponseWriter, meta *StreamMetadata, interval int) *icyWriter {
+	return &icyWriter{ResponseWriter: w, meta: meta, interval: interval, untilMeta: interval}
+}
+
+// Write sends p, with metadata blocks wherever the interval ends inside it
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n, err := iw.ResponseWriter.Write(p[:min(len(p), iw.untilMeta)])
+		written += n
+		iw.untilMeta -= n
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+		if iw.untilMeta == 0 {
+			if _, err := iw.ResponseWriter.Write(iw.block()); err != nil {
+				return written, err
+			}
+			iw.untilMeta = iw.interval
+		}
+	}
+	return written, nil
+}
+
+// block returns the title if it changed since the last block, otherwise
+// an empty block
+func (iw *icyWriter) block() []byte {
+	title, version := iw.meta.Snapshot()
+	if version == iw.sent {
+		return []byte{0}
+	}
+	iw.sent = version
+	return icyBlock(title)
+}
+
+// Flush pushes buffered data to the client
+func (iw *icyWriter) Flush() {
+	http.NewResponseController(iw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the response
+func (iw *icyWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// wrapICY sets the ICY headers and returns a writer that interleaves the
+// title, or returns w if the client didn't ask for metadata
+func (hs *HTTPServer) wrapICY(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	config := hs.config.Protocols.HTTP.ICY
+	if !config.Enabled || r.Header.Get("Icy-MetaData") != "1" {
+		return w
+	}
+	name := config.Name
+	if name == "" {
+		name = "audiorelay on " + icyHostname()
+	}
+	w.Header().Set("icy-metaint", strconv.Itoa(config.MetaInt))
+	w.Header().Set("icy-name", name)
+	return newICYWriter(w, hs.metadata, config.MetaInt)
+}
+
+// metadataUpdate sets the stream title
+type metadataUpdate struct {
+	Title *string `json:"title"`
+}
+
+// handleGetMetadata returns the stream title sent to ICY clients
+func (hs *HTTPServer) handleGetMetadata(w http.ResponseWriter, r *http.Request) {
+	title, _ := hs.metadata.Snapshot()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"title": title})
+}
+
+// handleSetMetadata changes the stream title, e.g. to the current track;
+// an empty title restores the configured one
+func (hs *HTTPServer) handleSetMetadata(w http.ResponseWriter, r *http.Request) {
+	var update metadataUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil || update.Title == nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	title := hs.metadata.SetTitle(strings.TrimSpace(*update.Title))
+	hs.events.Publish(EventMetadata, map[string]interface{}{"stream": hs.identity.name, "title": title})
+	hs.handleGetMetadata(w, r)
+}
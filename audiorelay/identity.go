@@ -0,0 +1,137 @@
+package audiorelay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// StreamIdentity identifies a logical stream across restarts. The ID never
+// changes; the format version goes up whenever the audio format changes so
+// players can tell a reconnect to the same stream from a changed one.
+type StreamIdentity struct {
+	ID            string `json:"id"`
+	FormatVersion int    `json:"format_version"`
+	Format        string `json:"format"`
+
+	name string
+	path string
+	mu   sync.RWMutex
+}
+
+// Snapshot returns the ID and format version
+func (si *StreamIdentity) Snapshot() (string, int) {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.ID, si.FormatVersion
+}
+
+// setHeaders adds the identity to an HTTP response
+func (si *StreamIdentity) setHeaders(w http.ResponseWriter) {
+	id, version := si.Snapshot()
+	w.Header().Set("X-Stream-Id", id)
+	w.Header().Set("X-Stream-Format-Version", strconv.Itoa(version))
+}
+
+// formatKey summarizes a stream format for change detection
+func formatKey(format wavFormat) string {
+	return fmt.Sprintf("%d Hz, %d ch, %s", format.SampleRate, format.Channels, format.describe())
+}
+
+// LoadStreamIdentity returns the persisted identity of the named stream,
+// creating it on first use and bumping the format version if the format
+// differs from the last run. An empty path keeps the identity in memory only.
+func LoadStreamIdentity(path, name string, format wavFormat) (*StreamIdentity, error) {
+	identities := make(map[string]*StreamIdentity)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read state file: %v", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &identities); err != nil {
+				return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+			}
+		}
+	}
+
+	si, ok := identities[name]
+	if !ok || si.ID == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, fmt.Errorf("failed to generate stream ID: %v", err)
+		}
+		si = &StreamIdentity{ID: hex.EncodeToString(id)}
+	}
+	si.name = name
+	si.path = path
+
+	if err := si.update(format); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// update records the current format, bumping the version when it changed
+func (si *StreamIdentity) update(format wavFormat) error {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	key := formatKey(format)
+	if si.Format == key && si.FormatVersion > 0 {
+		return nil
+	}
+	si.Format = key
+	si.FormatVersion++
+	return si.save()
+}
+
+// save writes the identity into the state file next to the other streams'
+// identities. The caller holds si.mu.
+func (si *StreamIdentity) save() error {
+	if si.path == "" {
+		return nil
+	}
+
+	identities := make(map[string]*StreamIdentity)
+	if data, err := os.ReadFile(si.path); err == nil {
+		json.Unmarshal(data, &identities)
+	}
+	identities[si.name] = si
+
+	data, err := json.MarshalIndent(identities, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(si.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create state directory: %v", err)
+		}
+	}
+
+	// Write atomically so a crash can't lose the ID
+	tmp := si.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp, si.path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+// handleStreamByID serves the stream only if the URL names this stream's ID,
+// so bookmarked URLs fail loudly instead of silently playing another stream
+func (hs *HTTPServer) handleStreamByID(w http.ResponseWriter, r *http.Request) {
+	if id, _ := hs.identity.Snapshot(); r.PathValue("id") != id {
+		http.Error(w, "unknown stream ID", http.StatusNotFound)
+		return
+	}
+	hs.handleWavStream(w, r)
+}
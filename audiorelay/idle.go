@@ -0,0 +1,136 @@
+package audiorelay
+
+import (
+	"log"
+	"time"
+)
+
+// Sleep asks the processing loop to stop the capture stream until Wake.
+// Listeners stay connected; they simply receive no audio meanwhile.
+func (ac *AudioCapture) Sleep() {
+	select {
+	case ac.sleepRequests <- struct{}{}:
+	default:
+	}
+}
+
+// Wake restarts a sleeping capture stream
+func (ac *AudioCapture) Wake() {
+	if !ac.asleep.Load() {
+		return
+	}
+	select {
+	case ac.wakeRequests <- struct{}{}:
+	default:
+	}
+}
+
+// IsAsleep reports whether capture is stopped by idle mode
+func (ac *AudioCapture) IsAsleep() bool {
+	return ac.asleep.Load()
+}
+
+// sleep stops the stream and blocks the processing loop until woken.
+// Device switches are still applied while asleep. It returns false if
+// capture was stopped in the meantime.
+func (ac *AudioCapture) sleep() bool {
+	ac.mu.Lock()
+	if ac.stream != nil {
+		ac.stream.Stop()
+	}
+	ac.mu.Unlock()
+	ac.asleep.Store(true)
+	log.Printf("💤 No listeners, capture paused")
+
+	for {
+		select {
+		case req := <-ac.switchRequests:
+			req.done <- ac.switchStream(req.device, false)
+			continue
+		case <-ac.wakeRequests:
+		}
+		break
+	}
+	ac.asleep.Store(false)
+	ac.markAlive()
+
+	if !ac.isRunning {
+		return false
+	}
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	// The read errors that follow either failure trigger the usual reconnect
+	if ac.stream == nil {
+		log.Printf("Failed to restart audio stream: a device switch left none open")
+	} else if err := ac.stream.Start(); err != nil {
+		log.Printf("Failed to restart audio stream: %v", err)
+	}
+	log.Printf("⏰ Listener connected, capture resumed")
+	return true
+}
+
+// idleAllowed reports whether anything besides connected clients needs the
+// audio: UDP targets, triggers and analyzers consume it continuously
+func (ar *AudioRelay) idleAllowed() bool {
+	c := ar.config
+	return !c.Protocols.UDP.Enabled && !c.Triggers.Level.Enabled && !c.Triggers.Snapshot.Enabled && !c.Analysis.Enabled
+}
+
+// clientCount returns the number of listeners of the main stream
+func (ar *AudioRelay) clientCount() int {
+	count := 0
+	if ar.tcpServer != nil {
+		count += ar.tcpServer.GetClientCount()
+	}
+	if ar.httpServer != nil {
+		count += ar.httpServer.GetClientCount()
+	}
+	return count
+}
+
+// listenerConnected runs when a listener of the main stream connects
+func (ar *AudioRelay) listenerConnected() {
+	ar.wakeCapture()
+	if ar.power != nil {
+		ar.power.Connected()
+	}
+}
+
+// wakeCapture restarts idle capture when a listener connects, before the
+// listener is primed from the history
+func (ar *AudioRelay) wakeCapture() {
+	if !ar.audioCapture.IsAsleep() {
+		return
+	}
+
+	// Audio from before the pause must not be replayed
+	if ar.httpServer != nil {
+		ar.httpServer.history.reset(ar.httpServer.wavFormat())
+	}
+	if ar.tcpServer != nil {
+		ar.tcpServer.history.reset(ar.tcpServer.streamFormat())
+	}
+	ar.audioCapture.Wake()
+}
+
+// idleMonitor pauses capture once no one has listened for the idle timeout
+func (ar *AudioRelay) idleMonitor() {
+	timeout := time.Duration(ar.config.Audio.Idle.TimeoutMinutes * float64(time.Minute))
+	ticker := time.NewTicker(min(timeout/4, 10*time.Second))
+	defer ticker.Stop()
+
+	lastListener := time.Now()
+	for ar.isRunning {
+		<-ticker.C
+		if ar.clientCount() > 0 {
+			// Catches a listener that connected just as capture went to sleep
+			ar.wakeCapture()
+			lastListener = time.Now()
+			continue
+		}
+		if ar.audioCapture.IsAsleep() || time.Since(lastListener) < timeout {
+			continue
+		}
+		ar.audioCapture.Sleep()
+	}
+}
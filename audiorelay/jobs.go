@@ -0,0 +1,305 @@
+package audiorelay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobState is the lifecycle state of a background job
+type JobState string
+
+const (
+	JobQueued   JobState = "queued"
+	JobRunning  JobState = "running"
+	JobDone     JobState = "done"
+	JobFailed   JobState = "failed"
+	JobCanceled JobState = "canceled"
+)
+
+// maxFinishedJobs is how many completed jobs are kept for the API
+const maxFinishedJobs = 100
+
+// Job is a unit of background work with progress reporting and cancellation.
+// Long-running work should check Context() and return its error when canceled.
+type Job struct {
+	mu     sync.RWMutex
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	id       string
+	kind     string
+	state    JobState
+	progress float64
+	err      string
+	result   map[string]interface{}
+	created  time.Time
+	started  time.Time
+	finished time.Time
+
+	run func(job *Job) (map[string]interface{}, error)
+}
+
+// JobInfo is a point-in-time view of a job for the API
+type JobInfo struct {
+	ID       string                 `json:"id"`
+	Kind     string                 `json:"kind"`
+	State    JobState               `json:"state"`
+	Progress float64                `json:"progress"`
+	Error    string                 `json:"error,omitempty"`
+	Result   map[string]interface{} `json:"result,omitempty"`
+	Created  time.Time              `json:"created"`
+	Started  *time.Time             `json:"started,omitempty"`
+	Finished *time.Time             `json:"finished,omitempty"`
+}
+
+// SetProgress updates the job's completion fraction (0-1)
+func (j *Job) SetProgress(progress float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = progress
+}
+
+// Context is canceled when the job is canceled through the API
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Info returns a snapshot of the job
+func (j *Job) Info() JobInfo {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	info := JobInfo{
+		ID:       j.id,
+		Kind:     j.kind,
+		State:    j.state,
+		Progress: j.progress,
+		Error:    j.err,
+		Result:   j.result,
+		Created:  j.created,
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		info.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		info.Finished = &finished
+	}
+	return info
+}
+
+// isFinished reports whether the job reached a terminal state
+func (j *Job) isFinished() bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.state == JobDone || j.state == JobFailed || j.state == JobCanceled
+}
+
+// JobQueue runs background jobs (clip processing, transcodes, analysis)
+// on a fixed number of workers
+type JobQueue struct {
+	jobs   map[string]*Job
+	jobsMu sync.RWMutex
+	nextID int
+
+	queue chan *Job
+}
+
+// NewJobQueue creates a job queue and starts its workers
+func NewJobQueue(workers int) *JobQueue {
+	q := &JobQueue{
+		jobs:  make(map[string]*Job),
+		queue: make(chan *Job, 100),
+	}
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// Submit queues a job and returns it immediately
+func (q *JobQueue) Submit(kind string, run func(job *Job) (map[string]interface{}, error)) (*Job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q.jobsMu.Lock()
+	q.nextID++
+	job := &Job{
+		ctx:     ctx,
+		cancel:  cancel,
+		id:      fmt.Sprintf("job-%d", q.nextID),
+		kind:    kind,
+		state:   JobQueued,
+		created: time.Now(),
+		run:     run,
+	}
+	q.jobs[job.id] = job
+	q.pruneLocked()
+	q.jobsMu.Unlock()
+
+	select {
+	case q.queue <- job:
+		return job, nil
+	default:
+		q.jobsMu.Lock()
+		delete(q.jobs, job.id)
+		q.jobsMu.Unlock()
+		return nil, fmt.Errorf("job queue is full")
+	}
+}
+
+// Get looks up a job by ID
+func (q *JobQueue) Get(id string) (*Job, bool) {
+	q.jobsMu.RLock()
+	defer q.jobsMu.RUnlock()
+	job, ok := q.jobs[id]
+	return job, ok
+}
+
+// List returns all known jobs, newest first
+func (q *JobQueue) List() []JobInfo {
+	q.jobsMu.RLock()
+	defer q.jobsMu.RUnlock()
+
+	infos := make([]JobInfo, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		infos = append(infos, job.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.After(infos[j].Created)
+	})
+	return infos
+}
+
+// Cancel stops a queued or running job
+func (q *JobQueue) Cancel(id string) error {
+	job, ok := q.Get(id)
+	if !ok {
+		return fmt.Errorf("job not found: %s", id)
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	switch job.state {
+	case JobQueued:
+		// The worker skips jobs that were canceled while waiting
+		job.state = JobCanceled
+		job.finished = time.Now()
+	case JobRunning:
+		// The worker records the final state once the job returns
+	default:
+		return fmt.Errorf("job already finished")
+	}
+	job.cancel()
+	return nil
+}
+
+// Stop cancels all outstanding jobs
+func (q *JobQueue) Stop() {
+	q.jobsMu.RLock()
+	defer q.jobsMu.RUnlock()
+	for _, job := range q.jobs {
+		job.cancel()
+	}
+}
+
+// pruneLocked drops the oldest finished jobs beyond the retention limit
+func (q *JobQueue) pruneLocked() {
+	var finished []*Job
+	for _, job := range q.jobs {
+		if job.isFinished() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].created.Before(finished[j].created)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(q.jobs, job.id)
+	}
+}
+
+// worker executes queued jobs one at a time
+func (q *JobQueue) worker() {
+	for job := range q.queue {
+		job.mu.Lock()
+		if job.state == JobCanceled {
+			job.mu.Unlock()
+			continue
+		}
+		job.state = JobRunning
+		job.started = time.Now()
+		job.mu.Unlock()
+
+		result, err := job.run(job)
+
+		job.mu.Lock()
+		job.finished = time.Now()
+		switch {
+		case errors.Is(err, context.Canceled) || job.ctx.Err() != nil:
+			job.state = JobCanceled
+			log.Printf("Job %s (%s) canceled", job.id, job.kind)
+		case err != nil:
+			job.state = JobFailed
+			job.err = err.Error()
+			log.Printf("Job %s (%s) failed: %v", job.id, job.kind, err)
+		default:
+			job.state = JobDone
+			job.progress = 1
+			job.result = result
+		}
+		job.mu.Unlock()
+		job.cancel()
+	}
+}
+
+// handleListJobs returns all jobs, optionally filtered by ?state= and ?kind=
+func (q *JobQueue) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	state := JobState(r.URL.Query().Get("state"))
+	kind := r.URL.Query().Get("kind")
+
+	jobs := make([]JobInfo, 0)
+	for _, info := range q.List() {
+		if (state == "" || info.State == state) && (kind == "" || info.Kind == kind) {
+			jobs = append(jobs, info)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": jobs})
+}
+
+// handleCancelJob cancels a queued or running job
+func (q *JobQueue) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := q.Cancel(id); err != nil {
+		status := http.StatusConflict
+		if _, ok := q.Get(id); !ok {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	job, _ := q.Get(id)
+	writeJSON(w, http.StatusOK, job.Info())
+}
+
+// handleGetJob returns the status of a single job
+func (q *JobQueue) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := q.Get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job.Info())
+}
@@ -0,0 +1,157 @@
+package audiorelay
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+// LatencyStage is the delay contributed by one part of the chain
+type LatencyStage struct {
+	Name string  `json:"name"`
+	Ms   float64 `json:"ms"`
+	Note string  `json:"note,omitempty"`
+}
+
+// EndpointLatency is the end-to-end delay until audio leaves an endpoint
+type EndpointLatency struct {
+	Stages  []LatencyStage `json:"stages"`
+	TotalMs float64        `json:"total_ms"`
+}
+
+// LatencyReport breaks down the delay between the microphone and each endpoint
+type LatencyReport struct {
+	Capture      []LatencyStage             `json:"capture"`
+	Processing   []LatencyStage             `json:"processing"`
+	CaptureMs    float64                    `json:"capture_ms"`
+	ProcessingMs float64                    `json:"processing_ms"`
+	Endpoints    map[string]EndpointLatency `json:"endpoints"`
+}
+
+// roundMs rounds a millisecond value to 0.01 ms
+func roundMs(ms float64) float64 {
+	return math.Round(ms*100) / 100
+}
+
+// durationMs converts a duration to milliseconds rounded to 0.01 ms
+func durationMs(d time.Duration) float64 {
+	return roundMs(d.Seconds() * 1000)
+}
+
+// framesMs converts a frame count to milliseconds at the capture rate
+func (ac *AudioCapture) framesMs(frames int) float64 {
+	return durationMs(time.Duration(float64(frames) / ac.SampleRate() * float64(time.Second)))
+}
+
+// BufferDuration returns the length of audio in one capture buffer
+func (ac *AudioCapture) BufferDuration() time.Duration {
+	frames := ac.actualBufferSize / ac.config.Audio.Channels
+	return time.Duration(float64(frames) / ac.SampleRate() * float64(time.Second))
+}
+
+// captureLatency lists the delays before audio reaches the processing chain
+func (ac *AudioCapture) captureLatency() []LatencyStage {
+	stages := []LatencyStage{}
+
+	ac.mu.RLock()
+	if ac.stream != nil {
+		if info := ac.stream.Info(); info != nil {
+			stages = append(stages, LatencyStage{
+				Name: "device",
+				Ms:   durationMs(info.InputLatency),
+				Note: "input latency reported by the audio driver",
+			})
+		}
+	}
+	ac.mu.RUnlock()
+
+	return append(stages, LatencyStage{
+		Name: "capture_buffer",
+		Ms:   durationMs(ac.BufferDuration()),
+		Note: "a buffer must fill before it is processed",
+	})
+}
+
+// processingLatency lists the algorithmic delay of each stage in the chain.
+// Custom stages report a delay by implementing Latency() int (in frames).
+func (ac *AudioCapture) processingLatency() []LatencyStage {
+	const iirNote = "IIR filter, no fixed delay"
+	notes := map[string]string{
+		"dc_block":   iirNote,
+		"highpass":   iirNote,
+		"lowpass":    iirNote,
+		"noise_gate": "no lookahead",
+		"limiter":    "lookahead delay",
+	}
+
+	ac.runtimeMu.RLock()
+	pipeline := ac.pipeline
+	ac.runtimeMu.RUnlock()
+
+	stages := []LatencyStage{}
+	for _, stage := range pipeline {
+		entry := LatencyStage{Name: stage.name, Note: notes[stage.name]}
+		if delayed, ok := stage.impl.(interface{ Latency() int }); ok {
+			entry.Ms = ac.framesMs(delayed.Latency())
+		}
+		stages = append(stages, entry)
+	}
+	return stages
+}
+
+// LatencyReport computes the delay introduced by the capture, processing
+// chain and server buffers for each enabled endpoint
+func (ar *AudioRelay) LatencyReport() LatencyReport {
+	report := LatencyReport{
+		Capture:    ar.audioCapture.captureLatency(),
+		Processing: ar.audioCapture.processingLatency(),
+		Endpoints:  make(map[string]EndpointLatency),
+	}
+	for _, stage := range report.Capture {
+		report.CaptureMs += stage.Ms
+	}
+	for _, stage := range report.Processing {
+		report.ProcessingMs += stage.Ms
+	}
+	report.CaptureMs = roundMs(report.CaptureMs)
+	report.ProcessingMs = roundMs(report.ProcessingMs)
+	base := roundMs(report.CaptureMs + report.ProcessingMs)
+
+	// New listeners start with the pre-buffer, so they stay that far behind live
+	if ar.tcpServer != nil {
+		prebuffer := ar.tcpServer.history.lengthMs()
+		report.Endpoints["tcp"] = EndpointLatency{
+			Stages: []LatencyStage{
+				{Name: "prebuffer", Ms: prebuffer, Note: "recent audio replayed to new clients"},
+				{Name: "send", Note: "written directly to client sockets"},
+			},
+			TotalMs: roundMs(base + prebuffer),
+		}
+	}
+
+	if ar.udpSender != nil {
+		report.Endpoints["udp"] = EndpointLatency{
+			Stages: []LatencyStage{
+				{Name: "send", Note: "pushed to fixed targets as captured"},
+			},
+			TotalMs: base,
+		}
+	}
+
+	if ar.httpServer != nil {
+		prebuffer := ar.httpServer.history.lengthMs()
+		report.Endpoints["http"] = EndpointLatency{
+			Stages: []LatencyStage{
+				{Name: "prebuffer", Ms: prebuffer, Note: "recent audio replayed to new listeners"},
+			},
+			TotalMs: roundMs(base + prebuffer),
+		}
+	}
+
+	return report
+}
+
+// handleLatency returns the latency breakdown of the running relay
+func (ar *AudioRelay) handleLatency(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ar.LatencyReport())
+}
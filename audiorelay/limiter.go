@@ -0,0 +1,132 @@
+package audiorelay
+
+import "math"
+
+// Limiter is a lookahead brickwall limiter operating on interleaved samples.
+// Gain is linked across channels so the stereo image is preserved, and the
+// output never exceeds the configured ceiling.
+type Limiter struct {
+	channels  int
+	ceiling   float64
+	lookahead int // lookahead length in frames
+
+	attackCoeff  float64
+	releaseCoeff float64
+	envelope     float64
+
+	// Delay line holding lookahead frames (interleaved)
+	delay    []float64
+	delayPos int
+
+	// Sliding window minimum of required gains (monotonic deque over a ring)
+	gains     []float64
+	gainIdx   []int
+	gainHead  int
+	gainCount int
+	frame     int
+}
+
+// NewLimiter creates a limiter for the given stream format.
+// ceiling is expressed in 16-bit sample units (e.g. 28000).
+func NewLimiter(sampleRate float64, channels int, ceiling float64, lookaheadMs, releaseMs float64) *Limiter {
+	lookahead := int(sampleRate * lookaheadMs / 1000)
+	if lookahead < 1 {
+		lookahead = 1
+	}
+
+	releaseFrames := sampleRate * releaseMs / 1000
+	if releaseFrames < 1 {
+		releaseFrames = 1
+	}
+
+	return &Limiter{
+		channels:  channels,
+		ceiling:   ceiling,
+		lookahead: lookahead,
+		// Reach the target gain well within the lookahead window
+		attackCoeff:  math.Exp(-5.0 / float64(lookahead)),
+		releaseCoeff: math.Exp(-1.0 / releaseFrames),
+		envelope:     1.0,
+		delay:        make([]float64, lookahead*channels),
+		gains:        make([]float64, lookahead+1),
+		gainIdx:      make([]int, lookahead+1),
+	}
+}
+
+// Latency returns the delay introduced by the lookahead in frames
+func (l *Limiter) Latency() int {
+	return l.lookahead
+}
+
+// Process limits the interleaved samples in place
+func (l *Limiter) Process(samples []float64) {
+	ch := l.channels
+	for i := 0; i+ch <= len(samples); i += ch {
+		// Required gain for the incoming frame
+		peak := 0.0
+		for c := 0; c < ch; c++ {
+			if v := math.Abs(samples[i+c]); v > peak {
+				peak = v
+			}
+		}
+		required := 1.0
+		if peak > l.ceiling {
+			required = l.ceiling / peak
+		}
+		target := l.pushGain(required)
+
+		// Smooth the gain envelope: fast attack inside the lookahead, slow release
+		if target < l.envelope {
+			l.envelope = target + (l.envelope-target)*l.attackCoeff
+		} else {
+			l.envelope = target + (l.envelope-target)*l.releaseCoeff
+		}
+
+		// Swap the incoming frame with the delayed one and apply gain
+		for c := 0; c < ch; c++ {
+			delayed := l.delay[l.delayPos+c]
+			l.delay[l.delayPos+c] = samples[i+c]
+
+			out := delayed * l.envelope
+			// Hard ceiling guarantees brickwall behaviour even if the envelope lags
+			if out > l.ceiling {
+				out = l.ceiling
+			} else if out < -l.ceiling {
+				out = -l.ceiling
+			}
+			samples[i+c] = out
+		}
+		l.delayPos += ch
+		if l.delayPos >= len(l.delay) {
+			l.delayPos = 0
+		}
+	}
+}
+
+// pushGain adds a required gain and returns the minimum over the lookahead window
+func (l *Limiter) pushGain(gain float64) float64 {
+	size := len(l.gains)
+
+	// Drop entries that left the window
+	for l.gainCount > 0 && l.gainIdx[l.gainHead] <= l.frame-size {
+		l.gainHead = (l.gainHead + 1) % size
+		l.gainCount--
+	}
+
+	// Drop entries that can no longer be the minimum
+	for l.gainCount > 0 {
+		tail := (l.gainHead + l.gainCount - 1) % size
+		if l.gains[tail] < gain {
+			break
+		}
+		l.gainCount--
+	}
+
+	tail := (l.gainHead + l.gainCount) % size
+	l.gains[tail] = gain
+	l.gainIdx[tail] = l.frame
+	l.gainCount++
+	l.frame++
+
+	return l.gains[l.gainHead]
+}
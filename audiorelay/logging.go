@@ -0,0 +1,16 @@
+package audiorelay
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// verboseLogging enables debug output; set from logging.verbose at startup
+var verboseLogging atomic.Bool
+
+// debugf logs a message only when verbose logging is enabled
+func debugf(format string, args ...interface{}) {
+	if verboseLogging.Load() {
+		log.Printf("[debug] "+format, args...)
+	}
+}
@@ -0,0 +1,52 @@
+package audiorelay
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// wasapiLoopbackSuffix marks the input devices PortAudio (19.7+) creates
+// on WASAPI for each render device; they capture what the device plays
+const wasapiLoopbackSuffix = " [Loopback]"
+
+// isLoopback reports whether a device captures system output
+func isLoopback(device *portaudio.DeviceInfo) bool {
+	return device.HostApi != nil && device.HostApi.Type == portaudio.WASAPI &&
+		strings.HasSuffix(device.Name, wasapiLoopbackSuffix)
+}
+
+// GetLoopbackDevices returns the output devices that can be captured via loopback
+func (dm *DeviceManager) GetLoopbackDevices() []*portaudio.DeviceInfo {
+	var devices []*portaudio.DeviceInfo
+	for _, device := range dm.devices {
+		if isLoopback(device) {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// GetLoopbackDevice finds the loopback source of an output device by name;
+// an empty name selects the default output device
+func (dm *DeviceManager) GetLoopbackDevice(outputName string) (*portaudio.DeviceInfo, error) {
+	if outputName == "" {
+		api, err := portaudio.HostApi(portaudio.WASAPI)
+		if err != nil || api == nil {
+			return nil, fmt.Errorf("WASAPI loopback capture is only available on Windows")
+		}
+		if api.DefaultOutputDevice == nil {
+			return nil, fmt.Errorf("no default output device")
+		}
+		outputName = api.DefaultOutputDevice.Name
+	}
+
+	name := strings.TrimSuffix(outputName, wasapiLoopbackSuffix) + wasapiLoopbackSuffix
+	for _, device := range dm.GetLoopbackDevices() {
+		if strings.EqualFold(device.Name, name) {
+			return device, nil
+		}
+	}
+	return nil, fmt.Errorf("no loopback source for %q (PortAudio 19.7+ with WASAPI is required)", outputName)
+}
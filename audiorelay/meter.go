@@ -0,0 +1,128 @@
+package audiorelay
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// meterInterval is the length of one level reading
+const meterInterval = 100 * time.Millisecond
+
+// minLevelDB floors readings so digital silence stays JSON-encodable
+const minLevelDB = -120.0
+
+// Levels is a reading of per-channel output levels in dBFS
+type Levels struct {
+	Time   time.Time `json:"time"`
+	PeakDB []float64 `json:"peak_db"`
+	RMSDB  []float64 `json:"rms_db"`
+}
+
+// LevelMeter accumulates per-channel peak and RMS levels over a fixed window
+type LevelMeter struct {
+	channels int
+	window   int // Frames per reading
+	frames   int
+
+	peak       []float64
+	sumSquares []float64
+
+	latest   Levels
+	latestMu sync.RWMutex
+}
+
+// NewLevelMeter creates a meter for the given stream format
+func NewLevelMeter(sampleRate float64, channels int) *LevelMeter {
+	return &LevelMeter{
+		channels:   channels,
+		window:     int(sampleRate * meterInterval.Seconds()),
+		peak:       make([]float64, channels),
+		sumSquares: make([]float64, channels),
+		latest: Levels{
+			PeakDB: silentLevels(channels),
+			RMSDB:  silentLevels(channels),
+		},
+	}
+}
+
+// Process measures interleaved samples and reports whether a new reading is ready
+func (m *LevelMeter) Process(samples []float64) bool {
+	ch := m.channels
+	ready := false
+	for i := 0; i+ch <= len(samples); i += ch {
+		for c := 0; c < ch; c++ {
+			s := samples[i+c]
+			m.peak[c] = math.Max(m.peak[c], math.Abs(s))
+			m.sumSquares[c] += s * s
+		}
+
+		m.frames++
+		if m.frames >= m.window {
+			m.publish()
+			ready = true
+		}
+	}
+	return ready
+}
+
+// publish turns the accumulated window into a reading and starts a new window
+func (m *LevelMeter) publish() {
+	levels := Levels{
+		Time:   time.Now(),
+		PeakDB: make([]float64, m.channels),
+		RMSDB:  make([]float64, m.channels),
+	}
+	for c := 0; c < m.channels; c++ {
+		levels.PeakDB[c] = levelDB(m.peak[c])
+		levels.RMSDB[c] = levelDB(math.Sqrt(m.sumSquares[c] / float64(m.frames)))
+		m.peak[c], m.sumSquares[c] = 0, 0
+	}
+	m.frames = 0
+
+	m.latestMu.Lock()
+	m.latest = levels
+	m.latestMu.Unlock()
+}
+
+// Levels returns the most recent reading
+func (m *LevelMeter) Levels() Levels {
+	m.latestMu.RLock()
+	defer m.latestMu.RUnlock()
+	return m.latest
+}
+
+// eventData converts a reading to an event payload
+func (l Levels) eventData() map[string]interface{} {
+	return map[string]interface{}{
+		"peak_db": l.PeakDB,
+		"rms_db":  l.RMSDB,
+	}
+}
+
+// levelDB converts a level in 16-bit full-scale units to dBFS
+func levelDB(level float64) float64 {
+	if level <= 0 {
+		return minLevelDB
+	}
+	return math.Max(minLevelDB, math.Round(20*math.Log10(level/32768)*10)/10)
+}
+
+// silentLevels returns a reading of digital silence for each channel
+func silentLevels(channels int) []float64 {
+	levels := make([]float64, channels)
+	for c := range levels {
+		levels[c] = minLevelDB
+	}
+	return levels
+}
+
+// handleLevels returns the latest output level reading
+func (hs *HTTPServer) handleLevels(w http.ResponseWriter, r *http.Request) {
+	if hs.audioCapture == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "audio capture not running")
+		return
+	}
+	writeJSON(w, http.StatusOK, hs.audioCapture.Levels())
+}
@@ -0,0 +1,99 @@
+package audiorelay
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// latencyQuantiles are the quantiles /metrics reports of delivery latency
+var latencyQuantiles = []struct {
+	label string
+	value func(LatencyPercentiles) float64
+}{
+	{"0.5", func(p LatencyPercentiles) float64 { return p.P50Ms }},
+	{"0.95", func(p LatencyPercentiles) float64 { return p.P95Ms }},
+	{"0.99", func(p LatencyPercentiles) float64 { return p.P99Ms }},
+}
+
+// handleMetrics serves the connected clients and the delivery latency per
+// protocol and per client in the Prometheus text format
+func (hs *HTTPServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if hs.registry == nil {
+		return
+	}
+	clients := hs.registry.List()
+
+	counts := make(map[string]int)
+	for _, client := range clients {
+		counts[client.Protocol]++
+	}
+	fmt.Fprintln(w, "# HELP audiorelay_clients Connected listeners.")
+	fmt.Fprintln(w, "# TYPE audiorelay_clients gauge")
+	for _, protocol := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(w, "audiorelay_clients{%s} %d\n", promLabel("protocol", protocol), counts[protocol])
+	}
+
+	latency, sums := hs.registry.deliveryLatency()
+	fmt.Fprintln(w, "# HELP audiorelay_delivery_latency_seconds Time from capture until the audio is written to a listener, over recent chunks.")
+	fmt.Fprintln(w, "# TYPE audiorelay_delivery_latency_seconds summary")
+	for _, protocol := range slices.Sorted(maps.Keys(latency)) {
+		p := latency[protocol]
+		labels := promLabel("protocol", protocol)
+		writeLatencySummary(w, "audiorelay_delivery_latency_seconds", labels, p)
+		fmt.Fprintf(w, "audiorelay_delivery_latency_seconds_sum{%s} %s\n", labels, formatFloat(sums[protocol].Seconds()))
+		fmt.Fprintf(w, "audiorelay_delivery_latency_seconds_count{%s} %d\n", labels, p.Count)
+	}
+
+	fmt.Fprintln(w, "# HELP audiorelay_client_delivery_latency_seconds Time from capture until the audio is written to the listener, over its recent chunks.")
+	fmt.Fprintln(w, "# TYPE audiorelay_client_delivery_latency_seconds gauge")
+	for _, client := range clients {
+		if client.Latency.Count == 0 {
+			continue
+		}
+		labels := clientLabels(client) + "," + promLabel("name", client.Name)
+		writeLatencySummary(w, "audiorelay_client_delivery_latency_seconds", labels, client.Latency)
+	}
+
+	fmt.Fprintln(w, "# HELP audiorelay_client_sent_bytes_total Bytes written to the listener.")
+	fmt.Fprintln(w, "# TYPE audiorelay_client_sent_bytes_total counter")
+	for _, client := range clients {
+		fmt.Fprintf(w, "audiorelay_client_sent_bytes_total{%s} %d\n", clientLabels(client), client.BytesSent)
+	}
+	fmt.Fprintln(w, "# HELP audiorelay_client_dropped_frames_total Audio frames the listener missed by falling behind.")
+	fmt.Fprintln(w, "# TYPE audiorelay_client_dropped_frames_total counter")
+	for _, client := range clients {
+		fmt.Fprintf(w, "audiorelay_client_dropped_frames_total{%s} %d\n", clientLabels(client), client.DroppedFrames)
+	}
+}
+
+// writeLatencySummary writes the latency quantiles of p, in seconds
+func writeLatencySummary(w io.Writer, name, labels string, p LatencyPercentiles) {
+	for _, q := range latencyQuantiles {
+		fmt.Fprintf(w, "%s{%s,%s} %s\n", name, labels, promLabel("quantile", q.label), formatFloat(q.value(p)/1000))
+	}
+}
+
+// formatFloat formats a sample value, dropping the noise of converting
+// milliseconds to seconds
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', 9, 64)
+}
+
+// labelEscaper escapes label values for the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabel formats a label pair
+func promLabel(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// clientLabels identifies a client's samples
+func clientLabels(client ClientInfo) string {
+	return promLabel("client", client.ID) + "," + promLabel("protocol", client.Protocol)
+}
@@ -0,0 +1,77 @@
+package audiorelay
+
+import "math"
+
+// gateAttackMs is how quickly the gate opens; short enough to keep transients
+// but long enough to avoid clicks
+const gateAttackMs = 1.0
+
+// NoiseGate mutes low-level signal such as microphone hiss between phrases.
+// It is independent of silence detection: the gate shapes the audio itself,
+// while silence detection only decides whether frames are sent.
+type NoiseGate struct {
+	channels  int
+	threshold float64
+
+	attackStep  float64 // gain increase per frame while opening
+	releaseStep float64 // gain decrease per frame while closing
+	holdFrames  int
+
+	gain        float64
+	holdCounter int
+	closed      bool // gate stayed fully closed for the last processed buffer
+}
+
+// NewNoiseGate creates a noise gate for the given stream format.
+// threshold is expressed in 16-bit sample units.
+func NewNoiseGate(sampleRate float64, channels int, threshold float64, holdMs, releaseMs float64) *NoiseGate {
+	attackFrames := math.Max(1, sampleRate*gateAttackMs/1000)
+	releaseFrames := math.Max(1, sampleRate*releaseMs/1000)
+
+	return &NoiseGate{
+		channels:    channels,
+		threshold:   threshold,
+		attackStep:  1 / attackFrames,
+		releaseStep: 1 / releaseFrames,
+		holdFrames:  int(sampleRate * holdMs / 1000),
+	}
+}
+
+// Process gates the interleaved samples in place
+func (g *NoiseGate) Process(samples []float64) {
+	ch := g.channels
+	closed := true
+
+	for i := 0; i+ch <= len(samples); i += ch {
+		peak := 0.0
+		for c := 0; c < ch; c++ {
+			if v := math.Abs(samples[i+c]); v > peak {
+				peak = v
+			}
+		}
+
+		if peak >= g.threshold {
+			// Signal present: open and restart the hold timer
+			g.holdCounter = g.holdFrames
+			g.gain = math.Min(1, g.gain+g.attackStep)
+		} else if g.holdCounter > 0 {
+			g.holdCounter--
+		} else {
+			g.gain = math.Max(0, g.gain-g.releaseStep)
+		}
+
+		if g.gain > 0 {
+			closed = false
+		}
+		for c := 0; c < ch; c++ {
+			samples[i+c] *= g.gain
+		}
+	}
+
+	g.closed = closed
+}
+
+// IsClosed reports whether the gate muted the whole last buffer
+func (g *NoiseGate) IsClosed() bool {
+	return g.closed
+}
@@ -0,0 +1,392 @@
+package audiorelay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// webhookTimeout bounds each webhook request
+	webhookTimeout = 10 * time.Second
+	// webhookShutdownWait is how long Stop waits for queued webhooks, such
+	// as the shutdown notice
+	webhookShutdownWait = 5 * time.Second
+	// webhookQueue is how many events may wait for a slow webhook before
+	// newer ones are dropped
+	webhookQueue = 32
+)
+
+// defaultWebhookEvents are sent to webhooks that don't list their events
+var defaultWebhookEvents = []string{
+	EventClientConnected, EventClientDisconnected,
+	EventCaptureLost, EventCaptureRecovered,
+	EventSilenceStarted, EventSilenceEnded,
+	EventShutdown,
+}
+
+// NotificationsConfig pushes relay events to webhooks, e.g. Home Assistant
+// or ntfy
+type NotificationsConfig struct {
+	SilenceSeconds float64         `mapstructure:"silence_seconds" desc:"Silence on a stream this long publishes silence_started, and silence_ended once audio returns; 0 disables"`
+	Webhooks       []WebhookConfig `mapstructure:"webhooks" desc:"Webhooks called on relay events"`
+}
+
+// WebhookConfig is one webhook. URL and body are Go templates of the event
+// (see webhookEvent).
+type WebhookConfig struct {
+	URL     string            `mapstructure:"url" desc:"URL template, e.g. https://ntfy.sh/relay or http://ha:8123/api/webhook/relay-{{.Type}}"`
+	Method  string            `mapstructure:"method" desc:"HTTP method, POST by default"`
+	Events  []string          `mapstructure:"events" desc:"Event types sent; client, capture, silence and shutdown events when empty"`
+	Body    string            `mapstructure:"body" desc:"Body template, e.g. {{.Message}}; the event as JSON when empty"`
+	Headers map[string]string `mapstructure:"headers" secret:"true" desc:"Extra request headers, e.g. Title or Authorization"`
+}
+
+// webhookEvent is what the URL and body templates of a webhook see
+type webhookEvent struct {
+	Type    string                 `json:"type"`
+	Time    time.Time              `json:"time"`
+	Host    string                 `json:"host"`
+	Message string                 `json:"message"` // One line describing the event
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// validate checks the silence delay and parses every webhook's templates
+func (n NotificationsConfig) validate() error {
+	if n.SilenceSeconds < 0 {
+		return fmt.Errorf("notifications silence_seconds cannot be negative")
+	}
+	for i, hook := range n.Webhooks {
+		if _, err := parseWebhook(hook); err != nil {
+			return fmt.Errorf("notifications webhook %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// webhook is a configured webhook with its templates parsed
+type webhook struct {
+	config WebhookConfig
+	url    *template.Template
+	body   *template.Template // nil sends the event as JSON
+	events map[string]bool
+	queue  chan webhookEvent
+}
+
+// parseWebhook parses a webhook's templates and checks its method and events
+func parseWebhook(config WebhookConfig) (*webhook, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+	config.Method = strings.ToUpper(config.Method)
+	if _, err := http.NewRequest(config.Method, "http://localhost/", nil); err != nil {
+		return nil, fmt.Errorf("invalid method %q", config.Method)
+	}
+
+	funcs := template.FuncMap{"json": webhookJSON}
+	w := &webhook{config: config, events: make(map[string]bool)}
+	var err error
+	if w.url, err = template.New("url").Funcs(funcs).Parse(config.URL); err != nil {
+		return nil, fmt.Errorf("invalid url template: %v", err)
+	}
+	if config.Body != "" {
+		if w.body, err = template.New("body").Funcs(funcs).Parse(config.Body); err != nil {
+			return nil, fmt.Errorf("invalid body template: %v", err)
+		}
+	}
+
+	events := config.Events
+	if len(events) == 0 {
+		events = defaultWebhookEvents
+	}
+	for _, event := range events {
+		known := false
+		for _, eventType := range eventTypes {
+			known = known || event == eventType
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+		if event == EventLevels {
+			return nil, fmt.Errorf("levels are published several times a second, too often for a webhook")
+		}
+		w.events[event] = true
+	}
+	return w, nil
+}
+
+// webhookJSON is the json function of webhook templates
+func webhookJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// Notifier sends bus events to the configured webhooks. Each webhook has its
+// own queue, so a slow one doesn't hold up the others.
+type Notifier struct {
+	webhooks []*webhook
+	events   *EventBus
+	host     string
+	client   *http.Client
+
+	stop    chan struct{}
+	done    chan struct{}
+	senders sync.WaitGroup
+}
+
+// NewNotifier creates a notifier for the webhooks of config, which has been
+// validated
+func NewNotifier(config NotificationsConfig, events *EventBus) *Notifier {
+	host, _ := os.Hostname()
+	n := &Notifier{
+		events: events,
+		host:   host,
+		client: &http.Client{Timeout: webhookTimeout},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, hook := range config.Webhooks {
+		w, err := parseWebhook(hook)
+		if err != nil {
+			continue
+		}
+		w.queue = make(chan webhookEvent, webhookQueue)
+		n.webhooks = append(n.webhooks, w)
+	}
+	return n
+}
+
+// Start sends events until Stop
+func (n *Notifier) Start() {
+	ch := n.events.Subscribe()
+	for _, w := range n.webhooks {
+		n.senders.Add(1)
+		go n.send(w)
+	}
+	go n.run(ch)
+}
+
+// Stop sends the events already published, such as shutdown, giving the
+// webhooks up to webhookShutdownWait to take them
+func (n *Notifier) Stop() {
+	close(n.stop)
+	<-n.done
+
+	sent := make(chan struct{})
+	go func() {
+		n.senders.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(webhookShutdownWait):
+		log.Printf("⚠️ Webhooks still pending at shutdown, giving up")
+	}
+}
+
+// run queues each event for the webhooks that want it
+func (n *Notifier) run(ch chan Event) {
+	defer close(n.done)
+	defer n.events.Unsubscribe(ch)
+	for {
+		select {
+		case event := <-ch:
+			n.dispatch(event)
+		case <-n.stop:
+			// Publish is synchronous, so everything published before Stop
+			// is already waiting
+			for {
+				select {
+				case event := <-ch:
+					n.dispatch(event)
+				default:
+					for _, w := range n.webhooks {
+						close(w.queue)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// dispatch queues an event for the webhooks that want it
+func (n *Notifier) dispatch(event Event) {
+	var payload *webhookEvent
+	for _, w := range n.webhooks {
+		if !w.events[event.Type] {
+			continue
+		}
+		if payload == nil {
+			payload = &webhookEvent{
+				Type:    event.Type,
+				Time:    event.Time,
+				Host:    n.host,
+				Message: describeEvent(event),
+				Data:    event.Data,
+			}
+		}
+		select {
+		case w.queue <- *payload:
+		default:
+			log.Printf("⚠️ Webhook %s is falling behind, dropped %s", w.config.URL, event.Type)
+		}
+	}
+}
+
+// send calls a webhook for each event queued for it
+func (n *Notifier) send(w *webhook) {
+	defer n.senders.Done()
+	for event := range w.queue {
+		if err := n.call(w, event); err != nil {
+			log.Printf("⚠️ Webhook %s for %s failed: %v", w.config.URL, event.Type, err)
+		}
+	}
+}
+
+// call renders a webhook's templates for event and makes the request
+func (n *Notifier) call(w *webhook, event webhookEvent) error {
+	var url bytes.Buffer
+	if err := w.url.Execute(&url, event); err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	contentType := "text/plain; charset=utf-8"
+	if w.body != nil {
+		if err := w.body.Execute(&body, event); err != nil {
+			return err
+		}
+	} else {
+		if err := json.NewEncoder(&body).Encode(event); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(w.config.Method, strings.TrimSpace(url.String()), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "audiorelay")
+	for name, value := range w.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+// describeEvent returns a line describing an event for people, e.g. as a
+// push notification's text
+func describeEvent(event Event) string {
+	data := event.Data
+	stream := ""
+	if name, ok := data["stream"].(string); ok && name != "main" {
+		stream = fmt.Sprintf(" (stream %s)", name)
+	}
+	switch event.Type {
+	case EventClientConnected:
+		return fmt.Sprintf("%s client connected from %v", data["protocol"], data["remote_addr"])
+	case EventClientDisconnected:
+		return fmt.Sprintf("%s client from %v disconnected after %vs", data["protocol"], data["remote_addr"], data["connected_seconds"])
+	case EventCaptureLost:
+		if reconnecting, _ := data["reconnecting"].(bool); reconnecting {
+			return fmt.Sprintf("Capture device lost: %v%s, waiting for it to return", data["device"], stream)
+		}
+		return fmt.Sprintf("Capture device failed: %v%s, capture stopped", data["device"], stream)
+	case EventCaptureRecovered:
+		return fmt.Sprintf("Capture resumed on %v%s after %vs", data["device"], stream, data["down_seconds"])
+	case EventSilenceStarted:
+		return fmt.Sprintf("Silent for %vs%s", data["silent_seconds"], stream)
+	case EventSilenceEnded:
+		return fmt.Sprintf("Audio returned after %vs of silence%s", data["silent_seconds"], stream)
+	case EventShutdown:
+		return "Audio relay shutting down"
+	case EventCaptureStalled:
+		return fmt.Sprintf("Capture stalled on %v for %vs, restarted", data["device"], data["stalled_seconds"])
+	}
+	return event.Type
+}
+
+// watchCapture publishes the device losses, recoveries and long silences of
+// a stream's capture
+func (ar *AudioRelay) watchCapture(stream string, capture *AudioCapture) {
+	capture.SetCaptureCallbacks(func(device string, reconnecting bool) {
+		ar.events.Publish(EventCaptureLost, map[string]interface{}{
+			"stream":       stream,
+			"device":       device,
+			"reconnecting": reconnecting,
+		})
+	}, func(device string, down time.Duration) {
+		ar.events.Publish(EventCaptureRecovered, map[string]interface{}{
+			"stream":       stream,
+			"device":       device,
+			"down_seconds": math.Round(down.Seconds()*10) / 10,
+		})
+	})
+
+	after := time.Duration(ar.config.Notifications.SilenceSeconds * float64(time.Second))
+	if after <= 0 {
+		return
+	}
+	capture.SetSilenceCallback(after, func(silent bool, duration time.Duration) {
+		eventType := EventSilenceEnded
+		if silent {
+			eventType = EventSilenceStarted
+		}
+		ar.events.Publish(eventType, map[string]interface{}{
+			"stream":         stream,
+			"silent_seconds": math.Round(duration.Seconds()),
+		})
+	})
+}
+
+// SetSilenceCallback sets the function called once the input has been
+// silent for after, and again when audio returns
+func (ac *AudioCapture) SetSilenceCallback(after time.Duration, callback func(silent bool, duration time.Duration)) {
+	ac.silenceAfter = after
+	ac.silenceCallback = callback
+}
+
+// watchSilence follows how long the input has been silent, called with each
+// captured buffer. Silence is counted in captured audio, so time spent
+// asleep or waiting for a lost device doesn't add to it.
+func (ac *AudioCapture) watchSilence(silent bool) {
+	if ac.silenceCallback == nil {
+		return
+	}
+	if !silent {
+		if ac.silenceReported {
+			ac.silenceCallback(false, ac.silentFor)
+		}
+		ac.silentFor = 0
+		ac.silenceReported = false
+		return
+	}
+
+	frames := len(ac.work) / ac.config.Audio.Channels
+	ac.silentFor += time.Duration(float64(frames) / ac.SampleRate() * float64(time.Second))
+	if !ac.silenceReported && ac.silentFor >= ac.silenceAfter {
+		ac.silenceReported = true
+		ac.silenceCallback(true, ac.silentFor)
+	}
+}
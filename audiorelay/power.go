@@ -0,0 +1,192 @@
+package audiorelay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// powerHookTimeout bounds each power command and webhook
+const powerHookTimeout = 10 * time.Second
+
+// PowerConfig switches attached gear, such as an amplifier on a smart plug,
+// on with the first listener of a zone and off after the last one leaves
+type PowerConfig struct {
+	OnCommand       string  `mapstructure:"on_command" desc:"Shell command run when the first listener connects"`
+	OffCommand      string  `mapstructure:"off_command" desc:"Shell command run once no one has listened for off_delay_seconds"`
+	OnURL           string  `mapstructure:"on_url" desc:"URL POSTed when the first listener connects, e.g. a smart plug webhook"`
+	OffURL          string  `mapstructure:"off_url" desc:"URL POSTed once no one has listened for off_delay_seconds"`
+	OffDelaySeconds float64 `mapstructure:"off_delay_seconds" desc:"Time without listeners before powering off, so reconnects don't cycle the gear"`
+}
+
+// Enabled reports whether any power hook is configured
+func (p PowerConfig) Enabled() bool {
+	return p.OnCommand != "" || p.OffCommand != "" || p.OnURL != "" || p.OffURL != ""
+}
+
+// PowerHooks runs a zone's power hooks. Powering on happens as soon as a
+// listener connects; powering off is checked once a second so a short gap
+// between listeners doesn't switch the gear.
+type PowerHooks struct {
+	zone    string
+	config  PowerConfig
+	events  *EventBus
+	count   func() int // Listeners currently connected to the zone
+	powered bool       // Only touched by run
+
+	connects chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewPowerHooks creates the power hooks of a zone
+func NewPowerHooks(zone string, config PowerConfig, events *EventBus, count func() int) *PowerHooks {
+	return &PowerHooks{
+		zone:     zone,
+		config:   config,
+		events:   events,
+		count:    count,
+		connects: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start watches the zone's listeners until Stop
+func (ph *PowerHooks) Start() {
+	go ph.run()
+}
+
+// Stop powers the zone off if it is on and ends the hooks
+func (ph *PowerHooks) Stop() {
+	close(ph.stop)
+	<-ph.done
+}
+
+// Connected notes that a listener connected, powering on without waiting
+func (ph *PowerHooks) Connected() {
+	select {
+	case ph.connects <- struct{}{}:
+	default:
+	}
+}
+
+// run switches power as listeners come and go
+func (ph *PowerHooks) run() {
+	defer close(ph.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	offDelay := time.Duration(ph.config.OffDelaySeconds * float64(time.Second))
+	var idleSince time.Time
+	for {
+		select {
+		case <-ph.stop:
+			if ph.powered {
+				ph.switchPower(false)
+			}
+			return
+		case <-ph.connects:
+			idleSince = time.Time{}
+			if !ph.powered {
+				ph.switchPower(true)
+			}
+		case <-ticker.C:
+			if !ph.powered || ph.count() > 0 {
+				idleSince = time.Time{}
+				continue
+			}
+			// The first empty tick only starts the delay, so a listener
+			// still registering after Connected isn't cut off
+			if idleSince.IsZero() {
+				idleSince = time.Now()
+				continue
+			}
+			if time.Since(idleSince) >= offDelay {
+				ph.switchPower(false)
+			}
+		}
+	}
+}
+
+// switchPower runs the on or off hooks. Failures are logged; the zone is
+// considered switched anyway so a broken hook isn't retried on every tick.
+func (ph *PowerHooks) switchPower(on bool) {
+	state, command, url := "off", ph.config.OffCommand, ph.config.OffURL
+	if on {
+		state, command, url = "on", ph.config.OnCommand, ph.config.OnURL
+	}
+	ph.powered = on
+	log.Printf("🔌 Zone %s: power %s", ph.zone, state)
+
+	data := map[string]interface{}{"zone": ph.zone, "state": state}
+	if command != "" {
+		if err := runPowerCommand(command, ph.zone, state); err != nil {
+			log.Printf("Power %s command for zone %s failed: %v", state, ph.zone, err)
+			data["command_error"] = err.Error()
+		}
+	}
+	if url != "" {
+		if err := postPowerWebhook(url, ph.zone, state); err != nil {
+			log.Printf("Power %s webhook for zone %s failed: %v", state, ph.zone, err)
+			data["webhook_error"] = err.Error()
+		}
+	}
+	ph.events.Publish(EventPower, data)
+}
+
+// runPowerCommand runs a hook command through the system shell, with the
+// zone and state in AUDIORELAY_ZONE and AUDIORELAY_POWER
+func runPowerCommand(command, zone, state string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), powerHookTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(cmd.Environ(), "AUDIORELAY_ZONE="+zone, "AUDIORELAY_POWER="+state)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// postPowerWebhook POSTs the zone and state as JSON
+func postPowerWebhook(url, zone, state string) error {
+	body, _ := json.Marshal(map[string]string{"zone": zone, "state": state})
+	client := &http.Client{Timeout: powerHookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+// validate checks the hook URLs and delay
+func (p PowerConfig) validate() error {
+	if p.OffDelaySeconds < 0 {
+		return fmt.Errorf("power off_delay_seconds cannot be negative")
+	}
+	for _, url := range []string{p.OnURL, p.OffURL} {
+		if url == "" {
+			continue
+		}
+		if _, err := http.NewRequest(http.MethodPost, url, nil); err != nil {
+			return fmt.Errorf("invalid power webhook URL %q: %v", url, err)
+		}
+	}
+	return nil
+}
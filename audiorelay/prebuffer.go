@@ -0,0 +1,118 @@
+package audiorelay
+
+import "sync"
+
+// maxPrebufferMs bounds the per-protocol history, which is held in memory
+const maxPrebufferMs = 30000
+
+// prebuffer keeps the most recent audio so new listeners can be primed
+// instead of starting from silence. Old data is trimmed at frame boundaries.
+type prebuffer struct {
+	mu     sync.RWMutex
+	chunks [][]byte
+	size   int
+	limit  int     // Bytes kept, 0 disables the buffer
+	ms     float64 // Configured length, which the limit is derived from
+}
+
+// newPrebuffer creates a history buffer holding ms of audio in format
+func newPrebuffer(format wavFormat, ms float64) *prebuffer {
+	return &prebuffer{limit: prebufferBytes(format, ms), ms: ms}
+}
+
+// prebufferBytes returns the size of ms of audio in format, in whole frames
+func prebufferBytes(format wavFormat, ms float64) int {
+	frames := int(ms / 1000 * float64(format.SampleRate))
+	return frames * format.blockAlign()
+}
+
+// add appends a chunk and drops the oldest audio beyond the limit
+func (p *prebuffer) add(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.limit == 0 {
+		return
+	}
+	p.chunks = append(p.chunks, data)
+	p.size += len(data)
+	p.trim()
+}
+
+// trim drops the oldest audio beyond the limit; the caller holds mu
+func (p *prebuffer) trim() {
+	for p.size > p.limit {
+		excess := p.size - p.limit
+		if excess >= len(p.chunks[0]) {
+			p.size -= len(p.chunks[0])
+			p.chunks = p.chunks[1:]
+			continue
+		}
+		// The limit is a whole number of frames, so this cut is frame-aligned
+		p.chunks[0] = p.chunks[0][excess:]
+		p.size -= excess
+	}
+}
+
+// replay writes the buffered audio, oldest first, stopping at the first error
+func (p *prebuffer) replay(write func([]byte) error) error {
+	return p.replayLast(-1, write)
+}
+
+// replayLast writes at most the newest n bytes of buffered audio, or all
+// of it if n is negative. n must be a whole number of frames.
+func (p *prebuffer) replayLast(n int, write func([]byte) error) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	skip := 0
+	if n >= 0 && p.size > n {
+		skip = p.size - n
+	}
+	for _, data := range p.chunks {
+		if skip >= len(data) {
+			skip -= len(data)
+			continue
+		}
+		if err := write(data[skip:]); err != nil {
+			return err
+		}
+		skip = 0
+	}
+	return nil
+}
+
+// reset drops the buffered audio and resizes the buffer for a new format,
+// keeping its length
+func (p *prebuffer) reset(format wavFormat) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.chunks = nil
+	p.size = 0
+	p.limit = prebufferBytes(format, p.ms)
+}
+
+// resize changes how much audio is kept, keeping the most recent audio
+func (p *prebuffer) resize(format wavFormat, ms float64) {
+	limit := prebufferBytes(format, ms)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = limit
+	p.ms = ms
+	p.trim()
+}
+
+// lengthMs returns how many milliseconds of audio the buffer holds when full
+func (p *prebuffer) lengthMs() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ms
+}
+
+// stats returns the buffered and maximum number of bytes
+func (p *prebuffer) stats() (size, limit int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.size, p.limit
+}
@@ -0,0 +1,251 @@
+package audiorelay
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Processor is one stage of the audio processing chain. Samples are
+// interleaved float64 in 16-bit full-scale units rather than int16: capture
+// may be int16, int24 or float32, and in floating point every format shares
+// one chain, a boost followed by the limiter doesn't clip in between and
+// stages don't each round; the output is quantized once when encoded.
+//
+// A stage may process in place or return a different slice; the next stage
+// receives whatever it returns. A stage that changes the number of
+// channels reports the count it returns by implementing OutputChannels,
+// so the stages after it are built for that layout. The chain must end in
+// the stream's layout.
+type Processor interface {
+	Process(samples []float64) []float64
+}
+
+// ProcessorFunc adapts an ordinary function to a Processor
+type ProcessorFunc func(samples []float64) []float64
+
+// Process calls f(samples)
+func (f ProcessorFunc) Process(samples []float64) []float64 {
+	return f(samples)
+}
+
+// ProcessorFactory builds a stage for the given channel count at its position
+// in the chain. Returning a nil Processor leaves the stage out.
+type ProcessorFactory func(config *Config, channels int) (Processor, error)
+
+// defaultProcessingChain is the built-in stage order
+var defaultProcessingChain = []string{
+	"channels",   // Runtime mute/polarity/swap, so every later stage sees the routed signal
+	"dc_block",   // Strip DC offset before anything measures levels
+	"highpass",   // Remove rumble so it neither holds the gate open nor eats headroom
+	"noise_gate", // Works on the input level, before any gain is applied
+	"volume",     // Gentle volume adjustment to preserve dynamics
+	"stereo",     // Mid/side conversion and stereo width on the first channel pair
+	"downmix",    // Everything after this runs on the output channel layout
+	"lowpass",    // Band-limit the output for small drivers
+	"limiter",    // Keeps peaks below the clip threshold without distortion
+}
+
+// builtinProcessors lists the stage names AudioCapture knows how to build
+var builtinProcessors = map[string]bool{
+	"channels": true, "dc_block": true, "highpass": true, "noise_gate": true, "volume": true,
+	"stereo": true, "downmix": true, "lowpass": true, "limiter": true,
+}
+
+var (
+	customProcessors   = make(map[string]ProcessorFactory)
+	customProcessorsMu sync.RWMutex
+)
+
+// RegisterProcessor makes a custom stage available to processing.chain
+// under name. Register before loading the configuration.
+func RegisterProcessor(name string, factory ProcessorFactory) error {
+	if builtinProcessors[name] {
+		return fmt.Errorf("processor %q is built in", name)
+	}
+
+	customProcessorsMu.Lock()
+	defer customProcessorsMu.Unlock()
+	if _, exists := customProcessors[name]; exists {
+		return fmt.Errorf("processor %q is already registered", name)
+	}
+	customProcessors[name] = factory
+	return nil
+}
+
+// lookupProcessor returns the factory of a custom stage
+func lookupProcessor(name string) (ProcessorFactory, bool) {
+	customProcessorsMu.RLock()
+	defer customProcessorsMu.RUnlock()
+	factory, ok := customProcessors[name]
+	return factory, ok
+}
+
+// inPlace adapts stages that modify samples in place to a Processor
+type inPlace struct {
+	stage interface{ Process([]float64) }
+}
+
+// Process runs the stage and passes the same slice on
+func (p inPlace) Process(samples []float64) []float64 {
+	p.stage.Process(samples)
+	return samples
+}
+
+// pipelineStage is a built stage with the object behind it, which the
+// latency report inspects
+type pipelineStage struct {
+	name      string
+	processor Processor
+	impl      interface{}
+}
+
+// buildPipeline assembles the processing stages in processing.chain order.
+// Channel counts are tracked along the way so stages after a downmix, or a
+// custom stage with OutputChannels, are built for the new layout.
+func (ac *AudioCapture) buildPipeline() error {
+	p := ac.processingSettings()
+	channels := ac.config.Audio.Channels
+	config := *ac.config // Custom stages see the processing settings in effect
+	config.Processing = p
+
+	var pipeline []pipelineStage
+	var limiter *Limiter
+	var noiseGate *NoiseGate
+	for _, name := range p.Chain {
+		var processor Processor
+		var impl interface{}
+
+		if builtinProcessors[name] {
+			impl = ac.buildStage(p, name, channels)
+			switch stage := impl.(type) {
+			case nil:
+			case Processor:
+				processor = stage
+			case interface{ Process([]float64) }:
+				processor = inPlace{stage}
+			}
+			switch stage := impl.(type) {
+			case *Limiter:
+				limiter = stage
+			case *NoiseGate:
+				noiseGate = stage
+			}
+		} else {
+			factory, ok := lookupProcessor(name)
+			if !ok {
+				return fmt.Errorf("unknown processor: %q", name)
+			}
+			custom, err := factory(&config, channels)
+			if err != nil {
+				return fmt.Errorf("failed to build processor %q: %v", name, err)
+			}
+			processor, impl = custom, custom
+			if layout, ok := custom.(interface{ OutputChannels() int }); ok {
+				if channels = layout.OutputChannels(); channels < 1 {
+					return fmt.Errorf("processor %q outputs %d channels", name, channels)
+				}
+			}
+		}
+
+		if name == "downmix" && p.DownmixMono {
+			channels = 1
+		}
+		if processor != nil {
+			pipeline = append(pipeline, pipelineStage{name: name, processor: processor, impl: impl})
+		}
+	}
+
+	if channels != ac.output.Channels {
+		return fmt.Errorf("the chain ends with %d channels but the stream has %d", channels, ac.output.Channels)
+	}
+
+	// Only the capture loop uses the stages, but the latency report lists them
+	ac.runtimeMu.Lock()
+	ac.pipeline = pipeline
+	ac.runtimeMu.Unlock()
+	ac.limiter, ac.noiseGate = limiter, noiseGate
+	return nil
+}
+
+// buildStage creates a built-in stage for the given channel count, or
+// returns nil when the configuration leaves it disabled
+func (ac *AudioCapture) buildStage(cfg ProcessingConfig, name string, channels int) interface{} {
+	rate := ac.SampleRate()
+
+	switch name {
+	case "channels":
+		return ProcessorFunc(func(samples []float64) []float64 {
+			ac.runtimeMu.RLock()
+			applyChannelState(samples, channels, ac.channelState)
+			ac.runtimeMu.RUnlock()
+			return samples
+		})
+
+	case "dc_block":
+		if cfg.DCBlock {
+			return NewDCBlocker(rate, channels)
+		}
+
+	case "highpass":
+		if cfg.HighPass.CutoffHz > 0 {
+			return NewBiquad(HighPass, rate, channels, cfg.HighPass.CutoffHz, cfg.HighPass.Q, cfg.HighPass.Order)
+		}
+
+	case "noise_gate":
+		if cfg.NoiseGate.Enabled {
+			return NewNoiseGate(rate, channels, float64(cfg.NoiseGate.Threshold),
+				cfg.NoiseGate.HoldMs, cfg.NoiseGate.ReleaseMs)
+		}
+
+	case "volume":
+		// Always present, the volume can change on a config reload
+		return ProcessorFunc(func(samples []float64) []float64 {
+			volume := ac.levelSettings().Volume
+			if volume == 1 {
+				return samples
+			}
+			for i := range samples {
+				samples[i] *= volume
+			}
+			return samples
+		})
+
+	case "stereo":
+		var midSide *MidSide
+		if cfg.MidSide.Mode != MidSideOff {
+			midSide = NewMidSide(cfg.MidSide.Mode, channels, cfg.MidSide.MidGain, cfg.MidSide.SideGain)
+		}
+		return ProcessorFunc(func(samples []float64) []float64 {
+			width := ac.StereoWidth()
+
+			// Stereo width works on left/right, so it runs before an
+			// encode and after any other mode
+			if midSide != nil && midSide.mode == MidSideEncode {
+				applyStereoWidth(samples, channels, width)
+				midSide.Process(samples)
+			} else {
+				if midSide != nil {
+					midSide.Process(samples)
+				}
+				applyStereoWidth(samples, channels, width)
+			}
+			return samples
+		})
+
+	case "downmix":
+		if cfg.DownmixMono {
+			return ProcessorFunc(func(samples []float64) []float64 {
+				return downmixMono(samples, channels)
+			})
+		}
+
+	case "lowpass":
+		if cfg.LowPass.CutoffHz > 0 {
+			return NewBiquad(LowPass, rate, channels, cfg.LowPass.CutoffHz, cfg.LowPass.Q, cfg.LowPass.Order)
+		}
+
+	case "limiter":
+		return NewLimiter(rate, channels, float64(cfg.ClipThreshold), cfg.Limiter.LookaheadMs, cfg.Limiter.ReleaseMs)
+	}
+	return nil
+}
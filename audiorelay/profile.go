@@ -0,0 +1,75 @@
+package audiorelay
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Latency/quality profiles
+const (
+	ProfileLowLatency = "low-latency"
+	ProfileBalanced   = "balanced"
+	ProfileQuality    = "quality"
+)
+
+// profileDefaults are the defaults each profile changes. They replace the
+// built-in defaults only, so keys set in the config file or on the command
+// line still win.
+var profileDefaults = map[string]map[string]interface{}{
+	// Small capture buffers, no history burst for new listeners, and a
+	// continuous stream so players don't drain and rebuffer on silence
+	ProfileLowLatency: {
+		"audio.buffer_size":            128,
+		"audio.output_bit_depth":       16,
+		"protocols.tcp.prebuffer_ms":   0,
+		"protocols.http.prebuffer_ms":  0,
+		"processing.silence_detection": false,
+	},
+	// The built-in defaults
+	ProfileBalanced: {},
+	// Large buffers that ride out scheduling hiccups and network jitter,
+	// 24-bit output, and no gaps from silence skipping
+	ProfileQuality: {
+		"audio.buffer_size":            2048,
+		"audio.output_bit_depth":       24,
+		"protocols.tcp.prebuffer_ms":   500,
+		"protocols.http.prebuffer_ms":  2000,
+		"processing.silence_detection": false,
+	},
+}
+
+// applyProfile sets the defaults of the profile chosen in v
+func applyProfile(v *viper.Viper) error {
+	profile := v.GetString("profile")
+	defaults, ok := profileDefaults[profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, expected %s", profile, strings.Join(profileNames(), ", "))
+	}
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+	return nil
+}
+
+// profileKey reports whether a profile sets the default of key
+func profileKey(key string) bool {
+	for _, defaults := range profileDefaults {
+		if _, ok := defaults[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// profileNames returns the known profiles in order
+func profileNames() []string {
+	names := make([]string, 0, len(profileDefaults))
+	for name := range profileDefaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
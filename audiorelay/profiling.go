@@ -0,0 +1,72 @@
+package audiorelay
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// registerProfiling serves net/http/pprof under /api/v1/debug/pprof/ and
+// expvar at /api/v1/debug/vars, with server.profiling enabled. Being under
+// /api/ they need the admin credentials; without any they only answer
+// requests made on this machine directly, not through a reverse proxy.
+func (ar *AudioRelay) registerProfiling(hs *HTTPServer) {
+	// pprof finds the profile by the path after /debug/pprof/
+	debug := http.NewServeMux()
+	debug.HandleFunc("/debug/pprof/", pprof.Index)
+	debug.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debug.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debug.Handle("/debug/vars", expvar.Handler())
+	handler := http.StripPrefix("/api/v1", debug)
+
+	open := !NewAuthenticator(ar.config.Auth).protects(authAdmin)
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		if open && (!fromLoopback(r) || proxied(r)) {
+			writeJSONError(w, http.StatusForbidden, "profiling is only served to direct requests from localhost without auth.admin credentials")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+	hs.HandleFunc("/api/v1/debug/pprof/", serve)
+	hs.HandleFunc("GET /api/v1/debug/vars", serve)
+
+	// Published once per process; the standard memstats and cmdline come
+	// with expvar
+	if expvar.Get("audiorelay") == nil {
+		expvar.Publish("audiorelay", expvar.Func(func() interface{} {
+			clients := make(map[string]int)
+			for _, client := range ar.clients.List() {
+				clients[client.Protocol]++
+			}
+			return map[string]interface{}{
+				"goroutines": runtime.NumGoroutine(),
+				"clients":    clients,
+			}
+		}))
+	}
+}
+
+// proxied reports whether a request passed through a reverse proxy, which
+// makes requests from anywhere arrive from loopback
+func proxied(r *http.Request) bool {
+	for _, header := range []string{"X-Forwarded-For", "Forwarded", "X-Real-IP"} {
+		if r.Header.Get(header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// fromLoopback reports whether a request comes from this machine
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,174 @@
+package audiorelay
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Thresholds for flagging proxy behaviour during proxy-check
+const (
+	proxyCheckMaxFirstAudio = 2 * time.Second        // Longer means the proxy holds back the start
+	proxyCheckMaxGap        = 500 * time.Millisecond // Longer gaps mean the proxy releases audio in batches
+	proxyCheckMinThroughput = 0.9                    // Share of the nominal byte rate that must arrive
+)
+
+// proxyCheckResult holds the measurements of one proxy-check run
+type proxyCheckResult struct {
+	Headers    time.Duration // Request to response headers
+	FirstAudio time.Duration // Request to the first sample data
+	MaxGap     time.Duration // Longest pause between reads once audio flows
+	Received   int64         // Audio bytes after the initial burst
+	Elapsed    time.Duration // Time over which Received arrived
+	Closed     time.Duration // When the proxy ended the response, 0 if it stayed open
+	EndReason  string
+	Format     wavFormat
+	Problems   []string
+}
+
+// runProxyCheckCommand handles "audiorelay proxy-check"
+func runProxyCheckCommand(args []string) error {
+	flags := flag.NewFlagSet("proxy-check", flag.ContinueOnError)
+	url := flags.String("url", "", "stream URL as seen through the reverse proxy, e.g. https://myhost/audio/stream.wav")
+	seconds := flags.Float64("seconds", 15, "how long to listen")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *url == "" {
+		return fmt.Errorf("usage: audiorelay proxy-check --url https://myhost/audio/stream.wav")
+	}
+	if *seconds < 3 {
+		return fmt.Errorf("seconds must be at least 3")
+	}
+
+	fmt.Printf("🔎 Listening to %s for %.0f seconds...\n", *url, *seconds)
+	result, err := proxyCheck(*url, time.Duration(*seconds*float64(time.Second)))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("   Format: %d Hz, %d ch, %s\n", result.Format.SampleRate, result.Format.Channels, result.Format.describe())
+	fmt.Printf("   Response headers after %d ms, first audio after %d ms\n",
+		result.Headers.Milliseconds(), result.FirstAudio.Milliseconds())
+	fmt.Printf("   Longest pause between reads: %d ms\n", result.MaxGap.Milliseconds())
+	if result.Elapsed > 0 {
+		rate := float64(result.Received) / result.Elapsed.Seconds()
+		fmt.Printf("   Throughput: %.1f KB/s (stream rate %.1f KB/s)\n", rate/1024, float64(result.Format.byteRate())/1024)
+	}
+
+	if len(result.Problems) == 0 {
+		fmt.Println("√ No proxy problems found")
+		return nil
+	}
+	for _, problem := range result.Problems {
+		fmt.Printf("⚠ %s\n", problem)
+	}
+	return fmt.Errorf("%d problem(s) found", len(result.Problems))
+}
+
+// proxyCheck streams from url for the given duration and reports behaviour
+// typical of misconfigured reverse proxies
+func proxyCheck(url string, duration time.Duration) (*proxyCheckResult, error) {
+	result := &proxyCheckResult{}
+	start := time.Now()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+	result.Headers = time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	result.checkHeaders(resp)
+
+	format, _, err := readWAVHeader(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("not a WAV stream: %v", err)
+	}
+	result.Format = format
+
+	// Drop the connection once the test duration is over
+	timer := time.AfterFunc(duration, func() { resp.Body.Close() })
+	defer timer.Stop()
+
+	// The server's prebuffer arrives in a burst, so pacing is only judged
+	// from one second after the first audio
+	buf := make([]byte, 16*1024)
+	var first, last, steady time.Time
+	for {
+		n, err := resp.Body.Read(buf)
+		now := time.Now()
+		if n > 0 {
+			switch {
+			case first.IsZero():
+				first = now
+				result.FirstAudio = now.Sub(start)
+			case steady.IsZero() && now.Sub(first) >= time.Second:
+				steady = now
+			case !steady.IsZero():
+				result.Received += int64(n)
+				result.Elapsed = now.Sub(steady)
+			}
+			if !last.IsZero() && now.Sub(last) > result.MaxGap {
+				result.MaxGap = now.Sub(last)
+			}
+			last = now
+		}
+		if err != nil {
+			if time.Since(start) < duration {
+				result.Closed = time.Since(start)
+				if err == io.EOF {
+					result.EndReason = resp.Trailer.Get(streamEndTrailer)
+				}
+			}
+			break
+		}
+	}
+
+	result.judge()
+	return result, nil
+}
+
+// checkHeaders flags response headers that reveal proxy rewriting
+func (r *proxyCheckResult) checkHeaders(resp *http.Response) {
+	if resp.Header.Get("X-Stream-Id") == "" {
+		r.Problems = append(r.Problems, "X-Stream-Id header is missing: the proxy strips headers or the URL is not an audiorelay stream")
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		r.Problems = append(r.Problems, fmt.Sprintf("response is compressed (%s): compression buffers audio, disable it for this location (nginx: gzip off)", enc))
+	}
+	if query := resp.Request.URL.Query(); resp.ContentLength >= 0 && !query.Has("max_seconds") && !query.Has("max_bytes") {
+		r.Problems = append(r.Problems, "endless stream has a Content-Length: the proxy buffered or rewrote the response")
+	}
+	if resp.ProtoMajor == 1 && resp.ProtoMinor == 0 {
+		r.Problems = append(r.Problems, "proxy answers with HTTP/1.0, which can't carry an endless chunked stream (nginx: proxy_http_version 1.1)")
+	}
+}
+
+// judge turns the timing measurements into problems
+func (r *proxyCheckResult) judge() {
+	if r.FirstAudio > proxyCheckMaxFirstAudio {
+		r.Problems = append(r.Problems, fmt.Sprintf("first audio took %d ms: the proxy buffers the response (nginx: proxy_buffering off)", r.FirstAudio.Milliseconds()))
+	}
+	if r.MaxGap > proxyCheckMaxGap {
+		r.Problems = append(r.Problems, fmt.Sprintf("audio arrives in bursts up to %d ms apart: the proxy buffers the response (nginx: proxy_buffering off), or silence detection is skipping audio", r.MaxGap.Milliseconds()))
+	}
+	if r.Elapsed > 0 {
+		ratio := float64(r.Received) / r.Elapsed.Seconds() / float64(r.Format.byteRate())
+		if ratio < proxyCheckMinThroughput {
+			r.Problems = append(r.Problems, fmt.Sprintf("only %.0f%% of the stream rate arrives: the proxy or link can't keep up, delay will keep growing", ratio*100))
+		}
+	}
+	if r.Closed > 0 {
+		if r.EndReason != "" {
+			r.Problems = append(r.Problems, fmt.Sprintf("server ended the stream after %.1fs (%s)", r.Closed.Seconds(), r.EndReason))
+		} else {
+			r.Problems = append(r.Problems, fmt.Sprintf("connection closed after %.1fs: check the proxy's read/idle timeout (nginx: proxy_read_timeout)", r.Closed.Seconds()))
+		}
+	}
+}
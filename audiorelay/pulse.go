@@ -0,0 +1,197 @@
+package audiorelay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// Capture backends
+const (
+	BackendPortAudio = "portaudio"
+	BackendPulse     = "pulse"
+)
+
+// pulseDefaultMonitor is the monitor of whatever sink is currently the default
+const pulseDefaultMonitor = "@DEFAULT_MONITOR@"
+
+// captureStream is a source of capture buffers. PortAudio streams satisfy it
+// directly; other backends fill the same typed buffer.
+type captureStream interface {
+	Start() error
+	Stop() error
+	Close() error
+	Read() error
+	Info() *portaudio.StreamInfo
+}
+
+// pulseStream captures from PulseAudio (or PipeWire's pulse server) through
+// parec, which can record sink monitors and individual application streams
+// that PortAudio's ALSA view doesn't expose reliably
+type pulseStream struct {
+	args   []string
+	buffer interface{} // Same typed buffer the PortAudio path reads into
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdout *bufio.Reader
+}
+
+// pulseDevice describes the configured PulseAudio source as a device so the
+// rest of the relay can treat both backends alike
+func pulseDevice(config *Config) *portaudio.DeviceInfo {
+	name := config.Audio.Pulse.Source
+	if name == "" {
+		name = pulseDefaultMonitor
+	}
+	if app := config.Audio.Pulse.Application; app != "" {
+		name = "app:" + app
+	}
+	return &portaudio.DeviceInfo{
+		Name:              "pulse:" + name,
+		MaxInputChannels:  config.Audio.Channels,
+		DefaultSampleRate: config.Audio.SampleRate,
+	}
+}
+
+// openPulseStream prepares a parec command for the configured source
+func (ac *AudioCapture) openPulseStream() (captureStream, error) {
+	if _, err := exec.LookPath("parec"); err != nil {
+		return nil, fmt.Errorf("parec not found, install pulseaudio-utils (or pipewire-pulse): %v", err)
+	}
+
+	format := "s16le"
+	switch ac.config.Audio.SampleFormat {
+	case "int24":
+		// Full 32-bit samples read the same way as PortAudio's int24-in-int32
+		format = "s32le"
+	case "float32":
+		format = "float32le"
+	}
+
+	frames := ac.actualBufferSize / ac.config.Audio.Channels
+	latencyMs := max(1, int(float64(frames)/ac.SampleRate()*1000))
+	args := []string{
+		"--raw",
+		"--format=" + format,
+		"--rate=" + strconv.Itoa(int(ac.SampleRate())),
+		"--channels=" + strconv.Itoa(ac.config.Audio.Channels),
+		"--latency-msec=" + strconv.Itoa(latencyMs),
+		"--client-name=audiorelay",
+	}
+
+	if app := ac.config.Audio.Pulse.Application; app != "" {
+		// Per-application capture records one playback stream
+		index, err := findSinkInput(app)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--monitor-stream="+strconv.Itoa(index))
+	} else {
+		source := ac.config.Audio.Pulse.Source
+		if source == "" {
+			source = pulseDefaultMonitor
+		}
+		args = append(args, "--device="+source)
+	}
+
+	return &pulseStream{args: args, buffer: ac.buffer}, nil
+}
+
+// Start launches parec
+func (ps *pulseStream) Start() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.cmd != nil {
+		return nil
+	}
+	cmd := exec.Command("parec", ps.args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start parec: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start parec: %v", err)
+	}
+	debugf("Started parec %s", strings.Join(ps.args, " "))
+
+	ps.cmd = cmd
+	ps.stdout = bufio.NewReaderSize(stdout, 64*1024)
+	return nil
+}
+
+// Stop terminates parec
+func (ps *pulseStream) Stop() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.cmd == nil {
+		return nil
+	}
+	ps.cmd.Process.Kill()
+	ps.cmd.Wait()
+	ps.cmd = nil
+	ps.stdout = nil
+	return nil
+}
+
+// Close releases the stream
+func (ps *pulseStream) Close() error {
+	return ps.Stop()
+}
+
+// Read fills the capture buffer with the next block of samples
+func (ps *pulseStream) Read() error {
+	ps.mu.Lock()
+	stdout := ps.stdout
+	ps.mu.Unlock()
+
+	if stdout == nil {
+		return fmt.Errorf("pulse stream is not running")
+	}
+	if err := binary.Read(stdout, binary.LittleEndian, ps.buffer); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("parec exited (source gone?)")
+		}
+		return err
+	}
+	return nil
+}
+
+// Info is not available for parec streams
+func (ps *pulseStream) Info() *portaudio.StreamInfo {
+	return nil
+}
+
+// findSinkInput returns the index of the playback stream of an application
+func findSinkInput(application string) (int, error) {
+	out, err := exec.Command("pactl", "-f", "json", "list", "sink-inputs").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list application streams with pactl: %v", err)
+	}
+
+	var inputs []struct {
+		Index      int               `json:"index"`
+		Properties map[string]string `json:"properties"`
+	}
+	if err := json.Unmarshal(out, &inputs); err != nil {
+		return 0, fmt.Errorf("failed to parse pactl output: %v", err)
+	}
+	for _, input := range inputs {
+		for _, key := range []string{"application.name", "application.process.binary"} {
+			if strings.EqualFold(input.Properties[key], application) {
+				return input.Index, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no playback stream from application %q", application)
+}
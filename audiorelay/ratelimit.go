@@ -0,0 +1,117 @@
+package audiorelay
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often buckets of quiet IPs are forgotten
+const rateLimitSweepInterval = time.Minute
+
+// RateLimitConfig limits how fast a single IP may connect, so a buggy
+// client can't exhaust memory by reconnecting in a loop
+type RateLimitConfig struct {
+	PerSecond float64 `mapstructure:"per_second" desc:"New TCP connections and HTTP stream and capture connections allowed per client IP and second, 0 for no limit"`
+	Burst     int     `mapstructure:"burst" desc:"Connections an IP may make at once before per_second applies"`
+}
+
+// validate checks that a limit can let anything through
+func (c RateLimitConfig) validate() error {
+	if c.PerSecond < 0 {
+		return fmt.Errorf("rate_limit per_second must not be negative")
+	}
+	if c.PerSecond > 0 && c.Burst < 1 {
+		return fmt.Errorf("rate_limit burst must be at least 1")
+	}
+	return nil
+}
+
+// tokenBucket holds the tokens of one IP as of updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// ipRateLimiter is a token bucket per client IP
+type ipRateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newIPRateLimiter creates a limiter, or returns nil if config has no limit
+func newIPRateLimiter(config RateLimitConfig) *ipRateLimiter {
+	if config.PerSecond <= 0 {
+		return nil
+	}
+	return &ipRateLimiter{
+		rate:      config.PerSecond,
+		burst:     float64(config.Burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token for the IP of addr. If there is none it reports
+// false and how long until the next one.
+func (l *ipRateLimiter) allow(addr string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		ip = addr
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[ip] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep forgets IPs whose buckets have refilled, which are the same as new
+func (l *ipRateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for ip, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= full {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// limited answers 429 and reports true if the IP of a new listener is over
+// the limit. Only listeners are limited, as they are what gets a copy of
+// the history; the web interface and API requests are not.
+func (l *ipRateLimiter) limited(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := l.allow(r.RemoteAddr)
+	if ok {
+		return false
+	}
+	debugf("HTTP listener %s %s rate limited", r.RemoteAddr, r.URL.Path)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeJSONError(w, http.StatusTooManyRequests, "too many connections, slow down")
+	return true
+}
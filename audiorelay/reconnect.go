@@ -0,0 +1,145 @@
+package audiorelay
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// SetCaptureCallbacks sets the functions called when the capture device is
+// lost, with whether capture waits for it to return, and when capture
+// resumes after a loss
+func (ac *AudioCapture) SetCaptureCallbacks(lost func(device string, reconnecting bool), recovered func(device string, down time.Duration)) {
+	ac.lostCallback = lost
+	ac.recoveredCallback = recovered
+}
+
+// reconnect waits for the lost capture device to return, or for any device
+// of audio.device_priority, and reopens the stream on it. It returns false
+// if capture was stopped in the meantime.
+func (ac *AudioCapture) reconnect() bool {
+	name := ac.Device().Name
+	log.Printf("🔌 Capture device lost: %s, waiting for it to return", name)
+	lost := time.Now()
+	if ac.lostCallback != nil {
+		ac.lostCallback(name, true)
+	}
+
+	// A vanished device can't be stopped cleanly; closing aborts the stream
+	ac.mu.Lock()
+	if ac.stream != nil {
+		ac.stream.Close()
+		ac.stream = nil
+	}
+	ac.mu.Unlock()
+
+	interval := time.Duration(ac.config.Audio.Reconnect.IntervalSeconds * float64(time.Second))
+	for attempt := 1; ac.isRunning; attempt++ {
+		time.Sleep(interval)
+
+		// parec looks its source up again on every start
+		device := ac.Device()
+		var err error
+		if ac.config.Audio.Backend != BackendPulse {
+			device, err = refreshDevice(ac.reconnectCandidates(name), ac.config.Audio.HostApi)
+		}
+		if err != nil {
+			debugf("Reconnect attempt %d: %v", attempt, err)
+			continue
+		}
+
+		ac.mu.Lock()
+		if !ac.isRunning {
+			ac.mu.Unlock()
+			return false
+		}
+		stream, err := ac.openStream(device)
+		if err == nil {
+			if err = stream.Start(); err != nil {
+				stream.Close()
+				err = ac.captureFailed("start audio stream", device, err)
+			}
+		}
+		if err == nil {
+			ac.stream = stream
+			ac.device = device
+			ac.markAlive()
+		}
+		ac.mu.Unlock()
+
+		if err != nil {
+			debugf("Reconnect attempt %d: %v", attempt, err)
+			continue
+		}
+		log.Printf("🔌 Capture device reconnected: %s (after %d attempts)", device.Name, attempt)
+		if ac.recoveredCallback != nil {
+			ac.recoveredCallback(device.Name, time.Since(lost))
+		}
+		return true
+	}
+	return false
+}
+
+// reconnectCandidates lists the devices to look for after losing name: the
+// configured priority list, so capture moves on to the next present device,
+// or else just the lost device
+func (ac *AudioCapture) reconnectCandidates(name string) []string {
+	if len(ac.config.Audio.DevicePriority) > 0 && ac.config.Audio.DeviceName == "" {
+		return ac.config.Audio.DevicePriority
+	}
+	return []string{name}
+}
+
+// portaudioMu keeps refreshDevice from restarting PortAudio while streams
+// are opened or devices listed elsewhere, which hold it for reading
+var portaudioMu sync.RWMutex
+
+// openPortAudioStreams counts the PortAudio capture streams open across all
+// captures of the process
+var openPortAudioStreams atomic.Int64
+
+// portaudioStream is a PortAudio capture stream counted in
+// openPortAudioStreams until it is closed
+type portaudioStream struct {
+	*portaudio.Stream
+	closed sync.Once
+}
+
+// Close closes the stream
+func (s *portaudioStream) Close() error {
+	err := s.Stream.Close()
+	s.closed.Do(func() { openPortAudioStreams.Add(-1) })
+	return err
+}
+
+// refreshDevice restarts PortAudio so devices attached since startup are
+// enumerated, then returns the first present input device of names.
+// PortAudio only scans for devices when it is initialized, but terminating
+// it closes every stream, so while another capture still has one open the
+// devices already known have to do.
+func refreshDevice(names []string, hostApi string) (*portaudio.DeviceInfo, error) {
+	portaudioMu.Lock()
+	defer portaudioMu.Unlock()
+
+	if open := openPortAudioStreams.Load(); open > 0 {
+		debugf("Not restarting PortAudio, %d capture stream(s) still open", open)
+	} else {
+		if err := portaudio.Terminate(); err != nil {
+			return nil, fmt.Errorf("failed to restart PortAudio: %v", err)
+		}
+		if err := portaudio.Initialize(); err != nil {
+			return nil, fmt.Errorf("failed to restart PortAudio: %v", err)
+		}
+	}
+
+	dm := NewDeviceManager()
+	dm.SetHostApi(hostApi)
+	if err := dm.Initialize(); err != nil {
+		return nil, err
+	}
+	return dm.GetFirstAvailable(names)
+}
@@ -0,0 +1,405 @@
+package audiorelay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecordingStore manages saved clips and their post-processing
+type RecordingStore struct {
+	capture *AudioCapture
+	dir     string
+	jobs    *JobQueue
+}
+
+// RecordingInfo describes a saved recording
+type RecordingInfo struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Duration   float64   `json:"duration"`
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Modified   time.Time `json:"modified"`
+}
+
+// ProcessRequest describes a post-processing operation on a recording
+type ProcessRequest struct {
+	Operation  string  `json:"operation"`   // normalize, trim or convert
+	TargetDB   float64 `json:"target_db"`   // normalize: peak level in dBFS
+	Threshold  int     `json:"threshold"`   // trim: silence threshold in sample units
+	Channels   int     `json:"channels"`    // convert: output channel count
+	SampleRate int     `json:"sample_rate"` // convert: output sample rate
+	Output     string  `json:"output"`      // Optional output file name
+}
+
+// NewRecordingStore creates a store for recordings in dir, trimmed by
+// default at the silence threshold capture uses
+func NewRecordingStore(capture *AudioCapture, dir string, jobs *JobQueue) *RecordingStore {
+	return &RecordingStore{
+		capture: capture,
+		dir:     dir,
+		jobs:    jobs,
+	}
+}
+
+// List returns all recordings, newest first
+func (rs *RecordingStore) List() ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(rs.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RecordingInfo{}, nil
+		}
+		return nil, err
+	}
+
+	recordings := make([]RecordingInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".wav") {
+			continue
+		}
+		info, err := rs.stat(entry.Name())
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, info)
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Modified.After(recordings[j].Modified)
+	})
+	return recordings, nil
+}
+
+// stat reads the metadata of a single recording
+func (rs *RecordingStore) stat(name string) (RecordingInfo, error) {
+	path, err := rs.path(name)
+	if err != nil {
+		return RecordingInfo{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return RecordingInfo{}, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return RecordingInfo{}, err
+	}
+
+	info := RecordingInfo{
+		Name:     name,
+		Size:     fileInfo.Size(),
+		Modified: fileInfo.ModTime(),
+	}
+
+	// Only the header is needed to describe the recording
+	format, size, err := readWAVHeader(file)
+	if err == nil {
+		if size == wavUnknownSize {
+			// Streams run to the end of the file, right after the header
+			offset, _ := file.Seek(0, io.SeekCurrent)
+			size = uint32(fileInfo.Size() - offset)
+		}
+		info.SampleRate = format.SampleRate
+		info.Channels = format.Channels
+		info.Duration = float64(size) / float64(format.byteRate())
+	}
+	return info, nil
+}
+
+// path resolves a recording name to a file path, rejecting anything outside the store
+func (rs *RecordingStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid recording name: %q", name)
+	}
+	if !strings.EqualFold(filepath.Ext(name), ".wav") {
+		return "", fmt.Errorf("recording name must end in .wav")
+	}
+	return filepath.Join(rs.dir, name), nil
+}
+
+// Process queues a post-processing job for a recording
+func (rs *RecordingStore) Process(name string, req ProcessRequest) (*Job, error) {
+	src, err := rs.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(src); err != nil {
+		return nil, fmt.Errorf("recording not found: %s", name)
+	}
+
+	switch req.Operation {
+	case "normalize":
+		if req.TargetDB == 0 {
+			req.TargetDB = -1
+		}
+		if req.TargetDB > 0 {
+			return nil, fmt.Errorf("target_db must be 0 or below")
+		}
+	case "trim":
+		if req.Threshold <= 0 {
+			req.Threshold = rs.capture.levelSettings().SilenceThreshold
+		}
+	case "convert":
+		if req.Channels < 0 || req.SampleRate < 0 || (req.Channels == 0 && req.SampleRate == 0) {
+			return nil, fmt.Errorf("convert needs channels and/or sample_rate")
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation: %q", req.Operation)
+	}
+
+	if req.Output == "" {
+		req.Output = strings.TrimSuffix(name, filepath.Ext(name)) + "-" + req.Operation + ".wav"
+	}
+	dst, err := rs.path(req.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.jobs.Submit("recording."+req.Operation, func(job *Job) (map[string]interface{}, error) {
+		return rs.runProcess(job, src, dst, req)
+	})
+}
+
+// runProcess executes a post-processing operation and writes the result
+func (rs *RecordingStore) runProcess(job *Job, src, dst string, req ProcessRequest) (map[string]interface{}, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	format, samples, err := readWAV(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	job.SetProgress(0.2)
+
+	switch req.Operation {
+	case "normalize":
+		if err := normalizePeak(samples, req.TargetDB, job); err != nil {
+			return nil, err
+		}
+	case "trim":
+		samples = trimSilence(samples, format.Channels, req.Threshold)
+	case "convert":
+		if req.Channels > 0 && req.Channels != format.Channels {
+			samples, err = convertChannels(samples, format.Channels, req.Channels)
+			if err != nil {
+				return nil, err
+			}
+			format.Channels = req.Channels
+		}
+		if req.SampleRate > 0 && req.SampleRate != format.SampleRate {
+			samples = resampleLinear(samples, format.Channels, format.SampleRate, req.SampleRate)
+			format.SampleRate = req.SampleRate
+		}
+	}
+	job.SetProgress(0.9)
+
+	// Don't write output for a job canceled mid-way
+	if err := job.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first so readers never see a partial recording
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	data := format.encodeSamples(samples)
+	format.writeHeader(out, uint32(len(data)))
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"recording": filepath.Base(dst),
+		"duration":  float64(len(data)) / float64(format.byteRate()),
+	}, nil
+}
+
+// normalizePeak scales samples so the loudest peak reaches targetDB (dBFS)
+func normalizePeak(samples []float64, targetDB float64, job *Job) error {
+	peak := 0.0
+	for _, sample := range samples {
+		peak = math.Max(peak, math.Abs(sample))
+	}
+	if peak == 0 {
+		return nil
+	}
+
+	gain := 32767 * math.Pow(10, targetDB/20) / peak
+	const chunk = 48000
+	for i, sample := range samples {
+		samples[i] = sample * gain
+		if i%chunk == 0 {
+			if err := job.Context().Err(); err != nil {
+				return err
+			}
+			job.SetProgress(0.2 + 0.7*float64(i)/float64(len(samples)))
+		}
+	}
+	return nil
+}
+
+// trimSilence removes leading and trailing frames whose samples all stay below threshold
+func trimSilence(samples []float64, channels, threshold int) []float64 {
+	start, end := audibleFrames(samples, channels, threshold)
+	return samples[start*channels : end*channels]
+}
+
+// trimSilentPCM removes leading and trailing silence from encoded audio,
+// returning a slice of data
+func trimSilentPCM(data []byte, format wavFormat, threshold int) []byte {
+	start, end := audibleFrames(format.decodeSamples(data), format.Channels, threshold)
+	return data[start*format.blockAlign() : end*format.blockAlign()]
+}
+
+// audibleFrames returns the range of frames from the first to the last one
+// with a sample above threshold; start equals end if all are silent
+func audibleFrames(samples []float64, channels, threshold int) (int, int) {
+	loud := func(frame int) bool {
+		for c := 0; c < channels; c++ {
+			if math.Abs(samples[frame*channels+c]) > float64(threshold) {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := len(samples) / channels
+	start, end := 0, frames
+	for start < end && !loud(start) {
+		start++
+	}
+	for end > start && !loud(end-1) {
+		end--
+	}
+	return start, end
+}
+
+// convertChannels downmixes to mono or duplicates mono to more channels
+func convertChannels(samples []float64, from, to int) ([]float64, error) {
+	frames := len(samples) / from
+	out := make([]float64, frames*to)
+
+	switch {
+	case to == 1:
+		for f := 0; f < frames; f++ {
+			sum := 0.0
+			for c := 0; c < from; c++ {
+				sum += samples[f*from+c]
+			}
+			out[f] = sum / float64(from)
+		}
+	case from == 1:
+		for f := 0; f < frames; f++ {
+			for c := 0; c < to; c++ {
+				out[f*to+c] = samples[f]
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cannot convert %d channels to %d", from, to)
+	}
+	return out, nil
+}
+
+// resampleLinear changes the sample rate using linear interpolation
+func resampleLinear(samples []float64, channels, fromRate, toRate int) []float64 {
+	inFrames := len(samples) / channels
+	if inFrames == 0 {
+		return samples
+	}
+	outFrames := int(int64(inFrames) * int64(toRate) / int64(fromRate))
+	out := make([]float64, outFrames*channels)
+	step := float64(fromRate) / float64(toRate)
+
+	for f := 0; f < outFrames; f++ {
+		pos := float64(f) * step
+		i := int(pos)
+		frac := pos - float64(i)
+		next := i + 1
+		if next >= inFrames {
+			next = inFrames - 1
+		}
+		for c := 0; c < channels; c++ {
+			a := samples[i*channels+c]
+			b := samples[next*channels+c]
+			out[f*channels+c] = a + (b-a)*frac
+		}
+	}
+	return out
+}
+
+// handleList returns all saved recordings
+func (rs *RecordingStore) handleList(w http.ResponseWriter, r *http.Request) {
+	recordings, err := rs.List()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"recordings": recordings})
+}
+
+// handleGet downloads a single recording. Range requests are supported so
+// players can seek within it.
+func (rs *RecordingStore) handleGet(w http.ResponseWriter, r *http.Request) {
+	path, err := rs.path(r.PathValue("name"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "recording not found")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	setFilename(w, r, filepath.Base(path))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// handleProcess queues a post-processing job for a recording
+func (rs *RecordingStore) handleProcess(w http.ResponseWriter, r *http.Request) {
+	var req ProcessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	job, err := rs.Process(r.PathValue("name"), req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job.Info())
+}
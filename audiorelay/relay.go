@@ -1,13 +1,20 @@
 package audiorelay
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
-	"os"
+	"log"
+	"math"
 	"os/signal"
+	"reflect"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/gordonklaus/portaudio"
+	"github.com/spf13/pflag"
 )
 
 // AudioRelay is the main audio relay service
@@ -20,6 +27,38 @@ type AudioRelay struct {
 	deviceMgr    *DeviceManager
 	tcpServer    *TCPServer
 	httpServer   *HTTPServer
+	udpSender    *UDPSender
+	streams      map[string]*namedStream
+	identity     *StreamIdentity
+	events       *EventBus
+	clients      *ClientRegistry
+	clientPrefs  *ClientPrefStore
+	triggers     *TriggerManager
+	analysis     *AnalysisTap
+	power        *PowerHooks
+	notifier     *Notifier
+	stats        *StatsHistory
+	tracer       *Tracer // Nil unless tracing is configured
+	jobs         *JobQueue
+	recordings   *RecordingStore
+	shares       *ShareStore
+	assets       *AssetStore
+	faults       *FaultInjector // Developer mode only
+	standbys     *StandbyRegistry
+	standby      *StandbyMirror // Set when running as another relay's hot standby
+
+	configWatcher *fsnotify.Watcher
+	configPath    string // File written by PUT /api/v1/config?persist=true, empty in safe mode
+
+	// config is never changed once running, so components read it freely;
+	// running is the configuration with the hot settings applied since
+	configMu sync.Mutex
+	running  *Config
+
+	// Root context of HTTP requests; cancelled once Stop has given the
+	// listeners their drain period
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	// Control
 	isRunning bool
@@ -27,12 +66,50 @@ type AudioRelay struct {
 
 // New creates a new AudioRelay instance with the given configuration
 func New(config *Config, webFS fs.FS) *AudioRelay {
-	return &AudioRelay{
+	ar := &AudioRelay{
 		config:       config,
+		running:      config,
 		webFS:        webFS, // 初始化 webFS
 		deviceMgr:    NewDeviceManager(),
 		audioCapture: NewAudioCapture(config),
+		events:       NewEventBus(),
+		clients:      NewClientRegistry(),
+		jobs:         NewJobQueue(config.Jobs.Workers),
+		streams:      make(map[string]*namedStream),
+		shares:       NewShareStore(config),
+		standbys:     NewStandbyRegistry(),
 	}
+	ar.ctx, ar.cancel = context.WithCancel(context.Background())
+	ar.deviceMgr.SetHostApi(config.Audio.HostApi)
+	ar.recordings = NewRecordingStore(ar.audioCapture, config.Triggers.Snapshot.Directory, ar.jobs)
+	ar.assets = NewAssetStore(config, ar.audioCapture)
+
+	ar.clients.events = ar.events
+
+	if config.Tracing.Enabled() {
+		ar.tracer = NewTracer(config.Tracing)
+		ar.audioCapture.tracer = ar.tracer
+	}
+
+	if config.Server.StatsMinutes > 0 {
+		ar.stats = NewStatsHistory(config.Server.StatsMinutes, ar.audioCapture, ar.clients)
+	}
+
+	if config.Triggers.Level.Enabled {
+		ar.triggers = NewTriggerManager(config, ar.audioCapture, ar.events)
+	}
+
+	if config.Server.DeveloperMode {
+		ar.faults = NewFaultInjector(ar.events)
+		ar.audioCapture.faults = ar.faults
+	}
+
+	return ar
+}
+
+// Events returns the relay's event bus
+func (ar *AudioRelay) Events() *EventBus {
+	return ar.events
 }
 
 // Start begins the audio relay service
@@ -44,9 +121,11 @@ func (ar *AudioRelay) Start() error {
 	fmt.Println("🎧 Audio Relay Service Starting...")
 	fmt.Println("==================================")
 
-	// Initialize device manager
-	if err := ar.deviceMgr.Initialize(); err != nil {
-		return fmt.Errorf("failed to initialize device manager: %v", err)
+	// Initialize device manager; the pulse backend doesn't use PortAudio devices
+	if ar.config.Audio.Backend != BackendPulse {
+		if err := ar.deviceMgr.Initialize(); err != nil {
+			return fmt.Errorf("failed to initialize device manager: %v", err)
+		}
 	}
 
 	// Select audio input device
@@ -60,24 +139,129 @@ func (ar *AudioRelay) Start() error {
 		return fmt.Errorf("failed to initialize audio capture: %v", err)
 	}
 
+	// Load the stream's persistent identity
+	identity, err := LoadStreamIdentity(ar.config.Server.StateFile, "main", ar.config.StreamFormat(false))
+	if err != nil {
+		return fmt.Errorf("failed to load stream identity: %v", err)
+	}
+	ar.identity = identity
+	fmt.Printf("🆔 Stream ID: %s (format version %d)\n", identity.ID, identity.FormatVersion)
+
+	ar.assets.CheckLibrary()
+
+	if ar.faults != nil {
+		log.Printf("🧪 Developer mode: fault injection enabled at /api/v1/dev/faults")
+	}
+
+	// Settings remembered for named listeners
+	ar.clientPrefs, err = LoadClientPrefs(ar.config.Server.ClientPrefsFile)
+	if err != nil {
+		return err
+	}
+
+	if ar.stats != nil {
+		ar.stats.Start()
+	}
+	if ar.tracer != nil {
+		ar.tracer.Start()
+	}
+
+	// Push events to webhooks
+	if len(ar.config.Notifications.Webhooks) > 0 {
+		ar.notifier = NewNotifier(ar.config.Notifications, ar.events)
+		ar.notifier.Start()
+	}
+
+	// Switch attached gear with the listeners
+	if ar.config.Power.Enabled() {
+		ar.power = NewPowerHooks("main", ar.config.Power, ar.events, ar.clientCount)
+		ar.power.Start()
+	}
+
+	// A hot standby relays its primary and holds back local capture. Its
+	// broadcasts also come from the mirror, so they aren't traced.
+	if ar.config.Standby.Enabled() {
+		ar.standby = NewStandbyMirror(ar.config, ar.events, func() wavFormat {
+			return ar.audioCapture.StreamFormat(false)
+		}, func(data []byte, captured time.Time) {
+			ar.broadcastAudioData(data, captured, nil)
+		}, ar.mirrorPrimary)
+	}
+
 	// Start protocol servers
 	if err := ar.startProtocolServers(); err != nil {
 		return fmt.Errorf("failed to start protocol servers: %v", err)
 	}
 
 	// Set up audio data callback to broadcast to all clients
-	ar.audioCapture.SetDataCallback(ar.broadcastAudioData)
+	if ar.standby != nil {
+		ar.audioCapture.SetDataCallback(ar.standby.localAudio)
+	} else {
+		ar.audioCapture.SetDataCallback(func(data []byte, captured time.Time) {
+			ar.broadcastAudioData(data, captured, ar.audioCapture.broadcastSpan())
+		})
+	}
+
+	// Push level meter readings to event subscribers (e.g. web UI VU meters)
+	ar.audioCapture.SetLevelsCallback(func(levels Levels) {
+		ar.events.Publish(EventLevels, levels.eventData())
+	})
+
+	// Tell clients to reconnect when a device switch changes the format
+	ar.audioCapture.SetFormatCallback(ar.formatChanged)
+
+	// Report lost devices and long silences
+	ar.watchCapture("main", ar.audioCapture)
+
+	// Report streams the watchdog had to restart
+	ar.audioCapture.SetStallCallback(func(device string, stalled time.Duration) {
+		ar.events.Publish(EventCaptureStalled, map[string]interface{}{
+			"device":          device,
+			"stalled_seconds": math.Round(stalled.Seconds()*10) / 10,
+		})
+	})
+
+	// Analyzers get their own copy of the stream
+	if ar.config.Analysis.Enabled {
+		tap, err := NewAnalysisTap(ar.config, ar.audioCapture, ar.events)
+		if err != nil {
+			return fmt.Errorf("failed to set up analysis: %v", err)
+		}
+		ar.analysis = tap
+		ar.analysis.Start()
+		if ar.httpServer != nil {
+			ar.httpServer.AddDebugInfo("analysis", ar.analysis.debugInfo)
+		}
+	}
 
 	// Start audio capture
 	if err := ar.audioCapture.Start(); err != nil {
 		return fmt.Errorf("failed to start audio capture: %v", err)
 	}
 
+	// Start additional named streams
+	if err := ar.startStreams(); err != nil {
+		return fmt.Errorf("failed to start streams: %v", err)
+	}
+
+	if ar.standby != nil {
+		ar.standby.Start()
+	}
+
 	ar.isRunning = true
 
+	// Pause capture while no one listens
+	if ar.config.Audio.Idle.Enabled {
+		if ar.idleAllowed() {
+			go ar.idleMonitor()
+		} else {
+			log.Printf("  Idle mode disabled: UDP targets, triggers or analysis need continuous audio")
+		}
+	}
+
 	fmt.Println(" Audio Relay Service Started Successfully")
 	fmt.Printf("🎵 Sample Rate: %.0f Hz, Channels: %d\n",
-		ar.config.Audio.SampleRate, ar.config.Audio.Channels)
+		ar.config.Audio.SampleRate, ar.config.OutputChannels())
 	fmt.Println("==================================")
 	fmt.Println("")
 
@@ -92,13 +276,45 @@ func (ar *AudioRelay) Stop() {
 
 	fmt.Println("\n×Shutting down Audio Relay Service...")
 
+	// Sent before clients disconnect, whose events then aren't
+	ar.events.Publish(EventShutdown, nil)
+	if ar.notifier != nil {
+		ar.notifier.Stop()
+	}
+
+	if ar.configWatcher != nil {
+		ar.configWatcher.Close()
+	}
+
+	if ar.standby != nil {
+		ar.standby.Stop()
+	}
+
 	// Stop audio capture
 	if ar.audioCapture != nil {
 		ar.audioCapture.Stop()
 	}
+	ar.stopStreams()
 
-	// Stop protocol servers
+	// Stop protocol servers, then cut off whatever outlived the drain period
 	ar.stopProtocolServers()
+	ar.cancel()
+
+	if ar.analysis != nil {
+		ar.analysis.Stop()
+	}
+	if ar.power != nil {
+		ar.power.Stop()
+	}
+	if ar.stats != nil {
+		ar.stats.Stop()
+	}
+	if ar.tracer != nil {
+		ar.tracer.Stop()
+	}
+
+	// Cancel outstanding background jobs
+	ar.jobs.Stop()
 
 	ar.isRunning = false
 	fmt.Println(" Audio Relay Service Stopped")
@@ -106,6 +322,24 @@ func (ar *AudioRelay) Stop() {
 
 // selectAudioDevice handles audio device selection based on configuration
 func (ar *AudioRelay) selectAudioDevice() (*portaudio.DeviceInfo, error) {
+	if ar.config.Audio.Backend == BackendPulse {
+		return pulseDevice(ar.config), nil
+	}
+
+	// Capture system output directly; device_name then names the output device
+	if ar.config.Audio.Loopback {
+		device, err := ar.deviceMgr.GetLoopbackDevice(ar.config.Audio.DeviceName)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf(" Capturing system output via WASAPI loopback: %s\n", device.Name)
+		if device.DefaultSampleRate != ar.config.Audio.SampleRate {
+			log.Printf("  Loopback runs at the output's mix rate (%.0f Hz), set audio.sample_rate to match",
+				device.DefaultSampleRate)
+		}
+		return device, nil
+	}
+
 	// Use specified device if configured
 	if ar.config.Audio.DeviceName != "" {
 		device, err := ar.deviceMgr.GetDeviceByName(ar.config.Audio.DeviceName)
@@ -115,10 +349,35 @@ func (ar *AudioRelay) selectAudioDevice() (*portaudio.DeviceInfo, error) {
 		return device, nil
 	}
 
-	// Auto-select BlackHole device if preferred
+	// Select by position in the device list
+	if ar.config.Audio.DeviceIndex >= 0 {
+		device, err := ar.deviceMgr.GetDeviceByIndex(ar.config.Audio.DeviceIndex)
+		if err != nil {
+			ar.deviceMgr.PrintInputDevices()
+			return nil, err
+		}
+		fmt.Printf(" Selected device %d: %s\n", ar.config.Audio.DeviceIndex, device.Name)
+		return device, nil
+	}
+
+	// Take the first device of the priority list that is present
+	if priority := ar.config.Audio.DevicePriority; len(priority) > 0 {
+		device, err := ar.deviceMgr.GetFirstAvailable(priority)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := ar.deviceMgr.GetFirstAvailable(priority[:1]); err != nil {
+			fmt.Printf(" %s is not present, falling back to: %s\n", priority[0], device.Name)
+		} else {
+			fmt.Printf(" Selected device: %s\n", device.Name)
+		}
+		return device, nil
+	}
+
+	// Auto-select a virtual device if preferred
 	if ar.config.Audio.PreferBlackHole {
-		if device := ar.deviceMgr.AutoDetectBlackHole(); device != nil {
-			fmt.Printf(" Auto-selected BlackHole device: %s\n", device.Name)
+		if device := ar.deviceMgr.AutoDetectVirtual(ar.config.Audio.PreferDevices); device != nil {
+			fmt.Printf(" Auto-selected virtual device: %s\n", device.Name)
 			return device, nil
 		}
 	}
@@ -142,7 +401,11 @@ func (ar *AudioRelay) selectAudioDevice() (*portaudio.DeviceInfo, error) {
 func (ar *AudioRelay) startProtocolServers() error {
 	// Start TCP server if enabled
 	if ar.config.Protocols.TCP.Enabled {
-		ar.tcpServer = NewTCPServer(ar.config)
+		ar.tcpServer = NewTCPServer(ar.config, ar.clients)
+		ar.tcpServer.onConnect = ar.listenerConnected
+		ar.tcpServer.faults = ar.faults
+		ar.tcpServer.identity = ar.identity
+		ar.tcpServer.capture = ar.audioCapture
 		if err := ar.tcpServer.Start(); err != nil {
 			return fmt.Errorf("failed to start TCP server: %v", err)
 		}
@@ -150,12 +413,35 @@ func (ar *AudioRelay) startProtocolServers() error {
 
 	// Start HTTP server if enabled
 	if ar.config.Protocols.HTTP.Enabled {
-		ar.httpServer = NewHTTPServer(ar.config, ar.webFS, ar.audioCapture)
+		ar.httpServer = NewHTTPServer(ar.config, ar.webFS, ar.audioCapture, ar.events, ar.clients)
+		ar.httpServer.identity = ar.identity
+		ar.httpServer.prefs = ar.clientPrefs
+		ar.httpServer.standbys = ar.standbys
+		ar.httpServer.standby = ar.standby
+		ar.httpServer.stats = ar.stats
+		ar.httpServer.onConnect = ar.listenerConnected
+		ar.httpServer.faults = ar.faults
+		ar.httpServer.ctx = ar.ctx
+		ar.registerAPI(ar.httpServer)
 		if err := ar.httpServer.Start(); err != nil {
 			return fmt.Errorf("failed to start HTTP server: %v", err)
 		}
 	}
 
+	// Start UDP sender if enabled
+	if ar.config.Protocols.UDP.Enabled {
+		ar.udpSender = NewUDPSender(ar.config)
+		ar.udpSender.identity = ar.identity
+		ar.udpSender.capture = ar.audioCapture
+		ar.udpSender.delivery = ar.clients.deliveryWindow("udp")
+		if err := ar.udpSender.Start(); err != nil {
+			return fmt.Errorf("failed to start UDP sender: %v", err)
+		}
+		if ar.httpServer != nil {
+			ar.httpServer.AddDebugInfo("udp", ar.udpSender.debugInfo)
+		}
+	}
+
 	return nil
 }
 
@@ -167,18 +453,71 @@ func (ar *AudioRelay) stopProtocolServers() {
 	if ar.httpServer != nil {
 		ar.httpServer.Stop()
 	}
+	if ar.udpSender != nil {
+		ar.udpSender.Stop()
+	}
+}
+
+// formatChanged bumps the format version and restarts every client stream,
+// announcing the change where the protocol can carry it
+func (ar *AudioRelay) formatChanged(format wavFormat) {
+	if err := ar.identity.update(format); err != nil {
+		log.Printf("Failed to save stream identity: %v", err)
+	}
+	_, version := ar.identity.Snapshot()
+	log.Printf("🔀 Stream format changed to %s (format version %d), restarting client streams", formatKey(format), version)
+
+	if ar.udpSender != nil {
+		ar.udpSender.formatChanged()
+	}
+	if ar.httpServer != nil {
+		ar.httpServer.restartStreams()
+	}
+	if ar.tcpServer != nil {
+		ar.tcpServer.restartClients()
+	}
+
+	ar.events.Publish(EventFormatChange, map[string]interface{}{
+		"sample_rate":     format.SampleRate,
+		"channels":        format.Channels,
+		"bits_per_sample": format.BitsPerSample,
+		"float":           format.Float,
+		"format_version":  version,
+		"reconnect":       true,
+	})
 }
 
 // broadcastAudioData broadcasts audio data to all connected clients
-func (ar *AudioRelay) broadcastAudioData(audioData []byte) {
+func (ar *AudioRelay) broadcastAudioData(audioData []byte, captured time.Time, trace *span) {
 	// Broadcast to TCP clients
 	if ar.tcpServer != nil && ar.config.Protocols.TCP.Enabled {
-		ar.tcpServer.Broadcast(audioData)
+		step := trace.child("broadcast.tcp")
+		ar.tcpServer.Broadcast(audioData, captured)
+		step.finish()
 	}
 
 	// Broadcast to HTTP stream clients
 	if ar.httpServer != nil && ar.config.Protocols.HTTP.Enabled {
-		ar.httpServer.Broadcast(audioData)
+		step := trace.child("broadcast.http")
+		ar.httpServer.Broadcast(audioData, captured)
+		step.finish()
+	}
+
+	// Push to fixed UDP targets
+	if ar.udpSender != nil {
+		step := trace.child("broadcast.udp")
+		ar.udpSender.Broadcast(audioData, captured)
+		step.finish()
+	}
+
+	// Check triggers and collect snapshot audio
+	if ar.triggers != nil {
+		ar.triggers.Feed(audioData)
+	}
+
+	// Hand a copy to analyzers last, off the listeners' path
+	if ar.analysis != nil {
+		ar.analysis.Feed(audioData)
 	}
 }
 
@@ -188,13 +527,40 @@ func (emptyFS) Open(name string) (fs.File, error) {
 	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 }
 
+// Options controls how the service is started
+type Options struct {
+	ConfigPath string         // Configuration file to load
+	SafeMode   bool           // Ignore the config file and run a minimal known-good setup
+	Device     string         // Capture device name or device list index, overriding the config
+	Flags      *pflag.FlagSet // Config key overrides from RegisterConfigFlags, may be nil
+}
+
 // StartWithConfig starts the audio relay service with configuration file
 func StartWithConfig(configPath string) error {
-	// Load configuration
-	config, err := LoadConfig(configPath)
+	return StartWithOptions(Options{ConfigPath: configPath})
+}
+
+// StartWithOptions starts the audio relay service
+func StartWithOptions(opts Options) error {
+	var config *Config
+	var err error
+	if opts.SafeMode {
+		// Start from built-in defaults so a broken config file can't interfere
+		config, err = DefaultConfig()
+		if err == nil {
+			config.ApplySafeMode()
+			err = config.Validate()
+		}
+		fmt.Println("🛟 Safe mode: defaults only, processing disabled, listening on localhost")
+	} else {
+		config, err = LoadConfigWithFlags(opts.ConfigPath, opts.Flags)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to load config: %v", err)
 	}
+	config.ApplyDeviceFlag(opts.Device)
+	verboseLogging.Store(config.Logging.Verbose)
+	debugf("Configuration: %v", configMap(reflect.ValueOf(*config), true))
 
 	// Initialize PortAudio
 	if err := portaudio.Initialize(); err != nil {
@@ -202,14 +568,15 @@ func StartWithConfig(configPath string) error {
 	}
 	defer portaudio.Terminate()
 
-	var webFS fs.FS = emptyFS{}
-
 	// Create and start relay
-	relay := New(config, webFS)
+	relay := New(config, WebFS(config.Protocols.HTTP.WebRoot))
+	if !opts.SafeMode {
+		relay.configPath = opts.ConfigPath
+	}
 
 	// Set up signal handling
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	// Start service
 	fmt.Println("👊Starting Audio Relay Service...")
@@ -217,9 +584,18 @@ func StartWithConfig(configPath string) error {
 		return err
 	}
 
-	// Wait for shutdown signal
-	<-sigChan
-	fmt.Println("\n×Shutting down audio relay...")
+	// Apply edits of the config file without a restart where possible
+	if !opts.SafeMode {
+		if err := relay.watchConfig(opts); err != nil {
+			log.Printf("Config changes will need a restart: %v", err)
+		}
+	}
+
+	// Wait for shutdown signal. Listeners get the drain period to finish;
+	// a second Ctrl-C quits at once.
+	<-ctx.Done()
+	stop()
+	fmt.Println("\n×Shutting down audio relay... (Ctrl-C again to quit immediately)")
 	relay.Stop()
 
 	fmt.Println("√ Service stopped successfully")
@@ -0,0 +1,285 @@
+package audiorelay
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce lets editors finish writing before the file is reloaded
+const reloadDebounce = 500 * time.Millisecond
+
+// hotSettings are the config keys a reload applies to the running relay;
+// everything else only takes effect after a restart
+var hotSettings = map[string]bool{
+	"processing.volume_multiplier": true,
+	"processing.silence_threshold": true,
+	"processing.clip_threshold":    true,
+	"protocols.tcp.prebuffer_ms":   true,
+	"protocols.http.prebuffer_ms":  true,
+	"logging.verbose":              true,
+	"logging.access_log":           true,
+	"processing.mute_channels":     true,
+	"processing.swap_channels":     true,
+	"processing.invert_channels":   true,
+	"processing.stereo_width":      true,
+	"processing.dc_block":          true,
+	"processing.chain":             true,
+}
+
+// hotStageSections are the sections of processing stages a reload rebuilds
+// the processing chain for
+var hotStageSections = []string{
+	"processing.highpass.",
+	"processing.noise_gate.",
+	"processing.mid_side.",
+	"processing.lowpass.",
+	"processing.limiter.",
+}
+
+// channelSettings and stageSettings are the hot settings applied by
+// resetting the channel routing and by rebuilding the processing chain
+var (
+	channelSettings = []string{"processing.mute_channels", "processing.swap_channels", "processing.invert_channels"}
+	stageSettings   = append([]string{"processing.dc_block", "processing.chain"}, hotStageSections...)
+)
+
+// isHotSetting reports whether a reload applies a config key to the
+// running relay
+func isHotSetting(key string) bool {
+	return hotSettings[key] || hasAnyPrefix(key, hotStageSections)
+}
+
+// splitSettings separates changed config keys into hot settings and those
+// that need a restart
+func splitSettings(keys []string) (hot, restart []string) {
+	for _, key := range keys {
+		if isHotSetting(key) {
+			hot = append(hot, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+	return hot, restart
+}
+
+// hasAnyPrefix reports whether s starts with one of prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchConfig reloads the config file whenever it changes, until Stop.
+// The command-line overrides in opts still apply after a reload.
+func (ar *AudioRelay) watchConfig(opts Options) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch config: %v", err)
+	}
+	// Editors often save by replacing the file, so watch its directory
+	path := filepath.Clean(opts.ConfigPath)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch config: %v", err)
+	}
+	ar.configWatcher = watcher
+
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == path && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					debounce = time.After(reloadDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Config watcher error: %v", err)
+			case <-debounce:
+				debounce = nil
+				ar.reloadConfig(opts)
+			}
+		}
+	}()
+	log.Printf("👀 Watching %s for changes", path)
+	return nil
+}
+
+// reloadConfig applies the hot settings of the changed config file and
+// lists the changes that need a restart
+func (ar *AudioRelay) reloadConfig(opts Options) {
+	config, err := LoadConfigWithFlags(opts.ConfigPath, opts.Flags)
+	if err != nil {
+		log.Printf("⚠️ Config reload failed, keeping the running configuration: %v", err)
+		return
+	}
+	config.ApplyDeviceFlag(opts.Device)
+
+	// A standby keeps the settings it mirrors from the primary
+	if ar.standby != nil {
+		if settings := ar.standby.Settings(); settings != nil {
+			settings.apply(config)
+		}
+	}
+
+	applied, restart := ar.applyConfig(config)
+	if len(applied) == 0 && len(restart) == 0 {
+		debugf("Config file changed, no settings differ")
+		return
+	}
+
+	if len(applied) > 0 {
+		log.Printf("🔄 Config reloaded, applied: %s", strings.Join(applied, ", "))
+	}
+	for _, key := range restart {
+		log.Printf("⚠️ %s changed, restart to apply", key)
+	}
+	ar.events.Publish(EventConfigReloaded, map[string]interface{}{
+		"applied":          applied,
+		"restart_required": restart,
+	})
+}
+
+// runningConfig returns a copy of the configuration in effect
+func (ar *AudioRelay) runningConfig() Config {
+	ar.configMu.Lock()
+	defer ar.configMu.Unlock()
+	return *ar.running
+}
+
+// applyConfig applies the hot settings that differ in config, returning
+// them and the changed settings that need a restart
+func (ar *AudioRelay) applyConfig(config *Config) (applied, restart []string) {
+	ar.configMu.Lock()
+	defer ar.configMu.Unlock()
+
+	running := *ar.running
+	applied, restart = splitSettings(changedSettings(reflect.ValueOf(running), reflect.ValueOf(*config), ""))
+	for _, key := range applied {
+		copySetting(reflect.ValueOf(&running).Elem(), reflect.ValueOf(*config), key)
+	}
+	if len(applied) > 0 {
+		ar.running = &running
+		ar.applyHotSettings(config, applied)
+	}
+	return applied, restart
+}
+
+// applyHotSettings hands the applied hot settings to the running relay and
+// its named streams, which share the processing and protocol settings. The
+// components keep them behind their own locks, as their configs are read
+// without one. Channel routing and stereo width are only reset when they
+// changed, so adjustments made through the API survive other changes.
+func (ar *AudioRelay) applyHotSettings(config *Config, applied []string) {
+	levels := newLevelSettings(config.Processing)
+	verboseLogging.Store(config.Logging.Verbose)
+
+	changed := func(keys []string) bool {
+		for _, key := range applied {
+			if hasAnyPrefix(key, keys) {
+				return true
+			}
+		}
+		return false
+	}
+	routing := changed(channelSettings)
+	width := changed([]string{"processing.stereo_width"})
+	stages := changed(stageSettings)
+
+	apply := func(c *Config, capture *AudioCapture, tcp *TCPServer, http *HTTPServer) {
+		capture.setLevelSettings(levels)
+		if routing {
+			if err := capture.SetChannelState(newChannelState(config.Processing, c.Audio.Channels)); err != nil {
+				log.Printf("⚠️ Failed to apply the channel settings: %v", err)
+			}
+		}
+		if width {
+			if err := capture.SetStereoWidth(config.Processing.StereoWidth); err != nil {
+				log.Printf("⚠️ Failed to apply processing.stereo_width: %v", err)
+			}
+		}
+		if stages {
+			p := capture.processingSettings()
+			for _, key := range applied {
+				if hasAnyPrefix(key, stageSettings) {
+					copySetting(reflect.ValueOf(&p).Elem(), reflect.ValueOf(config.Processing), strings.TrimPrefix(key, "processing."))
+				}
+			}
+			capture.setProcessing(p)
+		}
+		if tcp != nil {
+			tcp.history.resize(tcp.streamFormat(), config.Protocols.TCP.PrebufferMs)
+		}
+		if http != nil {
+			http.history.resize(http.wavFormat(), config.Protocols.HTTP.PrebufferMs)
+			http.accessLog.Store(config.Logging.AccessLog)
+		}
+	}
+
+	apply(ar.config, ar.audioCapture, ar.tcpServer, ar.httpServer)
+	for _, stream := range ar.streams {
+		apply(stream.config, stream.capture, stream.tcp, stream.http)
+	}
+}
+
+// copySetting copies the value of a config key, as written in the file,
+// from src to dst
+func copySetting(dst, src reflect.Value, key string) {
+	name, rest, nested := strings.Cut(key, ".")
+	for i := 0; i < dst.NumField(); i++ {
+		if dst.Type().Field(i).Tag.Get("mapstructure") != name {
+			continue
+		}
+		if nested {
+			copySetting(dst.Field(i), src.Field(i), rest)
+		} else {
+			dst.Field(i).Set(src.Field(i))
+		}
+		return
+	}
+}
+
+// sameSetting compares two values of a config key; empty and missing lists
+// are the same, as unmarshalling doesn't tell them apart
+func sameSetting(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Slice, reflect.Map:
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// changedSettings lists the config keys, as written in the file, whose
+// values differ between two configs. Only keys are returned, so changed
+// secrets can be logged and published without their values.
+func changedSettings(old, new reflect.Value, prefix string) []string {
+	var changed []string
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct {
+			changed = append(changed, changedSettings(old.Field(i), new.Field(i), key+".")...)
+			continue
+		}
+		if !sameSetting(old.Field(i), new.Field(i)) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
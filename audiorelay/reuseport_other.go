@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package audiorelay
+
+import (
+	"fmt"
+	"net"
+)
+
+// listenReusePort is unsupported on this platform
+func listenReusePort(addr string) (net.Listener, error) {
+	return nil, fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+}
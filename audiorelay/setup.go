@@ -0,0 +1,219 @@
+package audiorelay
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+	"github.com/spf13/viper"
+)
+
+// setupTestSeconds is how long the wizard shows the live level bar
+const setupTestSeconds = 5
+
+// RunSetup walks a new user through device, port, protocol and format
+// choices and writes the result to configPath. Settings the wizard doesn't
+// ask about keep their current (or default) values.
+func RunSetup(configPath string) error {
+	if err := portaudio.Initialize(); err != nil {
+		return fmt.Errorf("PortAudio initialization failed: %v", err)
+	}
+	defer portaudio.Terminate()
+
+	// Start from the existing file so hand-tuned settings survive
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(configPath)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err == nil {
+		fmt.Printf("Updating existing configuration: %s\n", configPath)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("🧙 Audio Relay setup")
+
+	// Device, with a capture test so users can tell they picked the right one
+	deviceMgr := NewDeviceManager()
+	if err := deviceMgr.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize device manager: %v", err)
+	}
+	var device *portaudio.DeviceInfo
+	for {
+		selected, err := deviceMgr.SelectInputDevice()
+		if err != nil {
+			return err
+		}
+		channels := min(2, selected.MaxInputChannels)
+		if err := testCapture(selected, channels); err != nil {
+			fmt.Printf("Capture test failed: %v\n", err)
+		}
+		if promptYesNo(reader, "Use this device?", true) {
+			device = selected
+			break
+		}
+	}
+	v.Set("audio.device_name", device.Name)
+
+	// Formats
+	v.Set("audio.sample_rate", promptFloat(reader, "Sample rate (Hz)", device.DefaultSampleRate))
+	v.Set("audio.channels", promptInt(reader, "Channels", min(2, device.MaxInputChannels), 1, device.MaxInputChannels))
+	v.Set("audio.sample_format", promptChoice(reader, "Capture format", v.GetString("audio.sample_format"),
+		[]string{"int16", "int24", "float32"}))
+
+	// Protocols and ports
+	tcp := promptYesNo(reader, "Enable TCP (raw PCM)?", v.GetBool("protocols.tcp.enabled"))
+	v.Set("protocols.tcp.enabled", tcp)
+	if tcp {
+		v.Set("server.port", strconv.Itoa(promptInt(reader, "TCP port", v.GetInt("server.port"), 1, 65535)))
+	}
+	http := promptYesNo(reader, "Enable HTTP (WAV stream and web page)?", v.GetBool("protocols.http.enabled"))
+	v.Set("protocols.http.enabled", http)
+	if http {
+		v.Set("server.http_port", strconv.Itoa(promptInt(reader, "HTTP port", v.GetInt("server.http_port"), 1, 65535)))
+	}
+	if !tcp && !http {
+		fmt.Println("Warning: no protocol enabled, nobody will be able to listen")
+	}
+
+	// Make sure the result actually loads before writing it
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return fmt.Errorf("failed to build config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid settings: %v", err)
+	}
+
+	if !promptYesNo(reader, fmt.Sprintf("Write %s?", configPath), true) {
+		return fmt.Errorf("setup cancelled, nothing written")
+	}
+	if err := v.WriteConfigAs(configPath); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	fmt.Printf("√ Configuration written to %s\n", configPath)
+	return nil
+}
+
+// testCapture shows a live peak level bar for the device
+func testCapture(device *portaudio.DeviceInfo, channels int) error {
+	buffer := make([]int16, 1024*channels)
+	stream, err := portaudio.OpenStream(
+		portaudio.StreamParameters{
+			Input: portaudio.StreamDeviceParameters{
+				Device:   device,
+				Channels: channels,
+				Latency:  device.DefaultLowInputLatency,
+			},
+			SampleRate:      device.DefaultSampleRate,
+			FramesPerBuffer: 1024,
+		},
+		buffer,
+	)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := stream.Start(); err != nil {
+		return err
+	}
+	defer stream.Stop()
+
+	fmt.Printf("Make some noise... (testing for %d seconds)\n", setupTestSeconds)
+	deadline := time.Now().Add(setupTestSeconds * time.Second)
+	maxPeak := 0.0
+	for time.Now().Before(deadline) {
+		if err := stream.Read(); err != nil {
+			return err
+		}
+
+		peak := 0.0
+		for _, sample := range buffer {
+			peak = math.Max(peak, math.Abs(float64(sample)))
+		}
+		maxPeak = math.Max(maxPeak, peak)
+		fmt.Printf("\r%s %6.1f dBFS", levelBar(levelDB(peak), 40), levelDB(peak))
+	}
+	fmt.Printf("\rLoudest peak: %.1f dBFS%s\n", levelDB(maxPeak), strings.Repeat(" ", 40))
+
+	if maxPeak == 0 {
+		fmt.Println("Warning: only digital silence was captured")
+	}
+	return nil
+}
+
+// levelBar renders a dBFS level (-60..0) as a text meter
+func levelBar(db float64, width int) string {
+	filled := int(math.Round((db + 60) / 60 * float64(width)))
+	filled = max(0, min(width, filled))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// prompt asks a question and returns the answer, or def for an empty answer
+func prompt(reader *bufio.Reader, question, def string) string {
+	fmt.Printf("%s [%s]: ", question, def)
+	input, _ := reader.ReadString('\n')
+	if input = strings.TrimSpace(input); input != "" {
+		return input
+	}
+	return def
+}
+
+// promptYesNo asks a yes/no question
+func promptYesNo(reader *bufio.Reader, question string, def bool) bool {
+	defText := "y/N"
+	if def {
+		defText = "Y/n"
+	}
+	for {
+		switch strings.ToLower(prompt(reader, question, defText)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case strings.ToLower(defText):
+			return def
+		}
+		fmt.Println("Please answer y or n")
+	}
+}
+
+// promptInt asks for an integer within [lo, hi]
+func promptInt(reader *bufio.Reader, question string, def, lo, hi int) int {
+	for {
+		value, err := strconv.Atoi(prompt(reader, question, strconv.Itoa(def)))
+		if err == nil && value >= lo && value <= hi {
+			return value
+		}
+		fmt.Printf("Please enter a number between %d and %d\n", lo, hi)
+	}
+}
+
+// promptFloat asks for a positive number
+func promptFloat(reader *bufio.Reader, question string, def float64) float64 {
+	for {
+		value, err := strconv.ParseFloat(prompt(reader, question, strconv.FormatFloat(def, 'f', -1, 64)), 64)
+		if err == nil && value > 0 {
+			return value
+		}
+		fmt.Println("Please enter a positive number")
+	}
+}
+
+// promptChoice asks for one of a fixed set of options
+func promptChoice(reader *bufio.Reader, question, def string, options []string) string {
+	for {
+		answer := prompt(reader, question+" ("+strings.Join(options, "/")+")", def)
+		for _, option := range options {
+			if strings.EqualFold(answer, option) {
+				return option
+			}
+		}
+		fmt.Printf("Please choose one of: %s\n", strings.Join(options, ", "))
+	}
+}
@@ -0,0 +1,345 @@
+package audiorelay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// shareIDBytes is the randomness in a share link, which is its only secret
+// when no PIN is set
+const shareIDBytes = 16
+
+// maxPINFailures revokes a share after this many wrong PINs, so short PINs
+// can't be guessed
+const maxPINFailures = 10
+
+// ShareConfig controls public listening links
+type ShareConfig struct {
+	Enabled              bool    `mapstructure:"enabled" desc:"Allow creating share links via /api/v1/shares"`
+	DefaultExpiryMinutes float64 `mapstructure:"default_expiry_minutes" desc:"Lifetime of a share link unless the request sets one"`
+	MaxExpiryMinutes     float64 `mapstructure:"max_expiry_minutes" desc:"Longest lifetime a share link may have"`
+}
+
+// Share is a link to a listening page for one stream
+type Share struct {
+	ID      string
+	Stream  string // Named stream, empty for the main stream
+	Created time.Time
+	Expires time.Time
+
+	pin      string
+	failures int // Wrong PINs so far, guarded by the store's mutex
+}
+
+// ShareInfo is a view of a share for the API; the PIN is never returned
+type ShareInfo struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Stream  string    `json:"stream,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	HasPIN  bool      `json:"has_pin"`
+}
+
+// Info returns the API view of the share
+func (s *Share) Info() ShareInfo {
+	return ShareInfo{
+		ID:      s.ID,
+		URL:     "/share/" + s.ID,
+		Stream:  s.Stream,
+		Created: s.Created,
+		Expires: s.Expires,
+		HasPIN:  s.pin != "",
+	}
+}
+
+// ShareStore keeps the share links in memory; they end with the process
+type ShareStore struct {
+	config   *Config
+	shares   map[string]*Share
+	sharesMu sync.Mutex
+}
+
+// NewShareStore creates an empty share store
+func NewShareStore(config *Config) *ShareStore {
+	return &ShareStore{
+		config: config,
+		shares: make(map[string]*Share),
+	}
+}
+
+// Create adds a share for stream, valid for expiry (0 for the default)
+func (ss *ShareStore) Create(stream, pin string, expiry time.Duration) (*Share, error) {
+	maxExpiry := time.Duration(ss.config.Share.MaxExpiryMinutes * float64(time.Minute))
+	if expiry == 0 {
+		expiry = time.Duration(ss.config.Share.DefaultExpiryMinutes * float64(time.Minute))
+	}
+	if expiry < 0 || expiry > maxExpiry {
+		return nil, fmt.Errorf("expiry must be between 0 and %.0f minutes", maxExpiry.Minutes())
+	}
+
+	id := make([]byte, shareIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate share ID: %v", err)
+	}
+	now := time.Now()
+	share := &Share{
+		ID:      base64.RawURLEncoding.EncodeToString(id),
+		Stream:  stream,
+		Created: now,
+		Expires: now.Add(expiry),
+		pin:     pin,
+	}
+
+	ss.sharesMu.Lock()
+	defer ss.sharesMu.Unlock()
+	ss.shares[share.ID] = share
+	return share, nil
+}
+
+// Get looks up a share that hasn't expired
+func (ss *ShareStore) Get(id string) (*Share, bool) {
+	ss.sharesMu.Lock()
+	defer ss.sharesMu.Unlock()
+	ss.dropExpired()
+	share, ok := ss.shares[id]
+	return share, ok
+}
+
+// Delete revokes a share
+func (ss *ShareStore) Delete(id string) bool {
+	ss.sharesMu.Lock()
+	defer ss.sharesMu.Unlock()
+	_, ok := ss.shares[id]
+	delete(ss.shares, id)
+	return ok
+}
+
+// List returns the active shares, newest first
+func (ss *ShareStore) List() []ShareInfo {
+	ss.sharesMu.Lock()
+	defer ss.sharesMu.Unlock()
+	ss.dropExpired()
+
+	infos := make([]ShareInfo, 0, len(ss.shares))
+	for _, share := range ss.shares {
+		infos = append(infos, share.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.After(infos[j].Created)
+	})
+	return infos
+}
+
+// CheckPIN reports whether pin unlocks the share. Too many wrong PINs
+// revoke it.
+func (ss *ShareStore) CheckPIN(share *Share, pin string) bool {
+	if share.pin == "" {
+		return true
+	}
+	if subtle.ConstantTimeCompare([]byte(share.pin), []byte(pin)) == 1 {
+		return true
+	}
+	if pin == "" {
+		// Opening the page before entering a PIN is no guess
+		return false
+	}
+
+	ss.sharesMu.Lock()
+	defer ss.sharesMu.Unlock()
+	share.failures++
+	if share.failures >= maxPINFailures {
+		delete(ss.shares, share.ID)
+		log.Printf("🔗 Share %s revoked after %d wrong PINs", share.ID, share.failures)
+	}
+	return false
+}
+
+// dropExpired forgets expired shares; the caller holds sharesMu
+func (ss *ShareStore) dropExpired() {
+	now := time.Now()
+	for id, share := range ss.shares {
+		if now.After(share.Expires) {
+			delete(ss.shares, id)
+		}
+	}
+}
+
+// shareRequest creates a share link
+type shareRequest struct {
+	Stream         string  `json:"stream"`
+	PIN            string  `json:"pin"`
+	ExpiresMinutes float64 `json:"expires_minutes"`
+}
+
+// handleCreateShare creates a share link for the main or a named stream
+func (ar *AudioRelay) handleCreateShare(w http.ResponseWriter, r *http.Request) {
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Stream != "" && !ar.config.hasStream(req.Stream) {
+		writeJSONError(w, http.StatusNotFound, "stream not found")
+		return
+	}
+
+	share, err := ar.shares.Create(req.Stream, req.PIN, time.Duration(req.ExpiresMinutes*float64(time.Minute)))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("🔗 Share created for %s, expires %s", share.title(), share.Expires.Format(time.DateTime))
+	writeJSON(w, http.StatusCreated, share.Info())
+}
+
+// handleListShares returns the active share links
+func (ar *AudioRelay) handleListShares(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"shares": ar.shares.List()})
+}
+
+// handleDeleteShare revokes a share link
+func (ar *AudioRelay) handleDeleteShare(w http.ResponseWriter, r *http.Request) {
+	if !ar.shares.Delete(r.PathValue("id")) {
+		writeJSONError(w, http.StatusNotFound, "share not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// title names the shared stream on its page
+func (s *Share) title() string {
+	if s.Stream == "" {
+		return "Audio Relay"
+	}
+	return s.Stream
+}
+
+// sharePage is the listening page behind a share link
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Title}}</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <style>
+        body {
+            font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            box-sizing: border-box;
+        }
+        .card {
+            max-width: 480px;
+            margin: 10vh auto 0;
+            background: white;
+            padding: 30px;
+            border-radius: 15px;
+            box-shadow: 0 10px 30px rgba(0,0,0,0.2);
+            text-align: center;
+        }
+        h1 { color: #333; margin-top: 0; }
+        audio { width: 100%; margin: 20px 0; }
+        input, button { font-size: 1.1em; padding: 8px 12px; border-radius: 8px; border: 1px solid #ccc; }
+        button { background: #667eea; color: white; border: none; cursor: pointer; }
+        .note { color: #666; font-size: 0.9em; }
+        .error { color: #c0392b; }
+    </style>
+</head>
+<body>
+    <div class="card">
+        <h1>🎧 {{.Title}}</h1>
+        {{if .NeedPIN}}
+        <form method="get">
+            <p>Enter the PIN to listen.</p>
+            {{if .WrongPIN}}<p class="error">Wrong PIN</p>{{end}}
+            <input name="pin" type="password" inputmode="numeric" autocomplete="off" autofocus>
+            <button type="submit">Listen</button>
+        </form>
+        {{else}}
+        <audio controls autoplay preload="none" src="{{.StreamURL}}"></audio>
+        {{end}}
+        <p class="note">This link expires {{.Expires}}</p>
+    </div>
+</body>
+</html>
+`))
+
+// handleSharePage renders the listening page, or the PIN form
+func (ar *AudioRelay) handleSharePage(w http.ResponseWriter, r *http.Request) {
+	share, ok := ar.shares.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "This link has expired or does not exist", http.StatusNotFound)
+		return
+	}
+
+	pin := r.URL.Query().Get("pin")
+	unlocked := ar.shares.CheckPIN(share, pin)
+	streamURL := "/share/" + share.ID + "/stream.wav"
+	if share.pin != "" {
+		streamURL += "?" + url.Values{"pin": {pin}}.Encode()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	sharePage.Execute(w, map[string]interface{}{
+		"Title":     share.title(),
+		"NeedPIN":   !unlocked,
+		"WrongPIN":  !unlocked && pin != "",
+		"StreamURL": streamURL,
+		"Expires":   share.Expires.Format("2006-01-02 15:04 MST"),
+	})
+}
+
+// handleShareStream plays the shared stream until the share expires
+func (ar *AudioRelay) handleShareStream(w http.ResponseWriter, r *http.Request) {
+	share, ok := ar.shares.Get(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !ar.shares.CheckPIN(share, r.URL.Query().Get("pin")) {
+		http.Error(w, "wrong PIN", http.StatusForbidden)
+		return
+	}
+
+	hs := ar.httpServer
+	if share.Stream != "" {
+		stream, ok := ar.streams[share.Stream]
+		if !ok || stream.http == nil {
+			http.NotFound(w, r)
+			return
+		}
+		hs = stream.http
+	}
+
+	// Listeners are cut off when the link expires
+	ctx, cancel := context.WithDeadline(r.Context(), share.Expires)
+	defer cancel()
+	hs.handleWavStream(w, r.WithContext(ctx))
+}
+
+// hasStream reports whether a named stream is configured
+func (c *Config) hasStream(name string) bool {
+	for _, s := range c.Streams {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
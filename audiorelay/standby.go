@@ -0,0 +1,516 @@
+package audiorelay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Standby timing
+const (
+	standbyRequestTimeout  = 5 * time.Second // Registration requests to the primary
+	standbyExpiryIntervals = 3               // Missed syncs before a standby is dropped
+)
+
+// StandbyConfig makes this relay a hot standby of another instance: it
+// mirrors the primary's stream settings and relays its audio, and serves
+// its own capture if the primary goes away
+type StandbyConfig struct {
+	Primary      string  `mapstructure:"primary" desc:"Base URL of the primary relay, e.g. http://192.168.1.10:8080; empty runs as a primary"`
+	AdvertiseURL string  `mapstructure:"advertise_url" desc:"Base URL listeners use to reach this standby; empty uses this host's address as seen by the primary"`
+	SyncSeconds  float64 `mapstructure:"sync_seconds" desc:"How often the standby registers with the primary and mirrors its settings"`
+	Token        string  `mapstructure:"token" secret:"true" desc:"Token sent to the primary when it requires authentication; it must be accepted for streams and admin"`
+}
+
+// Enabled reports whether this relay runs as a standby
+func (s StandbyConfig) Enabled() bool {
+	return s.Primary != ""
+}
+
+// standbyRequest is what a standby sends when it registers
+type standbyRequest struct {
+	URL         string  `json:"url,omitempty"` // Empty to use the request's address and HTTPPort
+	HTTPPort    string  `json:"http_port"`
+	HTTPS       bool    `json:"https,omitempty"`
+	SyncSeconds float64 `json:"sync_seconds"`
+}
+
+// standbySettings are the primary's settings a standby mirrors, so its
+// stream has the same format and sounds the same when it takes over
+type standbySettings struct {
+	SampleRate      float64
+	Channels        int
+	SampleFormat    string
+	OutputBitDepth  int
+	Processing      ProcessingConfig
+	TCPPrebufferMs  float64
+	HTTPPrebufferMs float64
+}
+
+// newStandbySettings returns the mirrored settings of a config
+func newStandbySettings(config *Config) standbySettings {
+	return standbySettings{
+		SampleRate:      config.Audio.SampleRate,
+		Channels:        config.Audio.Channels,
+		SampleFormat:    config.Audio.SampleFormat,
+		OutputBitDepth:  config.Audio.OutputBitDepth,
+		Processing:      config.Processing,
+		TCPPrebufferMs:  config.Protocols.TCP.PrebufferMs,
+		HTTPPrebufferMs: config.Protocols.HTTP.PrebufferMs,
+	}
+}
+
+// apply overwrites the mirrored settings of config
+func (s standbySettings) apply(config *Config) {
+	config.Audio.SampleRate = s.SampleRate
+	config.Audio.Channels = s.Channels
+	config.Audio.SampleFormat = s.SampleFormat
+	config.Audio.OutputBitDepth = s.OutputBitDepth
+	config.Processing = s.Processing
+	config.Protocols.TCP.PrebufferMs = s.TCPPrebufferMs
+	config.Protocols.HTTP.PrebufferMs = s.HTTPPrebufferMs
+}
+
+// StandbyRegistry tracks the standbys registered with this relay, which
+// listeners are told about so players can fail over
+type StandbyRegistry struct {
+	standbys   map[string]time.Time // Base URL to registration expiry
+	standbysMu sync.Mutex
+}
+
+// NewStandbyRegistry creates an empty standby registry
+func NewStandbyRegistry() *StandbyRegistry {
+	return &StandbyRegistry{
+		standbys: make(map[string]time.Time),
+	}
+}
+
+// register adds or renews a standby, reporting whether it is new
+func (sr *StandbyRegistry) register(url string, ttl time.Duration) bool {
+	sr.standbysMu.Lock()
+	defer sr.standbysMu.Unlock()
+	expires, ok := sr.standbys[url]
+	sr.standbys[url] = time.Now().Add(ttl)
+	return !ok || time.Now().After(expires)
+}
+
+// URLs returns the base URLs of the standbys whose registration is current
+func (sr *StandbyRegistry) URLs() []string {
+	if sr == nil {
+		return nil
+	}
+	sr.standbysMu.Lock()
+	defer sr.standbysMu.Unlock()
+
+	urls := make([]string, 0, len(sr.standbys))
+	for url, expires := range sr.standbys {
+		if time.Now().After(expires) {
+			delete(sr.standbys, url)
+			continue
+		}
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// setHeaders adds the standby URLs to a stream response
+func (sr *StandbyRegistry) setHeaders(w http.ResponseWriter) {
+	if urls := sr.URLs(); len(urls) > 0 {
+		w.Header().Set("X-Standby-Url", strings.Join(urls, ", "))
+	}
+}
+
+// handleRegisterStandby registers or renews a standby and returns the
+// settings it should mirror
+func (ar *AudioRelay) handleRegisterStandby(w http.ResponseWriter, r *http.Request) {
+	var req standbyRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.SyncSeconds <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "sync_seconds must be positive")
+		return
+	}
+
+	base := req.URL
+	if base == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || req.HTTPPort == "" {
+			writeJSONError(w, http.StatusBadRequest, "url or http_port is required")
+			return
+		}
+		scheme := "http"
+		if req.HTTPS {
+			scheme = "https"
+		}
+		base = scheme + "://" + net.JoinHostPort(host, req.HTTPPort)
+	}
+	if err := checkBaseURL(base); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ttl := time.Duration(req.SyncSeconds * standbyExpiryIntervals * float64(time.Second))
+	if ar.standbys.register(base, ttl) {
+		log.Printf("🛟 Standby registered: %s", base)
+	}
+	running := ar.runningConfig()
+	writeJSON(w, http.StatusOK, newStandbySettings(&running))
+}
+
+// mirrorPrimary applies the settings a standby received from its primary,
+// returning the hot settings applied and the changes that need a restart
+func (ar *AudioRelay) mirrorPrimary(settings standbySettings) ([]string, []string) {
+	config := ar.runningConfig()
+	settings.apply(&config)
+	return ar.applyConfig(&config)
+}
+
+// handleGetStandby reports this relay's standbys, and its primary if it is
+// a standby itself
+func (ar *AudioRelay) handleGetStandby(w http.ResponseWriter, r *http.Request) {
+	info := map[string]interface{}{
+		"standbys": ar.standbys.URLs(),
+	}
+	if ar.standby != nil {
+		info["standby"] = ar.standby.info()
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// checkBaseURL validates the base URL of a relay
+func checkBaseURL(base string) error {
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid relay URL %q, expected e.g. http://192.168.1.10:8080", base)
+	}
+	return nil
+}
+
+// StandbyMirror keeps a standby in step with its primary: it registers
+// periodically, mirrors the primary's settings and relays its stream.
+// While the primary's stream is live, local capture is held back.
+type StandbyMirror struct {
+	config   StandbyConfig
+	httpPort string
+	https    bool
+	events   *EventBus
+	client   *http.Client
+
+	format    func() wavFormat                                  // Format the relay broadcasts
+	broadcast func([]byte, time.Time)                           // Sends audio to the relay's listeners
+	mirror    func(standbySettings) (applied, restart []string) // Applies the primary's settings
+
+	settings atomic.Pointer[standbySettings] // Last settings received
+	live     atomic.Bool                     // Relaying the primary's stream
+	since    atomic.Int64                    // Unix nanoseconds of the last switch
+	lastErr  atomic.Pointer[string]
+
+	// Cancels the running stream request, so a failed sync fails over
+	// without waiting for a dead connection to time out
+	cancel   context.CancelFunc
+	cancelMu sync.Mutex
+
+	pendingRestart string // Restart-only settings last reported, to log changes once
+	stop           chan struct{}
+	wg             sync.WaitGroup
+}
+
+// NewStandbyMirror creates the standby side of a relay
+func NewStandbyMirror(config *Config, events *EventBus, format func() wavFormat, broadcast func([]byte, time.Time),
+	mirror func(standbySettings) ([]string, []string)) *StandbyMirror {
+	sm := &StandbyMirror{
+		config:    config.Standby,
+		httpPort:  config.Server.HttpPort,
+		https:     config.Protocols.HTTP.TLS.Enabled,
+		events:    events,
+		client:    &http.Client{Timeout: standbyRequestTimeout},
+		format:    format,
+		broadcast: broadcast,
+		mirror:    mirror,
+		stop:      make(chan struct{}),
+	}
+	sm.since.Store(time.Now().UnixNano())
+	return sm
+}
+
+// Start registers with the primary and starts relaying its stream
+func (sm *StandbyMirror) Start() {
+	log.Printf("🛟 Running as hot standby of %s", sm.config.Primary)
+	sm.wg.Add(2)
+	go sm.syncLoop()
+	go sm.streamLoop()
+}
+
+// Stop ends the mirror
+func (sm *StandbyMirror) Stop() {
+	close(sm.stop)
+	sm.cancelStream()
+	sm.wg.Wait()
+}
+
+// Settings returns the last settings received from the primary, or nil
+func (sm *StandbyMirror) Settings() *standbySettings {
+	return sm.settings.Load()
+}
+
+// localAudio is the local capture's data callback: its audio is only
+// broadcast while the primary's stream is down
+func (sm *StandbyMirror) localAudio(data []byte, captured time.Time) {
+	if !sm.live.Load() {
+		sm.broadcast(data, captured)
+	}
+}
+
+// interval returns the sync interval
+func (sm *StandbyMirror) interval() time.Duration {
+	return time.Duration(sm.config.SyncSeconds * float64(time.Second))
+}
+
+// syncLoop registers with the primary every interval until Stop
+func (sm *StandbyMirror) syncLoop() {
+	defer sm.wg.Done()
+	ticker := time.NewTicker(sm.interval())
+	defer ticker.Stop()
+
+	reachable := true
+	for {
+		if err := sm.sync(); err != nil {
+			if reachable {
+				log.Printf("⚠️ Standby: primary %s unreachable: %v", sm.config.Primary, err)
+			}
+			reachable = false
+			sm.cancelStream()
+		} else {
+			if !reachable {
+				log.Printf("🛟 Standby: primary %s reachable again", sm.config.Primary)
+			}
+			reachable = true
+		}
+
+		select {
+		case <-sm.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// authorize adds the standby's token to a request to the primary
+func (sm *StandbyMirror) authorize(req *http.Request) {
+	if sm.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+sm.config.Token)
+	}
+}
+
+// sync registers with the primary and mirrors the settings it returns
+func (sm *StandbyMirror) sync() error {
+	body, _ := json.Marshal(standbyRequest{
+		URL:         sm.config.AdvertiseURL,
+		HTTPPort:    sm.httpPort,
+		HTTPS:       sm.https,
+		SyncSeconds: sm.config.SyncSeconds,
+	})
+	req, err := http.NewRequest(http.MethodPost, sm.config.Primary+"/api/v1/standby", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sm.authorize(req)
+	resp, err := sm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("registration failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var settings standbySettings
+	if err := json.NewDecoder(resp.Body).Decode(&settings); err != nil {
+		return fmt.Errorf("invalid registration response: %v", err)
+	}
+	sm.settings.Store(&settings)
+
+	applied, restart := sm.mirror(settings)
+	if len(applied) > 0 {
+		log.Printf("🔄 Mirrored from primary: %s", strings.Join(applied, ", "))
+	}
+	if pending := strings.Join(restart, ", "); pending != sm.pendingRestart {
+		sm.pendingRestart = pending
+		if pending != "" {
+			log.Printf("⚠️ Primary uses different %s, restart this standby to apply", pending)
+		}
+	}
+	if len(applied) > 0 || len(restart) > 0 {
+		sm.events.Publish(EventConfigReloaded, map[string]interface{}{
+			"source":           "primary",
+			"applied":          applied,
+			"restart_required": restart,
+		})
+	}
+	return nil
+}
+
+// streamLoop relays the primary's stream, reconnecting every interval
+// while it is down, until Stop
+func (sm *StandbyMirror) streamLoop() {
+	defer sm.wg.Done()
+	for {
+		err := sm.relayStream()
+		select {
+		case <-sm.stop:
+			return
+		default:
+		}
+		sm.setLive(false, err)
+
+		select {
+		case <-sm.stop:
+			return
+		case <-time.After(sm.interval()):
+		}
+	}
+}
+
+// relayStream connects to the primary's stream and broadcasts it until the
+// connection ends
+func (sm *StandbyMirror) relayStream() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	sm.cancelMu.Lock()
+	sm.cancel = cancel
+	sm.cancelMu.Unlock()
+	defer sm.cancelStream()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sm.config.Primary+"/stream.wav?client=standby", nil)
+	if err != nil {
+		return err
+	}
+	sm.authorize(req)
+	// The stream is endless, so it can't have a timeout; a failed sync cancels it
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stream request failed: %s", resp.Status)
+	}
+
+	got, _, err := readWAVHeader(resp.Body)
+	if err != nil {
+		return err
+	}
+	want := sm.format()
+	upmixed := got != want && got.Channels == 2 && want.Channels == 1 &&
+		got.SampleRate == want.SampleRate && got.BitsPerSample == want.BitsPerSample && got.Float == want.Float
+	if got != want && !upmixed {
+		return fmt.Errorf("primary streams %s but this relay is set up for %s; restart it to mirror the primary's format",
+			formatKey(got), formatKey(want))
+	}
+
+	// Relay whole frames, in blocks of about 20 ms
+	frames := max(1, got.SampleRate/50)
+	buf := make([]byte, frames*got.blockAlign())
+	for {
+		n, err := io.ReadAtLeast(resp.Body, buf, got.blockAlign())
+		if n -= n % got.blockAlign(); n > 0 {
+			sm.setLive(true, nil)
+			data := append([]byte(nil), buf[:n]...)
+			if upmixed {
+				// Both channels carry the same audio, keep one
+				data = firstChannel(data, got.bytesPerSample())
+			}
+			// Latency is counted from when the primary's audio arrived
+			sm.broadcast(data, time.Now())
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("primary unreachable")
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return fmt.Errorf("primary ended the stream")
+			}
+			return err
+		}
+	}
+}
+
+// cancelStream ends the running stream request, if any
+func (sm *StandbyMirror) cancelStream() {
+	sm.cancelMu.Lock()
+	defer sm.cancelMu.Unlock()
+	if sm.cancel != nil {
+		sm.cancel()
+		sm.cancel = nil
+	}
+}
+
+// setLive switches between relaying the primary and serving local capture
+func (sm *StandbyMirror) setLive(live bool, err error) {
+	if err != nil {
+		msg := err.Error()
+		sm.lastErr.Store(&msg)
+	}
+	if sm.live.Swap(live) == live {
+		return
+	}
+	sm.since.Store(time.Now().UnixNano())
+
+	data := map[string]interface{}{
+		"primary": sm.config.Primary,
+		"serving": sm.serving(),
+	}
+	if live {
+		log.Printf("🛟 Standby: relaying the primary's stream")
+	} else {
+		log.Printf("⚠️ Standby: lost the primary's stream (%v), serving local capture", err)
+		data["error"] = err.Error()
+	}
+	sm.events.Publish(EventStandby, data)
+}
+
+// serving names the audio source listeners currently hear
+func (sm *StandbyMirror) serving() string {
+	if sm.live.Load() {
+		return "primary"
+	}
+	return "local"
+}
+
+// info returns the standby state as reported in /status
+func (sm *StandbyMirror) info() map[string]interface{} {
+	info := map[string]interface{}{
+		"primary": sm.config.Primary,
+		"serving": sm.serving(),
+		"since":   time.Unix(0, sm.since.Load()),
+	}
+	if msg := sm.lastErr.Load(); msg != nil && !sm.live.Load() {
+		info["error"] = *msg
+	}
+	return info
+}
+
+// firstChannel keeps the first channel of two-channel interleaved audio
+func firstChannel(data []byte, bytesPerSample int) []byte {
+	frame := 2 * bytesPerSample
+	out := make([]byte, 0, len(data)/2)
+	for i := 0; i+frame <= len(data); i += frame {
+		out = append(out, data[i:i+bytesPerSample]...)
+	}
+	return out
+}
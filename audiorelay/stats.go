@@ -0,0 +1,140 @@
+package audiorelay
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statsInterval is the length of one sample of the statistics history
+const statsInterval = time.Minute
+
+// StatsSample is the relay's traffic during one interval, with the silence
+// of the main stream
+type StatsSample struct {
+	Time            time.Time `json:"time"` // End of the interval
+	SentBytes       int64     `json:"sent_bytes"`
+	SentKbps        float64   `json:"sent_kbps"` // Average to all listeners together
+	Clients         int       `json:"clients"`   // Connected at the end of the interval
+	PeakClients     int       `json:"peak_clients"`
+	DroppedFrames   int64     `json:"dropped_frames"`
+	CapturedBuffers int64     `json:"captured_buffers"` // 0 while capture was idle or down
+	SilencePercent  float64   `json:"silence_percent"`  // Of the captured buffers
+}
+
+// statsCounters are the running totals samples are the differences of
+type statsCounters struct {
+	sent, dropped, buffers, silent int64
+}
+
+// StatsHistory keeps a ring of per-interval statistics, so the web UI can
+// draw recent trends without an external metrics stack
+type StatsHistory struct {
+	interval time.Duration
+	capture  *AudioCapture
+	clients  *ClientRegistry
+
+	mu      sync.RWMutex
+	samples []StatsSample // Ring, oldest at next once full
+	next    int
+	last    statsCounters
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewStatsHistory creates a history of the last size intervals
+func NewStatsHistory(size int, capture *AudioCapture, clients *ClientRegistry) *StatsHistory {
+	return &StatsHistory{
+		interval: statsInterval,
+		capture:  capture,
+		clients:  clients,
+		samples:  make([]StatsSample, 0, size),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start takes a sample every interval until Stop
+func (sh *StatsHistory) Start() {
+	sh.last = sh.counters()
+	go sh.run()
+}
+
+// Stop ends sampling
+func (sh *StatsHistory) Stop() {
+	close(sh.stop)
+	<-sh.done
+}
+
+// run takes the samples
+func (sh *StatsHistory) run() {
+	defer close(sh.done)
+	ticker := time.NewTicker(sh.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sh.stop:
+			return
+		case <-ticker.C:
+			sh.sample()
+		}
+	}
+}
+
+// counters reads the running totals
+func (sh *StatsHistory) counters() statsCounters {
+	sent, dropped := sh.clients.totals()
+	buffers, silent := sh.capture.bufferCounts()
+	return statsCounters{sent: sent, dropped: dropped, buffers: buffers, silent: silent}
+}
+
+// sample adds the statistics of the interval that just ended
+func (sh *StatsHistory) sample() {
+	now := sh.counters()
+	clients, peak := sh.clients.takePeak()
+	s := StatsSample{
+		Time:            time.Now().UTC().Truncate(time.Second),
+		SentBytes:       now.sent - sh.last.sent,
+		Clients:         clients,
+		PeakClients:     peak,
+		DroppedFrames:   now.dropped - sh.last.dropped,
+		CapturedBuffers: now.buffers - sh.last.buffers,
+	}
+	s.SentKbps = math.Round(float64(s.SentBytes)*8/1000/sh.interval.Seconds()*10) / 10
+	if s.CapturedBuffers > 0 {
+		s.SilencePercent = math.Round(float64(now.silent-sh.last.silent)/float64(s.CapturedBuffers)*1000) / 10
+	}
+	sh.last = now
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if len(sh.samples) < cap(sh.samples) {
+		sh.samples = append(sh.samples, s)
+		return
+	}
+	sh.samples[sh.next] = s
+	sh.next = (sh.next + 1) % len(sh.samples)
+}
+
+// Samples returns the history, oldest first
+func (sh *StatsHistory) Samples() []StatsSample {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	samples := make([]StatsSample, 0, len(sh.samples))
+	samples = append(samples, sh.samples[sh.next:]...)
+	return append(samples, sh.samples[:sh.next]...)
+}
+
+// handleStats serves the statistics history of the main stream
+func (hs *HTTPServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if hs.stats == nil {
+		writeJSONError(w, http.StatusNotFound, "statistics history is disabled (server.stats_minutes is 0)")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"interval_seconds": hs.stats.interval.Seconds(),
+		"samples":          hs.stats.Samples(),
+	})
+}
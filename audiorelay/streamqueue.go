@@ -0,0 +1,84 @@
+package audiorelay
+
+import (
+	"sync"
+	"time"
+)
+
+// chunkQueue is a bounded ring of audio chunks between the broadcast loop
+// and one listener's writer. When the listener falls behind, the oldest
+// audio is dropped so the broadcast never waits for it.
+type chunkQueue struct {
+	mu     sync.Mutex
+	chunks [][]byte    // Ring storage, grown as needed
+	times  []time.Time // When each chunk was captured, for delivery latency
+	head   int         // Index of the oldest chunk
+	count  int
+	size   int // Bytes queued
+	limit  int // Maximum bytes queued; the newest chunk is always kept
+
+	ready chan struct{} // Signalled when a chunk is pushed
+}
+
+// newChunkQueue creates a queue holding up to limit bytes
+func newChunkQueue(limit int) *chunkQueue {
+	return &chunkQueue{
+		chunks: make([][]byte, 8),
+		times:  make([]time.Time, 8),
+		limit:  limit,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push queues a chunk captured at captured without blocking and returns the
+// number of bytes and chunks dropped to make room for it. The chunk must not
+// be modified afterwards.
+func (q *chunkQueue) push(chunk []byte, captured time.Time) (int, int) {
+	q.mu.Lock()
+	if q.count == len(q.chunks) {
+		grown := make([][]byte, 2*len(q.chunks))
+		times := make([]time.Time, 2*len(q.chunks))
+		for i := 0; i < q.count; i++ {
+			grown[i] = q.chunks[(q.head+i)%len(q.chunks)]
+			times[i] = q.times[(q.head+i)%len(q.chunks)]
+		}
+		q.chunks, q.times, q.head = grown, times, 0
+	}
+	q.chunks[(q.head+q.count)%len(q.chunks)] = chunk
+	q.times[(q.head+q.count)%len(q.chunks)] = captured
+	q.count++
+	q.size += len(chunk)
+
+	dropped, chunks := 0, 0
+	for q.size > q.limit && q.count > 1 {
+		dropped += len(q.chunks[q.head])
+		chunks++
+		q.size -= len(q.chunks[q.head])
+		q.chunks[q.head] = nil
+		q.head = (q.head + 1) % len(q.chunks)
+		q.count--
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return dropped, chunks
+}
+
+// pop removes and returns the oldest chunk and when it was captured, or nil
+// if the queue is empty
+func (q *chunkQueue) pop() ([]byte, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.count == 0 {
+		return nil, time.Time{}
+	}
+	chunk, captured := q.chunks[q.head], q.times[q.head]
+	q.chunks[q.head] = nil
+	q.head = (q.head + 1) % len(q.chunks)
+	q.count--
+	q.size -= len(chunk)
+	return chunk, captured
+}
@@ -0,0 +1,247 @@
+package audiorelay
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// streamNamePattern keeps stream names usable as URL path segments
+var streamNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// StreamConfig binds an additional capture device to its own endpoints
+type StreamConfig struct {
+	Name       string  `mapstructure:"name" desc:"Stream name, used in /streams/{name}/stream.wav"`
+	DeviceName string  `mapstructure:"device_name" desc:"Capture device for this stream"`
+	Port       string  `mapstructure:"port" desc:"TCP port for this stream, empty for no TCP"`
+	SampleRate float64 `mapstructure:"sample_rate" desc:"Sample rate, 0 uses audio.sample_rate"`
+	Channels   int     `mapstructure:"channels" desc:"Channel count, 0 uses audio.channels"`
+
+	Power PowerConfig `mapstructure:"power" desc:"Power hooks of this stream's zone; off_delay_seconds 0 uses power.off_delay_seconds"`
+}
+
+// forStream returns a copy of the configuration for a named stream. Audio
+// format, processing and protocol settings are shared with the main stream.
+func (c *Config) forStream(s StreamConfig) *Config {
+	cfg := *c
+	cfg.Streams = nil
+	cfg.Audio.DeviceName = s.DeviceName
+	if cfg.Audio.Backend == BackendPulse {
+		cfg.Audio.Pulse.Source = s.DeviceName
+		cfg.Audio.Pulse.Application = ""
+	}
+	cfg.Server.Port = s.Port
+	cfg.Protocols.TCP.Enabled = s.Port != ""
+	cfg.Protocols.UDP.Enabled = false
+	cfg.Triggers.Level.Enabled = false
+	cfg.Triggers.Snapshot.Enabled = false
+	if s.SampleRate > 0 {
+		cfg.Audio.SampleRate = s.SampleRate
+	}
+	if s.Channels > 0 {
+		cfg.Audio.Channels = s.Channels
+	}
+	cfg.Power = s.Power
+	if s.Power.OffDelaySeconds == 0 {
+		cfg.Power.OffDelaySeconds = c.Power.OffDelaySeconds
+	}
+	return &cfg
+}
+
+// validateStreams checks stream names, devices and ports
+func (c *Config) validateStreams() error {
+	names := make(map[string]bool)
+	ports := map[string]bool{c.Server.Port: true, c.Server.HttpPort: true}
+	for _, s := range c.Streams {
+		if !streamNamePattern.MatchString(s.Name) {
+			return fmt.Errorf("invalid stream name %q (use letters, digits, - and _)", s.Name)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("duplicate stream name %q", s.Name)
+		}
+		names[s.Name] = true
+
+		if s.DeviceName == "" {
+			return fmt.Errorf("stream %q: device_name is required", s.Name)
+		}
+		if s.Port != "" {
+			if ports[s.Port] {
+				return fmt.Errorf("stream %q: port %s is already in use", s.Name, s.Port)
+			}
+			ports[s.Port] = true
+		}
+		if err := c.forStream(s).Validate(); err != nil {
+			return fmt.Errorf("stream %q: %v", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// namedStream is an additional device relayed independently of the main one
+type namedStream struct {
+	name    string
+	config  *Config
+	capture *AudioCapture
+	tcp     *TCPServer
+	http    *HTTPServer // Serves /streams/{name}/ through the main HTTP server
+	power   *PowerHooks
+
+	identity *StreamIdentity
+}
+
+// startStreams opens every configured named stream
+func (ar *AudioRelay) startStreams() error {
+	for _, s := range ar.config.Streams {
+		config := ar.config.forStream(s)
+		stream := &namedStream{
+			name:    s.Name,
+			config:  config,
+			capture: NewAudioCapture(config),
+		}
+
+		device, err := ar.deviceMgr.GetDeviceByName(s.DeviceName)
+		if config.Audio.Backend == BackendPulse {
+			device, err = pulseDevice(config), nil
+		}
+		if err != nil {
+			return fmt.Errorf("stream %q: %v", s.Name, err)
+		}
+		fmt.Printf("\n📡 Stream %q:\n", s.Name)
+		if err := stream.capture.Initialize(device); err != nil {
+			return fmt.Errorf("stream %q: %v", s.Name, err)
+		}
+
+		identity, err := LoadStreamIdentity(config.Server.StateFile, s.Name, config.StreamFormat(false))
+		if err != nil {
+			return fmt.Errorf("stream %q: %v", s.Name, err)
+		}
+		stream.identity = identity
+
+		if config.Power.Enabled() {
+			stream.power = NewPowerHooks(s.Name, config.Power, ar.events, stream.clientCount)
+			stream.power.Start()
+		}
+
+		if stream.config.Protocols.TCP.Enabled {
+			stream.tcp = NewTCPServer(stream.config, ar.clients)
+			stream.tcp.onConnect = stream.listenerConnected
+			stream.tcp.identity = identity
+			stream.tcp.capture = stream.capture
+			if err := stream.tcp.Start(); err != nil {
+				return fmt.Errorf("stream %q: %v", s.Name, err)
+			}
+		}
+		if ar.httpServer != nil {
+			stream.http = NewHTTPServer(stream.config, ar.webFS, stream.capture, ar.events, ar.clients)
+			stream.http.identity = identity
+			stream.http.prefs = ar.clientPrefs
+			stream.http.onConnect = stream.listenerConnected
+			stream.http.limiter = ar.httpServer.limiter // One budget per IP across streams
+		}
+
+		stream.capture.tracer = ar.tracer
+		stream.capture.SetDataCallback(func(data []byte, captured time.Time) {
+			stream.broadcast(data, captured, stream.capture.broadcastSpan())
+		})
+		ar.watchCapture(s.Name, stream.capture)
+		if err := stream.capture.Start(); err != nil {
+			return fmt.Errorf("stream %q: %v", s.Name, err)
+		}
+		ar.streams[s.Name] = stream
+	}
+
+	if ar.httpServer != nil && len(ar.streams) > 0 {
+		ar.httpServer.HandleFunc("GET /streams/{name}/stream.wav", ar.streamHandler((*HTTPServer).handleWavStream))
+		ar.httpServer.HandleFunc("GET /streams/{name}/stream.pcm", ar.streamHandler((*HTTPServer).handlePCMStream))
+		ar.httpServer.HandleFunc("GET /streams/{name}/stream", ar.streamHandler((*HTTPServer).handleStream))
+		ar.httpServer.HandleFunc("GET /streams/{name}/status", ar.streamHandler((*HTTPServer).handleStatus))
+		ar.httpServer.HandleFunc("GET /streams", ar.handleListStreams)
+		ar.httpServer.HandleFunc("GET /api/v1/streams/{name}/metadata", ar.streamHandler((*HTTPServer).handleGetMetadata))
+		ar.httpServer.HandleFunc("PUT /api/v1/streams/{name}/metadata", ar.streamHandler((*HTTPServer).handleSetMetadata))
+	}
+	return nil
+}
+
+// stopStreams closes all named streams
+func (ar *AudioRelay) stopStreams() {
+	for _, stream := range ar.streams {
+		stream.capture.Stop()
+		if stream.tcp != nil {
+			stream.tcp.Stop()
+		}
+		if stream.http != nil {
+			stream.http.Stop()
+		}
+		if stream.power != nil {
+			stream.power.Stop()
+		}
+	}
+}
+
+// listenerConnected powers the stream's zone on
+func (s *namedStream) listenerConnected() {
+	if s.power != nil {
+		s.power.Connected()
+	}
+}
+
+// clientCount returns the number of listeners of the stream
+func (s *namedStream) clientCount() int {
+	count := 0
+	if s.tcp != nil {
+		count += s.tcp.GetClientCount()
+	}
+	if s.http != nil {
+		count += s.http.GetClientCount()
+	}
+	return count
+}
+
+// broadcast sends a named stream's audio to its own clients
+func (s *namedStream) broadcast(data []byte, captured time.Time, trace *span) {
+	if s.tcp != nil {
+		step := trace.child("broadcast.tcp")
+		s.tcp.Broadcast(data, captured)
+		step.finish()
+	}
+	if s.http != nil {
+		step := trace.child("broadcast.http")
+		s.http.Broadcast(data, captured)
+		step.finish()
+	}
+}
+
+// streamHandler routes a request to the named stream's HTTP handler
+func (ar *AudioRelay) streamHandler(handler func(*HTTPServer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stream, ok := ar.streams[r.PathValue("name")]
+		if !ok || stream.http == nil {
+			http.NotFound(w, r)
+			return
+		}
+		handler(stream.http, w, r)
+	}
+}
+
+// handleListStreams lists the named streams and their endpoints
+func (ar *AudioRelay) handleListStreams(w http.ResponseWriter, r *http.Request) {
+	streams := make([]map[string]interface{}, 0, len(ar.streams))
+	for _, s := range ar.config.Streams {
+		stream, ok := ar.streams[s.Name]
+		if !ok {
+			continue
+		}
+		streams = append(streams, map[string]interface{}{
+			"name":        s.Name,
+			"stream_id":   stream.identity.ID,
+			"device":      stream.capture.Device().Name,
+			"sample_rate": stream.capture.SampleRate(),
+			"channels":    stream.config.OutputChannels(),
+			"tcp_port":    s.Port,
+			"url":         "/streams/" + s.Name + "/stream.wav",
+			"clients":     stream.http.GetClientCount(),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"streams": streams})
+}
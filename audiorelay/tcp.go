@@ -1,142 +1,624 @@
 package audiorelay
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // TCPServer handles TCP client connections and data broadcasting
 type TCPServer struct {
-	config    *Config
-	listener  net.Listener
-	clients   map[net.Conn]bool
-	clientsMu sync.RWMutex
+	config   *Config
+	registry *ClientRegistry
+	shards   []*tcpShard
+
+	// Recent audio for new clients
+	history *prebuffer
+
+	// Called when a listener connects, e.g. to wake idle capture
+	onConnect func()
+
+	// Developer-mode fault injection, may be nil
+	faults *FaultInjector
+
+	// New connections per IP, nil for no limit
+	limiter *ipRateLimiter
+
+	// Stream identity whose format version frames carry, may be nil
+	identity *StreamIdentity
+
+	// Capture whose rate the stream follows, nil for the configured rate
+	capture *AudioCapture
+
+	// Certificate of TLS connections, nil for plain TCP
+	tlsConfig *tls.Config
 
-	// Control
-	isRunning bool
+	// Opus encoders by the layout they encode
+	encoders   map[wavFormat]*tcpEncoder
+	encodersMu sync.Mutex
+
+	// Connections counted against max_clients: those still in their
+	// handshakes and the connected clients
+	slots atomic.Int64
+
+	// Control; read by clients finishing their handshakes while Stop runs
+	isRunning atomic.Bool
 }
 
 // NewTCPServer creates a new TCP server instance
-func NewTCPServer(config *Config) *TCPServer {
+func NewTCPServer(config *Config, registry *ClientRegistry) *TCPServer {
 	return &TCPServer{
-		config:  config,
-		clients: make(map[net.Conn]bool),
+		config:   config,
+		registry: registry,
+		history:  newPrebuffer(config.StreamFormat(config.Protocols.TCP.UpmixStereo), config.Protocols.TCP.PrebufferMs),
+		limiter:  newIPRateLimiter(config.Protocols.RateLimit),
 	}
 }
 
+// tcpClient is a connected TCP listener
+type tcpClient struct {
+	conn net.Conn
+	*Client
+
+	// Sequence number of the next frame, with framing enabled
+	sequence uint32
+
+	// Layout the client gets, converted from the stream's if it asked for
+	// another in the handshake or a control message; changed under the
+	// shard's lock
+	format  wavFormat
+	convert *streamConverter
+	codec   string // PCM, or Opus packets fanned out by an encoder
+
+	// Audio waiting for the client's writer goroutine, which owns conn once
+	// started
+	queue    *chunkQueue
+	quit     chan struct{}
+	quitOnce sync.Once
+	stopped  chan struct{}
+}
+
+// prepare returns broadcast audio in source as sent to the client, before
+// framing
+func (c *tcpClient) prepare(data []byte, source wavFormat) []byte {
+	data = c.applyGain(data, source)
+	if c.format == source {
+		return data
+	}
+	samples := source.decodeSamples(data)
+	if c.convert != nil {
+		samples = c.convert.process(samples)
+	}
+	return c.format.encodeSamples(samples)
+}
+
+// tcpShard is one accept loop and the clients it accepted. With SO_REUSEPORT
+// the kernel spreads connections over the shards, and broadcasts write to
+// the shards in parallel.
+type tcpShard struct {
+	listener  net.Listener
+	clients   map[*tcpClient]bool
+	clientsMu sync.RWMutex
+}
+
 // Start begins the TCP server
 func (ts *TCPServer) Start() error {
-	var err error
-	ts.listener, err = net.Listen("tcp", ":"+ts.config.Server.Port)
-	if err != nil {
-		return fmt.Errorf("failed to start TCP server: %v", err)
+	addr := ts.config.ListenAddr(ts.config.Server.Port)
+	listeners := ts.config.Protocols.TCP.Listeners
+
+	// Certificate problems should stop startup rather than fail every client
+	if ts.config.Protocols.TCP.TLS {
+		tlsConfig, err := loadTLSConfig(ts.config.Protocols.HTTP.TLS)
+		if err != nil {
+			return err
+		}
+		ts.tlsConfig = tlsConfig
+	}
+
+	if listeners > 1 {
+		for i := 0; i < listeners; i++ {
+			listener, err := listenReusePort(addr)
+			if err != nil {
+				ts.closeListeners()
+				if i == 0 {
+					// Unsupported platform, a single accept loop still works
+					log.Printf("  SO_REUSEPORT unavailable (%v), using one listener", err)
+					break
+				}
+				return fmt.Errorf("failed to start TCP server: %v", err)
+			}
+			ts.shards = append(ts.shards, &tcpShard{listener: listener, clients: make(map[*tcpClient]bool)})
+		}
+	}
+	if len(ts.shards) == 0 {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to start TCP server: %v", err)
+		}
+		ts.shards = []*tcpShard{{listener: listener, clients: make(map[*tcpClient]bool)}}
 	}
 
-	ts.isRunning = true
+	ts.isRunning.Store(true)
 
 	// Display server information
 	ts.displayServerInfo()
 
 	// Start accepting clients
-	go ts.acceptClients()
+	for _, shard := range ts.shards {
+		go ts.acceptClients(shard)
+	}
 
 	return nil
 }
 
+// closeListeners closes and forgets all listeners
+func (ts *TCPServer) closeListeners() {
+	for _, shard := range ts.shards {
+		shard.listener.Close()
+	}
+	ts.shards = nil
+}
+
 // Stop gracefully shuts down the TCP server
 func (ts *TCPServer) Stop() {
-	ts.isRunning = false
+	ts.isRunning.Store(false)
 
-	if ts.listener != nil {
-		ts.listener.Close()
+	for _, shard := range ts.shards {
+		shard.listener.Close()
 	}
+	ts.disconnectAll(streamEndShutdown).Wait()
 
-	// Close all client connections
-	ts.clientsMu.Lock()
-	for client := range ts.clients {
-		client.Close()
+	fmt.Println(" TCP server stopped")
+}
+
+// streamFormat returns the PCM layout sent to clients
+func (ts *TCPServer) streamFormat() wavFormat {
+	if ts.capture == nil {
+		return ts.config.StreamFormat(ts.config.Protocols.TCP.UpmixStereo)
 	}
-	ts.clients = make(map[net.Conn]bool)
-	ts.clientsMu.Unlock()
+	return ts.capture.StreamFormat(ts.config.Protocols.TCP.UpmixStereo)
+}
 
-	fmt.Println(" TCP server stopped")
+// restartClients disconnects every client because the audio format changed.
+// Raw PCM has no way to announce the change in-band, so the orderly EOF is
+// the reconnect hint; reconnecting clients get the new format.
+func (ts *TCPServer) restartClients() {
+	ts.history.reset(ts.streamFormat())
+	ts.disconnectAll(streamEndFormatChange)
 }
 
-// Broadcast sends audio data to all connected clients
-func (ts *TCPServer) Broadcast(data []byte) {
-	ts.clientsMu.RLock()
-	defer ts.clientsMu.RUnlock()
+// disconnectAll drains and forgets all clients: their queued audio is
+// sent, with framing followed by an end of stream frame giving reason,
+// before the connections are closed. The returned group is done once every
+// connection is closed.
+func (ts *TCPServer) disconnectAll(reason string) *sync.WaitGroup {
+	var drained sync.WaitGroup
+	deadline := time.Now().Add(ts.config.DrainTimeout())
+	framing := ts.config.Protocols.TCP.Framing
+	for _, shard := range ts.shards {
+		shard.clientsMu.Lock()
+		for client := range shard.clients {
+			ts.registry.Unregister(client.Client)
+			end := ts.endFrame(client, reason)
+			drained.Add(1)
+			go func() {
+				defer drained.Done()
+				// Written only once the writer is done with the connection,
+				// so the marker can't land in the middle of a frame
+				if client.stop(deadline) && framing {
+					client.conn.SetWriteDeadline(deadline)
+					client.conn.Write(end)
+				}
+				drainConn(client.conn, deadline)
+			}()
+		}
+		ts.slots.Add(-int64(len(shard.clients)))
+		shard.clients = make(map[*tcpClient]bool)
+		shard.clientsMu.Unlock()
+	}
+	ts.closeEncoders()
+	return &drained
+}
+
+// drainConn ends a raw stream cleanly: the half-close gives the client an
+// orderly EOF after the last audio instead of a reset, and the connection
+// is closed once the client hangs up or the drain period is over. TLS
+// connections send close_notify first.
+func drainConn(conn net.Conn, deadline time.Time) {
+	defer conn.Close()
 
-	if len(ts.clients) == 0 {
+	halfCloser, ok := conn.(interface{ CloseWrite() error })
+	if !ok || halfCloser.CloseWrite() != nil {
 		return
 	}
+	conn.SetReadDeadline(deadline)
+	io.Copy(io.Discard, conn)
+}
+
+// Broadcast sends audio data read from the device at captured to all
+// connected clients
+func (ts *TCPServer) Broadcast(data []byte, captured time.Time) {
+	// Raw PCM carries no header, so the channel layout is fixed by config
+	format := ts.streamFormat()
+	if format.Channels != ts.config.OutputChannels() {
+		data = duplicateChannels(data, format.Channels, format.bytesPerSample())
+	}
+	ts.history.add(data)
 
-	failedClients := make([]net.Conn, 0)
+	// Encoders time their packets by the chunk's first sample
+	ts.feedEncoders(data, captured.Add(-audioDuration(format, len(data))))
 
-	for client := range ts.clients {
-		client.SetWriteDeadline(time.Now().Add(2 * time.Second))
-		_, err := client.Write(data)
-		if err != nil {
-			failedClients = append(failedClients, client)
+	if len(ts.shards) == 1 {
+		ts.broadcastShard(ts.shards[0], data, format, captured)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, shard := range ts.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ts.broadcastShard(shard, data, format, captured)
+		}()
+	}
+	wg.Wait()
+}
+
+// broadcastShard queues audio data for the clients of one shard. Their
+// writers send it, so a slow client only delays itself.
+func (ts *TCPServer) broadcastShard(shard *tcpShard, data []byte, format wavFormat, captured time.Time) {
+	shard.clientsMu.RLock()
+	defer shard.clientsMu.RUnlock()
+
+	// Frame headers carry when the chunk's first sample was captured
+	started := captured.Add(-audioDuration(format, len(data)))
+	overhead := ts.frameOverhead()
+	for client := range shard.clients {
+		// Encoders send the packets of other codecs
+		if client.codec != tcpCodecPCM || client.Paused() {
+			continue
+		}
+		client.enqueue(ts.frame(client, client.prepare(data, format), started), captured, overhead)
+	}
+}
+
+// enqueue queues a chunk, whose audio was captured by captured, for the
+// writer without blocking. Chunks dropped to make room are counted as
+// missed audio frames; overhead is the size of each chunk's frame header
+// and CRC.
+func (c *tcpClient) enqueue(chunk []byte, captured time.Time, overhead int) {
+	dropped, chunks := c.queue.push(chunk, captured)
+	switch {
+	case dropped == 0:
+	case c.codec == tcpCodecOpus:
+		c.recordDropped(chunks * int(tcpOpusPacketDuration.Seconds()*float64(c.format.SampleRate)))
+	default:
+		c.recordDropped((dropped - chunks*overhead) / c.format.blockAlign())
+	}
+}
+
+// runClient writes the client's queued audio until it fails or is stopped,
+// then sends what is left
+func (ts *TCPServer) runClient(shard *tcpShard, client *tcpClient) {
+	defer close(client.stopped)
+
+	// A heartbeat follows every interval without a write
+	interval := ts.heartbeatInterval()
+	var heartbeat *time.Timer
+	var beat <-chan time.Time
+	if interval > 0 {
+		heartbeat = time.NewTimer(interval)
+		defer heartbeat.Stop()
+		beat = heartbeat.C
+	}
+
+	for {
+		select {
+		case <-client.queue.ready:
+			if !ts.drainClient(shard, client) {
+				return
+			}
+		case <-beat:
+			// Control messages may change the client's layout
+			shard.clientsMu.RLock()
+			frame := ts.heartbeat(client)
+			shard.clientsMu.RUnlock()
+			if !ts.writeClient(shard, client, frame) {
+				return
+			}
+		case <-client.quit:
+			ts.drainClient(shard, client)
+			return
 		}
+		if heartbeat != nil {
+			heartbeat.Reset(interval)
+		}
+	}
+}
+
+// drainClient writes all queued audio, reporting false and removing the
+// client once it can't be written to anymore
+func (ts *TCPServer) drainClient(shard *tcpShard, client *tcpClient) bool {
+	for chunk, captured := client.queue.pop(); chunk != nil; chunk, captured = client.queue.pop() {
+		ts.faults.slowClient(client.Client)
+		if !ts.writeClient(shard, client, chunk) {
+			return false
+		}
+		client.recordDelivery(captured)
+	}
+	return true
+}
+
+// writeClient sends data to the client, reporting false and removing the
+// client if the write fails
+func (ts *TCPServer) writeClient(shard *tcpShard, client *tcpClient, data []byte) bool {
+	client.conn.SetWriteDeadline(time.Now().Add(ts.writeTimeout()))
+	start := time.Now()
+	n, err := client.conn.Write(data)
+	client.recordSent(n)
+	client.recordLatency(time.Since(start))
+	if err != nil {
+		debugf("TCP client %s write failed: %v", client.conn.RemoteAddr(), err)
+		go ts.cleanupClients(shard, []*tcpClient{client})
+		return false
 	}
+	return true
+}
 
-	// Clean up failed clients
-	if len(failedClients) > 0 {
-		go ts.cleanupClients(failedClients)
+// stop ends the client's writer once it has sent the queued audio, or
+// once deadline has passed, and reports whether the writer has finished
+func (c *tcpClient) stop(deadline time.Time) bool {
+	c.quitOnce.Do(func() { close(c.quit) })
+	select {
+	case <-c.stopped:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
 	}
 }
 
 // GetClientCount returns the number of connected clients
 func (ts *TCPServer) GetClientCount() int {
-	ts.clientsMu.RLock()
-	defer ts.clientsMu.RUnlock()
-	return len(ts.clients)
+	count := 0
+	for _, shard := range ts.shards {
+		shard.clientsMu.RLock()
+		count += len(shard.clients)
+		shard.clientsMu.RUnlock()
+	}
+	return count
 }
 
-// acceptClients handles incoming client connections
-func (ts *TCPServer) acceptClients() {
-	for ts.isRunning {
-		conn, err := ts.listener.Accept()
+// acceptClients handles incoming client connections on one shard
+func (ts *TCPServer) acceptClients(shard *tcpShard) {
+	for ts.isRunning.Load() {
+		conn, err := shard.listener.Accept()
 		if err != nil {
-			if ts.isRunning {
+			if ts.isRunning.Load() {
 				log.Printf("Client connection error: %v", err)
 			}
 			return
 		}
 
+		// Closed right away: a client reconnecting in a loop shouldn't get
+		// history copies or goroutines
+		if ok, _ := ts.limiter.allow(conn.RemoteAddr().String()); !ok {
+			debugf("TCP client %s rate limited", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
 		// Optimize TCP connection
 		if tcpConn, ok := conn.(*net.TCPConn); ok {
-			tcpConn.SetNoDelay(true)
-			tcpConn.SetWriteBuffer(32 * 1024)
-			tcpConn.SetReadBuffer(16 * 1024)
-			tcpConn.SetKeepAlive(true)
+			ts.tuneConn(tcpConn)
+			if ms := ts.config.Protocols.TCP.DeadClientMs; ms > 0 {
+				if err := setTCPUserTimeout(tcpConn, time.Duration(ms*float64(time.Millisecond))); err != nil {
+					debugf("TCP client %s: %v", conn.RemoteAddr(), err)
+				}
+			}
+		}
+		if ts.tlsConfig != nil {
+			conn = tls.Server(conn, ts.tlsConfig)
+		}
+
+		// The slot is taken before the handshakes, so a burst of
+		// connections can't overshoot the limit while they're in progress
+		if slots := ts.slots.Add(1); ts.config.Protocols.TCP.MaxClients > 0 && slots > int64(ts.config.Protocols.TCP.MaxClients) {
+			ts.slots.Add(-1)
+			log.Printf("⚠️ TCP client %s turned away, %d clients connected", conn.RemoteAddr(), ts.config.Protocols.TCP.MaxClients)
+			go rejectConn(conn)
+			continue
 		}
 
 		fmt.Printf(" Client connected: %s\n", conn.RemoteAddr())
-		ts.addClient(conn)
+		// Handshakes wait for the client, which mustn't hold up the accept loop
+		go ts.setupClient(shard, conn)
 	}
 }
 
-// addClient adds a new client to the connection pool
-func (ts *TCPServer) addClient(conn net.Conn) {
-	ts.clientsMu.Lock()
-	defer ts.clientsMu.Unlock()
-	ts.clients[conn] = true
+// tuneConn applies the socket options of protocols.tcp to a new connection
+func (ts *TCPServer) tuneConn(conn *net.TCPConn) {
+	tcp := ts.config.Protocols.TCP
+	conn.SetNoDelay(true)
+	if tcp.WriteBufferBytes > 0 {
+		conn.SetWriteBuffer(tcp.WriteBufferBytes)
+	}
+	if tcp.ReadBufferBytes > 0 {
+		conn.SetReadBuffer(tcp.ReadBufferBytes)
+	}
+	if tcp.KeepaliveMs > 0 {
+		period := time.Duration(tcp.KeepaliveMs * float64(time.Millisecond))
+		conn.SetKeepAliveConfig(net.KeepAliveConfig{Enable: true, Idle: period, Interval: period, Count: -1})
+	} else {
+		conn.SetKeepAlive(false)
+	}
+	ts.config.markConn(conn)
+}
+
+// writeTimeout returns how long a write to a client may block before the
+// client is dropped
+func (ts *TCPServer) writeTimeout() time.Duration {
+	return time.Duration(ts.config.Protocols.TCP.WriteTimeoutMs * float64(time.Millisecond))
+}
+
+// tcpTLSHandshakeTimeout bounds the TLS handshake of a new connection
+const tcpTLSHandshakeTimeout = 5 * time.Second
+
+// setupClient completes the TLS handshake, authentication and format
+// handshake that are enabled and adds the client. The connection's slot
+// is released if it doesn't become a client.
+func (ts *TCPServer) setupClient(shard *tcpShard, conn net.Conn) {
+	added := false
+	defer func() {
+		if !added {
+			ts.slots.Add(-1)
+		}
+	}()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(tcpTLSHandshakeTimeout))
+		err := tlsConn.Handshake()
+		conn.SetDeadline(time.Time{})
+		if err != nil {
+			debugf("TCP client %s TLS handshake failed: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+	}
+
+	if ts.config.Protocols.TCP.AuthToken != "" {
+		if err := ts.authenticate(conn); err != nil {
+			debugf("🔐 Rejected TCP client %s: %v", conn.RemoteAddr(), err)
+			conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+			io.WriteString(conn, tcpUnauthorized)
+			drainConn(conn, time.Now().Add(2*time.Second))
+			return
+		}
+	}
+
+	format, codec := ts.streamFormat(), tcpCodecPCM
+	if ts.config.Protocols.TCP.Handshake {
+		requested, requestedCodec, err := ts.handshake(conn, format)
+		if err != nil {
+			debugf("TCP client %s handshake failed: %v", conn.RemoteAddr(), err)
+			drainConn(conn, time.Now().Add(2*time.Second))
+			return
+		}
+		format, codec = requested, requestedCodec
+	}
+	debugf("TCP client %s: format=%+v codec=%s", conn.RemoteAddr(), format, codec)
+	added = ts.addClient(shard, conn, format, codec)
+}
+
+// tcpServerFull is sent to clients turned away by max_clients before the
+// connection is closed. Raw PCM has no framing, so it is a short text line
+// that players that don't look for it hear as a click.
+const tcpServerFull = "ERROR server full\n"
+
+// rejectConn tells a client the server is full and closes the connection
+func rejectConn(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, tcpServerFull)
+	drainConn(conn, time.Now().Add(2*time.Second))
+}
+
+// addClient adds a new client receiving format in codec to a shard's
+// connection pool, reporting whether it was added. Once Stop has begun
+// clients are refused, as the shards may already have been drained.
+func (ts *TCPServer) addClient(shard *tcpShard, conn net.Conn, format wavFormat, codec string) bool {
+	if ts.onConnect != nil {
+		ts.onConnect()
+	}
+
+	source := ts.streamFormat()
+	client := &tcpClient{
+		conn:    conn,
+		Client:  ts.registry.Register("tcp", conn.RemoteAddr().String()),
+		format:  format,
+		convert: newStreamConverter(source, format),
+		codec:   codec,
+		queue:   newChunkQueue(ts.queueBytes(format, codec)),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if codec == tcpCodecPCM {
+		// Prime the client with recent audio before it joins the live
+		// broadcast; there is no encoded history for other codecs
+		conn.SetWriteDeadline(time.Now().Add(ts.writeTimeout()))
+		buffered, _ := ts.history.stats()
+		now := time.Now()
+		err := ts.history.replay(func(data []byte) error {
+			captured := now.Add(-audioDuration(source, buffered))
+			buffered -= len(data)
+			n, err := conn.Write(ts.frame(client, client.prepare(data, source), captured))
+			client.recordSent(n)
+			return err
+		})
+		if err != nil {
+			ts.registry.Unregister(client.Client)
+			conn.Close()
+			return false
+		}
+	}
+
+	shard.clientsMu.Lock()
+	defer shard.clientsMu.Unlock()
+	if !ts.isRunning.Load() {
+		ts.registry.Unregister(client.Client)
+		conn.Close()
+		return false
+	}
+	if codec != tcpCodecPCM {
+		if err := ts.subscribe(client); err != nil {
+			log.Printf("⚠️ TCP client %s: %v", conn.RemoteAddr(), err)
+			ts.registry.Unregister(client.Client)
+			conn.Close()
+			return false
+		}
+	}
+	shard.clients[client] = true
+	client.onDisconnect(func() { ts.cleanupClients(shard, []*tcpClient{client}) })
+	go ts.runClient(shard, client)
+	if ts.config.Protocols.TCP.Control {
+		go ts.readControl(shard, client)
+	}
+	return true
+}
+
+// queueBytes returns the size of a client's queue, holding queue_ms of
+// audio in format and codec
+func (ts *TCPServer) queueBytes(format wavFormat, codec string) int {
+	queueMs := ts.config.Protocols.TCP.QueueMs
+	if codec == tcpCodecOpus {
+		packets := int(queueMs/float64(tcpOpusPacketDuration.Milliseconds())) + 1
+		return packets * (tcpOpusPacketBytes + ts.frameOverhead())
+	}
+	return prebufferBytes(format, queueMs)
 }
 
 // cleanupClients removes failed client connections
-func (ts *TCPServer) cleanupClients(failedClients []net.Conn) {
-	ts.clientsMu.Lock()
-	defer ts.clientsMu.Unlock()
+func (ts *TCPServer) cleanupClients(shard *tcpShard, failedClients []*tcpClient) {
+	shard.clientsMu.Lock()
+	defer shard.clientsMu.Unlock()
 
 	for _, client := range failedClients {
-		delete(ts.clients, client)
-		client.Close()
-		fmt.Printf("  Client disconnected: %s\n", client.RemoteAddr())
+		if !shard.clients[client] {
+			continue
+		}
+		delete(shard.clients, client)
+		ts.slots.Add(-1)
+		ts.registry.Unregister(client.Client)
+		if client.codec != tcpCodecPCM {
+			ts.unsubscribe(client)
+		}
+		client.quitOnce.Do(func() { close(client.quit) })
+		client.conn.Close()
+		fmt.Printf("  Client disconnected: %s\n", client.conn.RemoteAddr())
 	}
 }
 
@@ -166,10 +648,20 @@ func (ts *TCPServer) getLocalIPs() ([]string, error) {
 // displayServerInfo shows server connection information
 func (ts *TCPServer) displayServerInfo() {
 	fmt.Printf("\nTCP Server:\n")
+	if len(ts.shards) > 1 {
+		fmt.Printf("  Listeners: %d (SO_REUSEPORT)\n", len(ts.shards))
+	}
+	if ts.config.Protocols.TCP.AuthToken != "" {
+		fmt.Printf("  Auth: token required\n")
+	}
 	if ips, err := ts.getLocalIPs(); err == nil {
+		scheme := "tcp"
+		if ts.tlsConfig != nil {
+			scheme = "tls"
+		}
 		fmt.Printf("Addresses:\n")
 		for _, ip := range ips {
-			fmt.Printf("    tcp://%s:%s\n", ip, ts.config.Server.Port)
+			fmt.Printf("    %s://%s:%s\n", scheme, ip, ts.config.Server.Port)
 		}
 	} else {
 		fmt.Printf("  Server Address: 0.0.0.0:%s\n", ts.config.Server.Port)
@@ -0,0 +1,71 @@
+package audiorelay
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// BenchmarkTCPBroadcast measures the fan-out of one capture buffer to
+// loopback clients accepted by one listener and by several SO_REUSEPORT
+// shards, which broadcast in parallel
+func BenchmarkTCPBroadcast(b *testing.B) {
+	for _, shards := range []int{1, 4} {
+		for _, clients := range []int{100, 300} {
+			b.Run(fmt.Sprintf("shards=%d/clients=%d", shards, clients), func(b *testing.B) {
+				benchmarkTCPBroadcast(b, shards, clients)
+			})
+		}
+	}
+}
+
+func benchmarkTCPBroadcast(b *testing.B, shards, clients int) {
+	config, err := DefaultConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+	config.Server.BindAddress = "127.0.0.1"
+	config.Server.Port = "0"
+	config.Protocols.TCP.Listeners = shards
+	config.Protocols.TCP.MaxClients = 0
+	config.Protocols.RateLimit.PerSecond = 0
+	config.Protocols.TCP.PrebufferMs = 0
+
+	ts := NewTCPServer(config, NewClientRegistry())
+	if err := ts.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer ts.Stop()
+	if len(ts.shards) != shards {
+		b.Skipf("SO_REUSEPORT unavailable, %d listener(s)", len(ts.shards))
+	}
+
+	// Every shard listens on the same port, the kernel spreads the clients
+	addr := ts.shards[0].listener.Addr().String()
+	for i := 0; i < clients; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer conn.Close()
+		go io.Copy(io.Discard, conn)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for ts.GetClientCount() < clients {
+		if time.Now().After(deadline) {
+			b.Fatalf("%d of %d clients connected", ts.GetClientCount(), clients)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 10 ms of the default stream
+	format := config.StreamFormat(config.Protocols.TCP.UpmixStereo)
+	data := make([]byte, prebufferBytes(format, 10))
+	b.SetBytes(int64(len(data) * clients))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ts.Broadcast(data, time.Now())
+	}
+}
@@ -0,0 +1,63 @@
+package audiorelay
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// TCP authentication, used with protocols.tcp.auth_token. A client's first
+// bytes, inside TLS if enabled, must be the token followed by a newline;
+// only then does the format handshake or the audio start:
+//
+//	s3cret\n
+//
+// A wrong token, or none within tcpAuthTimeout, is answered with
+// tcpUnauthorized and the connection is closed.
+const (
+	tcpAuthTimeout  = 5 * time.Second
+	maxTCPAuthToken = 256
+	tcpUnauthorized = "ERROR unauthorized\n"
+)
+
+// validateTCPAuthToken checks that token fits on the line a client sends
+func validateTCPAuthToken(token string) error {
+	if len(token) > maxTCPAuthToken {
+		return fmt.Errorf("TCP auth_token can't be longer than %d bytes", maxTCPAuthToken)
+	}
+	if strings.ContainsAny(token, "\r\n") {
+		return fmt.Errorf("TCP auth_token can't contain line breaks")
+	}
+	return nil
+}
+
+// authenticate reads the token line of a new client and checks it. The line
+// is read a byte at a time so nothing the client sends after it is lost.
+func (ts *TCPServer) authenticate(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(tcpAuthTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	line := make([]byte, 0, 64)
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return fmt.Errorf("no token: %v", err)
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if len(line) > maxTCPAuthToken {
+			return fmt.Errorf("token longer than %d bytes", maxTCPAuthToken)
+		}
+		line = append(line, b[0])
+	}
+
+	token := strings.TrimSuffix(string(line), "\r")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ts.config.Protocols.TCP.AuthToken)) != 1 {
+		return fmt.Errorf("wrong token")
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package audiorelay
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Codecs of TCP clients. Opus needs framing, since every packet travels in
+// its own frame, and ffmpeg at protocols.http.ffmpeg_path. One encoder runs
+// per layout that Opus clients asked for, and its packets are fanned out to
+// all of them.
+const (
+	tcpCodecPCM  = "pcm"
+	tcpCodecOpus = "opus"
+
+	// tcpOpusPacketDuration is the -frame_duration of the opus encoding
+	tcpOpusPacketDuration = 20 * time.Millisecond
+	// tcpOpusPacketBytes is a packet's nominal size at 128 kbit/s, used to
+	// size client queues
+	tcpOpusPacketBytes = 128000 / 8 / 50
+)
+
+// tcpCodecs returns the codecs TCP clients can ask for on this host
+func (ts *TCPServer) tcpCodecs() []string {
+	codecs := []string{tcpCodecPCM}
+	if ts.config.Protocols.TCP.Framing {
+		if _, err := exec.LookPath(ts.config.Protocols.HTTP.FFmpegPath); err == nil {
+			codecs = append(codecs, tcpCodecOpus)
+		}
+	}
+	return codecs
+}
+
+// captureMark is when the audio fed to an encoder from offset on was
+// captured
+type captureMark struct {
+	offset   time.Duration
+	captured time.Time
+}
+
+// tcpEncoder runs ffmpeg encoding the broadcast to Opus in one layout and
+// sends each packet to the clients that asked for it
+type tcpEncoder struct {
+	ts     *TCPServer
+	format wavFormat // Layout encoded
+	source wavFormat // Layout of the broadcast fed in
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+
+	input     *chunkQueue // Broadcast audio waiting for ffmpeg
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	clients map[*tcpClient]bool
+	fed     time.Duration // Audio fed so far
+	marks   []captureMark
+}
+
+// startTCPEncoder starts an Opus encoder for format
+func (ts *TCPServer) startTCPEncoder(source, format wavFormat) (*tcpEncoder, error) {
+	args := encoderArgs(streamEncodings[tcpCodecOpus], source, format,
+		// One Ogg page per packet, so packets aren't held back
+		"-page_duration", fmt.Sprint(tcpOpusPacketDuration.Microseconds()))
+	e := &tcpEncoder{
+		ts:      ts,
+		format:  format,
+		source:  source,
+		cmd:     exec.Command(ts.config.Protocols.HTTP.FFmpegPath, args...),
+		input:   newChunkQueue(prebufferBytes(source, ts.config.Protocols.TCP.QueueMs)),
+		done:    make(chan struct{}),
+		clients: make(map[*tcpClient]bool),
+	}
+	e.cmd.Stderr = &e.stderr
+	stdin, err := e.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := e.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+
+	go e.feed(stdin)
+	go e.fanOut(stdout)
+	return e, nil
+}
+
+// push queues broadcast audio captured at captured for the encoder
+func (e *tcpEncoder) push(data []byte, captured time.Time) {
+	e.mu.Lock()
+	e.marks = append(e.marks, captureMark{offset: e.fed, captured: captured})
+	e.fed += audioDuration(e.source, len(data))
+	e.mu.Unlock()
+	e.input.push(data, captured)
+}
+
+// feed writes queued audio to ffmpeg until the encoder is closed
+func (e *tcpEncoder) feed(stdin io.WriteCloser) {
+	defer stdin.Close()
+	for {
+		select {
+		case <-e.input.ready:
+			for chunk, _ := e.input.pop(); chunk != nil; chunk, _ = e.input.pop() {
+				if _, err := stdin.Write(chunk); err != nil {
+					return
+				}
+			}
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// fanOut reads the encoded packets and queues each for every client of the
+// encoder. If ffmpeg fails, its clients are disconnected.
+func (e *tcpEncoder) fanOut(stdout io.Reader) {
+	overhead := e.ts.frameOverhead()
+	var packets int
+	err := readOggPackets(stdout, func(packet []byte) {
+		// The first two packets are the OpusHead and OpusTags headers
+		packets++
+		if packets <= 2 {
+			return
+		}
+		offset := time.Duration(packets-3) * tcpOpusPacketDuration
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		started := e.captureTime(offset)
+		captured := started.Add(tcpOpusPacketDuration)
+		for client := range e.clients {
+			if !client.Paused() {
+				client.enqueue(e.ts.frame(client, packet, started), captured, overhead)
+			}
+		}
+	})
+	e.cmd.Wait()
+
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+	log.Printf("⚠️ TCP opus encoder stopped: %v: %s", err, strings.TrimSpace(e.stderr.String()))
+	e.mu.Lock()
+	clients := make([]*tcpClient, 0, len(e.clients))
+	for client := range e.clients {
+		clients = append(clients, client)
+	}
+	e.mu.Unlock()
+	for _, client := range clients {
+		client.Disconnect()
+	}
+}
+
+// captureTime returns when the audio at offset into the encoded stream was
+// captured, forgetting marks before it. Silence that wasn't broadcast
+// leaves no gap in the encoded stream, so each chunk's own capture time is
+// used.
+func (e *tcpEncoder) captureTime(offset time.Duration) time.Time {
+	i := 0
+	for i+1 < len(e.marks) && e.marks[i+1].offset <= offset {
+		i++
+	}
+	e.marks = e.marks[i:]
+	if len(e.marks) == 0 {
+		return time.Now()
+	}
+	return e.marks[0].captured.Add(offset - e.marks[0].offset)
+}
+
+// close stops the encoder
+func (e *tcpEncoder) close() {
+	e.closeOnce.Do(func() {
+		close(e.done)
+		e.cmd.Process.Kill()
+	})
+}
+
+// subscribe sends the Opus packets of the client's layout to the client,
+// starting an encoder for the layout if needed
+func (ts *TCPServer) subscribe(client *tcpClient) error {
+	ts.encodersMu.Lock()
+	defer ts.encodersMu.Unlock()
+	encoder := ts.encoders[client.format]
+	if encoder == nil {
+		var err error
+		source := ts.streamFormat()
+		if encoder, err = ts.startTCPEncoder(source, client.format); err != nil {
+			return err
+		}
+		if ts.encoders == nil {
+			ts.encoders = make(map[wavFormat]*tcpEncoder)
+		}
+		ts.encoders[client.format] = encoder
+	}
+	encoder.mu.Lock()
+	encoder.clients[client] = true
+	encoder.mu.Unlock()
+	return nil
+}
+
+// unsubscribe stops sending Opus packets to the client, stopping the
+// encoder of its layout if no one else uses it
+func (ts *TCPServer) unsubscribe(client *tcpClient) {
+	ts.encodersMu.Lock()
+	defer ts.encodersMu.Unlock()
+	encoder := ts.encoders[client.format]
+	if encoder == nil {
+		return
+	}
+	encoder.mu.Lock()
+	delete(encoder.clients, client)
+	idle := len(encoder.clients) == 0
+	encoder.mu.Unlock()
+	if idle {
+		encoder.close()
+		delete(ts.encoders, client.format)
+	}
+}
+
+// feedEncoders passes broadcast audio to the running encoders
+func (ts *TCPServer) feedEncoders(data []byte, captured time.Time) {
+	ts.encodersMu.Lock()
+	defer ts.encodersMu.Unlock()
+	for _, encoder := range ts.encoders {
+		encoder.push(data, captured)
+	}
+}
+
+// closeEncoders stops all encoders
+func (ts *TCPServer) closeEncoders() {
+	ts.encodersMu.Lock()
+	defer ts.encodersMu.Unlock()
+	for format, encoder := range ts.encoders {
+		encoder.close()
+		delete(ts.encoders, format)
+	}
+}
+
+// readOggPackets calls packet with each packet of an Ogg stream until it
+// ends or fails
+func readOggPackets(r io.Reader, packet func([]byte)) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 27)
+	var pending []byte
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return err
+		}
+		if string(header[:4]) != "OggS" {
+			return fmt.Errorf("lost Ogg page sync")
+		}
+		lacing := make([]byte, header[26])
+		if _, err := io.ReadFull(br, lacing); err != nil {
+			return err
+		}
+		for _, size := range lacing {
+			segment := make([]byte, size)
+			if _, err := io.ReadFull(br, segment); err != nil {
+				return err
+			}
+			// Packets end with a segment shorter than 255 bytes and may
+			// continue on the next page
+			pending = append(pending, segment...)
+			if size < 255 {
+				packet(pending)
+				pending = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package audiorelay
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TCP control messages, used with protocols.tcp.control. Once the audio has
+// started (after the OK line with the handshake enabled), a client may send
+// text lines to adjust its own stream:
+//
+//	VOLUME 0.5                         gain between 0 and 4, as PATCH /api/v1/clients/{id}; PCM only
+//	FORMAT rate=44100 channels=1       another PCM layout, framing only; same parameters as the handshake
+//	PAUSE                              no audio until RESUME; heartbeats go on
+//	RESUME
+//	BUFFER 120                         the receiver's buffer level in ms, shown in /clients
+//
+// Messages get no reply: their effect shows in the audio, in the frame
+// headers and in /clients. Unknown and invalid lines are skipped, so
+// clients can send newer messages to older relays.
+const tcpControlMaxLine = tcpHandshakeMaxLine
+
+// readControl applies the client's control messages until it stops sending
+// or disconnects
+func (ts *TCPServer) readControl(shard *tcpShard, client *tcpClient) {
+	scanner := bufio.NewScanner(client.conn)
+	scanner.Buffer(make([]byte, tcpControlMaxLine), tcpControlMaxLine)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := ts.applyControl(shard, client, line); err != nil {
+			debugf("TCP client %s: %s: %v", client.conn.RemoteAddr(), strings.Fields(line)[0], err)
+		}
+	}
+}
+
+// applyControl applies one control message
+func (ts *TCPServer) applyControl(shard *tcpShard, client *tcpClient, line string) error {
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch command {
+	case "VOLUME":
+		if client.codec != tcpCodecPCM {
+			return fmt.Errorf("the %s stream is shared, its volume can't be changed", client.codec)
+		}
+		gain, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("invalid gain %q", arg)
+		}
+		return client.SetGain(gain)
+	case "FORMAT":
+		if !ts.config.Protocols.TCP.Framing {
+			return fmt.Errorf("needs framing, raw PCM can't show where the layout changes")
+		}
+		if client.codec != tcpCodecPCM {
+			return fmt.Errorf("the codec and layout of a %s stream are fixed in the handshake", client.codec)
+		}
+		source := ts.streamFormat()
+		format, _, err := parseTCPFormatRequest(line, source, []string{tcpCodecPCM})
+		if err != nil {
+			return err
+		}
+		// Broadcasts read the layout under the shard's lock
+		shard.clientsMu.Lock()
+		client.format = format
+		client.convert = newStreamConverter(source, format)
+		shard.clientsMu.Unlock()
+		return nil
+	case "PAUSE", "RESUME":
+		client.setPaused(command == "PAUSE")
+		return nil
+	case "BUFFER":
+		ms, err := strconv.ParseFloat(arg, 64)
+		if err != nil || ms < 0 {
+			return fmt.Errorf("invalid buffer level %q", arg)
+		}
+		client.reportBuffer(ms)
+		return nil
+	}
+	return fmt.Errorf("unknown message")
+}
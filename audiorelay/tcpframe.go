@@ -0,0 +1,145 @@
+package audiorelay
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// TCP frame layout, used with protocols.tcp.framing. Every frame starts
+// with a fixed big-endian header, so receivers can detect lost audio from
+// the sequence numbers, measure latency from the capture time, and find the
+// next frame by its magic after a partial read:
+//
+//	0  magic "ARTF"
+//	4  version
+//	5  frame type (0: audio, 1: heartbeat, 2: end of stream)
+//	6  flags (bit 0: IEEE float samples, bit 1: CRC-32 follows the payload,
+//	   bit 2: the payload is one Opus packet)
+//	7  channels
+//	8  bits per sample
+//	9  reserved (0)
+//	10 format version (uint16, low bits of the stream's format version)
+//	12 sample rate (uint32)
+//	16 sequence number (uint32, per connection, wraps; 0 in other frames)
+//	20 payload length (uint32)
+//	24 capture time of the first sample (int64, Unix nanoseconds; the send
+//	   time in other frames)
+//
+// The little-endian PCM payload follows and always holds whole frames;
+// clients that chose Opus in the handshake get one 20 ms packet per frame
+// instead, with the channels and rate it decodes to. With
+// flag bit 1 set, a CRC-32 (IEEE) of the header and payload follows it.
+// Heartbeats have no payload and are sent with protocols.tcp.heartbeat_ms
+// while no audio flows, e.g. during silence suppression. The end of stream
+// frame follows the last audio when the relay stops or the format changes;
+// its payload is the reason, as in the X-Stream-End trailer of HTTP streams.
+const (
+	tcpFrameMagic      = "ARTF"
+	tcpFrameHeaderSize = 32
+	tcpFrameVersion    = 1
+	tcpFrameAudio      = 0
+	tcpFrameHeartbeat  = 1
+	tcpFrameEnd        = 2
+	tcpFrameFlagFloat  = 0x01
+	tcpFrameFlagCRC    = 0x02
+	tcpFrameFlagOpus   = 0x04
+	tcpFrameCRCSize    = 4
+)
+
+// encodeTCPFrame builds a frame of frameType holding payload in codec
+func encodeTCPFrame(frameType byte, format wavFormat, codec string, formatVersion int, sequence uint32, captured time.Time, payload []byte, withCRC bool) []byte {
+	size := tcpFrameHeaderSize + len(payload)
+	if withCRC {
+		size += tcpFrameCRCSize
+	}
+	frame := make([]byte, tcpFrameHeaderSize, size)
+
+	var flags byte
+	if format.Float {
+		flags |= tcpFrameFlagFloat
+	}
+	if withCRC {
+		flags |= tcpFrameFlagCRC
+	}
+	if codec == tcpCodecOpus {
+		flags |= tcpFrameFlagOpus
+	}
+	copy(frame[0:4], tcpFrameMagic)
+	frame[4] = tcpFrameVersion
+	frame[5] = frameType
+	frame[6] = flags
+	frame[7] = byte(format.Channels)
+	frame[8] = byte(format.BitsPerSample)
+	binary.BigEndian.PutUint16(frame[10:12], uint16(formatVersion))
+	binary.BigEndian.PutUint32(frame[12:16], uint32(format.SampleRate))
+	binary.BigEndian.PutUint32(frame[16:20], sequence)
+	binary.BigEndian.PutUint32(frame[20:24], uint32(len(payload)))
+	binary.BigEndian.PutUint64(frame[24:32], uint64(captured.UnixNano()))
+
+	frame = append(frame, payload...)
+	if withCRC {
+		frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+	}
+	return frame
+}
+
+// audioDuration returns how long size bytes of audio in format play
+func audioDuration(format wavFormat, size int) time.Duration {
+	return time.Duration(float64(size) / float64(format.byteRate()) * float64(time.Second))
+}
+
+// frameOverhead returns the bytes framing adds to each chunk
+func (ts *TCPServer) frameOverhead() int {
+	tcp := ts.config.Protocols.TCP
+	switch {
+	case !tcp.Framing:
+		return 0
+	case tcp.FrameCRC:
+		return tcpFrameHeaderSize + tcpFrameCRCSize
+	}
+	return tcpFrameHeaderSize
+}
+
+// frame returns data in the client's layout and codec as sent to it: raw
+// PCM, or a frame with the client's next sequence number when framing is
+// enabled
+func (ts *TCPServer) frame(client *tcpClient, data []byte, captured time.Time) []byte {
+	tcp := ts.config.Protocols.TCP
+	if !tcp.Framing {
+		return data
+	}
+	frame := encodeTCPFrame(tcpFrameAudio, client.format, client.codec, ts.formatVersion(), client.sequence, captured, data, tcp.FrameCRC)
+	client.sequence++
+	return frame
+}
+
+// heartbeat returns a heartbeat frame for the client
+func (ts *TCPServer) heartbeat(client *tcpClient) []byte {
+	return encodeTCPFrame(tcpFrameHeartbeat, client.format, client.codec, ts.formatVersion(), 0, time.Now(), nil, ts.config.Protocols.TCP.FrameCRC)
+}
+
+// endFrame returns the end of stream frame telling the client why the
+// stream ends
+func (ts *TCPServer) endFrame(client *tcpClient, reason string) []byte {
+	return encodeTCPFrame(tcpFrameEnd, client.format, client.codec, ts.formatVersion(), 0, time.Now(), []byte(reason), ts.config.Protocols.TCP.FrameCRC)
+}
+
+// heartbeatInterval returns how long a client's connection may go without
+// frames before it gets a heartbeat, 0 if heartbeats are off
+func (ts *TCPServer) heartbeatInterval() time.Duration {
+	tcp := ts.config.Protocols.TCP
+	if !tcp.Framing {
+		return 0
+	}
+	return time.Duration(tcp.HeartbeatMs * float64(time.Millisecond))
+}
+
+// formatVersion returns the stream's format version for frame headers
+func (ts *TCPServer) formatVersion() int {
+	if ts.identity == nil {
+		return 0
+	}
+	_, version := ts.identity.Snapshot()
+	return version
+}
@@ -0,0 +1,135 @@
+package audiorelay
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TCP handshake, used with protocols.tcp.handshake. After connecting, the
+// server sends one line describing the stream and what it can convert to:
+//
+//	AUDIORELAY/1 rate=48000 channels=2 sample_format=int16 codec=pcm framing=0 sample_formats=int16,int24,float32 codecs=pcm
+//
+// Within handshake_timeout_ms the client may ask for another layout, giving
+// any of rate, channels, sample_format and codec, or send an empty line to
+// take the stream as it is. codecs lists opus too when framing is enabled
+// and ffmpeg is installed:
+//
+//	FORMAT rate=44100 channels=1 sample_format=int16
+//
+// The server confirms the layout of the audio that follows, or says why the
+// request can't be met and closes the connection:
+//
+//	OK rate=44100 channels=1 sample_format=int16 codec=pcm framing=0
+//	ERROR rate must be between 8000 and 192000
+//
+// A client that sends nothing gets the stream's own layout once the timeout
+// is over.
+const (
+	tcpHandshakeVersion = 1
+	tcpHandshakeMaxLine = 512
+	maxHandshakeMs      = 10000
+)
+
+// tcpSampleFormats are the sample formats a TCP client can ask for
+var tcpSampleFormats = []string{"int16", "int24", "float32"}
+
+// describeTCPFormat returns the key=value description of a layout used in
+// the handshake
+func describeTCPFormat(format wavFormat, codec string, framing bool) string {
+	framed := 0
+	if framing {
+		framed = 1
+	}
+	return fmt.Sprintf("rate=%d channels=%d sample_format=%s codec=%s framing=%d",
+		format.SampleRate, format.Channels, format.sampleFormat(), codec, framed)
+}
+
+// parseTCPFormatRequest returns the layout and codec, one of codecs, that a
+// client's FORMAT line asks for; an empty line keeps source in PCM
+func parseTCPFormatRequest(line string, source wavFormat, codecs []string) (wavFormat, string, error) {
+	if line == "" {
+		return source, tcpCodecPCM, nil
+	}
+	fields := strings.Fields(line)
+	if fields[0] != "FORMAT" {
+		return source, "", fmt.Errorf("expected FORMAT, got %q", fields[0])
+	}
+
+	params := url.Values{}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		switch {
+		case !ok:
+			return source, "", fmt.Errorf("invalid parameter %q", field)
+		case key == "codec" && !slices.Contains(codecs, value):
+			return source, "", fmt.Errorf("codec must be one of %s", strings.Join(codecs, ", "))
+		case key != "codec" && key != "rate" && key != "channels" && key != "sample_format":
+			return source, "", fmt.Errorf("unknown parameter %q", key)
+		}
+		params.Set(key, value)
+	}
+
+	format, err := clientFormat(source, params.Get("sample_format"))
+	if err != nil {
+		return source, "", err
+	}
+	if format, err = streamLayout(format, params); err != nil {
+		return source, "", err
+	}
+	codec := params.Get("codec")
+	if codec == "" {
+		codec = tcpCodecPCM
+	}
+	if codec == tcpCodecOpus {
+		if err := streamEncodings[tcpCodecOpus].check(format); err != nil {
+			return source, "", err
+		}
+	}
+	return format, codec, nil
+}
+
+// handshake describes the stream to a new client and returns the layout
+// and codec it asked for. Requests that can't be met are answered with an
+// ERROR line.
+func (ts *TCPServer) handshake(conn net.Conn, source wavFormat) (wavFormat, string, error) {
+	tcp := ts.config.Protocols.TCP
+	deadline := time.Now().Add(time.Duration(tcp.HandshakeTimeoutMs * float64(time.Millisecond)))
+	conn.SetWriteDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+
+	codecs := ts.tcpCodecs()
+	_, err := fmt.Fprintf(conn, "AUDIORELAY/%d %s sample_formats=%s codecs=%s\n", tcpHandshakeVersion,
+		describeTCPFormat(source, tcpCodecPCM, tcp.Framing), strings.Join(tcpSampleFormats, ","), strings.Join(codecs, ","))
+	if err != nil {
+		return source, "", err
+	}
+
+	conn.SetReadDeadline(deadline)
+	line, err := bufio.NewReaderSize(conn, tcpHandshakeMaxLine).ReadSlice('\n')
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && len(line) == 0 {
+		// The client takes the stream as it is
+		err = nil
+	}
+	format, codec := source, tcpCodecPCM
+	if err == nil {
+		format, codec, err = parseTCPFormatRequest(strings.TrimSpace(string(line)), source, codecs)
+	} else if errors.Is(err, bufio.ErrBufferFull) {
+		err = fmt.Errorf("request longer than %d bytes", tcpHandshakeMaxLine)
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		fmt.Fprintf(conn, "ERROR %v\n", err)
+		return source, "", err
+	}
+	_, err = fmt.Fprintf(conn, "OK %s\n", describeTCPFormat(format, codec, tcp.Framing))
+	return format, codec, err
+}
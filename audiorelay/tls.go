@@ -0,0 +1,167 @@
+package audiorelay
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is how long a generated certificate is valid
+const selfSignedValidity = 2 * 365 * 24 * time.Hour
+
+// TLSConfig serves the HTTP endpoints over HTTPS
+type TLSConfig struct {
+	Enabled    bool     `mapstructure:"enabled" desc:"Serve the stream, web page and API over HTTPS"`
+	CertFile   string   `mapstructure:"cert_file" desc:"PEM certificate, with any intermediates"`
+	KeyFile    string   `mapstructure:"key_file" desc:"PEM private key"`
+	SelfSigned bool     `mapstructure:"self_signed" desc:"Generate a self-signed certificate at cert_file and key_file if neither exists"`
+	Hosts      []string `mapstructure:"hosts" desc:"Names and IPs the self-signed certificate is valid for; empty for localhost, the hostname and local addresses"`
+}
+
+// loadTLSConfig loads the certificate, generating a self-signed one first
+// if configured and none exists yet
+func loadTLSConfig(config TLSConfig) (*tls.Config, error) {
+	if config.SelfSigned && !fileExists(config.CertFile) && !fileExists(config.KeyFile) {
+		if err := generateSelfSigned(config); err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %v", err)
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		if time.Now().After(leaf.NotAfter) {
+			log.Printf("⚠️ TLS certificate %s expired on %s", config.CertFile, leaf.NotAfter.Format("2006-01-02"))
+		}
+		log.Printf("🔒 TLS certificate for %s, SHA-256 fingerprint %s",
+			strings.Join(certNames(leaf), ", "), certFingerprint(leaf))
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// generateSelfSigned writes a new key and self-signed certificate
+func generateSelfSigned(config TLSConfig) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "audiorelay", Organization: []string{"audiorelay self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	hosts := config.Hosts
+	if len(hosts) == 0 {
+		hosts = defaultCertHosts()
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{config.CertFile, config.KeyFile} {
+		if dir := filepath.Dir(path); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.WriteFile(config.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(config.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	log.Printf("🔒 Generated a self-signed certificate for %s in %s; browsers will ask to trust it once",
+		strings.Join(hosts, ", "), config.CertFile)
+	return nil
+}
+
+// defaultCertHosts returns the names a self-signed certificate covers by
+// default: localhost, the hostname and the local addresses
+func defaultCertHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+		if !strings.Contains(name, ".") {
+			hosts = append(hosts, name+".local")
+		}
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+	return hosts
+}
+
+// certNames returns the names and addresses a certificate is valid for
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// certFingerprint formats the SHA-256 fingerprint of a certificate the way
+// browsers show it
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// fileExists reports whether path names an existing file
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
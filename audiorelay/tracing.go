@@ -0,0 +1,288 @@
+package audiorelay
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracing of the audio path, exported as OTLP/HTTP JSON so no collector
+// library is needed. A sampled capture buffer is one trace:
+//
+//	audio.buffer
+//	├── capture.read
+//	├── process
+//	│   └── process.<stage>   one per stage of processing.chain
+//	├── encode
+//	└── broadcast
+//	    └── broadcast.<protocol>
+const (
+	// tracingExportInterval is how often finished spans are sent
+	tracingExportInterval = 5 * time.Second
+	// tracingMaxPending bounds the spans waiting for export; newer ones are
+	// dropped while the collector is unreachable
+	tracingMaxPending = 8192
+	// tracingTimeout bounds each export request
+	tracingTimeout = 10 * time.Second
+)
+
+// TracingConfig sends spans of the audio path to an OpenTelemetry collector
+type TracingConfig struct {
+	Endpoint    string            `mapstructure:"endpoint" desc:"OTLP/HTTP collector, e.g. http://localhost:4318; spans are POSTed to /v1/traces. Empty disables tracing"`
+	ServiceName string            `mapstructure:"service_name" desc:"service.name of the spans"`
+	SampleRatio float64           `mapstructure:"sample_ratio" desc:"Fraction of capture buffers traced, between 0 and 1"`
+	Headers     map[string]string `mapstructure:"headers" secret:"true" desc:"Extra headers sent to the collector, e.g. for authentication"`
+}
+
+// Enabled reports whether spans are exported
+func (t TracingConfig) Enabled() bool {
+	return t.Endpoint != "" && t.SampleRatio > 0
+}
+
+// validate checks the endpoint and the sampling ratio
+func (t TracingConfig) validate() error {
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample_ratio must be between 0 and 1")
+	}
+	if t.Endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(t.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid tracing endpoint %q", t.Endpoint)
+	}
+	return nil
+}
+
+// Tracer samples capture buffers and exports their spans in batches
+type Tracer struct {
+	config TracingConfig
+	url    string
+	client *http.Client
+	every  uint64 // Every how many buffers one is traced
+	count  atomic.Uint64
+
+	mu      sync.Mutex
+	pending []*span
+	dropped int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTracer creates a tracer for config, which has been validated
+func NewTracer(config TracingConfig) *Tracer {
+	return &Tracer{
+		config: config,
+		url:    strings.TrimSuffix(config.Endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: tracingTimeout},
+		every:  uint64(max(1, math.Round(1/config.SampleRatio))),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start exports spans until Stop
+func (t *Tracer) Start() {
+	log.Printf("🔭 Tracing 1 in %d capture buffers to %s", t.every, t.url)
+	go t.run()
+}
+
+// Stop exports the remaining spans and ends the tracer
+func (t *Tracer) Stop() {
+	close(t.stop)
+	<-t.done
+}
+
+// run exports the finished spans every tracingExportInterval
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(tracingExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush()
+		case <-t.stop:
+			t.flush()
+			return
+		}
+	}
+}
+
+// span is one timed operation of a trace. Methods of nil spans do nothing,
+// so untraced buffers cost a nil check per stage.
+type span struct {
+	tracer  *Tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte
+	name    string
+	start   time.Time
+	end     time.Time
+	attrs   map[string]interface{}
+	ended   bool
+}
+
+// startTrace starts the root span of a buffer's trace, or returns nil if
+// the buffer isn't sampled. Nil tracers never sample.
+func (t *Tracer) startTrace(name string) *span {
+	if t == nil || t.count.Add(1)%t.every != 0 {
+		return nil
+	}
+	s := &span{tracer: t, name: name, start: time.Now()}
+	rand.Read(s.traceID[:])
+	rand.Read(s.id[:])
+	return s
+}
+
+// child starts a span inside s
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	c := &span{tracer: s.tracer, traceID: s.traceID, parent: s.id, name: name, start: time.Now()}
+	rand.Read(c.id[:])
+	return c
+}
+
+// set adds an attribute
+func (s *span) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// finish ends the span and queues it for export; later calls do nothing
+func (s *span) finish() {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= tracingMaxPending {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, s)
+}
+
+// flush exports the queued spans. Failed exports are dropped rather than
+// retried, so an unreachable collector doesn't grow memory.
+func (t *Tracer) flush() {
+	t.mu.Lock()
+	spans, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		debugf("Tracing: dropped %d spans, the collector is falling behind", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.export(spans); err != nil {
+		log.Printf("⚠️ Tracing export of %d spans failed: %v", len(spans), err)
+	}
+}
+
+// export POSTs spans as an OTLP ExportTraceServiceRequest in JSON
+func (t *Tracer) export(spans []*span) error {
+	encoded := make([]map[string]interface{}, len(spans))
+	for i, s := range spans {
+		encoded[i] = s.otlp()
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": t.config.ServiceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "audiorelay"},
+				"spans": encoded,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.config.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+// otlp encodes the span for OTLP JSON: hex IDs and nanosecond times as
+// strings
+func (s *span) otlp() map[string]interface{} {
+	encoded := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.id[:]),
+		"name":              s.name,
+		"kind":              1, // SPAN_KIND_INTERNAL
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		encoded["parentSpanId"] = hex.EncodeToString(s.parent[:])
+	}
+	if len(s.attrs) > 0 {
+		encoded["attributes"] = otlpAttributes(s.attrs)
+	}
+	return encoded
+}
+
+// otlpAttributes encodes attributes as OTLP KeyValues
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	encoded := make([]interface{}, 0, len(attrs))
+	for key, value := range attrs {
+		var v map[string]interface{}
+		switch value := value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": value}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": value}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+		}
+		encoded = append(encoded, map[string]interface{}{"key": key, "value": v})
+	}
+	return encoded
+}
@@ -0,0 +1,313 @@
+package audiorelay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of the rate parameter of /stream
+const (
+	minStreamRate = 8000
+	maxStreamRate = 192000
+)
+
+// encoderStopTimeout is how long ffmpeg gets to flush its output when a
+// stream ends before it is killed
+const encoderStopTimeout = 2 * time.Second
+
+// streamEncoding is a format /stream can deliver
+type streamEncoding struct {
+	contentType string
+	ffmpeg      []string // Output options of an ffmpeg encoder; nil for PCM served directly
+	maxChannels int
+	rates       []int // Sample rates the codec supports; nil for any
+}
+
+// streamEncodings are the formats of /stream, keyed by ?format=
+var streamEncodings = map[string]streamEncoding{
+	containerWAV: {contentType: "audio/wav", maxChannels: 8},
+	containerPCM: {contentType: "application/octet-stream", maxChannels: 8},
+	"opus": {
+		contentType: "audio/ogg; codecs=opus",
+		ffmpeg:      []string{"-c:a", "libopus", "-b:a", "128k", "-frame_duration", "20", "-f", "ogg"},
+		maxChannels: 2,
+		rates:       []int{8000, 12000, 16000, 24000, 48000},
+	},
+	"mp3": {
+		contentType: "audio/mpeg",
+		ffmpeg:      []string{"-c:a", "libmp3lame", "-b:a", "192k", "-f", "mp3"},
+		maxChannels: 2,
+		rates:       []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000},
+	},
+	"flac": {
+		contentType: "audio/flac",
+		ffmpeg:      []string{"-c:a", "flac", "-f", "flac"},
+		maxChannels: 8,
+	},
+}
+
+// encoded reports whether the format needs an ffmpeg encoder
+func (e streamEncoding) encoded() bool {
+	return e.ffmpeg != nil
+}
+
+// check reports whether the codec can carry the given layout
+func (e streamEncoding) check(format wavFormat) error {
+	if format.Channels > e.maxChannels {
+		return fmt.Errorf("at most %d channels are supported in this format", e.maxChannels)
+	}
+	if e.rates == nil {
+		return nil
+	}
+	for _, rate := range e.rates {
+		if rate == format.SampleRate {
+			return nil
+		}
+	}
+	return fmt.Errorf("rate %d isn't supported in this format", format.SampleRate)
+}
+
+// negotiateEncoding picks the format of /stream from ?format=, or from the
+// Accept header when it is missing
+func negotiateEncoding(format, accept string) (string, error) {
+	if format != "" {
+		if _, ok := streamEncodings[format]; !ok {
+			return "", fmt.Errorf("format must be one of %s", strings.Join(encodingNames(), ", "))
+		}
+		return format, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "audio/mpeg", "audio/mp3":
+			return "mp3", nil
+		case "audio/ogg", "audio/opus":
+			return "opus", nil
+		case "audio/flac", "audio/x-flac":
+			return "flac", nil
+		case "audio/wav", "audio/wave", "audio/x-wav":
+			return containerWAV, nil
+		}
+	}
+	return containerWAV, nil
+}
+
+// encodingNames returns the formats of /stream
+func encodingNames() []string {
+	return []string{containerWAV, containerPCM, "opus", "mp3", "flac"}
+}
+
+// streamFormats returns the formats /stream can deliver on this host
+func (hs *HTTPServer) streamFormats() []string {
+	_, err := exec.LookPath(hs.config.Protocols.HTTP.FFmpegPath)
+	formats := make([]string, 0, len(streamEncodings))
+	for _, name := range encodingNames() {
+		if !streamEncodings[name].encoded() || err == nil {
+			formats = append(formats, name)
+		}
+	}
+	return formats
+}
+
+// streamLayout applies the rate and channels query parameters to format
+func streamLayout(format wavFormat, query url.Values) (wavFormat, error) {
+	if v := query.Get("rate"); v != "" {
+		rate, err := strconv.Atoi(v)
+		if err != nil || rate < minStreamRate || rate > maxStreamRate {
+			return format, fmt.Errorf("rate must be between %d and %d", minStreamRate, maxStreamRate)
+		}
+		format.SampleRate = rate
+	}
+	if v := query.Get("channels"); v != "" {
+		channels, err := strconv.Atoi(v)
+		if err != nil || channels < 1 {
+			return format, fmt.Errorf("invalid channels: %q", v)
+		}
+		if channels != format.Channels && channels != 1 && format.Channels != 1 {
+			return format, fmt.Errorf("%d channels can only be mixed down to mono", format.Channels)
+		}
+		format.Channels = channels
+	}
+	return format, nil
+}
+
+// streamConverter changes the channel count and sample rate of a stream
+// chunk by chunk. It keeps the last frame of each chunk, so the linear
+// interpolation runs across chunk boundaries without clicks.
+type streamConverter struct {
+	fromChannels, toChannels int
+	step                     float64 // Input frames per output frame
+
+	pos  float64   // Position of the next output frame; -1 is prev
+	prev []float64 // Last frame of the previous chunk, nil before the first
+}
+
+// newStreamConverter converts from one layout to another, or returns nil
+// if they have the same rate and channels
+func newStreamConverter(from, to wavFormat) *streamConverter {
+	if from.SampleRate == to.SampleRate && from.Channels == to.Channels {
+		return nil
+	}
+	return &streamConverter{
+		fromChannels: from.Channels,
+		toChannels:   to.Channels,
+		step:         float64(from.SampleRate) / float64(to.SampleRate),
+	}
+}
+
+// process converts one chunk of interleaved samples
+func (sc *streamConverter) process(samples []float64) []float64 {
+	if sc.fromChannels != sc.toChannels {
+		// streamLayout only allows conversions convertChannels supports
+		samples, _ = convertChannels(samples, sc.fromChannels, sc.toChannels)
+	}
+	if sc.step == 1 {
+		return samples
+	}
+
+	channels := sc.toChannels
+	frames := len(samples) / channels
+	if frames == 0 {
+		return nil
+	}
+	frame := func(i int) []float64 {
+		if i < 0 {
+			return sc.prev
+		}
+		return samples[i*channels : (i+1)*channels]
+	}
+
+	out := make([]float64, 0, int(float64(frames)/sc.step+1)*channels)
+	for ; sc.pos < float64(frames-1); sc.pos += sc.step {
+		i := int(sc.pos+1) - 1 // Floor, also for -1 < pos < 0
+		frac := sc.pos - float64(i)
+		a, b := frame(i), frame(i+1)
+		for c := 0; c < channels; c++ {
+			out = append(out, a[c]+(b[c]-a[c])*frac)
+		}
+	}
+	sc.pos -= float64(frames)
+	sc.prev = append(sc.prev[:0], frame(frames-1)...)
+	return out
+}
+
+// ffmpegEncoder encodes PCM written to it with an ffmpeg process and sends
+// the output to an HTTP response
+type ffmpegEncoder struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+	copied chan struct{} // Closed once the output has been copied
+}
+
+// encoderArgs returns the ffmpeg arguments reading PCM in input from stdin
+// and writing the encoding at the rate and channels of output, with any
+// extra output options, to stdout
+func encoderArgs(encoding streamEncoding, input, output wavFormat, extra ...string) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-f", input.rawName(), "-ar", strconv.Itoa(input.SampleRate), "-ac", strconv.Itoa(input.Channels), "-i", "pipe:0",
+		"-ar", strconv.Itoa(output.SampleRate), "-ac", strconv.Itoa(output.Channels)}
+	args = append(args, encoding.ffmpeg...)
+	args = append(args, extra...)
+	return append(args, "-flush_packets", "1", "pipe:1")
+}
+
+// startEncoder starts ffmpeg reading PCM in input and writing the encoding
+// at the rate and channels of output to w
+func startEncoder(ctx context.Context, path string, encoding streamEncoding, input, output wavFormat, w http.ResponseWriter) (*ffmpegEncoder, error) {
+	e := &ffmpegEncoder{
+		cmd:    exec.CommandContext(ctx, path, encoderArgs(encoding, input, output)...),
+		copied: make(chan struct{}),
+	}
+	e.cmd.Stderr = &e.stderr
+	stdin, err := e.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := e.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+	e.stdin = stdin
+
+	go e.copyOutput(stdout, w)
+	return e, nil
+}
+
+// copyOutput sends the encoded stream to the client as it is produced
+func (e *ffmpegEncoder) copyOutput(stdout io.Reader, w http.ResponseWriter) {
+	defer close(e.copied)
+	rc := http.NewResponseController(w)
+	defer rc.SetWriteDeadline(time.Time{})
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			rc.SetWriteDeadline(time.Now().Add(httpWriteTimeout))
+			if _, err := w.Write(buf[:n]); err != nil {
+				// The client is gone; stop encoding for it
+				e.cmd.Process.Kill()
+				io.Copy(io.Discard, stdout)
+				return
+			}
+			rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// close lets ffmpeg encode the rest of its input and waits until the
+// output has been sent, killing it if it takes too long
+func (e *ffmpegEncoder) close() {
+	e.stdin.Close()
+
+	timer := time.AfterFunc(encoderStopTimeout, func() { e.cmd.Process.Kill() })
+	defer timer.Stop()
+
+	<-e.copied
+	if err := e.cmd.Wait(); err != nil && e.stderr.Len() > 0 {
+		log.Printf("⚠️ ffmpeg encoder: %v: %s", err, strings.TrimSpace(e.stderr.String()))
+	}
+}
+
+// handleStream serves the stream in the format, sample rate and channel
+// count the client asks for with ?format=, ?rate= and ?channels=
+func (hs *HTTPServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	container, err := negotiateEncoding(query.Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Here ?format= picks the container, so the sample encoding that is
+	// ?format= on /stream.wav comes from ?sample_format=
+	prefsQuery := url.Values{}
+	for key, values := range query {
+		prefsQuery[key] = values
+	}
+	prefsQuery.Del("format")
+	if v := query.Get("sample_format"); v != "" {
+		prefsQuery.Set("format", v)
+	}
+	hs.serveStream(w, r, container, prefsQuery)
+}
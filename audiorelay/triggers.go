@@ -0,0 +1,199 @@
+package audiorelay
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TriggerManager watches the broadcast stream for trigger conditions and
+// saves a snapshot of the surrounding audio when one fires
+type TriggerManager struct {
+	config  *Config
+	capture *AudioCapture // For the current silence threshold
+	events  *EventBus
+	format  wavFormat
+
+	// Recent audio kept for snapshot pre-roll
+	history       [][]byte
+	historyBytes  int
+	preRollBytes  int
+	postRollBytes int
+
+	// Snapshots still collecting post-roll audio
+	pending []*snapshot
+
+	lastTrigger time.Time
+}
+
+// snapshot is a clip being assembled around a trigger
+type snapshot struct {
+	name      string
+	data      bytes.Buffer
+	remaining int // post-roll bytes still to collect
+}
+
+// NewTriggerManager creates a trigger manager for the broadcast stream
+func NewTriggerManager(config *Config, capture *AudioCapture, events *EventBus) *TriggerManager {
+	// Snapshots hold the broadcast stream before any per-endpoint upmix
+	format := config.StreamFormat(false)
+	snap := config.Triggers.Snapshot
+
+	return &TriggerManager{
+		config:        config,
+		capture:       capture,
+		events:        events,
+		format:        format,
+		preRollBytes:  secondsToBytes(format, snap.PreRollSeconds),
+		postRollBytes: secondsToBytes(format, snap.PostRollSeconds),
+	}
+}
+
+// Feed inspects a broadcast buffer for triggers and collects snapshot audio
+func (tm *TriggerManager) Feed(data []byte) {
+	// Complete pending snapshots with post-roll audio
+	tm.collectPostRoll(data)
+
+	// Check the level trigger
+	level := tm.config.Triggers.Level
+	if level.Enabled {
+		peak := peakLevel(data, tm.format)
+		cooldown := time.Duration(level.CooldownSeconds * float64(time.Second))
+		if peak >= level.Threshold && time.Since(tm.lastTrigger) >= cooldown {
+			tm.lastTrigger = time.Now()
+			tm.fire(EventLevelTrigger, data, map[string]interface{}{
+				"level":     peak,
+				"threshold": level.Threshold,
+			})
+		}
+	}
+
+	// Remember the buffer for future pre-roll
+	if tm.config.Triggers.Snapshot.Enabled {
+		tm.history = append(tm.history, data)
+		tm.historyBytes += len(data)
+		for len(tm.history) > 1 && tm.historyBytes-len(tm.history[0]) >= tm.preRollBytes {
+			tm.historyBytes -= len(tm.history[0])
+			tm.history = tm.history[1:]
+		}
+	}
+}
+
+// fire publishes a trigger event, starting a snapshot if enabled
+func (tm *TriggerManager) fire(eventType string, data []byte, payload map[string]interface{}) {
+	if tm.config.Triggers.Snapshot.Enabled {
+		snap := &snapshot{
+			name:      fmt.Sprintf("%s-%s.wav", eventType, time.Now().Format("20060102-150405.000")),
+			remaining: tm.postRollBytes,
+		}
+
+		// Pre-roll from the history, trimmed to the configured length
+		skip := tm.historyBytes - tm.preRollBytes
+		for _, frame := range tm.history {
+			if skip >= len(frame) {
+				skip -= len(frame)
+				continue
+			}
+			if skip > 0 {
+				frame = frame[skip:]
+				skip = 0
+			}
+			snap.data.Write(frame)
+		}
+
+		// The triggering buffer is only new to this snapshot; older
+		// pending ones already got it in Feed
+		if snap.collect(data) {
+			tm.pending = append(tm.pending, snap)
+		} else {
+			go tm.saveSnapshot(snap)
+		}
+
+		payload["clip"] = snap.name
+	}
+
+	log.Printf("🔔 Trigger fired: %s", eventType)
+	tm.events.Publish(eventType, payload)
+}
+
+// collectPostRoll appends audio to pending snapshots and saves finished ones
+func (tm *TriggerManager) collectPostRoll(data []byte) {
+	if len(tm.pending) == 0 {
+		return
+	}
+
+	stillPending := tm.pending[:0]
+	for _, snap := range tm.pending {
+		if snap.collect(data) {
+			stillPending = append(stillPending, snap)
+		} else {
+			go tm.saveSnapshot(snap)
+		}
+	}
+	tm.pending = stillPending
+}
+
+// collect appends post-roll audio, reporting whether more is needed
+func (snap *snapshot) collect(data []byte) bool {
+	chunk := data
+	if len(chunk) > snap.remaining {
+		chunk = chunk[:snap.remaining]
+	}
+	snap.data.Write(chunk)
+	snap.remaining -= len(chunk)
+	return snap.remaining > 0
+}
+
+// saveSnapshot writes a finished snapshot to the clip directory
+func (tm *TriggerManager) saveSnapshot(snap *snapshot) {
+	dir := tm.config.Triggers.Snapshot.Directory
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Failed to create snapshot directory: %v", err)
+		return
+	}
+
+	path := filepath.Join(dir, snap.name)
+	file, err := os.Create(path)
+	if err != nil {
+		log.Printf("Failed to create snapshot: %v", err)
+		return
+	}
+	defer file.Close()
+
+	audio := snap.data.Bytes()
+	if tm.config.Triggers.Snapshot.TrimSilence {
+		audio = trimSilentPCM(audio, tm.format, tm.capture.levelSettings().SilenceThreshold)
+	}
+
+	tm.format.writeHeader(file, uint32(len(audio)))
+	if _, err := file.Write(audio); err != nil {
+		log.Printf("Failed to write snapshot: %v", err)
+		return
+	}
+
+	log.Printf("💾 Snapshot saved: %s", path)
+	tm.events.Publish(EventSnapshotSaved, map[string]interface{}{
+		"clip":     snap.name,
+		"path":     path,
+		"bytes":    len(audio),
+		"duration": float64(len(audio)) / float64(tm.format.byteRate()),
+	})
+}
+
+// secondsToBytes converts a duration to a frame-aligned byte count
+func secondsToBytes(format wavFormat, seconds float64) int {
+	return int(seconds*float64(format.SampleRate)) * format.blockAlign()
+}
+
+// peakLevel returns the largest absolute sample in 16-bit full-scale units
+func peakLevel(data []byte, format wavFormat) int {
+	peak := 0.0
+	for _, sample := range format.decodeSamples(data) {
+		peak = math.Max(peak, math.Abs(sample))
+	}
+	return int(math.Round(peak))
+}
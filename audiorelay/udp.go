@@ -0,0 +1,236 @@
+package audiorelay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDP packet layout. Every datagram starts with a fixed big-endian header
+// describing the audio so receivers can start at any packet:
+//
+//	0  magic "AR"
+//	2  version
+//	3  channels
+//	4  sample rate (uint32)
+//	8  bits per sample
+//	9  flags (bit 0: IEEE float samples, bit 1: end of stream, bit 2: format change)
+//	10 format version (uint16, low bits of the stream's format version)
+//	12 sequence number (uint32, wraps)
+//
+// The little-endian PCM payload follows and always holds whole frames.
+const (
+	udpHeaderSize = 16
+	udpVersion    = 1
+	udpFlagFloat  = 0x01
+	udpFlagEnd    = 0x02 // Control packet without audio: the stream has ended
+	udpFlagFormat = 0x04 // Control packet without audio: later packets use the new format
+)
+
+// IP and UDP header sizes subtracted from the MTU
+const (
+	ipv4Overhead = 20 + 8
+	ipv6Overhead = 40 + 8
+)
+
+// UDPSender pushes the stream to a fixed list of unicast targets
+type UDPSender struct {
+	config   *Config
+	conn     *net.UDPConn
+	targets  []*net.UDPAddr
+	identity *StreamIdentity
+	capture  *AudioCapture // Whose rate the stream follows, nil for the configured rate
+
+	// Largest whole-frame payload that fits in one unfragmented packet
+	maxPayload int
+
+	mu          sync.Mutex
+	sequence    uint32
+	pending     []byte // Aggregated audio not yet filling a packet
+	packetsSent int64
+
+	delivery *latencySamples // Capture to send latency of the packets, may be nil
+}
+
+// NewUDPSender creates a new UDP sender instance
+func NewUDPSender(config *Config) *UDPSender {
+	return &UDPSender{config: config}
+}
+
+// Start resolves the configured targets and opens the sending socket
+func (us *UDPSender) Start() error {
+	overhead := ipv4Overhead
+	for _, target := range us.config.Protocols.UDP.Targets {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			return fmt.Errorf("invalid UDP target %q: %v", target, err)
+		}
+		if addr.IP.To4() == nil {
+			overhead = ipv6Overhead
+		}
+		us.targets = append(us.targets, addr)
+	}
+
+	blockAlign := us.format().blockAlign()
+	us.maxPayload = (us.config.Protocols.UDP.MTU - overhead - udpHeaderSize) / blockAlign * blockAlign
+	if us.maxPayload <= 0 {
+		return fmt.Errorf("MTU %d is too small for one audio frame", us.config.Protocols.UDP.MTU)
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return fmt.Errorf("failed to open UDP socket: %v", err)
+	}
+	us.conn = conn
+	us.config.markConn(conn)
+
+	us.displayInfo()
+	return nil
+}
+
+// Stop closes the sending socket
+func (us *UDPSender) Stop() {
+	if us.conn != nil {
+		us.sendEnd()
+		us.conn.Close()
+	}
+	fmt.Println(" UDP sender stopped")
+}
+
+// format returns the PCM layout sent to UDP targets
+func (us *UDPSender) format() wavFormat {
+	if us.capture == nil {
+		return us.config.StreamFormat(us.config.Protocols.UDP.UpmixStereo)
+	}
+	return us.capture.StreamFormat(us.config.Protocols.UDP.UpmixStereo)
+}
+
+// Broadcast splits audio data read from the device at captured into
+// packets and sends them to every target
+func (us *UDPSender) Broadcast(data []byte, captured time.Time) {
+	format := us.format()
+	if format.Channels != us.config.OutputChannels() {
+		data = duplicateChannels(data, format.Channels, format.bytesPerSample())
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	// Aggregation carries the remainder over so every packet is full size
+	if us.config.Protocols.UDP.Aggregate {
+		data = append(us.pending, data...)
+		full := len(data) / us.maxPayload * us.maxPayload
+		us.pending = append(us.pending[:0:0], data[full:]...)
+		data = data[:full]
+	}
+
+	for _, packet := range us.packetize(data, format) {
+		for _, target := range us.targets {
+			// Receivers that are down must not stall the others
+			if _, err := us.conn.WriteToUDP(packet, target); err != nil {
+				debugf("UDP send to %s failed: %v", target, err)
+			}
+		}
+		us.packetsSent++
+		us.delivery.record(time.Since(captured))
+	}
+}
+
+// sendEnd flushes aggregated audio and tells the targets the stream ended
+func (us *UDPSender) sendEnd() {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	format := us.format()
+	packets := us.packetize(us.pending, format)
+	us.pending = nil
+
+	end := us.header(format, udpFlagEnd)
+	us.sequence++
+	packets = append(packets, end)
+
+	for _, packet := range packets {
+		for _, target := range us.targets {
+			us.conn.WriteToUDP(packet, target)
+		}
+	}
+}
+
+// formatChanged drops aggregated audio in the old format and announces the
+// new format before the first packet that uses it
+func (us *UDPSender) formatChanged() {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	us.pending = nil
+	packet := us.header(us.format(), udpFlagFormat)
+	us.sequence++
+	for _, target := range us.targets {
+		us.conn.WriteToUDP(packet, target)
+	}
+}
+
+// header builds a packet header for the current sequence number
+func (us *UDPSender) header(format wavFormat, flags byte) []byte {
+	if format.Float {
+		flags |= udpFlagFloat
+	}
+	header := make([]byte, udpHeaderSize)
+	copy(header[0:2], "AR")
+	header[2] = udpVersion
+	header[3] = byte(format.Channels)
+	binary.BigEndian.PutUint32(header[4:8], uint32(format.SampleRate))
+	header[8] = byte(format.BitsPerSample)
+	header[9] = flags
+	_, version := us.identity.Snapshot()
+	binary.BigEndian.PutUint16(header[10:12], uint16(version))
+	binary.BigEndian.PutUint32(header[12:16], us.sequence)
+	return header
+}
+
+// packetize splits data at frame boundaries and prefixes each part with a header
+func (us *UDPSender) packetize(data []byte, format wavFormat) [][]byte {
+	packets := make([][]byte, 0, len(data)/us.maxPayload+1)
+	for len(data) > 0 {
+		n := min(len(data), us.maxPayload)
+		packet := append(us.header(format, 0), data[:n]...)
+
+		us.sequence++
+		packets = append(packets, packet)
+		data = data[n:]
+	}
+	return packets
+}
+
+// debugInfo reports the packet sizing for the debug endpoint
+func (us *UDPSender) debugInfo() interface{} {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	format := us.format()
+	return map[string]interface{}{
+		"targets":           len(us.targets),
+		"mtu":               us.config.Protocols.UDP.MTU,
+		"aggregate":         us.config.Protocols.UDP.Aggregate,
+		"max_payload_bytes": us.maxPayload,
+		"max_packet_bytes":  us.maxPayload + udpHeaderSize,
+		"frames_per_packet": us.maxPayload / format.blockAlign(),
+		"packets_sent":      us.packetsSent,
+	}
+}
+
+// displayInfo shows where the stream is sent
+func (us *UDPSender) displayInfo() {
+	fmt.Printf("\nUDP Targets (packets up to %d bytes, MTU %d):\n",
+		us.maxPayload+udpHeaderSize, us.config.Protocols.UDP.MTU)
+	for _, target := range us.targets {
+		fmt.Printf("    udp://%s\n", target)
+	}
+	if len(us.targets) == 0 {
+		log.Printf("  UDP enabled without targets, nothing will be sent")
+	}
+	fmt.Println()
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package audiorelay
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTCPUserTimeout makes the kernel close the connection, failing the next
+// write, once sent data has gone unacknowledged for timeout. Heartbeats keep
+// data in flight, so a vanished client is noticed even while no audio flows.
+func setTCPUserTimeout(conn *net.TCPConn, timeout time.Duration) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(timeout.Milliseconds()))
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("failed to set TCP_USER_TIMEOUT: %v", sockErr)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package audiorelay
+
+import (
+	"net"
+	"time"
+)
+
+// setTCPUserTimeout is Linux only; elsewhere dead clients are found by
+// keepalive probes and write deadlines
+func setTCPUserTimeout(conn *net.TCPConn, timeout time.Duration) error {
+	return nil
+}
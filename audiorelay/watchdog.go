@@ -0,0 +1,79 @@
+package audiorelay
+
+import (
+	"log"
+	"time"
+)
+
+// WatchdogConfig restarts capture when the device stops delivering audio
+type WatchdogConfig struct {
+	Enabled        bool    `mapstructure:"enabled" desc:"Restart the capture stream when no audio arrives for timeout_seconds"`
+	TimeoutSeconds float64 `mapstructure:"timeout_seconds" desc:"Time without captured buffers before the stream counts as stalled"`
+}
+
+// SetStallCallback sets the function called when the watchdog restarts a
+// stalled stream
+func (ac *AudioCapture) SetStallCallback(callback func(device string, stalled time.Duration)) {
+	ac.stallCallback = callback
+}
+
+// markAlive records that the stream is delivering audio, or has just been
+// (re)started and deserves a full timeout before it counts as stalled
+func (ac *AudioCapture) markAlive() {
+	ac.lastRead.Store(time.Now().UnixNano())
+}
+
+// watchdog stops a stream that hasn't delivered a buffer within the
+// timeout. A driver that stalls after the machine slept usually leaves Read
+// blocked forever; stopping the stream makes it return, and the processing
+// loop then reopens the device.
+func (ac *AudioCapture) watchdog() {
+	timeout := time.Duration(ac.config.Audio.Watchdog.TimeoutSeconds * float64(time.Second))
+	ticker := time.NewTicker(max(timeout/4, 100*time.Millisecond))
+	defer ticker.Stop()
+
+	ac.markAlive()
+	for ac.isRunning {
+		<-ticker.C
+		if ac.IsAsleep() {
+			continue
+		}
+		stalled := time.Since(time.Unix(0, ac.lastRead.Load()))
+		if stalled < timeout {
+			continue
+		}
+
+		ac.mu.Lock()
+		if ac.stream == nil || !ac.isRunning {
+			// Reconnecting, or stopped
+			ac.mu.Unlock()
+			continue
+		}
+		log.Printf("🐕 No audio from %s for %.1fs, restarting capture", ac.device.Name, stalled.Seconds())
+		ac.stalled.Store(true)
+		ac.stream.Stop()
+		device := ac.device.Name
+		ac.mu.Unlock()
+
+		ac.markAlive()
+		if ac.stallCallback != nil {
+			ac.stallCallback(device, stalled)
+		}
+	}
+}
+
+// restartStalled reopens the device after the watchdog stopped its stream,
+// falling back to waiting for the device if it can't be reopened. It returns
+// false if capture was stopped in the meantime.
+func (ac *AudioCapture) restartStalled() bool {
+	if err := ac.switchStream(ac.Device(), true); err != nil {
+		log.Printf("Failed to restart stalled capture: %v", err)
+		if !ac.config.Audio.Reconnect.Enabled {
+			return false
+		}
+		return ac.reconnect()
+	}
+	ac.markAlive()
+	log.Printf("🐕 Capture restarted: %s", ac.Device().Name)
+	return true
+}
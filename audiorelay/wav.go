@@ -0,0 +1,290 @@
+package audiorelay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// wavHeaderSize is the length of the canonical 16-bit PCM WAV header
+const wavHeaderSize = 44
+
+// wavExtensibleHeaderSize is the length of a WAVE_FORMAT_EXTENSIBLE header
+const wavExtensibleHeaderSize = 68
+
+// wavUnknownSize marks the RIFF and data sizes as unknown for endless streams
+const wavUnknownSize = 0xffffffff
+
+// wavMaxDataSize is the largest data chunk a WAV header can describe
+const wavMaxDataSize = 0xffffffff - wavExtensibleHeaderSize + 8
+
+// WAV format tags
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatExtensible = 0xfffe
+)
+
+// wavSubFormatSuffix is the fixed tail of the KSDATAFORMAT_SUBTYPE GUIDs;
+// the leading four bytes hold the plain format tag
+var wavSubFormatSuffix = []byte{0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// wavChannelMasks are the speaker positions of the usual layouts by channel
+// count, as ffmpeg and Windows assign them: mono, stereo, 3.0, quad, 5.0,
+// 5.1, 6.1 and 7.1
+var wavChannelMasks = map[int]uint32{
+	1: 0x4,
+	2: 0x3,
+	3: 0x7,
+	4: 0x33,
+	5: 0x37,
+	6: 0x3f,
+	7: 0x70f,
+	8: 0x63f,
+}
+
+// wavFormat describes the PCM layout of relayed audio and WAV headers.
+// Supported layouts are 16-bit and 24-bit integer PCM and 32-bit float.
+type wavFormat struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	Float         bool // IEEE float samples (32-bit only)
+}
+
+// bytesPerSample returns the size of a single sample in bytes
+func (f wavFormat) bytesPerSample() int {
+	return f.BitsPerSample / 8
+}
+
+// blockAlign returns the size of one sample frame in bytes
+func (f wavFormat) blockAlign() int {
+	return f.Channels * f.bytesPerSample()
+}
+
+// byteRate returns the number of bytes per second of audio
+func (f wavFormat) byteRate() int {
+	return f.SampleRate * f.blockAlign()
+}
+
+// extensible reports whether the header needs WAVE_FORMAT_EXTENSIBLE,
+// which players expect for anything other than plain 16-bit mono or stereo
+// PCM
+func (f wavFormat) extensible() bool {
+	return f.BitsPerSample != 16 || f.Float || f.Channels > 2
+}
+
+// channelMask returns the speaker positions of the channels. Devices with
+// more than eight channels, such as 16-channel loopback drivers, carry
+// discrete channels without positions, which a zero mask declares.
+func (f wavFormat) channelMask() uint32 {
+	return wavChannelMasks[f.Channels]
+}
+
+// headerSize returns the length of the header written by writeHeader
+func (f wavFormat) headerSize() int {
+	if f.extensible() {
+		return wavExtensibleHeaderSize
+	}
+	return wavHeaderSize
+}
+
+// formatTag returns the plain WAV format tag of the sample encoding
+func (f wavFormat) formatTag() uint16 {
+	if f.Float {
+		return wavFormatIEEEFloat
+	}
+	return wavFormatPCM
+}
+
+// writeHeader writes a WAV file header; pass wavUnknownSize for endless streams
+func (f wavFormat) writeHeader(w io.Writer, dataSize uint32) {
+	riffSize := uint32(wavUnknownSize)
+	if dataSize != wavUnknownSize {
+		riffSize = dataSize + uint32(f.headerSize()) - 8
+	}
+
+	// RIFF header
+	w.Write([]byte("RIFF"))
+	binary.Write(w, binary.LittleEndian, riffSize) // File size
+	w.Write([]byte("WAVE"))
+
+	// Format chunk
+	tag, chunkSize := f.formatTag(), uint32(16)
+	if f.extensible() {
+		tag, chunkSize = wavFormatExtensible, 40
+	}
+	w.Write([]byte("fmt "))
+	binary.Write(w, binary.LittleEndian, chunkSize)               // Chunk size
+	binary.Write(w, binary.LittleEndian, tag)                     // Audio format
+	binary.Write(w, binary.LittleEndian, uint16(f.Channels))      // Number of channels
+	binary.Write(w, binary.LittleEndian, uint32(f.SampleRate))    // Sample rate
+	binary.Write(w, binary.LittleEndian, uint32(f.byteRate()))    // Byte rate
+	binary.Write(w, binary.LittleEndian, uint16(f.blockAlign()))  // Block align
+	binary.Write(w, binary.LittleEndian, uint16(f.BitsPerSample)) // Bits per sample
+	if f.extensible() {
+		binary.Write(w, binary.LittleEndian, uint16(22))              // Extension size
+		binary.Write(w, binary.LittleEndian, uint16(f.BitsPerSample)) // Valid bits per sample
+		binary.Write(w, binary.LittleEndian, f.channelMask())         // Channel mask
+		binary.Write(w, binary.LittleEndian, uint32(f.formatTag()))   // Sub-format GUID
+		w.Write(wavSubFormatSuffix)
+	}
+
+	// Data chunk
+	w.Write([]byte("data"))
+	binary.Write(w, binary.LittleEndian, dataSize) // Data size
+}
+
+// decodeSamples converts little-endian PCM to float samples in 16-bit full-scale units
+func (f wavFormat) decodeSamples(data []byte) []float64 {
+	size := f.bytesPerSample()
+	samples := make([]float64, len(data)/size)
+	for i := range samples {
+		b := data[i*size:]
+		switch {
+		case f.Float:
+			samples[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) * 32768
+		case f.BitsPerSample == 24:
+			// Shift into the top of an int32 so the sign extends
+			v := int32(uint32(b[0])<<8 | uint32(b[1])<<16 | uint32(b[2])<<24)
+			samples[i] = float64(v) / 65536
+		default:
+			samples[i] = float64(int16(binary.LittleEndian.Uint16(b)))
+		}
+	}
+	return samples
+}
+
+// encodeSamples converts float samples in 16-bit full-scale units to
+// little-endian PCM, rounding and saturating integer formats
+func (f wavFormat) encodeSamples(samples []float64) []byte {
+	size := f.bytesPerSample()
+	data := make([]byte, len(samples)*size)
+	for i, sample := range samples {
+		b := data[i*size:]
+		switch {
+		case f.Float:
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(sample/32768)))
+		case f.BitsPerSample == 24:
+			v := uint32(floatToInt24(sample * 256))
+			b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+		default:
+			binary.LittleEndian.PutUint16(b, uint16(floatToInt16(sample)))
+		}
+	}
+	return data
+}
+
+// describe returns a short human-readable name of the sample encoding
+func (f wavFormat) describe() string {
+	if f.Float {
+		return fmt.Sprintf("%d-bit float", f.BitsPerSample)
+	}
+	return fmt.Sprintf("%d-bit PCM", f.BitsPerSample)
+}
+
+// rawName returns the sample encoding as named by ffmpeg's -f option, for
+// headerless PCM
+func (f wavFormat) rawName() string {
+	if f.Float {
+		return fmt.Sprintf("f%dle", f.BitsPerSample)
+	}
+	return fmt.Sprintf("s%dle", f.BitsPerSample)
+}
+
+// sampleFormat returns the sample encoding as named in the config and the
+// format parameters: int16, int24 or float32
+func (f wavFormat) sampleFormat() string {
+	if f.Float {
+		return "float32"
+	}
+	return fmt.Sprintf("int%d", f.BitsPerSample)
+}
+
+// readWAVHeader parses a WAV header, leaving r positioned at the start of
+// the sample data. The returned size is wavUnknownSize for streams.
+func readWAVHeader(r io.Reader) (wavFormat, uint32, error) {
+	var format wavFormat
+
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return format, 0, fmt.Errorf("failed to read WAV header: %v", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return format, 0, fmt.Errorf("not a WAV file")
+	}
+
+	haveFormat := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return format, 0, fmt.Errorf("missing data chunk: %v", err)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return format, 0, fmt.Errorf("invalid fmt chunk")
+			}
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return format, 0, fmt.Errorf("invalid fmt chunk")
+			}
+			tag := binary.LittleEndian.Uint16(body[0:2])
+			if tag == wavFormatExtensible {
+				if size < 40 {
+					return format, 0, fmt.Errorf("invalid extensible fmt chunk")
+				}
+				tag = binary.LittleEndian.Uint16(body[24:26])
+			}
+			format.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			format.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			format.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			format.Float = tag == wavFormatIEEEFloat
+
+			supported := (tag == wavFormatPCM && (format.BitsPerSample == 16 || format.BitsPerSample == 24)) ||
+				(tag == wavFormatIEEEFloat && format.BitsPerSample == 32)
+			if !supported || format.Channels <= 0 || format.SampleRate <= 0 {
+				return format, 0, fmt.Errorf("unsupported WAV format: tag %#x, %d-bit, %d channels",
+					tag, format.BitsPerSample, format.Channels)
+			}
+			haveFormat = true
+
+		case "data":
+			if !haveFormat {
+				return format, 0, fmt.Errorf("data chunk before fmt chunk")
+			}
+			return format, size, nil
+
+		default:
+			// Skip unknown chunks (padded to an even size)
+			if _, err := io.CopyN(io.Discard, r, int64(size)+int64(size%2)); err != nil {
+				return format, 0, fmt.Errorf("truncated WAV chunk %q", id)
+			}
+		}
+	}
+}
+
+// readWAV parses a WAV file into interleaved float samples in 16-bit full-scale units
+func readWAV(r io.Reader) (wavFormat, []float64, error) {
+	format, size, err := readWAVHeader(r)
+	if err != nil {
+		return format, nil, err
+	}
+
+	// Streams written with an unknown size run to the end of the file
+	var data []byte
+	if size == wavUnknownSize {
+		data, err = io.ReadAll(r)
+	} else {
+		data = make([]byte, size)
+		_, err = io.ReadFull(r, data)
+	}
+	if err != nil {
+		return format, nil, fmt.Errorf("failed to read WAV data: %v", err)
+	}
+	return format, format.decodeSamples(data), nil
+}
@@ -0,0 +1,50 @@
+package audiorelay
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+//go:embed web
+var embeddedWeb embed.FS
+
+// WebFS returns the files of the web interface: those in webRoot, if set,
+// and the embedded ones for everything webRoot doesn't have
+func WebFS(webRoot string) fs.FS {
+	base, _ := fs.Sub(embeddedWeb, "web") // Only fails for invalid paths
+	if webRoot == "" {
+		return base
+	}
+	return overlayFS{top: os.DirFS(webRoot), base: base}
+}
+
+// checkWebRoot reports whether webRoot is a readable directory
+func checkWebRoot(webRoot string) error {
+	info, err := os.Stat(webRoot)
+	if err != nil {
+		return fmt.Errorf("HTTP web_root: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("HTTP web_root %s is not a directory", webRoot)
+	}
+	return nil
+}
+
+// overlayFS opens files from top, falling back to base for the ones top
+// doesn't have, so a directory can replace single files of the web UI
+type overlayFS struct {
+	top  fs.FS
+	base fs.FS
+}
+
+// Open opens name from top, or from base if top doesn't have it
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.base.Open(name)
+}
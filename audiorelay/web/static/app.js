@@ -0,0 +1,414 @@
+// A token the page was opened with (/?token=...) is passed on to the
+// stream, status and API requests, which can't prompt for one
+const authToken = new URLSearchParams(window.location.search).get('token');
+
+function withToken(url) {
+    if (!authToken) {
+        return url;
+    }
+    return url + (url.includes('?') ? '&' : '?') + 'token=' + encodeURIComponent(authToken);
+}
+
+// api calls an endpoint of this server and resolves to its JSON response,
+// or rejects with the server's error message
+function api(method, url, body) {
+    const options = {method: method};
+    if (body !== undefined) {
+        options.headers = {'Content-Type': 'application/json'};
+        options.body = JSON.stringify(body);
+    }
+    return fetch(withToken(url), options).then(response => {
+        if (response.status === 204) {
+            return null;
+        }
+        return response.json().then(data => {
+            if (!response.ok) {
+                throw new Error(data.error || response.statusText);
+            }
+            return data;
+        });
+    });
+}
+
+function showNotification(message, type) {
+    const notification = document.createElement('div');
+    notification.className = 'notification ' + type;
+    notification.textContent = message;
+    document.body.appendChild(notification);
+
+    // Animate in, then out and remove
+    setTimeout(() => notification.classList.add('visible'), 100);
+    setTimeout(() => {
+        notification.classList.remove('visible');
+        setTimeout(() => document.body.removeChild(notification), 300);
+    }, 3000);
+}
+
+function apiError(action) {
+    return error => showNotification(action + ' failed: ' + error.message, 'error');
+}
+
+// Player
+
+// Hot standby: relays registered with this one, and the one playing
+// once the player has failed over ('' is this server)
+let standbyUrls = [];
+let streamBase = '';
+let statusFailures = 0;
+
+const audio = document.getElementById('audioStream');
+const formatSelect = document.getElementById('format');
+let streamFormat = localStorage.getItem('audiorelay.format') || 'wav';
+
+// streamPath is the stream in the chosen format; WAV keeps the classic URL
+// that every player understands
+function streamPath() {
+    return streamFormat === 'wav' ? '/stream.wav' : '/stream?format=' + streamFormat;
+}
+
+function playStream() {
+    const url = streamBase + streamPath();
+    document.getElementById('streamUrl').textContent = (streamBase || window.location.origin) + streamPath();
+    document.getElementById('openStream').href = withToken(url);
+    audio.src = withToken(url);
+    audio.load();
+    audio.play().catch(e => console.log('Audio play failed:', e));
+}
+
+function updateFormats(formats) {
+    const key = formats.join(',');
+    if (formatSelect.dataset.formats === key) {
+        return;
+    }
+    formatSelect.dataset.formats = key;
+    formatSelect.innerHTML = '';
+    formats.filter(format => format !== 'pcm').forEach(format => {
+        formatSelect.add(new Option(format.toUpperCase(), format));
+    });
+    if (!formats.includes(streamFormat)) {
+        streamFormat = 'wav';
+    }
+    formatSelect.value = streamFormat;
+}
+
+formatSelect.addEventListener('change', () => {
+    streamFormat = formatSelect.value;
+    localStorage.setItem('audiorelay.format', streamFormat);
+    playStream();
+});
+
+function failover() {
+    if (streamBase !== '' || standbyUrls.length === 0) {
+        return false;
+    }
+    streamBase = standbyUrls[0];
+    playStream();
+    showNotification('Server unreachable, switched to standby ' + streamBase, 'error');
+    return true;
+}
+
+function restartAudio() {
+    playStream();
+    showNotification('Audio stream restarted', 'success');
+}
+
+// Auto-restart if audio stops (handles network issues)
+audio.addEventListener('error', function() {
+    console.log('Audio error detected');
+    if (failover()) {
+        return;
+    }
+    showNotification('Audio stream error. Attempting to reconnect...', 'error');
+    setTimeout(restartAudio, 2000);
+});
+
+audio.addEventListener('stalled', function() {
+    console.log('Audio stalled');
+    showNotification('Audio stream stalled. Reconnecting...', 'error');
+    setTimeout(restartAudio, 1000);
+});
+
+audio.addEventListener('waiting', function() {
+    console.log('Audio waiting for data');
+    showNotification('Buffering audio stream...', 'error');
+});
+
+audio.addEventListener('playing', function() {
+    console.log('Audio playing');
+    showNotification('Audio stream connected', 'success');
+});
+
+// Server stats
+
+function updateStats() {
+    fetch(withToken(streamBase + '/status'))
+        .then(response => response.json())
+        .then(data => {
+            statusFailures = 0;
+            if (streamBase === '') {
+                standbyUrls = data.standby_urls || [];
+                updateFormats(data.formats || ['wav']);
+                updateProcessing(data.processing || {});
+            }
+            document.getElementById('clientCount').textContent = data.clients || 0;
+            document.getElementById('sampleRate').textContent = data.sample_rate || 48000;
+            document.getElementById('channels').textContent = data.channels || 2;
+            document.getElementById('bufferSize').textContent = data.buffer_size || 0;
+            document.getElementById('actualBufferSize').textContent = data.actual_buffer_size || 1024;
+            document.getElementById('uptime').textContent = formatDuration(data.server_uptime || 0);
+        })
+        .catch(error => {
+            console.log('Status fetch error:', error);
+            if (++statusFailures >= 2) {
+                failover();
+            }
+        });
+}
+
+function formatBytes(bytes) {
+    const units = ['B', 'KB', 'MB', 'GB'];
+    let i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes /= 1024;
+        i++;
+    }
+    return bytes.toFixed(i === 0 ? 0 : 1) + ' ' + units[i];
+}
+
+function formatDuration(seconds) {
+    const h = Math.floor(seconds / 3600);
+    const m = Math.floor(seconds % 3600 / 60);
+    const s = Math.floor(seconds % 60);
+    return (h > 0 ? h + 'h ' : '') + (h > 0 || m > 0 ? m + 'm ' : '') + s + 's';
+}
+
+// Live VU meters from level events (-60 dBFS .. 0 dBFS)
+
+function levelPercent(db) {
+    return Math.max(0, Math.min(100, (db + 60) / 60 * 100));
+}
+
+function updateMeters(levels) {
+    const container = document.getElementById('meters');
+    if (container.children.length !== levels.rms_db.length) {
+        container.innerHTML = '';
+        levels.rms_db.forEach((_, i) => {
+            container.insertAdjacentHTML('beforeend',
+                '<div class="meter"><span>CH' + (i + 1) + '</span>' +
+                '<div class="meter-bar"><div class="meter-rms"></div><div class="meter-peak"></div></div>' +
+                '<span class="meter-value"></span></div>');
+        });
+    }
+    levels.rms_db.forEach((rms, i) => {
+        const meter = container.children[i];
+        meter.querySelector('.meter-rms').style.width = levelPercent(rms) + '%';
+        meter.querySelector('.meter-peak').style.left = levelPercent(levels.peak_db[i]) + '%';
+        meter.querySelector('.meter-value').textContent = levels.peak_db[i].toFixed(1) + ' dB';
+    });
+}
+
+// Listeners, built with textContent since client names come from the
+// listeners themselves
+
+function updateClients() {
+    if (streamBase !== '') {
+        return;
+    }
+    fetch(withToken('/clients'))
+        .then(response => response.json())
+        .then(data => {
+            const rows = document.getElementById('clients');
+            rows.innerHTML = '';
+            (data.clients || []).forEach(client => {
+                const row = rows.insertRow();
+                const cells = [
+                    client.name ? client.name + ' (' + client.remote_addr + ')' : client.remote_addr,
+                    client.protocol,
+                    formatDuration((Date.now() - new Date(client.connected)) / 1000),
+                    formatBytes(client.bytes_sent),
+                    client.dropped_frames,
+                    client.write_latency_ms.toFixed(1) + ' ms',
+                ];
+                cells.forEach((text, i) => {
+                    const cell = row.insertCell();
+                    cell.textContent = text;
+                    if (i >= 3) {
+                        cell.className = 'number';
+                    }
+                });
+
+                const kick = document.createElement('button');
+                kick.className = 'btn btn-small btn-danger';
+                kick.textContent = 'Disconnect';
+                kick.addEventListener('click', () => {
+                    api('DELETE', '/api/v1/clients/' + encodeURIComponent(client.id))
+                        .then(() => {
+                            showNotification('Disconnected ' + client.remote_addr, 'success');
+                            updateClients();
+                        })
+                        .catch(apiError('Disconnect'));
+                });
+                row.insertCell().appendChild(kick);
+            });
+        })
+        .catch(error => console.log('Clients fetch error:', error));
+}
+
+// Per-minute history from /stats, drawn as sparklines scaled to each
+// series' own maximum
+
+function updateHistory() {
+    fetch(withToken('/stats'))
+        .then(response => {
+            if (!response.ok) {
+                // Disabled with server.stats_minutes: 0
+                document.getElementById('history').hidden = true;
+                return null;
+            }
+            return response.json();
+        })
+        .then(data => {
+            if (!data) {
+                return;
+            }
+            const samples = data.samples || [];
+            document.querySelectorAll('.sparkline').forEach(chart => {
+                const values = samples.map(s => s[chart.dataset.field]);
+                const top = Math.max(1, ...values);
+                const step = 100 / Math.max(1, values.length - 1);
+                chart.querySelector('polyline').setAttribute('points',
+                    values.map((v, i) => (i * step).toFixed(1) + ',' + (30 - v / top * 28).toFixed(1)).join(' '));
+                chart.querySelector('.sparkline-value').textContent =
+                    values.length ? values[values.length - 1] + chart.dataset.unit : '–';
+            });
+        })
+        .catch(error => console.log('Stats fetch error:', error));
+}
+
+// Capture device
+
+const deviceSelect = document.getElementById('device');
+
+function updateDevices() {
+    fetch(withToken('/devices'))
+        .then(response => response.json())
+        .then(data => {
+            if (!data.devices) {
+                throw new Error(data.error || 'no devices');
+            }
+            deviceSelect.innerHTML = '';
+            data.devices.forEach(device => {
+                let label = device.name + ' (' + device.channels + ' ch)';
+                if (device.is_default) {
+                    label += ' - default';
+                }
+                const option = new Option(label, device.index, false, device.current);
+                deviceSelect.add(option);
+            });
+            deviceSelect.disabled = false;
+        })
+        .catch(error => {
+            deviceSelect.innerHTML = '';
+            deviceSelect.add(new Option('Devices unavailable: ' + error.message, ''));
+            deviceSelect.disabled = true;
+        });
+}
+
+deviceSelect.addEventListener('change', () => {
+    deviceSelect.disabled = true;
+    api('POST', '/api/v1/device', {index: Number(deviceSelect.value)})
+        .then(device => showNotification('Capturing from ' + device.name, 'success'))
+        .catch(apiError('Switching device'))
+        .finally(updateDevices);
+});
+
+// Processing controls. Values from the server are only shown while a
+// slider isn't being dragged, so polling doesn't fight the user.
+
+const volume = document.getElementById('volume');
+const width = document.getElementById('width');
+let dragging = null;
+
+function updateProcessing(processing) {
+    if (dragging !== volume && processing.volume_multiplier !== undefined) {
+        volume.value = processing.volume_multiplier;
+        document.getElementById('volumeValue').textContent = Number(processing.volume_multiplier).toFixed(2) + '×';
+    }
+    if (dragging !== width && processing.stereo_width !== undefined) {
+        width.value = processing.stereo_width;
+        document.getElementById('widthValue').textContent = Number(processing.stereo_width).toFixed(2);
+    }
+    const channels = processing.channels;
+    if (channels) {
+        document.getElementById('swap').checked = channels.swap;
+        updateMutes(channels.mute || []);
+    }
+}
+
+function updateMutes(mute) {
+    const container = document.getElementById('mutes');
+    if (container.children.length !== mute.length) {
+        container.innerHTML = '';
+        mute.forEach((_, i) => {
+            const label = document.createElement('label');
+            const box = document.createElement('input');
+            box.type = 'checkbox';
+            box.addEventListener('change', sendMutes);
+            label.appendChild(box);
+            label.appendChild(document.createTextNode(' Mute CH' + (i + 1)));
+            container.appendChild(label);
+        });
+    }
+    mute.forEach((muted, i) => {
+        container.children[i].querySelector('input').checked = muted;
+    });
+}
+
+function sendMutes() {
+    const mute = Array.from(document.querySelectorAll('#mutes input')).map(box => box.checked);
+    api('PATCH', '/api/v1/processing', {mute: mute}).catch(apiError('Muting'));
+}
+
+document.getElementById('swap').addEventListener('change', event => {
+    api('PATCH', '/api/v1/processing', {swap: event.target.checked}).catch(apiError('Swapping channels'));
+});
+
+volume.addEventListener('input', () => {
+    dragging = volume;
+    document.getElementById('volumeValue').textContent = Number(volume.value).toFixed(2) + '×';
+});
+volume.addEventListener('change', () => {
+    dragging = null;
+    api('PUT', '/api/v1/config', {processing: {volume_multiplier: Number(volume.value)}})
+        .catch(apiError('Changing volume'));
+});
+
+width.addEventListener('input', () => {
+    dragging = width;
+    document.getElementById('widthValue').textContent = Number(width.value).toFixed(2);
+});
+width.addEventListener('change', () => {
+    dragging = null;
+    api('PATCH', '/api/v1/processing', {stereo_width: Number(width.value)})
+        .catch(apiError('Changing stereo width'));
+});
+
+// Live updates
+
+if (window.EventSource) {
+    const events = new EventSource(withToken('/events'));
+    events.addEventListener('levels', function(e) {
+        updateMeters(JSON.parse(e.data).data);
+    });
+    events.addEventListener('device_switched', updateDevices);
+    events.addEventListener('config_reloaded', updateStats);
+}
+
+playStream();
+updateStats();
+updateClients();
+updateDevices();
+updateHistory();
+setInterval(updateStats, 3000);
+setInterval(updateClients, 3000);
+setInterval(updateHistory, 60000);
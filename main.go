@@ -3,10 +3,59 @@ package main
 import (
 	"audiorelay/audiorelay"
 	"fmt"
+	"os"
+	"strings"
+
+	flag "github.com/spf13/pflag"
 )
 
 func main() {
-	if err := audiorelay.StartWithConfig("config.yml"); err != nil {
+	configPath := flag.String("config", "", "configuration file (default: the first of "+strings.Join(audiorelay.ConfigSearchPaths(), ", ")+")")
+	safeMode := flag.Bool("safe-mode", false, "ignore the config file, disable all processing and extras, listen on localhost only and log verbosely")
+	device := flag.String("device", "", "capture device name, or its index in the device list")
+
+	// Every config key can be set on the command line, e.g. --http-port 8080
+	audiorelay.RegisterConfigFlags(flag.CommandLine)
+
+	// Subcommands parse their own flags
+	flag.CommandLine.SetInterspersed(false)
+	flag.CommandLine.Parse(longFlags(os.Args[1:]))
+
+	// Without -config, use the first config file found, like other daemons
+	if *configPath == "" {
+		*configPath = audiorelay.FindConfig()
+	}
+
+	opts := audiorelay.Options{
+		ConfigPath: *configPath,
+		SafeMode:   *safeMode,
+		Device:     *device,
+		Flags:      flag.CommandLine,
+	}
+
+	// Subcommands, e.g. "audiorelay config docs"
+	if flag.NArg() > 0 {
+		if err := audiorelay.RunCommand(opts, flag.Args()); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := audiorelay.StartWithOptions(opts); err != nil {
 		fmt.Println(err)
 	}
 }
+
+// longFlags keeps single-dash flags such as -config working; no flag has a
+// one-letter shorthand
+func longFlags(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && !strings.ContainsAny(arg[1:2], "0123456789.") {
+			arg = "-" + arg
+		}
+		out[i] = arg
+	}
+	return out
+}